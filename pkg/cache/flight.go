@@ -0,0 +1,60 @@
+package cache
+
+import (
+	"fmt"
+	"sync"
+)
+
+// call 一次进行中的加载
+type call[V any] struct {
+	done  chan struct{}
+	value V
+	found bool
+	err   error
+}
+
+// flightGroup 按照key:id合并并发的加载，同一个id同一时刻只会执行一次fn
+type flightGroup[K comparable, V any] struct {
+	mu    sync.Mutex
+	calls map[string]*call[V]
+}
+
+// claim 返回需要由当前请求加载的id，以及正在被其他请求加载的id
+func (g *flightGroup[K, V]) claim(key string, ids []K) (owned []K, ownedCalls, waiting map[K]*call[V]) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call[V])
+	}
+
+	ownedCalls = make(map[K]*call[V])
+	waiting = make(map[K]*call[V])
+	for _, id := range ids {
+		flightKey := g.getKey(key, id)
+		if c, ok := g.calls[flightKey]; ok {
+			waiting[id] = c
+			continue
+		}
+		c := &call[V]{done: make(chan struct{})}
+		g.calls[flightKey] = c
+		owned = append(owned, id)
+		ownedCalls[id] = c
+	}
+	return
+}
+
+// finish 将加载结果通知给等待的请求
+func (g *flightGroup[K, V]) finish(key string, ownedCalls map[K]*call[V], res map[K]V, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for id, c := range ownedCalls {
+		c.value, c.found = res[id]
+		c.err = err
+		close(c.done)
+		delete(g.calls, g.getKey(key, id))
+	}
+}
+
+func (g *flightGroup[K, V]) getKey(key string, id K) string {
+	return fmt.Sprintf("%s:%v", key, id)
+}
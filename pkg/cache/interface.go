@@ -1,13 +1,47 @@
 package cache
 
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+
+	"github.com/zhengyansheng/jupiter/pkg/util/xgo"
+)
+
 // Storage 接入本地缓存库需要实现的接口
 type Storage[K comparable, V any] interface {
 	GetCacheMapOrigin(key string, ids []K) (v map[K]V, idsNone []K)
 	SetCacheMapOrigin(key string, idsNone []K, fn func([]K) (map[K]V, error), v map[K]V) (err error)
+	// DelCacheMapOrigin 删除缓存数据
+	DelCacheMapOrigin(key string, ids []K) (err error)
+	// SetCacheValueOrigin 按照指定的失效时间设置单条缓存数据
+	SetCacheValueOrigin(key string, id K, value V, ttl time.Duration) (err error)
+	// Purge 清空缓存
+	Purge() (err error)
+	// Stats 缓存统计信息
+	Stats() Stats
+}
+
+// StaleStorage 支持stale-while-revalidate的Storage
+// idsStale中的数据已经过期但仍在v中返回，由Cache在后台刷新
+type StaleStorage[K comparable, V any] interface {
+	GetCacheMapStale(key string, ids []K) (v map[K]V, idsStale []K, idsNone []K)
+}
+
+// New 构建缓存实例，并注册到governor及metric上报
+func New[K comparable, V any](storage Storage[K, V]) *Cache[K, V] {
+	c := &Cache[K, V]{
+		Storage: storage,
+	}
+	register(c)
+	return c
 }
 
 type Cache[K comparable, V any] struct {
 	Storage[K, V]
+
+	flight flightGroup[K, V] // 合并并发的加载，防止缓存击穿
 }
 
 // GetAndSetCacheData 获取缓存后数据
@@ -37,12 +71,77 @@ func (c *Cache[K, V]) SetCacheValue(key string, id K, fn func() (V, error)) (err
 }
 
 // GetAndSetCacheMap 获取缓存后数据 map形式
+// 并发请求同一个id时只会执行一次fn，过期但仍在stale窗口内的数据直接返回并在后台刷新
 func (c *Cache[K, V]) GetAndSetCacheMap(key string, ids []K, fn func([]K) (map[K]V, error)) (v map[K]V, err error) {
 	// 获取缓存数据
-	v, idsNone := c.GetCacheMapOrigin(key, ids)
+	var idsStale, idsNone []K
+	if staleStorage, ok := c.Storage.(StaleStorage[K, V]); ok {
+		v, idsStale, idsNone = staleStorage.GetCacheMapStale(key, ids)
+	} else {
+		v, idsNone = c.GetCacheMapOrigin(key, ids)
+	}
+
+	if len(idsStale) > 0 {
+		c.refresh(key, idsStale, fn)
+	}
 
 	// 设置缓存数据
-	err = c.SetCacheMapOrigin(key, idsNone, fn, v)
+	err = c.load(key, idsNone, fn, v)
+	return
+}
+
+// load 加载未命中的数据，正在被其他请求加载的id等待其结果
+func (c *Cache[K, V]) load(key string, idsNone []K, fn func([]K) (map[K]V, error), v map[K]V) (err error) {
+	if len(idsNone) == 0 {
+		return
+	}
+
+	owned, ownedCalls, waiting := c.flight.claim(key, idsNone)
+	if len(owned) > 0 {
+		var res map[K]V
+		res, err = c.do(key, owned, ownedCalls, fn)
+		for k, value := range res {
+			v[k] = value
+		}
+	}
+
+	for id, call := range waiting {
+		<-call.done
+		if call.err != nil {
+			if err == nil {
+				err = call.err
+			}
+			continue
+		}
+		if call.found {
+			v[id] = call.value
+		}
+	}
+	return
+}
+
+// refresh 后台刷新stale数据，已经在刷新中的id会被跳过
+func (c *Cache[K, V]) refresh(key string, idsStale []K, fn func([]K) (map[K]V, error)) {
+	owned, ownedCalls, _ := c.flight.claim(key, idsStale)
+	if len(owned) == 0 {
+		return
+	}
+	xgo.Go(func() {
+		_, _ = c.do(key, owned, ownedCalls, fn)
+	})
+}
+
+// do 执行加载并通知等待的请求，fn panic时等待的请求会收到错误
+func (c *Cache[K, V]) do(key string, owned []K, ownedCalls map[K]*call[V], fn func([]K) (map[K]V, error)) (res map[K]V, err error) {
+	res = make(map[K]V, len(owned))
+	defer func() {
+		if r := recover(); r != nil {
+			c.flight.finish(key, ownedCalls, nil, fmt.Errorf("cache: load panic: %v", r))
+			panic(r)
+		}
+		c.flight.finish(key, ownedCalls, res, err)
+	}()
+	err = c.SetCacheMapOrigin(key, owned, fn, res)
 	return
 }
 
@@ -57,3 +156,40 @@ func (c *Cache[K, V]) SetCacheMap(key string, ids []K, fn func([]K) (map[K]V, er
 	err = c.SetCacheMapOrigin(key, ids, fn, nil)
 	return
 }
+
+// Del 删除缓存数据
+func (c *Cache[K, V]) Del(key string, id K) (err error) {
+	err = c.DelCacheMapOrigin(key, []K{id})
+	return
+}
+
+// DelMany 删除缓存数据 map形式
+func (c *Cache[K, V]) DelMany(key string, ids []K) (err error) {
+	err = c.DelCacheMapOrigin(key, ids)
+	return
+}
+
+// SetWithTTL 设置缓存数据，使用指定的失效时间，ttl<=0时使用配置的失效时间
+func (c *Cache[K, V]) SetWithTTL(key string, id K, value V, ttl time.Duration) (err error) {
+	err = c.SetCacheValueOrigin(key, id, value, ttl)
+	return
+}
+
+// Close 从governor及metric上报中移除，并释放Storage持有的资源，如二级缓存的失效广播订阅
+func (c *Cache[K, V]) Close() (err error) {
+	unregister(c)
+	if closer, ok := c.Storage.(io.Closer); ok {
+		err = closer.Close()
+	}
+	return
+}
+
+// IsNil 判断缓存数据是否为nil，nil指针、map、slice按照未找到处理，其余零值正常缓存
+func IsNil[V any](value V) bool {
+	rv := reflect.ValueOf(&value).Elem()
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface, reflect.Func, reflect.Chan:
+		return rv.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package cache
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/zhengyansheng/jupiter/pkg/core/metric"
+	"github.com/zhengyansheng/jupiter/pkg/server/governor"
+	"github.com/zhengyansheng/jupiter/pkg/util/xgo"
+)
+
+var (
+	// caches 通过New构建且未Close的缓存实例，用于governor及metric上报
+	caches = sync.Map{}
+	// monitorOnce 首次注册缓存实例时启动metric上报
+	monitorOnce sync.Once
+	// monitorMu 避免移除后的实例被再次上报
+	monitorMu sync.Mutex
+)
+
+type statser interface {
+	Stats() Stats
+}
+
+// Stats 缓存统计信息
+type Stats struct {
+	Type      string  `json:"type"`
+	Name      string  `json:"name"`
+	Hits      uint64  `json:"hits"`
+	Misses    uint64  `json:"misses"`
+	Evictions uint64  `json:"evictions"` // 容量不足或过期被淘汰的条目数，不包含主动删除
+	Entries   int     `json:"entries"`
+	HitRate   float64 `json:"hitRate"`
+}
+
+// Counter 统计命中次数，供Storage实现Stats使用，命中情况同时上报metric
+type Counter struct {
+	typ          string
+	name         string
+	enableMetric bool
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// NewCounter ...
+func NewCounter(typ, name string, enableMetric bool) *Counter {
+	return &Counter{
+		typ:          typ,
+		name:         name,
+		enableMetric: enableMetric,
+	}
+}
+
+// Hit 记录命中次数
+func (c *Counter) Hit(n int) {
+	if n <= 0 {
+		return
+	}
+	c.hits.Add(uint64(n))
+	if c.enableMetric {
+		metric.CacheHandleCounter.Add(float64(n), c.typ, c.name, "get", metric.CodeCacheHit)
+	}
+}
+
+// Miss 记录未命中次数
+func (c *Counter) Miss(n int) {
+	if n <= 0 {
+		return
+	}
+	c.misses.Add(uint64(n))
+	if c.enableMetric {
+		metric.CacheHandleCounter.Add(float64(n), c.typ, c.name, "get", metric.CodeCacheMiss)
+	}
+}
+
+// Stats 返回统计信息，entries及evictions由Storage提供
+func (c *Counter) Stats(entries int, evictions uint64) Stats {
+	stats := Stats{
+		Type:      c.typ,
+		Name:      c.name,
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: evictions,
+		Entries:   entries,
+	}
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(total)
+	}
+	return stats
+}
+
+// register 记录缓存实例，同名同类型的实例后注册的生效
+func register(s statser) {
+	stats := s.Stats()
+	caches.Store(stats.Type+":"+stats.Name, s)
+	monitorOnce.Do(func() {
+		xgo.Go(monitor)
+	})
+}
+
+// unregister 移除缓存实例及其上报的metric，同名的实例已被后注册的覆盖时不做处理
+func unregister(s statser) {
+	stats := s.Stats()
+	monitorMu.Lock()
+	defer monitorMu.Unlock()
+	if caches.CompareAndDelete(stats.Type+":"+stats.Name, s) {
+		for _, stat := range []string{"entries", "evictions", "hit_rate"} {
+			metric.CacheStatsGauge.DeleteLabelValues(stats.Type, stats.Name, stat)
+		}
+	}
+}
+
+// AllStats 返回所有缓存实例的统计信息
+func AllStats() []Stats {
+	list := make([]Stats, 0)
+	caches.Range(func(key, val interface{}) bool {
+		list = append(list, val.(statser).Stats())
+		return true
+	})
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Type != list[j].Type {
+			return list[i].Type < list[j].Type
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+func init() {
+	governor.HandleFunc("/cache/stats", func(w http.ResponseWriter, r *http.Request) {
+		_ = jsoniter.NewEncoder(w).Encode(AllStats())
+	})
+}
+
+func monitor() {
+	ticker := time.NewTicker(time.Second * 10)
+	defer ticker.Stop()
+	for ; true; <-ticker.C {
+		monitorMu.Lock()
+		for _, stats := range AllStats() {
+			metric.CacheStatsGauge.Set(float64(stats.Entries), stats.Type, stats.Name, "entries")
+			metric.CacheStatsGauge.Set(float64(stats.Evictions), stats.Type, stats.Name, "evictions")
+			metric.CacheStatsGauge.Set(stats.HitRate, stats.Type, stats.Name, "hit_rate")
+		}
+		monitorMu.Unlock()
+	}
+}
@@ -2,16 +2,24 @@ package xfreecache
 
 import (
 	"fmt"
+	"time"
+
+	"github.com/coocood/freecache"
 	"github.com/samber/lo"
+	"github.com/zhengyansheng/jupiter/pkg/cache"
 	"github.com/zhengyansheng/jupiter/pkg/xlog"
 	"go.uber.org/zap"
-	"reflect"
 )
 
 func (l *localStorage[K, V]) GetCacheMapOrigin(key string, ids []K) (v map[K]V, idsNone []K) {
-	var zero V
+	v, _, idsNone = l.GetCacheMapStale(key, ids)
+	return
+}
+
+func (l *localStorage[K, V]) GetCacheMapStale(key string, ids []K) (v map[K]V, idsStale []K, idsNone []K) {
 	v = make(map[K]V)
 	idsNone = make([]K, 0, len(ids))
+	now := time.Now()
 
 	// id去重
 	ids = lo.Uniq(ids)
@@ -19,14 +27,36 @@ func (l *localStorage[K, V]) GetCacheMapOrigin(key string, ids []K) (v map[K]V,
 		cacheKey := l.getKey(key, id)
 		resT, innerErr := l.getCacheData(cacheKey)
 		if innerErr == nil && resT != nil {
-			var value V
-			// 反序列化
-			value, innerErr = unmarshal[V](resT)
+			var (
+				flag       byte
+				freshUntil time.Time
+				body       []byte
+				value      V
+			)
+			flag, freshUntil, body, innerErr = decodeEntry(resT)
+			// freecache的失效时间精度为秒，按照毫秒精度的freshUntil判断是否失效
+			if innerErr == nil {
+				expireAt := freshUntil
+				if flag == flagValue {
+					expireAt = expireAt.Add(l.config.StaleWhileRevalidate)
+				}
+				if now.After(expireAt) {
+					innerErr = freecache.ErrNotFound
+				}
+			}
+			if innerErr == nil && flag == flagNotFound {
+				continue
+			}
+			if innerErr == nil {
+				// 反序列化
+				value, innerErr = unmarshal[V](body)
+			}
 			if innerErr != nil {
 				xlog.Jupiter().Error("cache unmarshalWithPool", zap.String("key", key), zap.Error(innerErr))
 			} else {
-				if !reflect.DeepEqual(value, zero) {
-					v[id] = value
+				v[id] = value
+				if now.After(freshUntil) {
+					idsStale = append(idsStale, id)
 				}
 			}
 		}
@@ -34,6 +64,8 @@ func (l *localStorage[K, V]) GetCacheMapOrigin(key string, ids []K) (v map[K]V,
 			idsNone = append(idsNone, id)
 		}
 	}
+	l.counter.Hit(len(ids) - len(idsNone))
+	l.counter.Miss(len(idsNone))
 	return
 }
 
@@ -58,34 +90,86 @@ func (l *localStorage[K, V]) SetCacheMapOrigin(key string, idsNone []K, fn func(
 		}
 	}
 
-	// 写入缓存
+	// 写入缓存，fn未返回的id按照notFoundExpire缓存
+	now := time.Now()
 	for _, id := range idsNone {
 		var (
-			cacheData V
-			data      []byte
+			data   []byte
+			expire = l.config.NotFoundExpire
 		)
 
-		if val, ok := resMap[id]; ok {
-			cacheData = val
+		if val, ok := resMap[id]; ok && !cache.IsNil(val) {
+			// 序列化
+			data, err = marshal(val)
+			if err != nil {
+				xlog.Jupiter().Error("GetAndSetCacheMap Marshal", append(args, zap.Error(err))...)
+				return
+			}
+			data = encodeEntry(flagValue, now.Add(l.config.Expire), data)
+			expire = l.config.Expire + l.config.StaleWhileRevalidate
+		} else {
+			data = encodeEntry(flagNotFound, now.Add(expire), nil)
 		}
-		// 序列化
-		data, err = marshal(cacheData)
 
+		cacheKey := l.getKey(key, id)
+		err = l.setCacheData(cacheKey, data, expire)
 		if err != nil {
-			xlog.Jupiter().Error("GetAndSetCacheMap Marshal", append(args, zap.Error(err))...)
+			xlog.Jupiter().Error("GetAndSetCacheMap setCacheData", append(args, zap.Error(err))...)
 			return
 		}
+	}
+	return
+}
 
-		cacheKey := l.getKey(key, id)
-		err = l.setCacheData(cacheKey, data)
+func (l *localStorage[K, V]) DelCacheMapOrigin(key string, ids []K) (err error) {
+	for _, id := range ids {
+		l.config.Cache.Del([]byte(l.getKey(key, id)))
+	}
+	return
+}
+
+func (l *localStorage[K, V]) SetCacheValueOrigin(key string, id K, value V, ttl time.Duration) (err error) {
+	args := []zap.Field{zap.Any("key", key), zap.Any("id", id)}
+
+	// freecache的失效时间为0表示永不失效，未指定ttl时使用配置的expire
+	if ttl <= 0 {
+		ttl = l.config.Expire
+	}
+	data := encodeEntry(flagNotFound, time.Now().Add(ttl), nil)
+	expire := ttl
+	if !cache.IsNil(value) {
+		data, err = marshal(value)
 		if err != nil {
-			xlog.Jupiter().Error("GetAndSetCacheMap setCacheData", append(args, zap.Error(err))...)
+			xlog.Jupiter().Error("SetCacheValueOrigin Marshal", append(args, zap.Error(err))...)
 			return
 		}
+		data = encodeEntry(flagValue, time.Now().Add(ttl), data)
+		expire = ttl + l.config.StaleWhileRevalidate
+	}
+
+	err = l.setCacheData(l.getKey(key, id), data, expire)
+	if err != nil {
+		xlog.Jupiter().Error("SetCacheValueOrigin setCacheData", append(args, zap.Error(err))...)
 	}
 	return
 }
 
+// Purge freecache实例在进程内共享，通过递增generation使当前缓存的数据全部失效
+func (l *localStorage[K, V]) Purge() (err error) {
+	l.generation.Add(1)
+	return
+}
+
+// Stats entries及evictions为进程内共享的freecache实例的统计
+func (l *localStorage[K, V]) Stats() cache.Stats {
+	evictions := l.config.Cache.EvacuateCount() + l.config.Cache.ExpiredCount()
+	return l.counter.Stats(int(l.config.Cache.EntryCount()), uint64(evictions))
+}
+
+// getKey 未Purge时保持原有的key格式，Purge后带上缓存名称及generation
 func (l *localStorage[K, V]) getKey(key string, id K) string {
+	if generation := l.generation.Load(); generation > 0 {
+		return fmt.Sprintf("%s:%d:%s:%v", l.config.Name, generation, key, id)
+	}
 	return fmt.Sprintf("%s:%v", key, id)
 }
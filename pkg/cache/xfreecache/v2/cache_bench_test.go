@@ -34,6 +34,7 @@ func BenchmarkLocalCache_GetCacheData(b *testing.B) {
 	b.Run("read & write & race", func(b *testing.B) {
 		eg := errgroup.Group{}
 		for i := 0; i < b.N; i++ {
+			i := i
 			eg.Go(func() error {
 				student := Student{10, "student" + strconv.Itoa(i)}
 				_, _ = localCache.GetAndSetCacheData("mytest", student.Name, func() (Student, error) {
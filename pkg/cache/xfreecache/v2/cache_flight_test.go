@@ -0,0 +1,88 @@
+package xfreecache
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 测试并发加载合并、未找到缓存及stale-while-revalidate
+
+func Test_cache_singleflight(t *testing.T) {
+	oneCache := New[int, Student](&Config{Expire: time.Minute, Name: "flight"})
+	var calls int32
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := oneCache.GetAndSetCacheData("Test_cache_singleflight", 1, func() (Student, error) {
+				atomic.AddInt32(&calls, 1)
+				time.Sleep(50 * time.Millisecond)
+				return Student{Age: 1, Name: "Student 1"}, nil
+			})
+			assert.Nil(t, err)
+			assert.Equal(t, "Student 1", res.Name)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func Test_cache_notFound(t *testing.T) {
+	oneCache := New[int, int](&Config{Expire: time.Minute, NotFoundExpire: 50 * time.Millisecond, Name: "notFound"})
+	calls := 0
+	load := func(ids []int) (map[int]int, error) {
+		calls++
+		return map[int]int{1: 0}, nil
+	}
+
+	v, err := oneCache.GetAndSetCacheMap("Test_cache_notFound", []int{1, 2}, load)
+	assert.Nil(t, err)
+	assert.Equal(t, map[int]int{1: 0}, v)
+
+	// 零值正常返回，未找到的id不再回源
+	v, idsNone := oneCache.GetCacheMapOrigin("Test_cache_notFound", []int{1, 2})
+	assert.Equal(t, map[int]int{1: 0}, v)
+	assert.Empty(t, idsNone)
+
+	time.Sleep(100 * time.Millisecond)
+	_, idsNone = oneCache.GetCacheMapOrigin("Test_cache_notFound", []int{1, 2})
+	assert.Equal(t, []int{2}, idsNone)
+
+	_, err = oneCache.GetAndSetCacheMap("Test_cache_notFound", []int{1, 2}, load)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, calls)
+}
+
+func Test_cache_staleWhileRevalidate(t *testing.T) {
+	oneCache := New[int, Student](&Config{Expire: 50 * time.Millisecond, StaleWhileRevalidate: time.Minute, Name: "stale"})
+	var calls int32
+	load := func(name string) func() (Student, error) {
+		return func() (Student, error) {
+			atomic.AddInt32(&calls, 1)
+			time.Sleep(20 * time.Millisecond)
+			return Student{Age: 1, Name: name}, nil
+		}
+	}
+
+	res, err := oneCache.GetAndSetCacheData("Test_cache_staleWhileRevalidate", 1, load("Student 1"))
+	assert.Nil(t, err)
+	assert.Equal(t, "Student 1", res.Name)
+
+	time.Sleep(100 * time.Millisecond)
+	for i := 0; i < 5; i++ {
+		res, err = oneCache.GetAndSetCacheData("Test_cache_staleWhileRevalidate", 1, load("Student 2"))
+		assert.Nil(t, err)
+		assert.Equal(t, "Student 1", res.Name)
+	}
+
+	assert.Eventually(t, func() bool {
+		return oneCache.GetCacheValue("Test_cache_staleWhileRevalidate", 1).Name == "Student 2"
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/BurntSushi/toml"
 	"github.com/stretchr/testify/assert"
+	"github.com/zhengyansheng/jupiter/pkg/cache"
 	"github.com/zhengyansheng/jupiter/pkg/conf"
 )
 
@@ -237,6 +238,69 @@ func Test_cache_GetAndSetCacheMap(t *testing.T) {
 	assert.Equalf(t, missCount, 3, "GetAndSetCacheMap miss count error")
 }
 
+func Test_cache_Del(t *testing.T) {
+	oneCache := New[int, Student](&Config{Expire: time.Minute, Name: "del"})
+	assert.Nil(t, oneCache.SetCacheValue("mytest", 1, func() (Student, error) {
+		return Student{Age: 1, Name: "Student 1"}, nil
+	}))
+	assert.Equal(t, "Student 1", oneCache.GetCacheValue("mytest", 1).Name)
+
+	assert.Nil(t, oneCache.DelMany("mytest", []int{1, 2}))
+	_, idsNone := oneCache.GetCacheMapOrigin("mytest", []int{1})
+	assert.Equal(t, []int{1}, idsNone)
+}
+
+func Test_cache_SetWithTTL(t *testing.T) {
+	oneCache := New[int, Student](&Config{Expire: time.Minute, Name: "ttl"})
+	assert.Nil(t, oneCache.SetWithTTL("Test_cache_SetWithTTL", 1, Student{Age: 1, Name: "Student 1"}, 50*time.Millisecond))
+	assert.Nil(t, oneCache.SetWithTTL("Test_cache_SetWithTTL", 2, Student{Age: 2, Name: "Student 2"}, time.Minute))
+	assert.Equal(t, "Student 1", oneCache.GetCacheValue("Test_cache_SetWithTTL", 1).Name)
+
+	time.Sleep(100 * time.Millisecond)
+	v, idsNone := oneCache.GetCacheMapOrigin("Test_cache_SetWithTTL", []int{1, 2})
+	assert.Equal(t, []int{1}, idsNone)
+	assert.Equal(t, "Student 2", v[2].Name)
+
+	// 未指定ttl时使用配置的expire，而不是永不失效
+	c := &Config{Expire: time.Minute, Name: "ttl-default"}
+	defaultCache := New[int, Student](c)
+	assert.Nil(t, defaultCache.SetWithTTL("Test_cache_SetWithTTL", 3, Student{Age: 3, Name: "Student 3"}, 0))
+	ttl, err := c.Cache.TTL([]byte("Test_cache_SetWithTTL:3"))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 0 && ttl <= 60)
+}
+
+func Test_cache_Purge(t *testing.T) {
+	oneCache := New[int, Student](&Config{Expire: time.Minute, Name: "purge"})
+	otherCache := New[int, Student](&Config{Expire: time.Minute, Name: "purge-other"})
+	for _, c := range []*cache.Cache[int, Student]{oneCache, otherCache} {
+		assert.Nil(t, c.SetWithTTL("Test_cache_Purge", 1, Student{Age: 1, Name: "Student 1"}, time.Minute))
+	}
+
+	assert.Nil(t, oneCache.Purge())
+	_, idsNone := oneCache.GetCacheMapOrigin("Test_cache_Purge", []int{1})
+	assert.Equal(t, []int{1}, idsNone)
+	assert.Equal(t, "Student 1", otherCache.GetCacheValue("Test_cache_Purge", 1).Name)
+}
+
+func Test_cache_Stats(t *testing.T) {
+	oneCache := New[int, Student](&Config{Expire: time.Minute, Name: "stats"})
+	_, err := oneCache.GetAndSetCacheMap("Test_cache_Stats", []int{1, 2}, func(ids []int) (map[int]Student, error) {
+		return map[int]Student{1: {Age: 1, Name: "Student 1"}}, nil
+	})
+	assert.Nil(t, err)
+	oneCache.GetCacheMap("Test_cache_Stats", []int{1, 2, 3})
+
+	stats := oneCache.Stats()
+	assert.Equal(t, "freecache", stats.Type)
+	assert.Equal(t, "stats", stats.Name)
+	assert.Equal(t, uint64(2), stats.Hits)
+	assert.Equal(t, uint64(3), stats.Misses)
+	assert.InDelta(t, 0.4, stats.HitRate, 0.001)
+	assert.True(t, stats.Entries >= 2)
+	assert.Contains(t, cache.AllStats(), stats)
+}
+
 func TestStdConfig(t *testing.T) {
 	var configStr = `
 		[jupiter.cache]
@@ -13,10 +13,12 @@ import (
 )
 
 type Config struct {
-	Cache         *freecache.Cache `json:"-" toml:"-"`                         // 本地缓存实例【必填】
-	Expire        time.Duration    `json:"expire" toml:"expire"`               // 失效时间 【必填】
-	DisableMetric bool             `json:"disableMetric" toml:"disableMetric"` // metric上报 false 开启  ture 关闭【选填，默认开启】
-	Name          string           `json:"-" toml:"-"`                         // 本地缓存名称，用于日志标识&metric上报【选填】
+	Cache                *freecache.Cache `json:"-" toml:"-"`                                       // 本地缓存实例【必填】
+	Expire               time.Duration    `json:"expire" toml:"expire"`                             // 失效时间 【必填】
+	NotFoundExpire       time.Duration    `json:"notFoundExpire" toml:"notFoundExpire"`             // fn未返回的id的失效时间【选填，默认与expire相同】
+	StaleWhileRevalidate time.Duration    `json:"staleWhileRevalidate" toml:"staleWhileRevalidate"` // 失效后仍返回旧数据并在后台刷新的时间窗口【选填，默认关闭】
+	DisableMetric        bool             `json:"disableMetric" toml:"disableMetric"`               // metric上报 false 开启  ture 关闭【选填，默认开启】
+	Name                 string           `json:"-" toml:"-"`                                       // 本地缓存名称，用于日志标识&metric上报【选填】
 }
 
 var (
@@ -60,6 +62,9 @@ func New[K comparable, V any](c *Config) (localCache *cache.Cache[K, V]) {
 	if len(c.Name) == 0 {
 		c.Name = fmt.Sprintf("cache-%d", time.Now().UnixNano())
 	}
+	if c.NotFoundExpire == 0 {
+		c.NotFoundExpire = c.Expire
+	}
 	if c.Cache == nil {
 		// 初始化缓存实例
 		once.Do(func() {
@@ -75,9 +80,8 @@ func New[K comparable, V any](c *Config) (localCache *cache.Cache[K, V]) {
 		c.Cache = innerCache
 	}
 
-	return &cache.Cache[K, V]{
-		Storage: &localStorage[K, V]{
-			config: c,
-		},
-	}
+	return cache.New[K, V](&localStorage[K, V]{
+		config:  c,
+		counter: cache.NewCounter("freecache", c.Name, !c.DisableMetric),
+	})
 }
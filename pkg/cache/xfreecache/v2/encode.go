@@ -1,7 +1,11 @@
 package xfreecache
 
 import (
+	"encoding/binary"
 	"encoding/json"
+	"errors"
+	"time"
+
 	"google.golang.org/protobuf/proto"
 	"reflect"
 )
@@ -32,3 +36,34 @@ func unmarshal[T any](body []byte) (value T, err error) {
 	}
 	return
 }
+
+const (
+	flagValue    byte = 0
+	flagNotFound byte = 1
+
+	// headerSize 1字节标记 + 8字节freshUntil(unix毫秒)，未找到的条目freshUntil即为失效时间
+	headerSize = 9
+)
+
+var errInvalidEntry = errors.New("xfreecache: invalid entry")
+
+// encodeEntry 在序列化数据前加上标记及新鲜截止时间
+func encodeEntry(flag byte, freshUntil time.Time, body []byte) []byte {
+	data := make([]byte, headerSize+len(body))
+	data[0] = flag
+	binary.BigEndian.PutUint64(data[1:headerSize], uint64(freshUntil.UnixMilli()))
+	copy(data[headerSize:], body)
+	return data
+}
+
+// decodeEntry 解析encodeEntry的数据
+func decodeEntry(data []byte) (flag byte, freshUntil time.Time, body []byte, err error) {
+	if len(data) < headerSize {
+		err = errInvalidEntry
+		return
+	}
+	flag = data[0]
+	freshUntil = time.UnixMilli(int64(binary.BigEndian.Uint64(data[1:headerSize])))
+	body = data[headerSize:]
+	return
+}
@@ -1,17 +1,25 @@
 package xfreecache
 
 import (
+	"math"
+	"sync/atomic"
+	"time"
+
 	"github.com/coocood/freecache"
+	"github.com/zhengyansheng/jupiter/pkg/cache"
 	"github.com/zhengyansheng/jupiter/pkg/xlog"
 	"go.uber.org/zap"
 )
 
 type localStorage[K comparable, V any] struct {
-	config *Config
+	config     *Config
+	counter    *cache.Counter
+	generation atomic.Uint64 // Purge时递增，freecache实例在进程内共享，旧的数据由freecache自行淘汰
 }
 
-func (l *localStorage[K, V]) setCacheData(key string, data []byte) (err error) {
-	err = l.config.Cache.Set([]byte(key), data, int(l.config.Expire.Seconds()))
+func (l *localStorage[K, V]) setCacheData(key string, data []byte, expire time.Duration) (err error) {
+	// freecache的失效时间精度为秒，不足1s按1s处理，避免0表示永不失效
+	err = l.config.Cache.Set([]byte(key), data, int(math.Ceil(expire.Seconds())))
 	if err != nil {
 		xlog.Jupiter().Error("cache SetCacheData", zap.String("data", string(data)), zap.Error(err))
 		if err == freecache.ErrLargeEntry || err == freecache.ErrLargeKey {
@@ -2,36 +2,62 @@ package xgolanglru
 
 import (
 	"fmt"
+	"sync/atomic"
+	"time"
+
 	"github.com/hashicorp/golang-lru/v2/expirable"
 	"github.com/samber/lo"
+	"github.com/zhengyansheng/jupiter/pkg/cache"
 	"github.com/zhengyansheng/jupiter/pkg/xlog"
 	"go.uber.org/zap"
-	"reflect"
 )
 
+// entry 缓存条目，notFound表示fn未返回该id
+type entry[V any] struct {
+	value      V
+	notFound   bool
+	freshUntil time.Time // 超过后为stale数据，需要后台刷新
+	expireAt   time.Time
+}
+
 type localStorage[K comparable, V any] struct {
-	config *Config
-	Cache  *expirable.LRU[string, V] // 本地缓存实例
+	config  *Config
+	Cache   *expirable.LRU[string, entry[V]] // 本地缓存实例
+	counter *cache.Counter
+	removed atomic.Uint64 // 所有被移除的条目数，包括主动删除
+	deleted atomic.Uint64 // 主动删除的条目数
+	sweptAt atomic.Int64  // 上次清理过期条目的时间
 }
 
 func (l *localStorage[K, V]) GetCacheMapOrigin(key string, ids []K) (v map[K]V, idsNone []K) {
-	var zero V
+	v, _, idsNone = l.GetCacheMapStale(key, ids)
+	return
+}
+
+func (l *localStorage[K, V]) GetCacheMapStale(key string, ids []K) (v map[K]V, idsStale []K, idsNone []K) {
 	v = make(map[K]V)
 	idsNone = make([]K, 0, len(ids))
+	now := time.Now()
 
 	// id去重
 	ids = lo.Uniq(ids)
 	for _, id := range ids {
 		cacheKey := l.getKey(key, id)
 		value, ok := l.Cache.Get(cacheKey)
-		if ok {
-			if !reflect.DeepEqual(value, zero) {
-				v[id] = value
-			}
-		} else {
+		if !ok || now.After(value.expireAt) {
 			idsNone = append(idsNone, id)
+			continue
+		}
+		if value.notFound {
+			continue
+		}
+		v[id] = value.value
+		if now.After(value.freshUntil) {
+			idsStale = append(idsStale, id)
 		}
 	}
+	l.counter.Hit(len(ids) - len(idsNone))
+	l.counter.Miss(len(idsNone))
 	return
 }
 
@@ -56,19 +82,96 @@ func (l *localStorage[K, V]) SetCacheMapOrigin(key string, idsNone []K, fn func(
 		}
 	}
 
-	// 写入缓存
+	// 写入缓存，fn未返回的id按照notFoundExpire缓存
+	now := time.Now()
 	for _, id := range idsNone {
-		var cacheData V
-		if val, ok := resMap[id]; ok {
-			cacheData = val
+		cacheData := entry[V]{
+			notFound: true,
+			expireAt: now.Add(l.config.NotFoundExpire),
+		}
+		if val, ok := resMap[id]; ok && !cache.IsNil(val) {
+			cacheData = entry[V]{
+				value:      val,
+				freshUntil: now.Add(l.config.Expire),
+				expireAt:   now.Add(l.config.Expire + l.config.StaleWhileRevalidate),
+			}
 		}
 
 		cacheKey := l.getKey(key, id)
 		l.Cache.Add(cacheKey, cacheData)
 	}
+	l.trySweep(now)
+	return
+}
+
+func (l *localStorage[K, V]) DelCacheMapOrigin(key string, ids []K) (err error) {
+	for _, id := range ids {
+		if l.Cache.Remove(l.getKey(key, id)) {
+			l.deleted.Add(1)
+		}
+	}
+	return
+}
+
+// SetCacheValueOrigin 按照ttl设置单条缓存数据，ttl可以超过expire，ttl<=0时使用expire
+func (l *localStorage[K, V]) SetCacheValueOrigin(key string, id K, value V, ttl time.Duration) (err error) {
+	if ttl <= 0 {
+		ttl = l.config.Expire
+	}
+	now := time.Now()
+	cacheData := entry[V]{
+		notFound: true,
+		expireAt: now.Add(ttl),
+	}
+	if !cache.IsNil(value) {
+		cacheData = entry[V]{
+			value:      value,
+			freshUntil: now.Add(ttl),
+			expireAt:   now.Add(ttl + l.config.StaleWhileRevalidate),
+		}
+	}
+	l.Cache.Add(l.getKey(key, id), cacheData)
+	l.trySweep(now)
+	return
+}
+
+func (l *localStorage[K, V]) Purge() (err error) {
+	l.deleted.Add(uint64(l.Cache.Len()))
+	l.Cache.Purge()
 	return
 }
 
+func (l *localStorage[K, V]) Stats() cache.Stats {
+	l.sweep(time.Now())
+	var evictions uint64
+	if removed, deleted := l.removed.Load(), l.deleted.Load(); removed > deleted {
+		evictions = removed - deleted
+	}
+	return l.counter.Stats(l.Cache.Len(), evictions)
+}
+
+// trySweep 距离上次清理超过expire时清理过期条目
+func (l *localStorage[K, V]) trySweep(now time.Time) {
+	last := l.sweptAt.Load()
+	if now.UnixNano()-last < int64(l.config.Expire) || !l.sweptAt.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+	l.sweep(now)
+}
+
+// sweep 删除已经过期的条目，LRU不设置ttl，过期的条目不会自动移除
+func (l *localStorage[K, V]) sweep(now time.Time) {
+	for _, key := range l.Cache.Keys() {
+		if value, ok := l.Cache.Peek(key); ok && now.After(value.expireAt) {
+			l.Cache.Remove(key)
+		}
+	}
+}
+
+func (l *localStorage[K, V]) onEvict(string, entry[V]) {
+	l.removed.Add(1)
+}
+
 func (l *localStorage[K, V]) getKey(key string, id K) string {
 	return fmt.Sprintf("%s:%v", key, id)
 }
@@ -12,10 +12,12 @@ import (
 )
 
 type Config struct {
-	Expire        time.Duration `json:"expire" toml:"expire"`               // 失效时间 【必填】
-	DisableMetric bool          `json:"disableMetric" toml:"disableMetric"` // metric上报 false 开启  ture 关闭【选填，默认开启】
-	Size          int           `json:"size" toml:"size"`                   // 缓存大小【选填，默认200000】
-	Name          string        `json:"-" toml:"-"`                         // 本地缓存名称，用于日志标识&metric上报【选填】
+	Expire               time.Duration `json:"expire" toml:"expire"`                             // 失效时间 【必填】
+	NotFoundExpire       time.Duration `json:"notFoundExpire" toml:"notFoundExpire"`             // fn未返回的id的失效时间【选填，默认与expire相同】
+	StaleWhileRevalidate time.Duration `json:"staleWhileRevalidate" toml:"staleWhileRevalidate"` // 失效后仍返回旧数据并在后台刷新的时间窗口【选填，默认关闭】
+	DisableMetric        bool          `json:"disableMetric" toml:"disableMetric"`               // metric上报 false 开启  ture 关闭【选填，默认开启】
+	Size                 int           `json:"size" toml:"size"`                                 // 缓存大小【选填，默认200000】
+	Name                 string        `json:"-" toml:"-"`                                       // 本地缓存名称，用于日志标识&metric上报【选填】
 }
 
 // DefaultConfig 返回默认配置
@@ -58,10 +60,14 @@ func New[K comparable, V any](c *Config) (localCache *cache.Cache[K, V]) {
 	if c.Size == 0 {
 		c.Size = 200000
 	}
-	return &cache.Cache[K, V]{
-		Storage: &localStorage[K, V]{
-			config: c,
-			Cache:  expirable.NewLRU[string, V](c.Size, nil, c.Expire),
-		},
+	if c.NotFoundExpire == 0 {
+		c.NotFoundExpire = c.Expire
 	}
+	storage := &localStorage[K, V]{
+		config:  c,
+		counter: cache.NewCounter("golanglru", c.Name, !c.DisableMetric),
+	}
+	// 条目自身记录失效时间，LRU不设置ttl，避免SetWithTTL指定的失效时间被截断，过期的条目在写入及统计时定期清理
+	storage.Cache = expirable.NewLRU[string, entry[V]](c.Size, storage.onEvict, 0)
+	return cache.New[K, V](storage)
 }
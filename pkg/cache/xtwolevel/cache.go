@@ -0,0 +1,324 @@
+package xtwolevel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/samber/lo"
+	"github.com/zhengyansheng/jupiter/pkg/cache"
+	"github.com/zhengyansheng/jupiter/pkg/core/metric"
+	"github.com/zhengyansheng/jupiter/pkg/xlog"
+	"go.uber.org/zap"
+)
+
+const (
+	levelLocal = "local"
+	levelRedis = "redis"
+
+	// notFoundMarker fn未返回的id在redis中的占位，json及proto的序列化结果都不会以0x00开头
+	notFoundMarker = "\x00"
+
+	// purgeBatch Purge时每批SCAN及UNLINK的key数量
+	purgeBatch = 500
+)
+
+// invalidation 失效广播的消息体
+type invalidation[K comparable] struct {
+	Origin string `json:"origin"`
+	Key    string `json:"key"`
+	Ids    []K    `json:"ids"`
+	Purge  bool   `json:"purge,omitempty"`
+}
+
+type twoLevelStorage[K comparable, V any] struct {
+	config *Config
+	local  *cache.Cache[K, V] // 一级本地缓存
+	client *redis.Client      // 二级redis缓存
+	origin string             // 实例标识，用于忽略自己发出的失效广播
+	pubsub *redis.PubSub
+	closed sync.Once
+
+	counter *cache.Counter
+}
+
+func newStorage[K comparable, V any](c *Config, local *cache.Cache[K, V], client *redis.Client) *twoLevelStorage[K, V] {
+	l := &twoLevelStorage[K, V]{
+		config: c,
+		local:  local,
+		client: client,
+		origin: fmt.Sprintf("%d-%d", os.Getpid(), time.Now().UnixNano()),
+		// 各级的命中情况由report上报，这里只做汇总
+		counter: cache.NewCounter("twolevel", c.Name, false),
+	}
+
+	// 等待订阅成功后再返回，避免丢失构建后立即发出的广播
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	l.pubsub = client.Subscribe(ctx, c.Channel)
+	if _, err := l.pubsub.Receive(ctx); err != nil {
+		xlog.Jupiter().Error("twoLevelCache subscribe", zap.String("channel", c.Channel), zap.Error(err))
+	}
+	return l
+}
+
+// GetCacheMapOrigin 依次读取本地缓存及redis，redis命中的数据回填到本地缓存
+func (l *twoLevelStorage[K, V]) GetCacheMapOrigin(key string, ids []K) (v map[K]V, idsNone []K) {
+	ids = lo.Uniq(ids)
+	v, idsNone = l.local.GetCacheMapOrigin(key, ids)
+	l.report(levelLocal, len(ids)-len(idsNone), len(idsNone))
+	defer func() {
+		l.counter.Hit(len(ids) - len(idsNone))
+		l.counter.Miss(len(idsNone))
+	}()
+	if len(idsNone) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(idsNone))
+	for _, id := range idsNone {
+		keys = append(keys, l.getKey(key, id))
+	}
+	values, err := l.client.MGet(context.Background(), keys...).Result()
+	if err != nil {
+		xlog.Jupiter().Error("twoLevelCache MGet", zap.String("key", key), zap.Error(err))
+		l.report(levelRedis, 0, len(idsNone))
+		return
+	}
+
+	found := make(map[K]V)
+	hits := make([]K, 0, len(idsNone))
+	missed := make([]K, 0, len(idsNone))
+	for i, id := range idsNone {
+		data, ok := values[i].(string)
+		if !ok {
+			missed = append(missed, id)
+			continue
+		}
+		if data == notFoundMarker {
+			hits = append(hits, id)
+			continue
+		}
+		value, innerErr := unmarshal[V]([]byte(data))
+		if innerErr != nil {
+			xlog.Jupiter().Error("twoLevelCache unmarshal", zap.String("key", key), zap.Error(innerErr))
+			missed = append(missed, id)
+			continue
+		}
+		found[id] = value
+		hits = append(hits, id)
+		v[id] = value
+	}
+	l.report(levelRedis, len(hits), len(missed))
+
+	// 回填本地缓存，未包含在found中的id在本地缓存中同样记为未找到
+	if len(hits) > 0 {
+		_ = l.local.SetCacheMapOrigin(key, hits, func([]K) (map[K]V, error) {
+			return found, nil
+		}, nil)
+	}
+	idsNone = missed
+	return
+}
+
+// SetCacheMapOrigin 执行fn后同时写入redis及本地缓存
+// v为nil时表示主动更新(SetCacheMap)，会广播失效消息，使其他实例的本地缓存失效
+func (l *twoLevelStorage[K, V]) SetCacheMapOrigin(key string, idsNone []K, fn func([]K) (map[K]V, error), v map[K]V) (err error) {
+	args := []zap.Field{zap.Any("key", key), zap.Any("ids", idsNone)}
+
+	if len(idsNone) == 0 {
+		return
+	}
+
+	// 执行函数
+	resMap, err := fn(idsNone)
+	if err != nil {
+		xlog.Jupiter().Error("twoLevelCache setCacheMap doMap", append(args, zap.Error(err))...)
+		return
+	}
+
+	// 填入返回中
+	if v != nil {
+		for k, value := range resMap {
+			v[k] = value
+		}
+	}
+
+	// 写入redis，fn未返回的id按照notFoundExpire缓存占位
+	pipe := l.client.Pipeline()
+	for _, id := range idsNone {
+		val, ok := resMap[id]
+		if !ok || cache.IsNil(val) {
+			pipe.Set(context.Background(), l.getKey(key, id), notFoundMarker, l.config.NotFoundExpire)
+			continue
+		}
+		var data []byte
+		data, err = marshal(val)
+		if err != nil {
+			xlog.Jupiter().Error("twoLevelCache Marshal", append(args, zap.Error(err))...)
+			return
+		}
+		pipe.Set(context.Background(), l.getKey(key, id), data, l.config.Expire)
+	}
+	if _, err = pipe.Exec(context.Background()); err != nil {
+		xlog.Jupiter().Error("twoLevelCache redis set", append(args, zap.Error(err))...)
+	}
+
+	// 写入本地缓存
+	_ = l.local.SetCacheMapOrigin(key, idsNone, func([]K) (map[K]V, error) {
+		return resMap, nil
+	}, nil)
+
+	if v == nil {
+		l.publish(invalidation[K]{Key: key, Ids: idsNone})
+	}
+	return
+}
+
+// DelCacheMapOrigin 删除redis及本地缓存，并广播失效消息
+func (l *twoLevelStorage[K, V]) DelCacheMapOrigin(key string, ids []K) (err error) {
+	if len(ids) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, l.getKey(key, id))
+	}
+	if err = l.client.Del(context.Background(), keys...).Err(); err != nil {
+		xlog.Jupiter().Error("twoLevelCache redis del", zap.Any("key", key), zap.Any("ids", ids), zap.Error(err))
+	}
+	_ = l.local.DelMany(key, ids)
+	l.publish(invalidation[K]{Key: key, Ids: ids})
+	return
+}
+
+// SetCacheValueOrigin 按照ttl写入redis及本地缓存，并广播失效消息，ttl<=0时分别使用各自配置的expire
+func (l *twoLevelStorage[K, V]) SetCacheValueOrigin(key string, id K, value V, ttl time.Duration) (err error) {
+	redisTTL := ttl
+	if redisTTL <= 0 {
+		redisTTL = l.config.Expire
+	}
+	data := []byte(notFoundMarker)
+	if !cache.IsNil(value) {
+		data, err = marshal(value)
+		if err != nil {
+			xlog.Jupiter().Error("twoLevelCache Marshal", zap.Any("key", key), zap.Any("id", id), zap.Error(err))
+			return
+		}
+	}
+	if err = l.client.Set(context.Background(), l.getKey(key, id), data, redisTTL).Err(); err != nil {
+		xlog.Jupiter().Error("twoLevelCache redis set", zap.Any("key", key), zap.Any("id", id), zap.Error(err))
+	}
+	_ = l.local.SetWithTTL(key, id, value, ttl)
+	l.publish(invalidation[K]{Key: key, Ids: []K{id}})
+	return
+}
+
+// Purge 删除redis中KeyPrefix下所有的key，并清空所有实例的本地缓存
+func (l *twoLevelStorage[K, V]) Purge() (err error) {
+	ctx := context.Background()
+	iter := l.client.Scan(ctx, 0, escapePattern(l.config.KeyPrefix)+"*", purgeBatch).Iterator()
+	keys := make([]string, 0, purgeBatch)
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+		if len(keys) == purgeBatch {
+			if err = l.client.Unlink(ctx, keys...).Err(); err != nil {
+				break
+			}
+			keys = keys[:0]
+		}
+	}
+	if err == nil {
+		err = iter.Err()
+	}
+	if err == nil && len(keys) > 0 {
+		err = l.client.Unlink(ctx, keys...).Err()
+	}
+	if err != nil {
+		xlog.Jupiter().Error("twoLevelCache redis purge", zap.String("prefix", l.config.KeyPrefix), zap.Error(err))
+	}
+
+	_ = l.local.Purge()
+	l.publish(invalidation[K]{Purge: true})
+	return
+}
+
+// Stats hits包含本地缓存及redis的命中，entries及evictions为本地缓存的统计
+func (l *twoLevelStorage[K, V]) Stats() cache.Stats {
+	local := l.local.Stats()
+	return l.counter.Stats(local.Entries, local.Evictions)
+}
+
+// Close 取消失效广播的订阅并停止subscribe，不会关闭redis客户端及本地缓存
+func (l *twoLevelStorage[K, V]) Close() (err error) {
+	l.closed.Do(func() {
+		_ = l.pubsub.Unsubscribe(context.Background(), l.config.Channel)
+		err = l.pubsub.Close()
+	})
+	return
+}
+
+func (l *twoLevelStorage[K, V]) publish(inv invalidation[K]) {
+	inv.Origin = l.origin
+	data, err := json.Marshal(inv)
+	if err != nil {
+		xlog.Jupiter().Error("twoLevelCache marshal invalidation", zap.String("key", inv.Key), zap.Error(err))
+		return
+	}
+	if err := l.client.Publish(context.Background(), l.config.Channel, data).Err(); err != nil {
+		xlog.Jupiter().Error("twoLevelCache publish invalidation", zap.String("key", inv.Key), zap.Error(err))
+	}
+}
+
+// subscribe 接收其他实例的失效广播，删除本地缓存，Close后退出
+func (l *twoLevelStorage[K, V]) subscribe() {
+	for msg := range l.pubsub.Channel() {
+		var inv invalidation[K]
+		if err := json.Unmarshal([]byte(msg.Payload), &inv); err != nil {
+			xlog.Jupiter().Error("twoLevelCache unmarshal invalidation", zap.String("payload", msg.Payload), zap.Error(err))
+			continue
+		}
+		if inv.Origin == l.origin {
+			continue
+		}
+		if inv.Purge {
+			_ = l.local.Purge()
+			continue
+		}
+		_ = l.local.DelMany(inv.Key, inv.Ids)
+	}
+}
+
+func (l *twoLevelStorage[K, V]) report(level string, hit, miss int) {
+	if l.config.DisableMetric {
+		return
+	}
+	if hit > 0 {
+		metric.CacheHandleCounter.Add(float64(hit), level, l.config.Name, "get", metric.CodeCacheHit)
+	}
+	if miss > 0 {
+		metric.CacheHandleCounter.Add(float64(miss), level, l.config.Name, "get", metric.CodeCacheMiss)
+	}
+}
+
+func (l *twoLevelStorage[K, V]) getKey(key string, id K) string {
+	return fmt.Sprintf("%s%s:%v", l.config.KeyPrefix, key, id)
+}
+
+// escapePattern 转义SCAN MATCH中的通配符
+func escapePattern(prefix string) string {
+	var b strings.Builder
+	for _, r := range prefix {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,155 @@
+package xtwolevel
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhengyansheng/jupiter/pkg/cache"
+	"github.com/zhengyansheng/jupiter/pkg/cache/xgolanglru"
+)
+
+type Student struct {
+	Age  int
+	Name string
+}
+
+func newTestCache(t *testing.T, mr *miniredis.Miniredis) *cache.Cache[int, Student] {
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	local := xgolanglru.New[int, Student](&xgolanglru.Config{Expire: time.Minute})
+	c := New[int, Student](&Config{Expire: time.Minute, Name: "test"}, local, client)
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+func Test_twoLevelStorage(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cacheA := newTestCache(t, mr)
+	cacheB := newTestCache(t, mr)
+
+	missCount := 0
+	load := func(stu Student) func() (Student, error) {
+		return func() (Student, error) {
+			missCount++
+			return stu, nil
+		}
+	}
+
+	t.Run("read through redis", func(t *testing.T) {
+		res, err := cacheA.GetAndSetCacheData("mytest", 1, load(Student{Age: 1, Name: "Student 1"}))
+		assert.Nil(t, err)
+		assert.Equal(t, "Student 1", res.Name)
+		assert.True(t, mr.Exists("jupiter:cache:test:mytest:1"))
+
+		res, err = cacheB.GetAndSetCacheData("mytest", 1, load(Student{Age: 1, Name: "Student 2"}))
+		assert.Nil(t, err)
+		assert.Equal(t, "Student 1", res.Name)
+		assert.Equal(t, 1, missCount)
+
+		// 本地缓存命中，redis中的数据被删除也不影响
+		mr.Del("jupiter:cache:test:mytest:1")
+		assert.Equal(t, "Student 1", cacheB.GetCacheValue("mytest", 1).Name)
+	})
+
+	t.Run("set broadcasts invalidation", func(t *testing.T) {
+		assert.Nil(t, cacheA.SetCacheValue("mytest", 1, load(Student{Age: 1, Name: "Student 3"})))
+		assert.Equal(t, "Student 3", cacheA.GetCacheValue("mytest", 1).Name)
+		assert.Eventually(t, func() bool {
+			return cacheB.GetCacheValue("mytest", 1).Name == "Student 3"
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("del broadcasts invalidation", func(t *testing.T) {
+		assert.Nil(t, cacheB.Del("mytest", 1))
+		assert.False(t, mr.Exists("jupiter:cache:test:mytest:1"))
+		assert.Equal(t, Student{}, cacheB.GetCacheValue("mytest", 1))
+		assert.Eventually(t, func() bool {
+			return cacheA.GetCacheValue("mytest", 1) == Student{}
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("zero value cached in both levels", func(t *testing.T) {
+		res, err := cacheA.GetAndSetCacheMap("mytest", []int{2, 3}, func(ids []int) (map[int]Student, error) {
+			missCount++
+			return map[int]Student{2: {Age: 2, Name: "Student 2"}}, nil
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(res))
+
+		v, idsNone := cacheB.GetCacheMapOrigin("mytest", []int{2, 3})
+		assert.Equal(t, map[int]Student{2: {Age: 2, Name: "Student 2"}}, v)
+		assert.Empty(t, idsNone)
+	})
+}
+
+func Test_twoLevelStorage_Purge(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cacheA := newTestCache(t, mr)
+	cacheB := newTestCache(t, mr)
+
+	assert.Nil(t, cacheA.SetWithTTL("Test_Purge", 1, Student{Age: 1, Name: "Student 1"}, time.Minute))
+	assert.Equal(t, "Student 1", cacheB.GetCacheValue("Test_Purge", 1).Name)
+	for i := 2; i <= 100; i++ {
+		assert.Nil(t, mr.Set(fmt.Sprintf("jupiter:cache:test:Test_Purge:%d", i), "{}"))
+	}
+	assert.Nil(t, mr.Set("jupiter:cache:other:Test_Purge:1", "{}"))
+
+	assert.Nil(t, cacheA.Purge())
+	assert.Equal(t, []string{"jupiter:cache:other:Test_Purge:1"}, mr.Keys())
+	assert.Eventually(t, func() bool {
+		_, idsNone := cacheB.GetCacheMapOrigin("Test_Purge", []int{1})
+		return len(idsNone) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	stats := cacheB.Stats()
+	assert.Equal(t, "twolevel", stats.Type)
+	assert.True(t, stats.Hits >= 1)
+	assert.True(t, stats.Misses >= 1)
+}
+
+func Test_twoLevelStorage_Close(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cacheA := newTestCache(t, mr)
+	cacheB := newTestCache(t, mr)
+	assert.Equal(t, 2, mr.PubSubNumSub("jupiter:cache:invalidate:test")["jupiter:cache:invalidate:test"])
+
+	assert.Nil(t, cacheB.Close())
+	assert.Nil(t, cacheB.Close())
+	assert.Eventually(t, func() bool {
+		return mr.PubSubNumSub("jupiter:cache:invalidate:test")["jupiter:cache:invalidate:test"] == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// 关闭后不再接收失效广播，但仍可正常读写
+	assert.Nil(t, cacheB.SetWithTTL("Test_Close", 1, Student{Age: 1, Name: "Student 1"}, time.Minute))
+	assert.Nil(t, cacheA.SetWithTTL("Test_Close", 1, Student{Age: 1, Name: "Student 2"}, time.Minute))
+	assert.Equal(t, "Student 2", cacheA.GetCacheValue("Test_Close", 1).Name)
+	assert.Equal(t, "Student 1", cacheB.GetCacheValue("Test_Close", 1).Name)
+}
+
+func Test_escapePattern(t *testing.T) {
+	assert.Equal(t, "jupiter:cache:test:", escapePattern("jupiter:cache:test:"))
+	assert.Equal(t, `a\*b\?c\[d\]e\\`, escapePattern(`a*b?c[d]e\`))
+}
+
+func Test_New(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	local := xgolanglru.New[int, Student](&xgolanglru.Config{Expire: time.Minute})
+
+	assert.Panics(t, func() {
+		New[int, Student](&Config{}, local, client)
+	})
+	assert.Panics(t, func() {
+		New[int, Student](&Config{Expire: time.Minute}, &cache.Cache[int, Student]{}, client)
+	})
+
+	c := DefaultConfig()
+	assert.Nil(t, New[int, Student](c, local, client).Close())
+	assert.Equal(t, "jupiter:cache:invalidate:default", c.Channel)
+	assert.Equal(t, "jupiter:cache:default:", c.KeyPrefix)
+}
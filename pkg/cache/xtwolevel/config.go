@@ -0,0 +1,76 @@
+package xtwolevel
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/zhengyansheng/jupiter/pkg/cache"
+	cfg "github.com/zhengyansheng/jupiter/pkg/conf"
+	"github.com/zhengyansheng/jupiter/pkg/util/xgo"
+	"github.com/zhengyansheng/jupiter/pkg/xlog"
+	"go.uber.org/zap"
+)
+
+type Config struct {
+	Expire         time.Duration `json:"expire" toml:"expire"`                 // redis中的失效时间，本地缓存的失效时间由本地缓存自己的配置决定【必填】
+	NotFoundExpire time.Duration `json:"notFoundExpire" toml:"notFoundExpire"` // fn未返回的id在redis中的失效时间【选填，默认与expire相同】
+	Channel        string        `json:"channel" toml:"channel"`               // 失效广播的pub/sub频道【选填，默认jupiter:cache:invalidate:{name}】
+	KeyPrefix      string        `json:"keyPrefix" toml:"keyPrefix"`           // redis中key的前缀，Purge时删除该前缀下所有的key【选填，默认jupiter:cache:{name}:】
+	DisableMetric  bool          `json:"disableMetric" toml:"disableMetric"`   // metric上报 false 开启  ture 关闭【选填，默认开启】
+	Name           string        `json:"-" toml:"-"`                           // 缓存名称，用于日志标识&metric上报【选填】
+}
+
+// DefaultConfig 返回默认配置
+func DefaultConfig() *Config {
+	return &Config{
+		Expire:        10 * time.Minute,
+		DisableMetric: false,
+		Name:          "default",
+	}
+}
+
+// StdConfig 返回标准配置
+func StdConfig(name string) *Config {
+	config := DefaultConfig()
+	key := "jupiter.xtwolevel." + name
+	if err := cfg.UnmarshalKey(key, &config, cfg.TagName("toml")); err != nil {
+		xlog.Jupiter().Warn("twoLevelCache StdConfig unmarshal config",
+			zap.Error(err), zap.Any("key", key))
+	}
+	config.Name = name
+	return config
+}
+
+// StdNew 构建二级缓存实例，local为一级本地缓存，client一般为CmdOnMaster()返回的客户端
+func StdNew[K comparable, V any](name string, local *cache.Cache[K, V], client *redis.Client) (twoLevelCache *cache.Cache[K, V]) {
+	c := StdConfig(name)
+	return New[K, V](c, local, client)
+}
+
+// New 构建二级缓存实例，不再使用时需调用Close取消失效广播的订阅
+func New[K comparable, V any](c *Config, local *cache.Cache[K, V], client *redis.Client) (twoLevelCache *cache.Cache[K, V]) {
+	// 校验参数
+	if c.Expire == 0 {
+		xlog.Jupiter().Panic("twoLevelCache New expire err", zap.Any("config", c))
+	}
+	if local == nil || local.Storage == nil || client == nil {
+		xlog.Jupiter().Panic("twoLevelCache New local or redis client nil", zap.Any("config", c))
+	}
+	if len(c.Name) == 0 {
+		c.Name = fmt.Sprintf("cache-%d", time.Now().UnixNano())
+	}
+	if c.NotFoundExpire == 0 {
+		c.NotFoundExpire = c.Expire
+	}
+	if len(c.Channel) == 0 {
+		c.Channel = "jupiter:cache:invalidate:" + c.Name
+	}
+	if len(c.KeyPrefix) == 0 {
+		c.KeyPrefix = "jupiter:cache:" + c.Name + ":"
+	}
+
+	storage := newStorage[K, V](c, local, client)
+	xgo.Go(storage.subscribe)
+	return cache.New[K, V](storage)
+}
@@ -15,8 +15,10 @@
 package balancer
 
 import (
+	"encoding/json"
 	"errors"
-	"fmt"
+	"sort"
+	"sync"
 
 	"google.golang.org/grpc/attributes"
 	"google.golang.org/grpc/balancer"
@@ -24,6 +26,7 @@ import (
 	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/grpclog"
 	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
 )
 
 // NewBalancerBuilderV2 returns a base balancer builder configured by the provided config.
@@ -45,17 +48,22 @@ type baseBuilder struct {
 func (bb *baseBuilder) Build(cc balancer.ClientConn, opt balancer.BuildOptions) balancer.Balancer {
 	bal := &baseBalancer{
 		cc:              cc,
+		name:            bb.name,
+		target:          opt.Target.URL.String(),
 		v2PickerBuilder: bb.v2PickerBuilder,
 
 		subConns: make(map[resolver.Address]balancer.SubConn),
 		scStates: make(map[balancer.SubConn]connectivity.State),
 		csEvltr:  &balancer.ConnectivityStateEvaluator{},
 		config:   bb.config,
+		state:    connectivity.Connecting,
 	}
 	// Initialize picker to a picker that always returns
 	// ErrNoSubConnAvailable, because when state of a SubConn changes, we
 	// may call UpdateState with this picker.
 	bal.v2Picker = NewErrPickerV2(balancer.ErrNoSubConnAvailable)
+	bal.saveState()
+	balancers.Store(bal, struct{}{})
 	return bal
 }
 
@@ -64,10 +72,35 @@ func (bb *baseBuilder) Name() string {
 	return bb.name
 }
 
+type lbConfig struct {
+	serviceconfig.LoadBalancingConfig
+	OutlierDetection *OutlierConfig `json:"outlierDetection"`
+	picker           serviceconfig.LoadBalancingConfig
+}
+
+// ParseConfig parses the loadBalancingConfig of service config, outlierDetection
+// is parsed by base balancer, and the rest is parsed by picker builder
+func (bb *baseBuilder) ParseConfig(js json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	var config lbConfig
+	if err := json.Unmarshal(js, &config); err != nil {
+		return nil, err
+	}
+	if parser, ok := bb.v2PickerBuilder.(ConfigParser); ok {
+		picker, err := parser.ParseConfig(js)
+		if err != nil {
+			return nil, err
+		}
+		config.picker = picker
+	}
+	return &config, nil
+}
+
 var _ balancer.Balancer = (*baseBalancer)(nil) // Assert that we implement V2Balancer
 
 type baseBalancer struct {
 	cc              balancer.ClientConn
+	name            string
+	target          string
 	v2PickerBuilder PickerBuilder
 
 	csEvltr *balancer.ConnectivityStateEvaluator
@@ -78,6 +111,57 @@ type baseBalancer struct {
 	v2Picker   balancer.Picker
 	config     base.Config
 	attributes *attributes.Attributes
+	lbConfig   serviceconfig.LoadBalancingConfig
+	outlier    *outlierDetector
+
+	// snapshot of the balancer for governor, the fields above are only
+	// accessed by grpc serially
+	mu       sync.RWMutex
+	snapshot State
+}
+
+// State is the state of a base balancer
+type State struct {
+	Target   string         `json:"target"`
+	Name     string         `json:"name"`
+	State    string         `json:"state"`
+	SubConns []SubConnState `json:"subConns"`
+}
+
+// SubConnState is the state of a SubConn of balancer
+type SubConnState struct {
+	Addr  string `json:"addr"`
+	State string `json:"state"`
+}
+
+// State returns the snapshot of the balancer
+func (b *baseBalancer) State() State {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.snapshot
+}
+
+func (b *baseBalancer) saveState() {
+	state := State{
+		Target:   b.target,
+		Name:     b.name,
+		State:    b.state.String(),
+		SubConns: make([]SubConnState, 0, len(b.subConns)),
+	}
+	for addr, sc := range b.subConns {
+		st, ok := b.scStates[sc]
+		if !ok {
+			st = connectivity.Shutdown
+		}
+		state.SubConns = append(state.SubConns, SubConnState{Addr: addr.Addr, State: st.String()})
+	}
+	sort.Slice(state.SubConns, func(i, j int) bool {
+		return state.SubConns[i].Addr < state.SubConns[j].Addr
+	})
+
+	b.mu.Lock()
+	b.snapshot = state
+	b.mu.Unlock()
 }
 
 // HandleResolvedAddrs ...
@@ -87,14 +171,22 @@ func (b *baseBalancer) HandleResolvedAddrs(addrs []resolver.Address, err error)
 
 // ResolverError ...
 func (b *baseBalancer) ResolverError(err error) {
-	switch b.state {
-	case connectivity.TransientFailure, connectivity.Idle, connectivity.Connecting:
-		b.v2Picker = NewErrPickerV2(err)
+	defer b.saveState()
+	if len(b.subConns) == 0 {
+		b.state = connectivity.TransientFailure
+	}
+	if b.state != connectivity.TransientFailure {
+		// The picker will not change since the balancer does not currently
+		// report an error.
+		return
 	}
+	b.regeneratePicker(err)
+	b.cc.UpdateState(balancer.State{ConnectivityState: b.state, Picker: b.v2Picker})
 }
 
 // UpdateClientConnState ...
 func (b *baseBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
+	defer b.saveState()
 	// TODO: handle s.ResolverState.Err (log if not nil) once implemented.
 	// TODO: handle s.ResolverState.ServiceConfig?
 	if grpclog.V(2) {
@@ -102,8 +194,6 @@ func (b *baseBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
 	}
 	// addrsSet is the set converted from addrs, it's used for quick lookup of an address.
 	addrsSet := make(map[resolver.Address]struct{})
-	fmt.Printf("s.ResolverState.Addresses = %+v\n", s.ResolverState.Addresses)
-	fmt.Printf("s.ResolverState.Attributes = %+v\n", s.ResolverState.Attributes)
 	for _, a := range s.ResolverState.Addresses {
 		addrsSet[a] = struct{}{}
 		if _, ok := b.subConns[a]; !ok {
@@ -118,11 +208,19 @@ func (b *baseBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
 			}
 			b.subConns[a] = sc
 			b.scStates[sc] = connectivity.Idle
+			b.csEvltr.RecordTransition(connectivity.Shutdown, connectivity.Idle)
 			sc.Connect()
 		}
 	}
 
 	b.attributes = s.ResolverState.Attributes
+	b.lbConfig = nil
+	var outlierConfig *OutlierConfig
+	if config, ok := s.BalancerConfig.(*lbConfig); ok {
+		b.lbConfig = config.picker
+		outlierConfig = config.OutlierDetection
+	}
+	b.updateOutlierDetector(outlierConfig)
 
 	for a, sc := range b.subConns {
 		// a was removed by resolver.
@@ -133,6 +231,18 @@ func (b *baseBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
 			// The entry will be deleted in HandleSubConnStateChange.
 		}
 	}
+
+	// If resolver state contains no addresses, return an error so ClientConn
+	// will trigger re-resolve.
+	if len(s.ResolverState.Addresses) == 0 {
+		b.ResolverError(errors.New("produced zero addresses"))
+		return balancer.ErrBadResolverState
+	}
+
+	// the picker depends on the attributes and service info of addresses,
+	// so it's regenerated even if ready SubConns are not changed
+	b.regeneratePicker(nil)
+	b.cc.UpdateState(balancer.State{ConnectivityState: b.state, Picker: b.v2Picker})
 	return nil
 }
 
@@ -154,19 +264,48 @@ func (b *baseBalancer) regeneratePicker(err error) {
 		return
 	}
 	readySCs := make(map[balancer.SubConn]base.SubConnInfo)
+	subConns := make(map[balancer.SubConn]base.SubConnInfo)
+	addrs := make(map[balancer.SubConn]string)
 
 	// Filter out all ready SCs from full subConn map.
 	for addr, sc := range b.subConns {
+		subConns[sc] = base.SubConnInfo{Address: addr}
+		addrs[sc] = addr.Addr
 		if st, ok := b.scStates[sc]; ok && st == connectivity.Ready {
 			readySCs[sc] = base.SubConnInfo{Address: addr}
 		}
 	}
+	if b.outlier != nil {
+		b.outlier.update(addrs)
+	}
+	if len(readySCs) == 0 {
+		b.v2Picker = NewErrPickerV2(balancer.ErrNoSubConnAvailable)
+		return
+	}
 	b.v2Picker = b.v2PickerBuilder.Build(
 		PickerBuildInfo{
 			ReadySCs:   readySCs,
+			SubConns:   subConns,
+			Config:     b.lbConfig,
 			Attributes: b.attributes,
 		},
 	)
+	if b.outlier != nil {
+		b.v2Picker = newOutlierPicker(b.v2Picker, b.outlier, len(readySCs))
+	}
+}
+
+func (b *baseBalancer) updateOutlierDetector(config *OutlierConfig) {
+	switch {
+	case config == nil && b.outlier != nil:
+		b.outlier.close()
+		b.outlier = nil
+	case config != nil && b.outlier == nil:
+		b.outlier = newOutlierDetector(b.target, *config)
+		detectors.Store(b.outlier, struct{}{})
+	case config != nil:
+		b.outlier.setConfig(*config)
+	}
 }
 
 // HandleSubConnStateChange ...
@@ -176,6 +315,7 @@ func (b *baseBalancer) HandleSubConnStateChange(sc balancer.SubConn, s connectiv
 
 // UpdateSubConnState ...
 func (b *baseBalancer) UpdateSubConnState(sc balancer.SubConn, state balancer.SubConnState) {
+	defer b.saveState()
 	s := state.ConnectivityState
 	if grpclog.V(2) {
 		grpclog.Infof("base.baseBalancer: handle SubConn state change: %p, %v", sc, s)
@@ -187,6 +327,16 @@ func (b *baseBalancer) UpdateSubConnState(sc balancer.SubConn, state balancer.Su
 		}
 		return
 	}
+	if oldS == connectivity.TransientFailure &&
+		(s == connectivity.Connecting || s == connectivity.Idle) {
+		// Once a subconn enters TRANSIENT_FAILURE, ignore subsequent IDLE or
+		// CONNECTING transitions to prevent the aggregated state from being
+		// always CONNECTING when many backends exist but are all down.
+		if s == connectivity.Idle {
+			sc.Connect()
+		}
+		return
+	}
 	b.scStates[sc] = s
 	switch s {
 	case connectivity.Idle:
@@ -197,25 +347,24 @@ func (b *baseBalancer) UpdateSubConnState(sc balancer.SubConn, state balancer.Su
 		delete(b.scStates, sc)
 	}
 
-	oldAggrState := b.state
 	b.state = b.csEvltr.RecordTransition(oldS, s)
 
 	// Regenerate picker when one of the following happens:
-	//  - this sc became ready from not-ready
-	//  - this sc became not-ready from ready
-	//  - the aggregated state of balancer became TransientFailure from non-TransientFailure
-	//  - the aggregated state of balancer became non-TransientFailure from TransientFailure
+	//  - this sc entered or left ready
+	//  - the aggregated state of balancer is TransientFailure
+	//    (may need to update error message)
 	if (s == connectivity.Ready) != (oldS == connectivity.Ready) ||
-		(b.state == connectivity.TransientFailure) != (oldAggrState == connectivity.TransientFailure) {
+		b.state == connectivity.TransientFailure {
 		b.regeneratePicker(state.ConnectionError)
 	}
 
 	b.cc.UpdateState(balancer.State{ConnectivityState: b.state, Picker: b.v2Picker})
 }
 
-// Close is a nop because base balancer doesn't have internal state to clean up,
-// and it doesn't need to call RemoveSubConn for the SubConns.
+// Close closes the outlier detector, it doesn't need to call RemoveSubConn for the SubConns.
 func (b *baseBalancer) Close() {
+	b.updateOutlierDetector(nil)
+	balancers.Delete(b)
 }
 
 // NewErrPickerV2 returns a V2Picker that always returns err on Pick().
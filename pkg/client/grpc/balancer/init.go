@@ -0,0 +1,67 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balancer
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/zhengyansheng/jupiter/pkg/server/governor"
+)
+
+// outlier detectors of balancers, *outlierDetector => struct{}
+var detectors sync.Map
+
+// base balancers built by builders, *baseBalancer => struct{}
+var balancers sync.Map
+
+func init() {
+	governor.HandleFunc("/debug/grpc/balancer", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = jsoniter.NewEncoder(w).Encode(States())
+	})
+	governor.HandleFunc("/debug/grpc/outlier", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = jsoniter.NewEncoder(w).Encode(OutlierStates())
+	})
+}
+
+// OutlierStates returns the states of all alive outlier detectors, sorted by target
+func OutlierStates() []OutlierState {
+	states := make([]OutlierState, 0)
+	detectors.Range(func(key, _ interface{}) bool {
+		states = append(states, key.(*outlierDetector).State())
+		return true
+	})
+	sort.SliceStable(states, func(i, j int) bool {
+		return states[i].Target < states[j].Target
+	})
+	return states
+}
+
+// States returns the states of all alive base balancers, sorted by target
+func States() []State {
+	states := make([]State, 0)
+	balancers.Range(func(key, _ interface{}) bool {
+		states = append(states, key.(*baseBalancer).State())
+		return true
+	})
+	sort.SliceStable(states, func(i, j int) bool {
+		return states[i].Target < states[j].Target
+	})
+	return states
+}
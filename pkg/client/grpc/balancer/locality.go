@@ -0,0 +1,265 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balancer
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/smallnest/weighted"
+	"github.com/zhengyansheng/jupiter/pkg"
+	"github.com/zhengyansheng/jupiter/pkg/core/constant"
+	"github.com/zhengyansheng/jupiter/pkg/registry"
+	"github.com/zhengyansheng/jupiter/pkg/server"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/serviceconfig"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// NameLocality ...
+	NameLocality = "locality"
+
+	unknownLocality = "unknown"
+)
+
+func init() {
+	balancer.Register(
+		NewBalancerBuilderV2(NameLocality, &localityPickerBuilder{}, base.Config{HealthCheck: true}),
+	)
+}
+
+// LocalityConfig config of locality balancer
+// 优先选择同可用区(zone)的节点, 不满足阈值时依次降级到同地域(region)、全部节点;
+// 流量默认限制在调用方所在的部署组(deployment)内
+type LocalityConfig struct {
+	// Zone 调用方可用区, 默认为 pkg.AppZone()
+	Zone string `json:"zone"`
+	// Region 调用方地域, 默认为 pkg.AppRegion()
+	Region string `json:"region"`
+	// Deployment 调用方部署组, 默认为 pkg.AppDeployment()
+	Deployment string `json:"deployment"`
+	// MinReadyNodes 某一层级可用节点数少于该值时降级到下一层级, 默认为1
+	MinReadyNodes int `json:"minReadyNodes"`
+	// MinReadyRatio 某一层级可用节点占比(ready/total)低于该值时降级到下一层级, 默认为0
+	MinReadyRatio float64 `json:"minReadyRatio"`
+	// StrictDeployment 部署组内没有节点时直接返回错误, 而不是使用全部节点
+	StrictDeployment bool `json:"strictDeployment"`
+}
+
+type localityLBConfig struct {
+	serviceconfig.LoadBalancingConfig
+	LocalityConfig
+}
+
+func (config LocalityConfig) normalize() LocalityConfig {
+	if config.Zone == "" {
+		config.Zone = pkg.AppZone()
+	}
+	if config.Region == "" {
+		config.Region = pkg.AppRegion()
+	}
+	if config.Deployment == "" {
+		config.Deployment = pkg.AppDeployment()
+	}
+	if config.Zone == unknownLocality {
+		config.Zone = ""
+	}
+	if config.Region == unknownLocality {
+		config.Region = ""
+	}
+	if config.Deployment == unknownLocality {
+		config.Deployment = ""
+	}
+	if config.MinReadyNodes <= 0 {
+		config.MinReadyNodes = 1
+	}
+	return config
+}
+
+type localityPickerBuilder struct{}
+
+// ParseConfig ...
+func (localityPickerBuilder) ParseConfig(js json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	var config localityLBConfig
+	if err := json.Unmarshal(js, &config.LocalityConfig); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// Build ...
+func (localityPickerBuilder) Build(info PickerBuildInfo) balancer.Picker {
+	var config LocalityConfig
+	if lbConfig, ok := info.Config.(*localityLBConfig); ok {
+		config = lbConfig.LocalityConfig
+	}
+	return newLocalityPicker(config.normalize(), info)
+}
+
+type localityNode struct {
+	subConn balancer.SubConn
+	info    server.ServiceInfo
+	ready   bool
+}
+
+type localityBucket struct {
+	sw  *weighted.SW
+	err error
+}
+
+type localityPicker struct {
+	mu           sync.Mutex
+	config       LocalityConfig
+	buckets      *localityBucket
+	routeBuckets map[string]*localityBucket
+}
+
+func newLocalityPicker(config LocalityConfig, info PickerBuildInfo) *localityPicker {
+	subConns := info.SubConns
+	if subConns == nil {
+		subConns = info.ReadySCs
+	}
+
+	nodes := make([]localityNode, 0, len(subConns))
+	for subConn, scInfo := range subConns {
+		node := localityNode{subConn: subConn}
+		_, node.ready = info.ReadySCs[subConn]
+		if scInfo.Address.Attributes != nil {
+			node.info, _ = scInfo.Address.Attributes.Value(constant.KeyServiceInfo).(server.ServiceInfo)
+		}
+		nodes = append(nodes, node)
+	}
+
+	picker := &localityPicker{
+		config:       config,
+		routeBuckets: map[string]*localityBucket{},
+	}
+	picker.buckets = picker.buildBucket(nodes, config.Deployment)
+
+	if info.Attributes == nil {
+		return picker
+	}
+
+	// 路由配置中的部署组优先于调用方部署组
+	routeConfigs, _ := info.Attributes.Value(constant.KeyRouteConfig).(map[string]registry.RouteConfig)
+	for _, routeConfig := range routeConfigs {
+		if routeConfig.URI == "" || routeConfig.Deployment == "" {
+			continue
+		}
+		picker.routeBuckets[routeConfig.URI] = picker.buildBucket(nodes, routeConfig.Deployment)
+	}
+	return picker
+}
+
+func (p *localityPicker) buildBucket(nodes []localityNode, deployment string) *localityBucket {
+	candidates := nodes
+	if deployment != "" {
+		candidates = filterNodes(nodes, func(info server.ServiceInfo) bool {
+			return info.Deployment == deployment
+		})
+		if len(candidates) == 0 {
+			if p.config.StrictDeployment {
+				return &localityBucket{
+					err: status.Errorf(codes.Unavailable, "no available node in deployment %s", deployment),
+				}
+			}
+			candidates = nodes
+		}
+	}
+
+	var tiers [][]localityNode
+	if p.config.Zone != "" {
+		tiers = append(tiers, filterNodes(candidates, func(info server.ServiceInfo) bool {
+			return info.Zone == p.config.Zone
+		}))
+	}
+	if p.config.Region != "" {
+		tiers = append(tiers, filterNodes(candidates, func(info server.ServiceInfo) bool {
+			return info.Region == p.config.Region
+		}))
+	}
+
+	selected := candidates
+	for _, tier := range tiers {
+		if p.satisfied(tier) {
+			selected = tier
+			break
+		}
+	}
+
+	sw := &weighted.SW{}
+	for _, node := range selected {
+		if !node.ready {
+			continue
+		}
+		weight := int(node.info.Weight)
+		if weight <= 0 {
+			weight = 1
+		}
+		sw.Add(node.subConn, weight)
+	}
+	return &localityBucket{sw: sw}
+}
+
+// satisfied 判断某一层级的可用节点是否满足阈值
+func (p *localityPicker) satisfied(nodes []localityNode) bool {
+	if len(nodes) == 0 {
+		return false
+	}
+
+	var ready int
+	for _, node := range nodes {
+		if node.ready {
+			ready++
+		}
+	}
+	if ready < p.config.MinReadyNodes {
+		return false
+	}
+	return float64(ready)/float64(len(nodes)) >= p.config.MinReadyRatio
+}
+
+func filterNodes(nodes []localityNode, match func(info server.ServiceInfo) bool) []localityNode {
+	filtered := make([]localityNode, 0, len(nodes))
+	for _, node := range nodes {
+		if match(node.info) {
+			filtered = append(filtered, node)
+		}
+	}
+	return filtered
+}
+
+// Pick ...
+func (p *localityPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	bucket := p.buckets
+	if bs, ok := p.routeBuckets[info.FullMethodName]; ok {
+		bucket = bs
+	}
+	if bucket.err != nil {
+		return balancer.PickResult{}, bucket.err
+	}
+
+	sub, ok := bucket.sw.Next().(balancer.SubConn)
+	if !ok {
+		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
+	}
+	return balancer.PickResult{SubConn: sub}, nil
+}
@@ -0,0 +1,198 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balancer
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zhengyansheng/jupiter/pkg/core/constant"
+	"github.com/zhengyansheng/jupiter/pkg/registry"
+	"github.com/zhengyansheng/jupiter/pkg/server"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
+)
+
+type fakeSubConn struct {
+	balancer.SubConn
+	addr string
+}
+
+type fakeNode struct {
+	server.ServiceInfo
+	ready bool
+}
+
+func newBuildInfo(nodes ...fakeNode) PickerBuildInfo {
+	info := PickerBuildInfo{
+		ReadySCs: map[balancer.SubConn]base.SubConnInfo{},
+		SubConns: map[balancer.SubConn]base.SubConnInfo{},
+	}
+	for _, node := range nodes {
+		sc := &fakeSubConn{addr: node.Address}
+		scInfo := base.SubConnInfo{Address: resolver.Address{
+			Addr:       node.Address,
+			Attributes: attributes.New(constant.KeyServiceInfo, node.ServiceInfo),
+		}}
+		info.SubConns[sc] = scInfo
+		if node.ready {
+			info.ReadySCs[sc] = scInfo
+		}
+	}
+	return info
+}
+
+func pickAddrs(t *testing.T, picker balancer.Picker, method string, n int) map[string]int {
+	addrs := map[string]int{}
+	for i := 0; i < n; i++ {
+		res, err := picker.Pick(balancer.PickInfo{FullMethodName: method})
+		require.NoError(t, err)
+		addrs[res.SubConn.(*fakeSubConn).addr]++
+	}
+	return addrs
+}
+
+func node(addr, region, zone, deployment string, ready bool) fakeNode {
+	return fakeNode{
+		ServiceInfo: server.ServiceInfo{Address: addr, Region: region, Zone: zone, Deployment: deployment},
+		ready:       ready,
+	}
+}
+
+func Test_localityPicker(t *testing.T) {
+	caller := LocalityConfig{Region: "bj", Zone: "bj-1", Deployment: "blue"}
+
+	t.Run("prefer same zone", func(t *testing.T) {
+		info := newBuildInfo(
+			node("10.0.0.1:9090", "bj", "bj-1", "", true),
+			node("10.0.0.2:9090", "bj", "bj-2", "", true),
+			node("10.0.0.3:9090", "sh", "sh-1", "", true),
+		)
+		picker := newLocalityPicker(LocalityConfig{Region: "bj", Zone: "bj-1"}.normalize(), info)
+		assert.Equal(t, map[string]int{"10.0.0.1:9090": 10}, pickAddrs(t, picker, "", 10))
+	})
+
+	t.Run("failover to same region", func(t *testing.T) {
+		info := newBuildInfo(
+			node("10.0.0.1:9090", "bj", "bj-1", "", false),
+			node("10.0.0.2:9090", "bj", "bj-2", "", true),
+			node("10.0.0.3:9090", "sh", "sh-1", "", true),
+		)
+		picker := newLocalityPicker(LocalityConfig{Region: "bj", Zone: "bj-1"}.normalize(), info)
+		assert.Equal(t, map[string]int{"10.0.0.2:9090": 10}, pickAddrs(t, picker, "", 10))
+	})
+
+	t.Run("failover to any node", func(t *testing.T) {
+		info := newBuildInfo(
+			node("10.0.0.1:9090", "bj", "bj-1", "", false),
+			node("10.0.0.3:9090", "sh", "sh-1", "", true),
+		)
+		picker := newLocalityPicker(LocalityConfig{Region: "bj", Zone: "bj-1"}.normalize(), info)
+		assert.Equal(t, map[string]int{"10.0.0.3:9090": 10}, pickAddrs(t, picker, "", 10))
+	})
+
+	t.Run("ready ratio threshold", func(t *testing.T) {
+		info := newBuildInfo(
+			node("10.0.0.1:9090", "bj", "bj-1", "", true),
+			node("10.0.0.2:9090", "bj", "bj-1", "", false),
+			node("10.0.0.3:9090", "bj", "bj-1", "", false),
+			node("10.0.0.4:9090", "bj", "bj-2", "", true),
+		)
+		picker := newLocalityPicker(LocalityConfig{Region: "bj", Zone: "bj-1", MinReadyRatio: 0.5}.normalize(), info)
+		assert.Equal(t, map[string]int{"10.0.0.1:9090": 5, "10.0.0.4:9090": 5}, pickAddrs(t, picker, "", 10))
+	})
+
+	t.Run("min ready nodes threshold", func(t *testing.T) {
+		info := newBuildInfo(
+			node("10.0.0.1:9090", "bj", "bj-1", "", true),
+			node("10.0.0.2:9090", "bj", "bj-2", "", true),
+		)
+		picker := newLocalityPicker(LocalityConfig{Region: "bj", Zone: "bj-1", MinReadyNodes: 2}.normalize(), info)
+		assert.Equal(t, map[string]int{"10.0.0.1:9090": 5, "10.0.0.2:9090": 5}, pickAddrs(t, picker, "", 10))
+	})
+
+	t.Run("deployment isolation", func(t *testing.T) {
+		info := newBuildInfo(
+			node("10.0.0.1:9090", "bj", "bj-1", "green", true),
+			node("10.0.0.2:9090", "bj", "bj-2", "blue", true),
+		)
+		picker := newLocalityPicker(caller.normalize(), info)
+		assert.Equal(t, map[string]int{"10.0.0.2:9090": 10}, pickAddrs(t, picker, "", 10))
+	})
+
+	t.Run("deployment fallback", func(t *testing.T) {
+		info := newBuildInfo(
+			node("10.0.0.1:9090", "bj", "bj-1", "green", true),
+		)
+		picker := newLocalityPicker(caller.normalize(), info)
+		assert.Equal(t, map[string]int{"10.0.0.1:9090": 10}, pickAddrs(t, picker, "", 10))
+	})
+
+	t.Run("strict deployment", func(t *testing.T) {
+		info := newBuildInfo(
+			node("10.0.0.1:9090", "bj", "bj-1", "green", true),
+		)
+		config := caller
+		config.StrictDeployment = true
+		picker := newLocalityPicker(config.normalize(), info)
+		_, err := picker.Pick(balancer.PickInfo{})
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+	})
+
+	t.Run("route deployment", func(t *testing.T) {
+		info := newBuildInfo(
+			node("10.0.0.1:9090", "bj", "bj-1", "green", true),
+			node("10.0.0.2:9090", "bj", "bj-1", "blue", true),
+		)
+		info.Attributes = attributes.New(constant.KeyRouteConfig, map[string]registry.RouteConfig{
+			"/helloworld.Greeter/SayHello": {URI: "/helloworld.Greeter/SayHello", Deployment: "green"},
+		})
+		picker := newLocalityPicker(caller.normalize(), info)
+		assert.Equal(t, map[string]int{"10.0.0.1:9090": 10}, pickAddrs(t, picker, "/helloworld.Greeter/SayHello", 10))
+		assert.Equal(t, map[string]int{"10.0.0.2:9090": 10}, pickAddrs(t, picker, "/helloworld.Greeter/SayHi", 10))
+	})
+
+	t.Run("weighted", func(t *testing.T) {
+		heavy := node("10.0.0.1:9090", "bj", "bj-1", "", true)
+		heavy.Weight = 3
+		info := newBuildInfo(heavy, node("10.0.0.2:9090", "bj", "bj-1", "", true))
+		picker := newLocalityPicker(caller.normalize(), info)
+		assert.Equal(t, map[string]int{"10.0.0.1:9090": 6, "10.0.0.2:9090": 2}, pickAddrs(t, picker, "", 8))
+	})
+}
+
+func Test_localityPickerBuilder_ParseConfig(t *testing.T) {
+	js, err := json.Marshal(LocalityConfig{Zone: "bj-1", MinReadyNodes: 2, StrictDeployment: true})
+	require.NoError(t, err)
+
+	lbConfig, err := localityPickerBuilder{}.ParseConfig(js)
+	require.NoError(t, err)
+
+	config := lbConfig.(*localityLBConfig).LocalityConfig
+	assert.Equal(t, "bj-1", config.Zone)
+	assert.Equal(t, 2, config.MinReadyNodes)
+	assert.True(t, config.StrictDeployment)
+
+	info := newBuildInfo(node("10.0.0.1:9090", "bj", "bj-1", "", true))
+	info.Config = lbConfig
+	picker := localityPickerBuilder{}.Build(info).(*localityPicker)
+	assert.Equal(t, 2, picker.config.MinReadyNodes)
+}
@@ -0,0 +1,370 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balancer
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zhengyansheng/jupiter/pkg/core/metric"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	ejectReasonConsecutiveErrors = "consecutive_errors"
+	ejectReasonSuccessRate       = "success_rate"
+)
+
+// OutlierConfig config of outlier detection
+// 根据调用结果被动探测异常节点, 摘除一段时间后自动恢复
+type OutlierConfig struct {
+	// Interval 成功率统计周期, 默认10s
+	Interval time.Duration `json:"interval"`
+	// BaseEjectionTime 基础摘除时长, 第n次连续摘除的时长为 n*BaseEjectionTime, 默认30s
+	BaseEjectionTime time.Duration `json:"baseEjectionTime"`
+	// MaxEjectionTime 最大摘除时长, 默认300s
+	MaxEjectionTime time.Duration `json:"maxEjectionTime"`
+	// MaxEjectionPercent 最多摘除的节点百分比, 默认10, 至少允许摘除一个节点
+	MaxEjectionPercent int `json:"maxEjectionPercent"`
+	// ConsecutiveErrors 连续错误次数达到该值时摘除, 默认5
+	ConsecutiveErrors int `json:"consecutiveErrors"`
+	// SuccessRateMinimumRequests 统计周期内请求数不少于该值时才根据成功率摘除, 默认100
+	SuccessRateMinimumRequests int `json:"successRateMinimumRequests"`
+	// SuccessRateThreshold 统计周期内成功率低于该值时摘除, 默认0.5
+	SuccessRateThreshold float64 `json:"successRateThreshold"`
+}
+
+// DefaultOutlierConfig ...
+func DefaultOutlierConfig() OutlierConfig {
+	return OutlierConfig{
+		Interval:                   10 * time.Second,
+		BaseEjectionTime:           30 * time.Second,
+		MaxEjectionTime:            300 * time.Second,
+		MaxEjectionPercent:         10,
+		ConsecutiveErrors:          5,
+		SuccessRateMinimumRequests: 100,
+		SuccessRateThreshold:       0.5,
+	}
+}
+
+func (config OutlierConfig) normalize() OutlierConfig {
+	defaults := DefaultOutlierConfig()
+	if config.Interval <= 0 {
+		config.Interval = defaults.Interval
+	}
+	if config.BaseEjectionTime <= 0 {
+		config.BaseEjectionTime = defaults.BaseEjectionTime
+	}
+	if config.MaxEjectionTime < config.BaseEjectionTime {
+		config.MaxEjectionTime = defaults.MaxEjectionTime
+		if config.MaxEjectionTime < config.BaseEjectionTime {
+			config.MaxEjectionTime = config.BaseEjectionTime
+		}
+	}
+	if config.MaxEjectionPercent <= 0 {
+		config.MaxEjectionPercent = defaults.MaxEjectionPercent
+	}
+	if config.ConsecutiveErrors <= 0 {
+		config.ConsecutiveErrors = defaults.ConsecutiveErrors
+	}
+	if config.SuccessRateMinimumRequests <= 0 {
+		config.SuccessRateMinimumRequests = defaults.SuccessRateMinimumRequests
+	}
+	if config.SuccessRateThreshold <= 0 {
+		config.SuccessRateThreshold = defaults.SuccessRateThreshold
+	}
+	return config
+}
+
+// OutlierState ...
+type OutlierState struct {
+	Target string             `json:"target"`
+	Config OutlierConfig      `json:"config"`
+	Nodes  []OutlierNodeState `json:"nodes"`
+}
+
+// OutlierNodeState ...
+type OutlierNodeState struct {
+	Addr              string    `json:"addr"`
+	Requests          int64     `json:"requests"`
+	Errors            int64     `json:"errors"`
+	ConsecutiveErrors int       `json:"consecutiveErrors"`
+	Ejected           bool      `json:"ejected"`
+	EjectedAt         time.Time `json:"ejectedAt,omitempty"`
+	EjectedUntil      time.Time `json:"ejectedUntil,omitempty"`
+	Ejections         int       `json:"ejections"`
+	Reason            string    `json:"reason,omitempty"`
+}
+
+type outlierNode struct {
+	addr        string
+	requests    int64
+	errors      int64
+	consecutive int
+	ejected     bool
+	ejectedAt   time.Time
+	ejections   int
+	reason      string
+}
+
+type outlierDetector struct {
+	mu       sync.Mutex
+	target   string
+	config   OutlierConfig
+	nodes    map[balancer.SubConn]*outlierNode
+	lastEval time.Time
+	now      func() time.Time
+}
+
+func newOutlierDetector(target string, config OutlierConfig) *outlierDetector {
+	return &outlierDetector{
+		target:   target,
+		config:   config.normalize(),
+		nodes:    map[balancer.SubConn]*outlierNode{},
+		lastEval: time.Now(),
+		now:      time.Now,
+	}
+}
+
+func (d *outlierDetector) setConfig(config OutlierConfig) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.config = config.normalize()
+}
+
+// update syncs the tracked nodes with the SubConns of balancer
+func (d *outlierDetector) update(subConns map[balancer.SubConn]string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for sc, addr := range subConns {
+		if _, ok := d.nodes[sc]; !ok {
+			d.nodes[sc] = &outlierNode{addr: addr}
+		}
+	}
+	for sc := range d.nodes {
+		if _, ok := subConns[sc]; !ok {
+			delete(d.nodes, sc)
+		}
+	}
+	d.reportEjected()
+}
+
+// record records the result of a call on sc
+func (d *outlierDetector) record(sc balancer.SubConn, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	node, ok := d.nodes[sc]
+	if !ok {
+		return
+	}
+	node.requests++
+	if !isOutlierError(err) {
+		node.consecutive = 0
+		return
+	}
+	node.errors++
+	node.consecutive++
+	if !node.ejected && node.consecutive >= d.config.ConsecutiveErrors {
+		d.eject(node, ejectReasonConsecutiveErrors, d.now())
+	}
+}
+
+// isEjected returns whether sc is ejected, and evaluates the nodes if interval elapsed
+func (d *outlierDetector) isEjected(sc balancer.SubConn) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	if now.Sub(d.lastEval) >= d.config.Interval {
+		d.evaluate(now)
+	}
+
+	node, ok := d.nodes[sc]
+	if !ok || !node.ejected {
+		return false
+	}
+	if !now.Before(d.ejectedUntil(node)) {
+		d.uneject(node)
+		return false
+	}
+	return true
+}
+
+func (d *outlierDetector) evaluate(now time.Time) {
+	d.lastEval = now
+	for _, node := range d.nodes {
+		switch {
+		case node.ejected:
+			if !now.Before(d.ejectedUntil(node)) {
+				d.uneject(node)
+			}
+		case node.requests >= int64(d.config.SuccessRateMinimumRequests) &&
+			float64(node.requests-node.errors)/float64(node.requests) < d.config.SuccessRateThreshold:
+			d.eject(node, ejectReasonSuccessRate, now)
+		case node.ejections > 0 && node.errors == 0:
+			// 一个周期内没有错误, 逐步降低摘除时长
+			node.ejections--
+		}
+		node.requests, node.errors = 0, 0
+	}
+}
+
+func (d *outlierDetector) ejectedUntil(node *outlierNode) time.Time {
+	duration := d.config.BaseEjectionTime * time.Duration(node.ejections)
+	if duration > d.config.MaxEjectionTime {
+		duration = d.config.MaxEjectionTime
+	}
+	return node.ejectedAt.Add(duration)
+}
+
+func (d *outlierDetector) eject(node *outlierNode, reason string, now time.Time) {
+	var ejected int
+	for _, n := range d.nodes {
+		if n.ejected {
+			ejected++
+		}
+	}
+	maxEjected := len(d.nodes) * d.config.MaxEjectionPercent / 100
+	if maxEjected < 1 {
+		maxEjected = 1
+	}
+	if ejected >= maxEjected {
+		return
+	}
+
+	node.ejected = true
+	node.ejectedAt = now
+	node.ejections++
+	node.reason = reason
+	metric.ClientOutlierEjectionCounter.Inc(metric.TypeGRPC, d.target, node.addr, reason)
+	d.reportEjected()
+}
+
+func (d *outlierDetector) uneject(node *outlierNode) {
+	node.ejected = false
+	node.consecutive = 0
+	node.reason = ""
+	d.reportEjected()
+}
+
+func (d *outlierDetector) reportEjected() {
+	var ejected int
+	for _, node := range d.nodes {
+		if node.ejected {
+			ejected++
+		}
+	}
+	metric.ClientOutlierEjectedGauge.Set(float64(ejected), metric.TypeGRPC, d.target)
+}
+
+// State returns the snapshot of detector
+func (d *outlierDetector) State() OutlierState {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	state := OutlierState{
+		Target: d.target,
+		Config: d.config,
+		Nodes:  make([]OutlierNodeState, 0, len(d.nodes)),
+	}
+	for _, node := range d.nodes {
+		nodeState := OutlierNodeState{
+			Addr:              node.addr,
+			Requests:          node.requests,
+			Errors:            node.errors,
+			ConsecutiveErrors: node.consecutive,
+			Ejected:           node.ejected,
+			Ejections:         node.ejections,
+			Reason:            node.reason,
+		}
+		if node.ejected {
+			nodeState.EjectedAt = node.ejectedAt
+			nodeState.EjectedUntil = d.ejectedUntil(node)
+		}
+		state.Nodes = append(state.Nodes, nodeState)
+	}
+	sort.Slice(state.Nodes, func(i, j int) bool {
+		return state.Nodes[i].Addr < state.Nodes[j].Addr
+	})
+	return state
+}
+
+func (d *outlierDetector) close() {
+	d.mu.Lock()
+	d.nodes = map[balancer.SubConn]*outlierNode{}
+	d.reportEjected()
+	d.mu.Unlock()
+	detectors.Delete(d)
+}
+
+// isOutlierError 只有服务端不可用或内部错误才被视为节点异常
+func isOutlierError(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.Internal:
+		return true
+	default:
+		return false
+	}
+}
+
+// outlierPicker skips the ejected SubConns picked by picker, and records the result of calls
+type outlierPicker struct {
+	picker   balancer.Picker
+	detector *outlierDetector
+	tries    int
+}
+
+func newOutlierPicker(picker balancer.Picker, detector *outlierDetector, tries int) *outlierPicker {
+	if tries < 1 {
+		tries = 1
+	}
+	return &outlierPicker{picker: picker, detector: detector, tries: tries}
+}
+
+// Pick ...
+func (p *outlierPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	var res balancer.PickResult
+	var err error
+	for i := 0; i < p.tries; i++ {
+		res, err = p.picker.Pick(info)
+		if err != nil {
+			return res, err
+		}
+		if !p.detector.isEjected(res.SubConn) || i == p.tries-1 {
+			break
+		}
+		// 节点已被摘除, 释放本次选择并重新选择
+		if res.Done != nil {
+			res.Done(balancer.DoneInfo{})
+		}
+	}
+
+	sc, done := res.SubConn, res.Done
+	res.Done = func(info balancer.DoneInfo) {
+		p.detector.record(sc, info.Err)
+		if done != nil {
+			done(info)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,206 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balancer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Add(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestDetector(config OutlierConfig, addrs ...string) (*outlierDetector, *fakeClock, []balancer.SubConn) {
+	clock := &fakeClock{now: time.Now()}
+	detector := newOutlierDetector("test", config)
+	detector.now = clock.Now
+	detector.lastEval = clock.Now()
+
+	subConns := make([]balancer.SubConn, 0, len(addrs))
+	scAddrs := map[balancer.SubConn]string{}
+	for _, addr := range addrs {
+		sc := &fakeSubConn{addr: addr}
+		subConns = append(subConns, sc)
+		scAddrs[sc] = addr
+	}
+	detector.update(scAddrs)
+	return detector, clock, subConns
+}
+
+func Test_outlierDetector_consecutiveErrors(t *testing.T) {
+	detector, clock, subConns := newTestDetector(OutlierConfig{
+		ConsecutiveErrors:  3,
+		BaseEjectionTime:   time.Second,
+		MaxEjectionTime:    3 * time.Second,
+		MaxEjectionPercent: 50,
+		Interval:           time.Hour,
+	}, "10.0.0.1:9090", "10.0.0.2:9090")
+	sc := subConns[0]
+	errUnavailable := status.Error(codes.Unavailable, "unavailable")
+
+	// 业务错误不计入
+	for i := 0; i < 5; i++ {
+		detector.record(sc, status.Error(codes.InvalidArgument, "invalid"))
+	}
+	assert.False(t, detector.isEjected(sc))
+
+	// 成功调用重置连续错误
+	detector.record(sc, errUnavailable)
+	detector.record(sc, errUnavailable)
+	detector.record(sc, nil)
+	detector.record(sc, errUnavailable)
+	assert.False(t, detector.isEjected(sc))
+
+	detector.record(sc, errUnavailable)
+	detector.record(sc, errUnavailable)
+	assert.True(t, detector.isEjected(sc))
+	assert.False(t, detector.isEjected(subConns[1]))
+
+	clock.Add(time.Second)
+	assert.False(t, detector.isEjected(sc))
+
+	// 再次摘除的时长递增
+	for i := 0; i < 3; i++ {
+		detector.record(sc, errUnavailable)
+	}
+	assert.True(t, detector.isEjected(sc))
+	clock.Add(time.Second)
+	assert.True(t, detector.isEjected(sc))
+	clock.Add(time.Second)
+	assert.False(t, detector.isEjected(sc))
+
+	state := detector.State()
+	assert.Equal(t, "test", state.Target)
+	require.Len(t, state.Nodes, 2)
+	assert.Equal(t, "10.0.0.1:9090", state.Nodes[0].Addr)
+	assert.Equal(t, 2, state.Nodes[0].Ejections)
+}
+
+func Test_outlierDetector_successRate(t *testing.T) {
+	detector, clock, subConns := newTestDetector(OutlierConfig{
+		ConsecutiveErrors:          100,
+		SuccessRateMinimumRequests: 10,
+		SuccessRateThreshold:       0.8,
+		MaxEjectionPercent:         50,
+		Interval:                   10 * time.Second,
+		BaseEjectionTime:           30 * time.Second,
+	}, "10.0.0.1:9090", "10.0.0.2:9090")
+
+	for i := 0; i < 10; i++ {
+		var err error
+		if i%2 == 0 {
+			err = status.Error(codes.Internal, "internal")
+		}
+		detector.record(subConns[0], err)
+		detector.record(subConns[1], nil)
+	}
+	assert.False(t, detector.isEjected(subConns[0]))
+
+	clock.Add(10 * time.Second)
+	assert.True(t, detector.isEjected(subConns[0]))
+	assert.False(t, detector.isEjected(subConns[1]))
+	assert.Equal(t, ejectReasonSuccessRate, detector.State().Nodes[0].Reason)
+
+	clock.Add(30 * time.Second)
+	assert.False(t, detector.isEjected(subConns[0]))
+}
+
+func Test_outlierDetector_maxEjectionPercent(t *testing.T) {
+	detector, _, subConns := newTestDetector(OutlierConfig{
+		ConsecutiveErrors:  1,
+		MaxEjectionPercent: 10,
+	}, "10.0.0.1:9090", "10.0.0.2:9090", "10.0.0.3:9090")
+
+	for _, sc := range subConns {
+		detector.record(sc, status.Error(codes.Unavailable, "unavailable"))
+	}
+
+	// 至少摘除一个节点, 且不超过上限
+	var ejected int
+	for _, sc := range subConns {
+		if detector.isEjected(sc) {
+			ejected++
+		}
+	}
+	assert.Equal(t, 1, ejected)
+}
+
+type fakePicker struct {
+	subConns []balancer.SubConn
+	next     int
+	done     int
+}
+
+func (p *fakePicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	sc := p.subConns[p.next%len(p.subConns)]
+	p.next++
+	return balancer.PickResult{SubConn: sc, Done: func(balancer.DoneInfo) { p.done++ }}, nil
+}
+
+func Test_outlierPicker(t *testing.T) {
+	detector, _, subConns := newTestDetector(OutlierConfig{
+		ConsecutiveErrors:  1,
+		MaxEjectionPercent: 50,
+	}, "10.0.0.1:9090", "10.0.0.2:9090")
+	picker := &fakePicker{subConns: subConns}
+	outlier := newOutlierPicker(picker, detector, len(subConns))
+
+	res, err := outlier.Pick(balancer.PickInfo{})
+	require.NoError(t, err)
+	assert.Equal(t, subConns[0], res.SubConn)
+	res.Done(balancer.DoneInfo{Err: status.Error(codes.Unavailable, "unavailable")})
+	assert.Equal(t, 1, picker.done)
+	assert.True(t, detector.isEjected(subConns[0]))
+
+	for i := 0; i < 4; i++ {
+		res, err = outlier.Pick(balancer.PickInfo{})
+		require.NoError(t, err)
+		assert.Equal(t, subConns[1], res.SubConn)
+		res.Done(balancer.DoneInfo{})
+	}
+}
+
+func Test_baseBuilder_ParseConfig(t *testing.T) {
+	builder := NewBalancerBuilderV2(NameLocality, &localityPickerBuilder{}, base.Config{}).(*baseBuilder)
+	config, err := builder.ParseConfig([]byte(`{"zone":"bj-1","outlierDetection":{"consecutiveErrors":3}}`))
+	require.NoError(t, err)
+
+	parsed := config.(*lbConfig)
+	require.NotNil(t, parsed.OutlierDetection)
+	assert.Equal(t, 3, parsed.OutlierDetection.ConsecutiveErrors)
+	assert.Equal(t, "bj-1", parsed.picker.(*localityLBConfig).Zone)
+
+	builder = NewBalancerBuilderV2(NameRoundRobin, &rrPickerBuilder{}, base.Config{}).(*baseBuilder)
+	config, err = builder.ParseConfig([]byte(`{}`))
+	require.NoError(t, err)
+	assert.Nil(t, config.(*lbConfig).OutlierDetection)
+	assert.Nil(t, config.(*lbConfig).picker)
+}
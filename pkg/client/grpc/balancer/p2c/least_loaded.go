@@ -15,6 +15,7 @@
 package p2c
 
 import (
+	jbalancer "github.com/zhengyansheng/jupiter/pkg/client/grpc/balancer"
 	"github.com/zhengyansheng/jupiter/pkg/util/xp2c"
 	"github.com/zhengyansheng/jupiter/pkg/util/xp2c/leastloaded"
 	"google.golang.org/grpc/balancer"
@@ -30,7 +31,7 @@ const (
 
 // newBuilder creates a new balance builder.
 func newBuilder() balancer.Builder {
-	return base.NewBalancerBuilder(Name, &p2cPickerBuilder{}, base.Config{HealthCheck: true})
+	return jbalancer.NewBalancerBuilderV2(Name, &p2cPickerBuilder{}, base.Config{HealthCheck: true})
 }
 
 func init() {
@@ -39,10 +40,10 @@ func init() {
 
 type p2cPickerBuilder struct{}
 
-func (*p2cPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+func (*p2cPickerBuilder) Build(info jbalancer.PickerBuildInfo) balancer.Picker {
 	grpclog.Infof("p2cPickerBuilder: newPicker called with readySCs: %v", info.ReadySCs)
 	if len(info.ReadySCs) == 0 {
-		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+		return jbalancer.NewErrPickerV2(balancer.ErrNoSubConnAvailable)
 	}
 
 	var p2c = leastloaded.New()
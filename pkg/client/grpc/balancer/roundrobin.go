@@ -0,0 +1,67 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balancer
+
+import (
+	"math/rand"
+	"sync"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+)
+
+const (
+	// NameRoundRobin is the round robin balancer built on base balancer of jupiter,
+	// which supports outlier detection compared with round_robin of grpc
+	NameRoundRobin = "jupiter_round_robin"
+)
+
+func init() {
+	balancer.Register(
+		NewBalancerBuilderV2(NameRoundRobin, &rrPickerBuilder{}, base.Config{HealthCheck: true}),
+	)
+}
+
+type rrPickerBuilder struct{}
+
+// Build ...
+func (rrPickerBuilder) Build(info PickerBuildInfo) balancer.Picker {
+	subConns := make([]balancer.SubConn, 0, len(info.ReadySCs))
+	for sc := range info.ReadySCs {
+		subConns = append(subConns, sc)
+	}
+	return &rrPicker{
+		subConns: subConns,
+		// Start at a random index, as the same RR balancer rebuilds a new
+		// picker when SubConn states change, and we don't want to apply excess
+		// load to the first server in the list.
+		next: rand.Intn(len(subConns)),
+	}
+}
+
+type rrPicker struct {
+	mu       sync.Mutex
+	subConns []balancer.SubConn
+	next     int
+}
+
+// Pick ...
+func (p *rrPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	p.mu.Lock()
+	sc := p.subConns[p.next]
+	p.next = (p.next + 1) % len(p.subConns)
+	p.mu.Unlock()
+	return balancer.PickResult{SubConn: sc}, nil
+}
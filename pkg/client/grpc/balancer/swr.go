@@ -15,8 +15,8 @@
 package balancer
 
 import (
+	"encoding/json"
 	"errors"
-	"fmt"
 	"sync"
 
 	"github.com/smallnest/weighted"
@@ -26,6 +26,7 @@ import (
 	"google.golang.org/grpc/attributes"
 	"google.golang.org/grpc/balancer"
 	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/serviceconfig"
 )
 
 const (
@@ -38,6 +39,11 @@ type PickerBuildInfo struct {
 	// ReadySCs is a map from all ready SubConns to the Addresses used to
 	// create them.
 	ReadySCs map[balancer.SubConn]base.SubConnInfo
+	// SubConns is a map from all SubConns to the Addresses used to create them.
+	SubConns map[balancer.SubConn]base.SubConnInfo
+	// Config is the loadBalancingConfig parsed by ConfigParser, it's nil if
+	// picker builder doesn't implement ConfigParser.
+	Config serviceconfig.LoadBalancingConfig
 	*attributes.Attributes
 }
 
@@ -46,6 +52,11 @@ type PickerBuilder interface {
 	Build(info PickerBuildInfo) balancer.Picker
 }
 
+// ConfigParser is implemented by PickerBuilder which supports loadBalancingConfig
+type ConfigParser interface {
+	ParseConfig(json.RawMessage) (serviceconfig.LoadBalancingConfig, error)
+}
+
 func init() {
 	balancer.Register(
 		NewBalancerBuilderV2(NameSmoothWeightRoundRobin, &swrPickerBuilder{}, base.Config{HealthCheck: true}),
@@ -115,27 +126,12 @@ func (p *swrPicker) parseBuildInfo(info PickerBuildInfo) {
 		}
 		host := info.Address.Addr
 		hostedSubConns[host] = subConn
-		p.buckets.Add(subConn, 1)
 	}
 
 	if info.Attributes == nil {
 		return
 	}
 
-	providerConfig, ok := info.Attributes.Value(constant.KeyProviderConfig).(registry.ProviderConfig)
-	if !ok {
-		return
-	}
-
-	fmt.Printf("providerConfig => %v\n", providerConfig)
-
-	consumerConfigs, ok := info.Attributes.Value(constant.KeyConsumerConfig).(map[string]registry.ConsumerConfig)
-	if !ok {
-		return
-	}
-
-	fmt.Printf("consumerConfigs => %v\n", consumerConfigs)
-
 	// 路由配置
 	routeConfigs, ok := info.Attributes.Value(constant.KeyRouteConfig).(map[string]registry.RouteConfig)
 	if !ok {
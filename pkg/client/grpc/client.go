@@ -16,13 +16,17 @@ package grpc
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"time"
 
+	"github.com/zhengyansheng/jupiter/pkg/client/grpc/balancer"
 	"github.com/zhengyansheng/jupiter/pkg/client/grpc/resolver"
 	"github.com/zhengyansheng/jupiter/pkg/core/ecode"
+	"github.com/zhengyansheng/jupiter/pkg/registry"
 	"github.com/zhengyansheng/jupiter/pkg/xlog"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer/roundrobin"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
@@ -69,12 +73,40 @@ func getDialOptions(config *Config) []grpc.DialOption {
 	dialOptions = append(dialOptions,
 		grpc.WithInsecure(),
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
-		grpc.WithResolvers(resolver.NewEtcdBuilder("etcd", config.RegistryConfig)),
 		grpc.WithDisableServiceConfig(),
 	)
 
-	svcCfg := fmt.Sprintf(`{"loadBalancingPolicy":"%s"}`, config.BalancerName)
-	dialOptions = append(dialOptions, grpc.WithDefaultServiceConfig(svcCfg))
+	// 每种注册中心对应一个resolver, eg: etcd:///main, consul:///main，别名(etcd及etcdv3)使用同一个注册中心实例
+	for _, kind := range registry.Kinds() {
+		dialOptions = append(dialOptions, grpc.WithResolvers(resolver.NewBuilder(kind, config.RegistryConfig)))
+	}
+
+	dialOptions = append(dialOptions, grpc.WithDefaultServiceConfig(getServiceConfig(config)))
 
 	return dialOptions
 }
+
+func getServiceConfig(config *Config) string {
+	var lbConfig struct {
+		*balancer.LocalityConfig
+		OutlierDetection *balancer.OutlierConfig `json:"outlierDetection,omitempty"`
+	}
+
+	name := config.BalancerName
+	if name == balancer.NameLocality {
+		lbConfig.LocalityConfig = &config.Locality
+	}
+	// grpc内置的round_robin不支持outlier detection, 也无法查看subconn状态, 使用jupiter实现的round_robin
+	if name == roundrobin.Name {
+		name = balancer.NameRoundRobin
+	}
+	if config.OutlierDetection != nil {
+		lbConfig.OutlierDetection = config.OutlierDetection
+	}
+	if lbConfig.LocalityConfig == nil && lbConfig.OutlierDetection == nil {
+		return fmt.Sprintf(`{"loadBalancingPolicy":"%s"}`, name)
+	}
+
+	js, _ := json.Marshal(lbConfig)
+	return fmt.Sprintf(`{"loadBalancingConfig":[{"%s":%s}]}`, name, js)
+}
@@ -19,6 +19,7 @@ import (
 
 	"github.com/samber/lo"
 	"github.com/spf13/cast"
+	"github.com/zhengyansheng/jupiter/pkg/client/grpc/balancer"
 	"github.com/zhengyansheng/jupiter/pkg/conf"
 	"github.com/zhengyansheng/jupiter/pkg/core/constant"
 	"github.com/zhengyansheng/jupiter/pkg/core/ecode"
@@ -33,17 +34,23 @@ import (
 // Config ...
 type Config struct {
 	Name           string // config's name
-	BalancerName   string
+	BalancerName   string `validate:"required"`
 	Addr           string
-	DialTimeout    time.Duration
-	ReadTimeout    time.Duration
+	DialTimeout    time.Duration `validate:"min=0"`
+	ReadTimeout    time.Duration `validate:"min=0"`
 	KeepAlive      *keepalive.ClientParameters
 	RegistryConfig string
+	// Locality 仅在 BalancerName 为 locality 时生效
+	Locality balancer.LocalityConfig
+	// OutlierDetection 被动健康检查, 为空时不开启, 支持 swr、p2c_least_loaded、locality 和 round_robin
+	OutlierDetection *balancer.OutlierConfig
+	// MethodConfig 按方法配置的重试或对冲策略, 仅对unary请求生效
+	MethodConfig []MethodConfig
 
 	logger      *xlog.Logger
 	dialOptions []grpc.DialOption
 
-	SlowThreshold time.Duration
+	SlowThreshold time.Duration `validate:"min=0"`
 
 	Debug                      bool
 	DisableSentinelInterceptor bool
@@ -80,9 +87,10 @@ func StdConfig(name string) *Config {
 func RawConfig(key string) *Config {
 	var config = DefaultConfig()
 	config.Name = key
-	if err := conf.UnmarshalKey(key, &config); err != nil {
+	if err := conf.UnmarshalKey(key, &config, conf.Strict()); err != nil {
 		config.logger.Panic("client grpc parse config panic", xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr), xlog.FieldErr(err), xlog.FieldKey(key), xlog.FieldValueAny(config))
 	}
+	conf.RegisterValidation(key, func() interface{} { return DefaultConfig() })
 	return config
 }
 
@@ -104,46 +112,68 @@ func (config *Config) WithDialOption(opts ...grpc.DialOption) *Config {
 // Build ...
 func (config *Config) Build() (*grpc.ClientConn, error) {
 	config.logger = xlog.Jupiter().Named(ecode.ModClientGrpc)
+	if err := conf.ValidateStruct(config); err != nil {
+		return nil, err
+	}
 
 	if config.Debug {
 		config.dialOptions = append(config.dialOptions,
 			grpc.WithChainUnaryInterceptor(debugUnaryClientInterceptor(config.Addr)),
+			grpc.WithChainStreamInterceptor(debugStreamClientInterceptor(config.Addr)),
 		)
 	}
 
 	if !config.DisableAidInterceptor {
 		config.dialOptions = append(config.dialOptions,
 			grpc.WithChainUnaryInterceptor(aidUnaryClientInterceptor()),
+			grpc.WithChainStreamInterceptor(aidStreamClientInterceptor()),
 		)
 	}
 
 	if !config.DisableTimeoutInterceptor {
 		config.dialOptions = append(config.dialOptions,
 			grpc.WithChainUnaryInterceptor(timeoutUnaryClientInterceptor(config.logger, config.ReadTimeout, config.SlowThreshold)),
+			grpc.WithChainStreamInterceptor(slowStreamClientInterceptor(config.logger, config.SlowThreshold)),
 		)
 	}
 
 	if !config.DisableTraceInterceptor {
 		config.dialOptions = append(config.dialOptions,
 			grpc.WithChainUnaryInterceptor(TraceUnaryClientInterceptor()),
+			grpc.WithChainStreamInterceptor(TraceStreamClientInterceptor()),
 		)
 	}
 
 	if !config.DisableAccessInterceptor {
 		config.dialOptions = append(config.dialOptions,
 			grpc.WithChainUnaryInterceptor(loggerUnaryClientInterceptor(config.logger, config.Name, config.AccessInterceptorLevel)),
+			grpc.WithChainStreamInterceptor(loggerStreamClientInterceptor(config.logger, config.Name, config.AccessInterceptorLevel)),
 		)
 	}
 
 	if !config.DisableMetricInterceptor {
 		config.dialOptions = append(config.dialOptions,
 			grpc.WithChainUnaryInterceptor(metricUnaryClientInterceptor(config.Name)),
+			grpc.WithChainStreamInterceptor(metricStreamClientInterceptor(config.Name)),
 		)
 	}
 
 	if !config.DisableSentinelInterceptor {
 		config.dialOptions = append(config.dialOptions,
 			grpc.WithChainUnaryInterceptor(sentinelUnaryClientInterceptor(config.Addr)),
+			grpc.WithChainStreamInterceptor(sentinelStreamClientInterceptor(config.Addr)),
+		)
+	}
+
+	// 重试放在最内层, 每次请求都经过负载均衡重新选择节点
+	if len(config.MethodConfig) > 0 {
+		mc, err := newMethodConfigs(config.MethodConfig)
+		if err != nil {
+			config.logger.Error("invalid method config", xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr), xlog.FieldErr(err), xlog.FieldName(config.Name))
+			return nil, err
+		}
+		config.dialOptions = append(config.dialOptions,
+			grpc.WithChainUnaryInterceptor(retryUnaryClientInterceptor(config.Name, mc)),
 		)
 	}
 
@@ -163,6 +193,7 @@ func (config *Config) Singleton() (*grpc.ClientConn, error) {
 	}
 
 	singleton.Store(constant.ModuleClientGrpc, config.Name, cc)
+	clients.Store(config.Name, &clientEntry{config: config, cc: cc})
 
 	return cc, nil
 }
@@ -16,12 +16,18 @@ package grpc
 
 import (
 	"bytes"
+	"context"
+	"net"
 	"testing"
 	"time"
 
 	"github.com/BurntSushi/toml"
+	"github.com/samber/lo"
 	"github.com/stretchr/testify/assert"
+	"github.com/zhengyansheng/jupiter/pkg/client/grpc/balancer"
 	"github.com/zhengyansheng/jupiter/pkg/conf"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
 )
 
 func TestConfig(t *testing.T) {
@@ -30,6 +36,21 @@ func TestConfig(t *testing.T) {
 	balancerName="swr"
 	addr="127.0.0.1:9091"
 	dialTimeout="10s"
+[jupiter.grpc.locality]
+	balancerName="locality"
+	[jupiter.grpc.locality.locality]
+		zone="bj-1"
+		minReadyNodes=2
+		strictDeployment=true
+	[jupiter.grpc.locality.outlierDetection]
+		consecutiveErrors=3
+		baseEjectionTime="10s"
+	[[jupiter.grpc.locality.methodConfig]]
+		methods=["/helloworld.Greeter/SayHello", "/helloworld.Greeter/"]
+		[jupiter.grpc.locality.methodConfig.retry]
+			maxAttempts=3
+			initialBackoff="50ms"
+			retryableStatusCodes=["UNAVAILABLE"]
 	`
 	assert.Nil(t, conf.LoadFromReader(bytes.NewBufferString(configStr), toml.Unmarshal))
 
@@ -39,4 +60,67 @@ func TestConfig(t *testing.T) {
 		assert.Equal(t, time.Second*10, config.DialTimeout)
 		assert.Equal(t, "127.0.0.1:9091", config.Addr)
 	})
+
+	t.Run("locality config", func(t *testing.T) {
+		config := StdConfig("locality")
+		assert.Equal(t, "locality", config.BalancerName)
+		assert.Equal(t, "bj-1", config.Locality.Zone)
+		assert.Equal(t, 2, config.Locality.MinReadyNodes)
+		assert.True(t, config.Locality.StrictDeployment)
+		assert.Equal(t, 3, config.OutlierDetection.ConsecutiveErrors)
+		assert.Equal(t, 10*time.Second, config.OutlierDetection.BaseEjectionTime)
+		assert.Len(t, config.MethodConfig, 1)
+		assert.Equal(t, []string{"/helloworld.Greeter/SayHello", "/helloworld.Greeter/"}, config.MethodConfig[0].Methods)
+		assert.Equal(t, 3, config.MethodConfig[0].Retry.MaxAttempts)
+		assert.Equal(t, 50*time.Millisecond, config.MethodConfig[0].Retry.InitialBackoff)
+	})
+}
+
+func Test_getServiceConfig(t *testing.T) {
+	config := DefaultConfig()
+	assert.Equal(t, `{"loadBalancingPolicy":"jupiter_round_robin"}`, getServiceConfig(config))
+
+	config.OutlierDetection = &balancer.OutlierConfig{ConsecutiveErrors: 3}
+	assert.Contains(t, getServiceConfig(config), `{"loadBalancingConfig":[{"jupiter_round_robin":{"outlierDetection":{`)
+
+	config.BalancerName = balancer.NameLocality
+	config.Locality.Zone = "bj-1"
+	assert.Contains(t, getServiceConfig(config), `{"loadBalancingConfig":[{"locality":{"zone":"bj-1",`)
+	assert.Contains(t, getServiceConfig(config), `"consecutiveErrors":3`)
+}
+
+func TestClientStates(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	srv := grpc.NewServer()
+	go func() { _ = srv.Serve(lis) }()
+	defer srv.Stop()
+
+	config := DefaultConfig()
+	config.Name = "test-client-states"
+	config.Addr = lis.Addr().String()
+	cc, err := config.Singleton()
+	assert.Nil(t, err)
+	defer clients.Delete(config.Name)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for s := cc.GetState(); s != connectivity.Ready; s = cc.GetState() {
+		assert.True(t, cc.WaitForStateChange(ctx, s))
+	}
+
+	state, ok := lo.Find(ClientStates(), func(s ClientState) bool { return s.Name == config.Name })
+	assert.True(t, ok)
+	assert.Equal(t, lis.Addr().String(), state.Target)
+	assert.Equal(t, "round_robin", state.Balancer)
+	assert.Equal(t, "READY", state.State)
+	assert.Nil(t, state.Resolver)
+	assert.Equal(t, []balancer.SubConnState{{Addr: lis.Addr().String(), State: "READY"}}, state.SubConns)
+}
+
+func Test_matchTarget(t *testing.T) {
+	assert.True(t, matchTarget("etcd:///main", "etcd:///main"))
+	assert.True(t, matchTarget("127.0.0.1:9091", "passthrough:///127.0.0.1:9091"))
+	assert.False(t, matchTarget("etcd:///main", "passthrough:///etcd:///main"))
+	assert.False(t, matchTarget("etcd:///main", "etcd:///user"))
 }
@@ -0,0 +1,96 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpc
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/zhengyansheng/jupiter/pkg/client/grpc/balancer"
+	"github.com/zhengyansheng/jupiter/pkg/client/grpc/resolver"
+	"github.com/zhengyansheng/jupiter/pkg/server/governor"
+	"google.golang.org/grpc"
+)
+
+// clients built by Singleton, name => *clientEntry
+var clients sync.Map
+
+type clientEntry struct {
+	config *Config
+	cc     *grpc.ClientConn
+}
+
+// ClientState is the connectivity state of a grpc client
+type ClientState struct {
+	Name     string `json:"name"`
+	Target   string `json:"target"`
+	Balancer string `json:"balancer"`
+	State    string `json:"state"`
+	// Resolver is the state of registry resolver, nil if the target is not resolved by registry
+	Resolver *resolver.State `json:"resolver,omitempty"`
+	// SubConns is nil if the balancer is not built by jupiter
+	SubConns []balancer.SubConnState `json:"subConns"`
+}
+
+func init() {
+	governor.HandleFunc("/debug/grpc/clients", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = jsoniter.NewEncoder(w).Encode(ClientStates())
+	})
+}
+
+// ClientStates returns the states of all clients built by Singleton, sorted by name
+func ClientStates() []ClientState {
+	resolverStates := resolver.States()
+	balancerStates := balancer.States()
+
+	states := make([]ClientState, 0)
+	clients.Range(func(key, value interface{}) bool {
+		entry := value.(*clientEntry)
+		state := ClientState{
+			Name:     key.(string),
+			Target:   entry.cc.Target(),
+			Balancer: entry.config.BalancerName,
+			State:    entry.cc.GetState().String(),
+		}
+		for i := range resolverStates {
+			if matchTarget(state.Target, resolverStates[i].Target) {
+				state.Resolver = &resolverStates[i]
+				break
+			}
+		}
+		for _, bs := range balancerStates {
+			if matchTarget(state.Target, bs.Target) {
+				state.SubConns = append(state.SubConns, bs.SubConns...)
+			}
+		}
+		states = append(states, state)
+		return true
+	})
+	sort.SliceStable(states, func(i, j int) bool {
+		return states[i].Name < states[j].Name
+	})
+	return states
+}
+
+// matchTarget reports whether the parsed target of resolver or balancer
+// belongs to the dial target, grpc dials the target without scheme by passthrough
+func matchTarget(dialTarget, target string) bool {
+	return target == dialTarget ||
+		(!strings.Contains(dialTarget, "://") && target == "passthrough:///"+dialTarget)
+}
@@ -19,6 +19,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/alibaba/sentinel-golang/api"
@@ -26,12 +29,20 @@ import (
 	"github.com/fatih/color"
 	"github.com/zhengyansheng/jupiter/pkg"
 	"github.com/zhengyansheng/jupiter/pkg/core/ecode"
+	"github.com/zhengyansheng/jupiter/pkg/core/metric"
 	"github.com/zhengyansheng/jupiter/pkg/core/sentinel"
+	"github.com/zhengyansheng/jupiter/pkg/core/xtrace"
 	"github.com/zhengyansheng/jupiter/pkg/util/xstring"
 	"github.com/zhengyansheng/jupiter/pkg/xlog"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 )
 
 var (
@@ -44,10 +55,23 @@ func metricUnaryClientInterceptor(name string) func(ctx context.Context, method
 		beg := time.Now()
 		err := invoker(ctx, method, req, reply, cc, opts...)
 
+		metric.ClientHandleCounter.Inc(metric.TypeGRPCUnary, name, method, cc.Target(), metricCode(err))
+		metric.ClientHandleHistogram.Observe(time.Since(beg).Seconds(), metric.TypeGRPCUnary, name, method, cc.Target())
+
 		return err
 	}
 }
 
+// metricCode 收敛err错误，将err过滤后，可以知道err是否为系统错误码
+func metricCode(err error) string {
+	spbStatus := ecode.ExtractCodes(err)
+	if spbStatus.Code < ecode.EcodeNum {
+		// 只记录系统级别的详细错误码
+		return codes.Code(spbStatus.Code).String()
+	}
+	return "biz error"
+}
+
 func sentinelUnaryClientInterceptor(name string) grpc.UnaryClientInterceptor {
 	return func(ctx context.Context, method string, req, reply interface{},
 		cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
@@ -86,13 +110,46 @@ func debugUnaryClientInterceptor(addr string) grpc.UnaryClientInterceptor {
 	}
 }
 
+// TraceUnaryClientInterceptor starts a client span for each call, and injects it into outgoing metadata
 func TraceUnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	tracer := xtrace.NewTracer(trace.SpanKindClient)
 	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) (err error) {
-
+		ctx, span := startClientSpan(tracer, ctx, method, cc)
+		err = invoker(ctx, method, req, reply, cc, opts...)
+		finishClientSpan(span, err)
 		return err
 	}
 }
 
+// startClientSpan starts a client span, and injects it into outgoing metadata
+func startClientSpan(tracer *xtrace.Tracer, ctx context.Context, method string, cc *grpc.ClientConn) (context.Context, trace.Span) {
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if !ok {
+		md = metadata.MD{}
+	} else {
+		md = md.Copy()
+	}
+
+	ctx, span := tracer.Start(ctx, method, xtrace.MetadataCarrier(md),
+		trace.WithAttributes(
+			semconv.RPCSystemKey.String("grpc"),
+			semconv.RPCMethodKey.String(method),
+			semconv.NetPeerNameKey.String(cc.Target()),
+		),
+	)
+	return metadata.NewOutgoingContext(ctx, md), span
+}
+
+func finishClientSpan(span trace.Span, err error) {
+	spbStatus := ecode.ExtractCodes(err)
+	span.SetAttributes(attribute.Int64("rpc.grpc.status_code", int64(spbStatus.Code)))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
+	}
+	span.End()
+}
+
 func aidUnaryClientInterceptor() grpc.UnaryClientInterceptor {
 	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
 		md, ok := metadata.FromOutgoingContext(ctx)
@@ -195,3 +252,312 @@ func loggerUnaryClientInterceptor(_logger *xlog.Logger, name string, accessInter
 		return nil
 	}
 }
+
+// streamHooks 流式调用的回调
+type streamHooks struct {
+	// onSend 每次SendMsg之后调用
+	onSend func(m interface{}, err error, cost time.Duration)
+	// onRecv 每次成功RecvMsg之后调用
+	onRecv func(m interface{})
+	// onDone 流结束时调用一次, 正常结束时err为nil
+	onDone func(err error)
+}
+
+// monitoredClientStream wraps grpc.ClientStream, a stream is done when RecvMsg
+// returns a non-nil error, SendMsg fails, the only response of a non server
+// streaming call is received, or the stream context is done. The stream context
+// is derived from the call ctx and is also canceled by grpc when the stream
+// finishes or the ClientConn closes, so an abandoned stream still releases its
+// sentinel entry and metrics.
+type monitoredClientStream struct {
+	grpc.ClientStream
+	desc  *grpc.StreamDesc
+	hooks streamHooks
+	once  sync.Once
+	stop  func() bool
+}
+
+func newMonitoredClientStream(desc *grpc.StreamDesc, cs grpc.ClientStream, hooks streamHooks) grpc.ClientStream {
+	s := &monitoredClientStream{
+		ClientStream: cs,
+		desc:         desc,
+		hooks:        hooks,
+	}
+	ctx := cs.Context()
+	s.stop = context.AfterFunc(ctx, func() {
+		s.finish(status.FromContextError(ctx.Err()).Err())
+	})
+	return s
+}
+
+// SendMsg ...
+func (s *monitoredClientStream) SendMsg(m interface{}) error {
+	beg := time.Now()
+	err := s.ClientStream.SendMsg(m)
+	if s.hooks.onSend != nil {
+		s.hooks.onSend(m, err, time.Since(beg))
+	}
+	// io.EOF 表示流已被对端结束, 真正的状态由RecvMsg返回
+	if err != nil && err != io.EOF {
+		s.finish(err)
+	}
+	return err
+}
+
+// RecvMsg ...
+func (s *monitoredClientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err == io.EOF {
+		s.finish(nil)
+		return err
+	}
+	if err != nil {
+		s.finish(err)
+		return err
+	}
+
+	if s.hooks.onRecv != nil {
+		s.hooks.onRecv(m)
+	}
+	if !s.desc.ServerStreams {
+		s.finish(nil)
+	}
+	return nil
+}
+
+func (s *monitoredClientStream) finish(err error) {
+	s.once.Do(func() {
+		s.stop()
+		if s.hooks.onDone != nil {
+			s.hooks.onDone(err)
+		}
+	})
+}
+
+func aidStreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		md, ok := metadata.FromOutgoingContext(ctx)
+		clientAidMD := metadata.Pairs("aid", pkg.AppID())
+		if ok {
+			md = metadata.Join(md, clientAidMD)
+		} else {
+			md = clientAidMD
+		}
+		ctx = metadata.NewOutgoingContext(ctx, md)
+
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
+
+func debugStreamClientInterceptor(addr string) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		prefix := fmt.Sprintf("[%s]", addr)
+
+		fmt.Printf("%-50s[%s] => %s\n", color.GreenString(prefix), time.Now().Format("04:05.000"), color.GreenString("Open: "+method))
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			fmt.Printf("%-50s[%s] => %s\n", color.RedString(prefix), time.Now().Format("04:05.000"), color.RedString("Erro: "+err.Error()))
+			return nil, err
+		}
+
+		return newMonitoredClientStream(desc, cs, streamHooks{
+			onSend: func(m interface{}, err error, _ time.Duration) {
+				if err != nil {
+					fmt.Printf("%-50s[%s] => %s\n", color.RedString(prefix), time.Now().Format("04:05.000"), color.RedString("Erro: "+err.Error()))
+					return
+				}
+				fmt.Printf("%-50s[%s] => %s\n", color.GreenString(prefix), time.Now().Format("04:05.000"), color.GreenString("Send: "+method+" | "+xstring.Json(m)))
+			},
+			onRecv: func(m interface{}) {
+				fmt.Printf("%-50s[%s] => %s\n", color.GreenString(prefix), time.Now().Format("04:05.000"), color.GreenString("Recv: "+xstring.Json(m)))
+			},
+			onDone: func(err error) {
+				if err != nil {
+					fmt.Printf("%-50s[%s] => %s\n", color.RedString(prefix), time.Now().Format("04:05.000"), color.RedString("Erro: "+err.Error()))
+					return
+				}
+				fmt.Printf("%-50s[%s] => %s\n", color.GreenString(prefix), time.Now().Format("04:05.000"), color.GreenString("Done: "+method))
+			},
+		}), nil
+	}
+}
+
+// TraceStreamClientInterceptor starts a client span for the stream lifetime, each message is recorded as a span event
+func TraceStreamClientInterceptor() grpc.StreamClientInterceptor {
+	tracer := xtrace.NewTracer(trace.SpanKindClient)
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx, span := startClientSpan(tracer, ctx, method, cc)
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			finishClientSpan(span, err)
+			return nil, err
+		}
+
+		var sent, recv atomic.Int64
+		return newMonitoredClientStream(desc, cs, streamHooks{
+			onSend: func(_ interface{}, err error, _ time.Duration) {
+				if err == nil {
+					span.AddEvent("message", trace.WithAttributes(
+						semconv.MessageTypeSent,
+						semconv.MessageIDKey.Int64(sent.Add(1)),
+					))
+				}
+			},
+			onRecv: func(interface{}) {
+				span.AddEvent("message", trace.WithAttributes(
+					semconv.MessageTypeReceived,
+					semconv.MessageIDKey.Int64(recv.Add(1)),
+				))
+			},
+			onDone: func(err error) {
+				finishClientSpan(span, err)
+			},
+		}), nil
+	}
+}
+
+// slowStreamClientInterceptor gRPC客户端慢流检测, 建立流或单次发送消息超过阈值时记录慢日志
+func slowStreamClientInterceptor(_logger *xlog.Logger, slowThreshold time.Duration) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		beg := time.Now()
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if slowThreshold <= time.Duration(0) || err != nil {
+			return cs, err
+		}
+
+		logSlow := func(event string, cost time.Duration) {
+			remoteIP := "unknown"
+			if remote, ok := peer.FromContext(cs.Context()); ok && remote.Addr != nil {
+				remoteIP = remote.Addr.String()
+			}
+			_logger.Error("slow",
+				xlog.FieldErr(errSlowCommand),
+				xlog.FieldType("stream"),
+				xlog.FieldEvent(event),
+				xlog.FieldMethod(method),
+				xlog.FieldName(cc.Target()),
+				xlog.FieldCost(cost),
+				xlog.FieldAddr(remoteIP),
+			)
+		}
+
+		if du := time.Since(beg); du > slowThreshold {
+			logSlow("open", du)
+		}
+
+		return newMonitoredClientStream(desc, cs, streamHooks{
+			onSend: func(_ interface{}, _ error, cost time.Duration) {
+				if cost > slowThreshold {
+					logSlow("send", cost)
+				}
+			},
+		}), nil
+	}
+}
+
+// loggerStreamClientInterceptor gRPC客户端流日志中间件, 每个流结束时记录一条访问日志
+func loggerStreamClientInterceptor(_logger *xlog.Logger, name string, accessInterceptorLevel string) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		beg := time.Now()
+		var sent, recv atomic.Int64
+
+		logAccess := func(err error) {
+			spbStatus := ecode.ExtractCodes(err)
+			fields := []xlog.Field{
+				xlog.FieldType("stream"),
+				xlog.FieldCode(spbStatus.Code),
+				xlog.FieldName(name),
+				xlog.FieldMethod(method),
+				xlog.FieldCost(time.Since(beg)),
+				xlog.Int64("sent", sent.Load()),
+				xlog.Int64("recv", recv.Load()),
+			}
+			if err == nil {
+				if accessInterceptorLevel == "info" {
+					_logger.Info("access", fields...)
+				}
+				return
+			}
+
+			fields = append(fields, xlog.FieldStringErr(spbStatus.Message))
+			if spbStatus.Code < ecode.EcodeNum {
+				// 只记录系统级别错误
+				_logger.Error("access", fields...)
+			} else {
+				// 业务报错只做warning
+				_logger.Warn("access", fields...)
+			}
+		}
+
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			logAccess(err)
+			return nil, err
+		}
+
+		return newMonitoredClientStream(desc, cs, streamHooks{
+			onSend: func(_ interface{}, err error, _ time.Duration) {
+				if err == nil {
+					sent.Add(1)
+				}
+			},
+			onRecv: func(interface{}) {
+				recv.Add(1)
+			},
+			onDone: logAccess,
+		}), nil
+	}
+}
+
+// metricStreamClientInterceptor 流式调用的metric统计, 按消息统计收发数量, 流结束时统计耗时与状态码
+func metricStreamClientInterceptor(name string) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		beg := time.Now()
+		done := func(err error) {
+			metric.ClientHandleCounter.Inc(metric.TypeGRPCStream, name, method, cc.Target(), metricCode(err))
+			metric.ClientHandleHistogram.Observe(time.Since(beg).Seconds(), metric.TypeGRPCStream, name, method, cc.Target())
+		}
+
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			done(err)
+			return nil, err
+		}
+
+		return newMonitoredClientStream(desc, cs, streamHooks{
+			onSend: func(_ interface{}, err error, _ time.Duration) {
+				if err == nil {
+					metric.ClientStreamMsgCounter.Inc(metric.TypeGRPCStream, name, method, cc.Target(), "sent")
+				}
+			},
+			onRecv: func(interface{}) {
+				metric.ClientStreamMsgCounter.Inc(metric.TypeGRPCStream, name, method, cc.Target(), "recv")
+			},
+			onDone: done,
+		}), nil
+	}
+}
+
+// sentinelStreamClientInterceptor 流的整个生命周期作为一次sentinel entry
+func sentinelStreamClientInterceptor(name string) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		entry, blockerr := sentinel.Entry(name,
+			api.WithResourceType(base.ResTypeRPC),
+			api.WithTrafficType(base.Outbound))
+		if blockerr != nil {
+			return nil, blockerr
+		}
+
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			entry.Exit(base.WithError(err))
+			return nil, err
+		}
+
+		return newMonitoredClientStream(desc, cs, streamHooks{
+			onDone: func(err error) {
+				entry.Exit(base.WithError(err))
+			},
+		}), nil
+	}
+}
@@ -0,0 +1,169 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zhengyansheng/jupiter/pkg"
+	"github.com/zhengyansheng/jupiter/pkg/core/metric"
+	"github.com/zhengyansheng/jupiter/pkg/core/xtrace"
+	"go.opentelemetry.io/otel/codes"
+	"google.golang.org/grpc"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// fakeClientStream returns msgs one by one, then err
+type fakeClientStream struct {
+	grpc.ClientStream
+	ctx  context.Context
+	msgs int
+	err  error
+	sent int
+}
+
+func (s *fakeClientStream) Context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+func (s *fakeClientStream) SendMsg(m interface{}) error {
+	s.sent++
+	return nil
+}
+
+func (s *fakeClientStream) RecvMsg(m interface{}) error {
+	if s.msgs > 0 {
+		s.msgs--
+		return nil
+	}
+	return s.err
+}
+
+func fakeStreamer(fcs *fakeClientStream) grpc.Streamer {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		fcs.ctx = ctx
+		return fcs, nil
+	}
+}
+
+func newFakeConn(t *testing.T) *grpc.ClientConn {
+	cc, err := grpc.Dial("passthrough:///127.0.0.1:0", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = cc.Close() })
+	return cc
+}
+
+var bidiDesc = &grpc.StreamDesc{ClientStreams: true, ServerStreams: true}
+
+func TestMonitoredClientStream(t *testing.T) {
+	t.Run("finish once on io.EOF", func(t *testing.T) {
+		var done []error
+		var recv int
+		cs := newMonitoredClientStream(bidiDesc, &fakeClientStream{msgs: 2, err: io.EOF}, streamHooks{
+			onRecv: func(interface{}) { recv++ },
+			onDone: func(err error) { done = append(done, err) },
+		})
+
+		for cs.RecvMsg(nil) == nil {
+		}
+		assert.Equal(t, io.EOF, cs.RecvMsg(nil))
+		assert.Equal(t, 2, recv)
+		assert.Equal(t, []error{nil}, done)
+	})
+
+	t.Run("finish on error", func(t *testing.T) {
+		var done []error
+		rpcErr := status.Error(grpccodes.Unavailable, "unavailable")
+		cs := newMonitoredClientStream(bidiDesc, &fakeClientStream{err: rpcErr}, streamHooks{
+			onDone: func(err error) { done = append(done, err) },
+		})
+
+		assert.Equal(t, rpcErr, cs.RecvMsg(nil))
+		assert.Equal(t, []error{rpcErr}, done)
+	})
+
+	t.Run("finish after the only response of client streaming", func(t *testing.T) {
+		var done []error
+		cs := newMonitoredClientStream(&grpc.StreamDesc{ClientStreams: true}, &fakeClientStream{msgs: 1}, streamHooks{
+			onDone: func(err error) { done = append(done, err) },
+		})
+
+		assert.NoError(t, cs.SendMsg(nil))
+		assert.NoError(t, cs.RecvMsg(nil))
+		assert.Equal(t, []error{nil}, done)
+	})
+
+	t.Run("finish on stream context done", func(t *testing.T) {
+		// grpc cancels the stream context when the stream finishes or the conn closes,
+		// even if the caller neither reads to the end nor cancels the call ctx
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		newMonitoredClientStream(bidiDesc, &fakeClientStream{ctx: ctx}, streamHooks{
+			onDone: func(err error) { done <- err },
+		})
+
+		cancel()
+		assert.Equal(t, grpccodes.Canceled, status.Code(<-done))
+	})
+}
+
+func TestAidStreamClientInterceptor(t *testing.T) {
+	fcs := &fakeClientStream{}
+	_, err := aidStreamClientInterceptor()(context.Background(), bidiDesc, nil, "/test/Stream", fakeStreamer(fcs))
+	require.NoError(t, err)
+
+	md, ok := metadata.FromOutgoingContext(fcs.ctx)
+	assert.True(t, ok)
+	assert.Equal(t, []string{pkg.AppID()}, md.Get("aid"))
+}
+
+func TestMetricStreamClientInterceptor(t *testing.T) {
+	cc := newFakeConn(t)
+	method := "/test/MetricStream"
+	fcs := &fakeClientStream{msgs: 2, err: io.EOF}
+
+	cs, err := metricStreamClientInterceptor("test")(context.Background(), bidiDesc, cc, method, fakeStreamer(fcs))
+	require.NoError(t, err)
+	assert.NoError(t, cs.SendMsg(nil))
+	for cs.RecvMsg(nil) == nil {
+	}
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(metric.ClientStreamMsgCounter.WithLabelValues(metric.TypeGRPCStream, "test", method, cc.Target(), "sent")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(metric.ClientStreamMsgCounter.WithLabelValues(metric.TypeGRPCStream, "test", method, cc.Target(), "recv")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metric.ClientHandleCounter.WithLabelValues(metric.TypeGRPCStream, "test", method, cc.Target(), grpccodes.OK.String())))
+}
+
+func TestTraceStreamClientInterceptor(t *testing.T) {
+	config := xtrace.DefaultConfig()
+	config.Exporter = xtrace.ExporterMemory
+	_, err := config.Build()
+	require.NoError(t, err)
+
+	cc := newFakeConn(t)
+	fcs := &fakeClientStream{msgs: 1, err: errors.New("broken")}
+	cs, err := TraceStreamClientInterceptor()(context.Background(), bidiDesc, cc, "/test/TraceStream", fakeStreamer(fcs))
+	require.NoError(t, err)
+
+	md, _ := metadata.FromOutgoingContext(fcs.ctx)
+	assert.NotEmpty(t, md.Get("traceparent"))
+
+	assert.NoError(t, cs.SendMsg(nil))
+	for cs.RecvMsg(nil) == nil {
+	}
+
+	spans := config.MemoryExporter().GetSpans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "/test/TraceStream", spans[0].Name)
+	assert.Len(t, spans[0].Events, 3) // sent, received and the error
+	assert.Equal(t, codes.Error, spans[0].Status.Code)
+}
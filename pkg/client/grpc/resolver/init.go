@@ -0,0 +1,47 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resolver
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/zhengyansheng/jupiter/pkg/server/governor"
+)
+
+// resolvers built by builders, *baseResolver => struct{}
+var resolvers sync.Map
+
+func init() {
+	governor.HandleFunc("/debug/grpc/resolver", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = jsoniter.NewEncoder(w).Encode(States())
+	})
+}
+
+// States returns the states of all alive resolvers, sorted by target
+func States() []State {
+	states := make([]State, 0)
+	resolvers.Range(func(key, _ interface{}) bool {
+		states = append(states, key.(*baseResolver).State())
+		return true
+	})
+	sort.SliceStable(states, func(i, j int) bool {
+		return states[i].Target < states[j].Target
+	})
+	return states
+}
@@ -16,20 +16,51 @@ package resolver
 
 import (
 	"context"
+	"errors"
+	"math/rand"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/spf13/cast"
+	"github.com/zhengyansheng/jupiter/pkg/conf"
 	"github.com/zhengyansheng/jupiter/pkg/core/constant"
-	"github.com/zhengyansheng/jupiter/pkg/registry/etcdv3"
+	"github.com/zhengyansheng/jupiter/pkg/registry"
+	_ "github.com/zhengyansheng/jupiter/pkg/registry/etcdv3"
+	"github.com/zhengyansheng/jupiter/pkg/server"
 	"github.com/zhengyansheng/jupiter/pkg/util/xgo"
 	"github.com/zhengyansheng/jupiter/pkg/xlog"
 	"google.golang.org/grpc/attributes"
 	"google.golang.org/grpc/resolver"
 )
 
+const (
+	// minRetryInterval is the initial interval to retry a failed watch
+	minRetryInterval = time.Second
+	// maxRetryInterval is the max interval to retry a failed watch
+	maxRetryInterval = time.Second * 30
+	// minResolveInterval limits the frequency of refresh triggered by ResolveNow
+	minResolveInterval = time.Second
+	// resolveTimeout is the timeout of refresh triggered by ResolveNow
+	resolveTimeout = time.Second * 3
+	// maxErrors is the number of recent errors kept in state
+	maxErrors = 10
+)
+
+// errWatchClosed is recorded when registry closes the watch channel
+var errWatchClosed = errors.New("watch channel closed")
+
 // NewEtcdBuilder returns a new etcdv3 resolver builder.
+// Deprecated: use NewBuilder instead
 func NewEtcdBuilder(name string, registryConfig string) resolver.Builder {
+	return NewBuilder(name, registryConfig)
+}
+
+// NewBuilder returns a resolver builder of scheme. The registry backend is
+// the kind configured in registryConfig, or the kind named by scheme.
+func NewBuilder(scheme string, registryConfig string) resolver.Builder {
 	return &baseBuilder{
-		name:           name,
+		name:           scheme,
 		registryConfig: registryConfig,
 	}
 }
@@ -42,63 +73,286 @@ type baseBuilder struct {
 
 // Build ...
 func (b *baseBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
-	reg := etcdv3.RawConfig(b.registryConfig).MustSingleton()
+	// read the kind from registry config, conf.GetString creates the missing registry config as side effect
+	kind := cast.ToString(cast.ToStringMap(conf.Get(b.registryConfig))["kind"])
+	if kind == "" {
+		kind = b.name
+	}
+
+	reg, err := registry.Singleton(kind, b.registryConfig)
+	if err != nil {
+		xlog.Jupiter().Error("build registry failed", xlog.FieldName(kind), xlog.FieldKey(b.registryConfig), xlog.FieldErr(err))
+		return nil, err
+	}
 
 	serviceName := target.Endpoint()
 	if !strings.HasSuffix(serviceName, "/") {
 		serviceName += "/"
 	}
 
-	endpoints, err := reg.WatchServices(context.Background(), serviceName)
+	r := newBaseResolver(b.name+":///"+target.Endpoint(), kind, serviceName, reg, cc)
+
+	// the first watch fails fast, so that misconfiguration is reported on dial
+	endpoints, err := reg.WatchServices(r.ctx, serviceName)
 	if err != nil {
+		r.cancel()
 		xlog.Jupiter().Error("watch services failed", xlog.FieldErr(err))
 		return nil, err
 	}
 
-	var stop = make(chan struct{})
-	xgo.Go(func() {
+	resolvers.Store(r, struct{}{})
+	xgo.Go(func() { r.run(endpoints) })
+
+	return r, nil
+}
+
+// Scheme ...
+func (b baseBuilder) Scheme() string {
+	return b.name
+}
+
+// State is the resolver state exposed on governor
+type State struct {
+	Target    string                        `json:"target"`
+	Registry  string                        `json:"registry"`
+	Endpoints map[string]server.ServiceInfo `json:"endpoints"`
+	// UpdatedAt is the time when endpoints are updated last time
+	UpdatedAt time.Time `json:"updatedAt"`
+	// Watching is false when the watch fails and is waiting for retry
+	Watching bool `json:"watching"`
+	// Retries is the number of consecutive watch failures
+	Retries int          `json:"retries"`
+	Errors  []StateError `json:"errors"`
+}
+
+// StateError is an error occurred in resolver
+type StateError struct {
+	Time  time.Time `json:"time"`
+	Error string    `json:"error"`
+}
+
+// baseResolver keeps the last known good endpoints, it retries the watch with
+// backoff and refreshes endpoints by ListServices on ResolveNow.
+type baseResolver struct {
+	ctx         context.Context
+	cancel      context.CancelFunc
+	cc          resolver.ClientConn
+	reg         registry.Registry
+	serviceName string
+	resolveNow  chan struct{}
+	logger      *xlog.Logger
+
+	minRetryInterval   time.Duration
+	maxRetryInterval   time.Duration
+	minResolveInterval time.Duration
+	lastResolve        time.Time
+
+	mu        sync.RWMutex
+	endpoints *registry.Endpoints
+	state     State
+}
+
+func newBaseResolver(target, kind, serviceName string, reg registry.Registry, cc resolver.ClientConn) *baseResolver {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &baseResolver{
+		ctx:                ctx,
+		cancel:             cancel,
+		cc:                 cc,
+		reg:                reg,
+		serviceName:        serviceName,
+		resolveNow:         make(chan struct{}, 1),
+		minRetryInterval:   minRetryInterval,
+		maxRetryInterval:   maxRetryInterval,
+		minResolveInterval: minResolveInterval,
+		logger:             xlog.Jupiter().With(xlog.FieldName(serviceName), xlog.String("registry", kind)),
+		state: State{
+			Target:   target,
+			Registry: kind,
+		},
+	}
+}
+
+// ResolveNow refreshes endpoints from registry, it's called by grpc when
+// connections fail, so the stale endpoints are replaced even if watch stalls.
+func (b *baseResolver) ResolveNow(options resolver.ResolveNowOptions) {
+	select {
+	case b.resolveNow <- struct{}{}:
+	default:
+	}
+}
+
+// Close ...
+func (b *baseResolver) Close() {
+	b.cancel()
+	resolvers.Delete(b)
+}
+
+// State returns a snapshot of resolver state
+func (b *baseResolver) State() State {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	state := b.state
+	state.Errors = append([]StateError(nil), b.state.Errors...)
+	state.Endpoints = make(map[string]server.ServiceInfo)
+	if b.endpoints != nil {
+		for addr, info := range b.endpoints.Nodes {
+			state.Endpoints[addr] = info
+		}
+	}
+	return state
+}
+
+func (b *baseResolver) run(endpoints chan registry.Endpoints) {
+	retryInterval := b.minRetryInterval
+	for {
+		if endpoints != nil {
+			b.setWatching(true)
+			b.consume(endpoints)
+			endpoints = nil
+			if b.ctx.Err() != nil {
+				return
+			}
+		}
+
+		// wait for retry, the cached endpoints are still served and can be refreshed by ResolveNow
+		timer := time.NewTimer(jitter(retryInterval))
+	wait:
 		for {
 			select {
-			case endpoint := <-endpoints:
-				xlog.Jupiter().Debug("watch services finished", xlog.FieldValueAny(endpoint))
-
-				var state = resolver.State{
-					Addresses: make([]resolver.Address, 0),
-					Attributes: attributes.
-						New(constant.KeyRouteConfig, endpoint.RouteConfigs).             // 路由配置
-						WithValue(constant.KeyProviderConfig, endpoint.ProviderConfigs). // 服务提供方元信息
-						WithValue(constant.KeyConsumerConfig, endpoint.ConsumerConfigs), // 服务消费方配置信息,
-				}
-				for _, node := range endpoint.Nodes {
-					var address resolver.Address
-					address.Addr = node.Address
-					address.ServerName = serviceName
-					address.Attributes = attributes.New(constant.KeyServiceInfo, node)
-					state.Addresses = append(state.Addresses, address)
+			case <-timer.C:
+				break wait
+			case <-b.resolveNow:
+				b.refresh()
+			case <-b.ctx.Done():
+				timer.Stop()
+				return
+			}
+		}
+
+		var err error
+		endpoints, err = b.reg.WatchServices(b.ctx, b.serviceName)
+		if err != nil {
+			b.recordError(err, true)
+			b.logger.Warn("watch services failed", xlog.FieldErr(err), xlog.Duration("retry", retryInterval))
+			if retryInterval *= 2; retryInterval > b.maxRetryInterval {
+				retryInterval = b.maxRetryInterval
+			}
+			continue
+		}
+		retryInterval = b.minRetryInterval
+	}
+}
+
+// consume updates endpoints from watch until the channel is closed or resolver is closed
+func (b *baseResolver) consume(endpoints chan registry.Endpoints) {
+	for {
+		select {
+		case endpoint, ok := <-endpoints:
+			if !ok {
+				if b.ctx.Err() == nil {
+					b.recordError(errWatchClosed, true)
+					b.logger.Warn("watch services closed, retry later")
 				}
-				_ = cc.UpdateState(state)
-			case <-stop:
 				return
 			}
+			xlog.Jupiter().Debug("watch services finished", xlog.FieldValueAny(endpoint))
+			b.update(&endpoint)
+		case <-b.resolveNow:
+			b.refresh()
+		case <-b.ctx.Done():
+			return
 		}
-	})
+	}
+}
 
-	return &baseResolver{
-		stop: stop,
-	}, nil
+// refresh lists the services from registry, the cached endpoints are kept if it fails
+func (b *baseResolver) refresh() {
+	if time.Since(b.lastResolve) < b.minResolveInterval {
+		return
+	}
+	b.lastResolve = time.Now()
+
+	ctx, cancel := context.WithTimeout(b.ctx, resolveTimeout)
+	defer cancel()
+
+	services, err := b.reg.ListServices(ctx, b.serviceName)
+	if err != nil {
+		b.recordError(err, false)
+		b.logger.Warn("list services failed, keep the last endpoints", xlog.FieldErr(err))
+		return
+	}
+
+	b.mu.RLock()
+	endpoints := b.endpoints.DeepCopy()
+	b.mu.RUnlock()
+	if endpoints == nil {
+		endpoints = registry.NewEndpoints()
+	}
+
+	// an empty list may be caused by registry failover, the watch tells the real deletion
+	if len(services) == 0 && len(endpoints.Nodes) > 0 {
+		b.logger.Warn("list services returns nothing, keep the last endpoints")
+		return
+	}
+
+	// only nodes are refreshed, the route configs are kept from watch
+	endpoints.Nodes = make(map[string]server.ServiceInfo, len(services))
+	for _, service := range services {
+		endpoints.Nodes[service.Address] = *service
+	}
+	b.update(endpoints)
 }
 
-// Scheme ...
-func (b baseBuilder) Scheme() string {
-	return b.name
+func (b *baseResolver) update(endpoint *registry.Endpoints) {
+	b.mu.Lock()
+	b.endpoints = endpoint
+	b.state.UpdatedAt = time.Now()
+	b.mu.Unlock()
+
+	var state = resolver.State{
+		Addresses: make([]resolver.Address, 0),
+		Attributes: attributes.
+			New(constant.KeyRouteConfig, endpoint.RouteConfigs).             // 路由配置
+			WithValue(constant.KeyProviderConfig, endpoint.ProviderConfigs). // 服务提供方元信息
+			WithValue(constant.KeyConsumerConfig, endpoint.ConsumerConfigs), // 服务消费方配置信息,
+	}
+	for _, node := range endpoint.Nodes {
+		var address resolver.Address
+		address.Addr = node.Address
+		address.ServerName = b.serviceName
+		address.Attributes = attributes.New(constant.KeyServiceInfo, node)
+		state.Addresses = append(state.Addresses, address)
+	}
+	_ = b.cc.UpdateState(state)
 }
 
-type baseResolver struct {
-	stop chan struct{}
+func (b *baseResolver) setWatching(watching bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.state.Watching = watching
+	if watching {
+		b.state.Retries = 0
+	}
 }
 
-// ResolveNow ...
-func (b *baseResolver) ResolveNow(options resolver.ResolveNowOptions) {}
+// recordError records the error into state, watchFailed means the watch is broken
+func (b *baseResolver) recordError(err error, watchFailed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
 
-// Close ...
-func (b *baseResolver) Close() { b.stop <- struct{}{} }
+	if watchFailed {
+		b.state.Watching = false
+		b.state.Retries++
+	}
+	b.state.Errors = append(b.state.Errors, StateError{Time: time.Now(), Error: err.Error()})
+	if len(b.state.Errors) > maxErrors {
+		b.state.Errors = b.state.Errors[len(b.state.Errors)-maxErrors:]
+	}
+}
+
+// jitter randomizes the interval by ±20% to avoid all clients retrying at the same time
+func jitter(interval time.Duration) time.Duration {
+	return interval + time.Duration((rand.Float64()*0.4-0.2)*float64(interval))
+}
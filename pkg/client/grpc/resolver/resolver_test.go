@@ -14,8 +14,200 @@
 
 package resolver
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zhengyansheng/jupiter/pkg/conf"
+	"github.com/zhengyansheng/jupiter/pkg/registry"
+	_ "github.com/zhengyansheng/jupiter/pkg/registry/file"
+	"github.com/zhengyansheng/jupiter/pkg/server"
+	"google.golang.org/grpc/resolver"
+)
+
+type fakeClientConn struct {
+	resolver.ClientConn
+	states chan resolver.State
+}
+
+func (cc *fakeClientConn) UpdateState(state resolver.State) error {
+	cc.states <- state
+	return nil
+}
+
+// fakeRegistry returns the watch channels and list results set by test
+type fakeRegistry struct {
+	registry.Registry
+	watches chan chan registry.Endpoints
+	lists   chan []*server.ServiceInfo
+}
+
+func newFakeRegistry() *fakeRegistry {
+	return &fakeRegistry{
+		watches: make(chan chan registry.Endpoints, 10),
+		lists:   make(chan []*server.ServiceInfo, 10),
+	}
+}
+
+func (reg *fakeRegistry) WatchServices(ctx context.Context, prefix string) (chan registry.Endpoints, error) {
+	select {
+	case ch := <-reg.watches:
+		return ch, nil
+	default:
+		return nil, errors.New("watch failed")
+	}
+}
+
+func (reg *fakeRegistry) ListServices(ctx context.Context, prefix string) ([]*server.ServiceInfo, error) {
+	select {
+	case services := <-reg.lists:
+		return services, nil
+	default:
+		return nil, errors.New("list failed")
+	}
+}
+
+func receiveState(t *testing.T, cc *fakeClientConn) resolver.State {
+	select {
+	case state := <-cc.states:
+		return state
+	case <-time.After(time.Second * 3):
+		t.Fatal("no state updated")
+		return resolver.State{}
+	}
+}
+
+func newEndpoints(addrs ...string) registry.Endpoints {
+	endpoints := registry.NewEndpoints()
+	for _, addr := range addrs {
+		endpoints.Nodes[addr] = server.ServiceInfo{Address: addr}
+	}
+	return *endpoints
+}
+
+func Test_baseResolver_retry(t *testing.T) {
+	reg := newFakeRegistry()
+	cc := &fakeClientConn{states: make(chan resolver.State, 10)}
+	r := newBaseResolver("etcd:///main", "etcd", "main/", reg, cc)
+	r.minRetryInterval = time.Millisecond * 10
+	r.maxRetryInterval = time.Millisecond * 20
+	resolvers.Store(r, struct{}{})
+	defer r.Close()
+
+	watch := make(chan registry.Endpoints, 1)
+	go r.run(watch)
+
+	watch <- newEndpoints("127.0.0.1:9091")
+	assert.Len(t, receiveState(t, cc).Addresses, 1)
+
+	// the watch is closed and fails again, endpoints are kept
+	close(watch)
+	assert.Eventually(t, func() bool {
+		state := r.State()
+		return !state.Watching && state.Retries >= 2
+	}, time.Second, time.Millisecond*5)
+	state := r.State()
+	assert.Contains(t, state.Endpoints, "127.0.0.1:9091")
+	assert.Equal(t, errWatchClosed.Error(), state.Errors[0].Error)
+	assert.Equal(t, "watch failed", state.Errors[1].Error)
+
+	watch = make(chan registry.Endpoints, 1)
+	watch <- newEndpoints("127.0.0.1:9092")
+	reg.watches <- watch
+	assert.Contains(t, receiveState(t, cc).Addresses[0].Addr, "127.0.0.1:9092")
+	state = r.State()
+	assert.True(t, state.Watching)
+	assert.Equal(t, 0, state.Retries)
+
+	states := States()
+	require.Len(t, states, 1)
+	assert.Equal(t, "etcd:///main", states[0].Target)
+	r.Close()
+	assert.Len(t, States(), 0)
+}
+
+func Test_baseResolver_ResolveNow(t *testing.T) {
+	reg := newFakeRegistry()
+	cc := &fakeClientConn{states: make(chan resolver.State, 10)}
+	r := newBaseResolver("etcd:///main", "etcd", "main/", reg, cc)
+	r.minResolveInterval = 0
+	defer r.Close()
+
+	// the watch stalls
+	watch := make(chan registry.Endpoints, 1)
+	watch <- newEndpoints("127.0.0.1:9091")
+	go r.run(watch)
+	assert.Len(t, receiveState(t, cc).Addresses, 1)
+
+	reg.lists <- []*server.ServiceInfo{{Address: "127.0.0.1:9092"}, {Address: "127.0.0.1:9093"}}
+	r.ResolveNow(resolver.ResolveNowOptions{})
+	assert.Len(t, receiveState(t, cc).Addresses, 2)
+
+	// the endpoints are kept when list fails or returns nothing
+	r.ResolveNow(resolver.ResolveNowOptions{})
+	assert.Eventually(t, func() bool {
+		return len(r.State().Errors) == 1
+	}, time.Second, time.Millisecond*5)
+	reg.lists <- []*server.ServiceInfo{}
+	r.ResolveNow(resolver.ResolveNowOptions{})
+	assert.Eventually(t, func() bool {
+		return len(reg.lists) == 0
+	}, time.Second, time.Millisecond*5)
+
+	state := r.State()
+	assert.Len(t, state.Endpoints, 2)
+	assert.True(t, state.Watching)
+	assert.Equal(t, "list failed", state.Errors[0].Error)
+	assert.Len(t, cc.states, 0)
+}
 
 func Test_baseResolver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+	configStr := `
+[jupiter.registry.resolver]
+	kind = "file"
+	configKey = "jupiter.registry.resolver"
+	path = "` + path + `"
+`
+	require.Nil(t, conf.LoadFromReader(bytes.NewBufferString(configStr), toml.Unmarshal))
+
+	// the kind configured takes precedence over scheme
+	builder := NewBuilder("etcd", "jupiter.registry.resolver")
+	assert.Equal(t, "etcd", builder.Scheme())
+
+	cc := &fakeClientConn{states: make(chan resolver.State, 10)}
+	r, err := builder.Build(resolver.Target{URL: url.URL{Scheme: "etcd", Path: "/service_1"}}, cc, resolver.BuildOptions{})
+	require.Nil(t, err)
+	defer r.Close()
+
+	state := <-cc.states
+	assert.Len(t, state.Addresses, 0)
+
+	reg, err := registry.Singleton("file", "jupiter.registry.resolver")
+	require.Nil(t, err)
+	assert.Equal(t, "file", reg.Kind())
+	require.Nil(t, reg.RegisterService(context.Background(), &server.ServiceInfo{
+		Name:    "service_1",
+		Scheme:  "grpc",
+		Address: "127.0.0.1:9091",
+	}))
+
+	select {
+	case state = <-cc.states:
+		require.Len(t, state.Addresses, 1)
+		assert.Equal(t, "127.0.0.1:9091", state.Addresses[0].Addr)
+	case <-time.After(time.Second * 3):
+		t.Fatal("no state updated")
+	}
 
+	_, err = NewBuilder("unknown", "jupiter.registry.unknown").Build(resolver.Target{URL: url.URL{Scheme: "unknown", Path: "/service_1"}}, cc, resolver.BuildOptions{})
+	assert.NotNil(t, err)
 }
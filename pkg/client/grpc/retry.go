@@ -0,0 +1,363 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zhengyansheng/jupiter/pkg/core/metric"
+	"github.com/zhengyansheng/jupiter/pkg/util/xgo"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	policyRetry   = "retry"
+	policyHedging = "hedging"
+
+	// 与grpc重试规范一致, 服务端可通过该元数据判断是否为重试请求
+	previousAttemptsKey = "grpc-previous-rpc-attempts"
+)
+
+// MethodConfig 按方法配置的重试或对冲策略, 同一方法只能配置其中一种
+type MethodConfig struct {
+	// Methods 方法全名, 如 /helloworld.Greeter/SayHello;
+	// 以 / 结尾时匹配整个服务, 如 /helloworld.Greeter/; * 匹配所有方法
+	Methods []string
+	Retry   *RetryPolicy
+	Hedging *HedgingPolicy
+}
+
+// RetryPolicy 失败后按指数退避重试
+type RetryPolicy struct {
+	// MaxAttempts 最大请求次数, 包括第一次请求
+	MaxAttempts int
+	// InitialBackoff 第一次重试前的最大退避时间, 默认100ms
+	InitialBackoff time.Duration
+	// MaxBackoff 最大退避时间, 默认1s
+	MaxBackoff time.Duration
+	// BackoffMultiplier 退避时间增长倍数, 默认2
+	BackoffMultiplier float64
+	// RetryableStatusCodes 可重试的状态码, 如 UNAVAILABLE, 默认 UNAVAILABLE
+	RetryableStatusCodes []string
+
+	retryableCodes map[codes.Code]struct{}
+}
+
+// HedgingPolicy 每隔 HedgingDelay 并发发起一次请求, 返回第一个成功或者致命错误的结果
+type HedgingPolicy struct {
+	// MaxAttempts 最大请求次数, 包括第一次请求
+	MaxAttempts int
+	// HedgingDelay 发起下一次请求的间隔, 为0时同时发起所有请求
+	HedgingDelay time.Duration
+	// NonFatalStatusCodes 非致命的状态码, 返回这些状态码时立即发起下一次请求, 默认 UNAVAILABLE
+	NonFatalStatusCodes []string
+
+	nonFatalCodes map[codes.Code]struct{}
+}
+
+func (config *MethodConfig) init() error {
+	if len(config.Methods) == 0 {
+		return fmt.Errorf("method config without methods")
+	}
+	if (config.Retry == nil) == (config.Hedging == nil) {
+		return fmt.Errorf("exactly one of retry and hedging policy should be set for methods %v", config.Methods)
+	}
+
+	var err error
+	if policy := config.Retry; policy != nil {
+		if policy.MaxAttempts < 2 {
+			return fmt.Errorf("retry policy of methods %v: maxAttempts should be greater than 1", config.Methods)
+		}
+		if policy.InitialBackoff <= 0 {
+			policy.InitialBackoff = 100 * time.Millisecond
+		}
+		if policy.MaxBackoff < policy.InitialBackoff {
+			policy.MaxBackoff = time.Second
+			if policy.MaxBackoff < policy.InitialBackoff {
+				policy.MaxBackoff = policy.InitialBackoff
+			}
+		}
+		if policy.BackoffMultiplier <= 0 {
+			policy.BackoffMultiplier = 2
+		}
+		if policy.retryableCodes, err = parseCodes(policy.RetryableStatusCodes); err != nil {
+			return fmt.Errorf("retry policy of methods %v: %w", config.Methods, err)
+		}
+	}
+	if policy := config.Hedging; policy != nil {
+		if policy.MaxAttempts < 2 {
+			return fmt.Errorf("hedging policy of methods %v: maxAttempts should be greater than 1", config.Methods)
+		}
+		if policy.nonFatalCodes, err = parseCodes(policy.NonFatalStatusCodes); err != nil {
+			return fmt.Errorf("hedging policy of methods %v: %w", config.Methods, err)
+		}
+	}
+	return nil
+}
+
+// backoff returns the backoff before the n-th retry, with full jitter
+func (policy *RetryPolicy) backoff(n int) time.Duration {
+	backoff := float64(policy.InitialBackoff) * math.Pow(policy.BackoffMultiplier, float64(n-1))
+	if backoff > float64(policy.MaxBackoff) {
+		backoff = float64(policy.MaxBackoff)
+	}
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
+}
+
+func parseCodes(names []string) (map[codes.Code]struct{}, error) {
+	if len(names) == 0 {
+		return map[codes.Code]struct{}{codes.Unavailable: {}}, nil
+	}
+
+	parsed := make(map[codes.Code]struct{}, len(names))
+	for _, name := range names {
+		c, err := parseCode(name)
+		if err != nil {
+			return nil, err
+		}
+		if c == codes.OK {
+			return nil, fmt.Errorf("status code OK can not be retried")
+		}
+		parsed[c] = struct{}{}
+	}
+	return parsed, nil
+}
+
+// parseCode 同时支持 DEADLINE_EXCEEDED 和 DeadlineExceeded 两种格式
+func parseCode(name string) (codes.Code, error) {
+	name = strings.TrimSpace(name)
+	for c := codes.OK; c <= codes.Unauthenticated; c++ {
+		if strings.EqualFold(c.String(), name) {
+			return c, nil
+		}
+	}
+
+	var c codes.Code
+	if err := c.UnmarshalJSON([]byte(strconv.Quote(strings.ToUpper(name)))); err != nil {
+		return c, fmt.Errorf("invalid status code: %s", name)
+	}
+	return c, nil
+}
+
+func hasCode(codeSet map[codes.Code]struct{}, err error) bool {
+	_, ok := codeSet[status.Code(err)]
+	return ok
+}
+
+// methodConfigs looks up the method config by full method name, service name and *
+type methodConfigs map[string]*MethodConfig
+
+func newMethodConfigs(configs []MethodConfig) (methodConfigs, error) {
+	mc := make(methodConfigs)
+	for i := range configs {
+		config := &configs[i]
+		if err := config.init(); err != nil {
+			return nil, err
+		}
+		for _, method := range config.Methods {
+			if _, ok := mc[method]; ok {
+				return nil, fmt.Errorf("duplicated method config: %s", method)
+			}
+			mc[method] = config
+		}
+	}
+	return mc, nil
+}
+
+func (mc methodConfigs) lookup(method string) *MethodConfig {
+	if config, ok := mc[method]; ok {
+		return config
+	}
+	if i := strings.LastIndex(method, "/"); i > 0 {
+		if config, ok := mc[method[:i+1]]; ok {
+			return config
+		}
+	}
+	return mc["*"]
+}
+
+// retryUnaryClientInterceptor 按方法配置进行重试或对冲请求
+func retryUnaryClientInterceptor(name string, mc methodConfigs) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		config := mc.lookup(method)
+		switch {
+		case config == nil:
+			return invoker(ctx, method, req, reply, cc, opts...)
+		case config.Retry != nil:
+			return retryInvoke(ctx, name, config.Retry, method, req, reply, cc, invoker, opts...)
+		default:
+			return hedgingInvoke(ctx, name, config.Hedging, method, req, reply, cc, invoker, opts...)
+		}
+	}
+}
+
+func retryInvoke(ctx context.Context, name string, policy *RetryPolicy, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	for attempt := 1; ; attempt++ {
+		err := invoker(withPreviousAttempts(ctx, attempt), method, req, reply, cc, opts...)
+		if err == nil || attempt >= policy.MaxAttempts || !hasCode(policy.retryableCodes, err) {
+			return err
+		}
+
+		backoff := policy.backoff(attempt)
+		// 剩余时间不足以完成退避时不再重试
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= backoff {
+			return err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		metric.ClientRetryCounter.Inc(metric.TypeGRPCUnary, name, method, policyRetry)
+	}
+}
+
+type hedgingResult struct {
+	reply proto.Message
+	err   error
+	// apply 将本次请求的header、trailer及peer写回调用方
+	apply func()
+}
+
+// errHedgingPanic 对冲请求panic时返回的错误
+var errHedgingPanic = status.Error(codes.Internal, "hedging attempt panic")
+
+func hedgingInvoke(ctx context.Context, name string, policy *HedgingPolicy, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	// 并发请求需要独立的reply, 只支持proto消息
+	msg, ok := reply.(proto.Message)
+	if !ok {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan hedgingResult, policy.MaxAttempts)
+	var attempts, pending int
+	attempt := func() {
+		attempts++
+		pending++
+		if attempts > 1 {
+			metric.ClientRetryCounter.Inc(metric.TypeGRPCUnary, name, method, policyHedging)
+		}
+		attemptReply := proto.Clone(msg)
+		proto.Reset(attemptReply)
+		attemptCtx := withPreviousAttempts(ctx, attempts)
+		attemptOpts, apply := attemptCallOptions(opts)
+		xgo.Go(func() {
+			res := hedgingResult{reply: attemptReply, err: errHedgingPanic, apply: apply}
+			// panic时同样需要返回结果, 否则会一直等待
+			defer func() { results <- res }()
+			res.err = invoker(attemptCtx, method, req, attemptReply, cc, attemptOpts...)
+		})
+	}
+
+	// 剩余时间不足以等待下一次请求时不再发起
+	var timer <-chan time.Time
+	schedule := func() {
+		timer = nil
+		if attempts >= policy.MaxAttempts {
+			return
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= policy.HedgingDelay {
+			return
+		}
+		timer = time.After(policy.HedgingDelay)
+	}
+
+	attempt()
+	schedule()
+
+	var lastErr error
+	for {
+		select {
+		case <-timer:
+			attempt()
+			schedule()
+		case res := <-results:
+			pending--
+			if res.err == nil || !hasCode(policy.nonFatalCodes, res.err) {
+				res.apply()
+				if res.err == nil {
+					proto.Reset(msg)
+					proto.Merge(msg, res.reply)
+				}
+				return res.err
+			}
+			lastErr = res.err
+			// 非致命错误立即发起下一次请求
+			if attempts < policy.MaxAttempts {
+				attempt()
+				schedule()
+			} else if pending == 0 {
+				res.apply()
+				return lastErr
+			}
+		}
+	}
+}
+
+// attemptCallOptions 并发的对冲请求不能共享header、trailer及peer的写入目标,
+// 为每次请求替换为独立的目标, 返回的apply将其写回调用方的目标
+func attemptCallOptions(opts []grpc.CallOption) ([]grpc.CallOption, func()) {
+	var (
+		attemptOpts = make([]grpc.CallOption, 0, len(opts))
+		applies     []func()
+	)
+	for _, opt := range opts {
+		switch o := opt.(type) {
+		case grpc.HeaderCallOption:
+			md := new(metadata.MD)
+			attemptOpts = append(attemptOpts, grpc.Header(md))
+			applies = append(applies, func() { *o.HeaderAddr = *md })
+		case grpc.TrailerCallOption:
+			md := new(metadata.MD)
+			attemptOpts = append(attemptOpts, grpc.Trailer(md))
+			applies = append(applies, func() { *o.TrailerAddr = *md })
+		case grpc.PeerCallOption:
+			p := new(peer.Peer)
+			attemptOpts = append(attemptOpts, grpc.Peer(p))
+			applies = append(applies, func() { *o.PeerAddr = *p })
+		default:
+			attemptOpts = append(attemptOpts, opt)
+		}
+	}
+	return attemptOpts, func() {
+		for _, apply := range applies {
+			apply()
+		}
+	}
+}
+
+func withPreviousAttempts(ctx context.Context, attempt int) context.Context {
+	if attempt <= 1 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, previousAttemptsKey, strconv.Itoa(attempt-1))
+}
@@ -0,0 +1,264 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpc
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const testMethod = "/helloworld.Greeter/SayHello"
+
+func newTestMethodConfigs(t *testing.T, configs ...MethodConfig) methodConfigs {
+	mc, err := newMethodConfigs(configs)
+	require.NoError(t, err)
+	return mc
+}
+
+func Test_parseCode(t *testing.T) {
+	for _, name := range []string{"UNAVAILABLE", "Unavailable", "unavailable"} {
+		c, err := parseCode(name)
+		assert.NoError(t, err)
+		assert.Equal(t, codes.Unavailable, c)
+	}
+	for _, name := range []string{"DEADLINE_EXCEEDED", "DeadlineExceeded"} {
+		c, err := parseCode(name)
+		assert.NoError(t, err)
+		assert.Equal(t, codes.DeadlineExceeded, c)
+	}
+	_, err := parseCode("NOT_A_CODE")
+	assert.Error(t, err)
+}
+
+func Test_newMethodConfigs(t *testing.T) {
+	_, err := newMethodConfigs([]MethodConfig{{Methods: []string{testMethod}}})
+	assert.Error(t, err, "policy is required")
+
+	_, err = newMethodConfigs([]MethodConfig{{Methods: []string{testMethod}, Retry: &RetryPolicy{MaxAttempts: 1}}})
+	assert.Error(t, err, "maxAttempts should be greater than 1")
+
+	_, err = newMethodConfigs([]MethodConfig{{Methods: []string{testMethod}, Retry: &RetryPolicy{MaxAttempts: 2, RetryableStatusCodes: []string{"OK"}}}})
+	assert.Error(t, err, "OK is not retryable")
+
+	_, err = newMethodConfigs([]MethodConfig{
+		{Methods: []string{testMethod}, Retry: &RetryPolicy{MaxAttempts: 2}},
+		{Methods: []string{testMethod}, Hedging: &HedgingPolicy{MaxAttempts: 2}},
+	})
+	assert.Error(t, err, "duplicated method")
+
+	mc := newTestMethodConfigs(t,
+		MethodConfig{Methods: []string{testMethod}, Retry: &RetryPolicy{MaxAttempts: 2}},
+		MethodConfig{Methods: []string{"/helloworld.Greeter/"}, Retry: &RetryPolicy{MaxAttempts: 3}},
+		MethodConfig{Methods: []string{"*"}, Hedging: &HedgingPolicy{MaxAttempts: 4}},
+	)
+	assert.Equal(t, 2, mc.lookup(testMethod).Retry.MaxAttempts)
+	assert.Equal(t, 100*time.Millisecond, mc.lookup(testMethod).Retry.InitialBackoff)
+	assert.Equal(t, 3, mc.lookup("/helloworld.Greeter/SayHi").Retry.MaxAttempts)
+	assert.Equal(t, 4, mc.lookup("/routeguide.RouteGuide/GetFeature").Hedging.MaxAttempts)
+}
+
+func Test_retryUnaryClientInterceptor_retry(t *testing.T) {
+	mc := newTestMethodConfigs(t, MethodConfig{
+		Methods: []string{testMethod},
+		Retry: &RetryPolicy{
+			MaxAttempts:          3,
+			InitialBackoff:       time.Millisecond,
+			RetryableStatusCodes: []string{"UNAVAILABLE", "RESOURCE_EXHAUSTED"},
+		},
+	})
+	interceptor := retryUnaryClientInterceptor("test", mc)
+
+	t.Run("retry until success", func(t *testing.T) {
+		var attempts []string
+		err := interceptor(context.Background(), testMethod, nil, nil, nil,
+			func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				md, _ := metadata.FromOutgoingContext(ctx)
+				attempts = append(attempts, append(md.Get(previousAttemptsKey), "")[0])
+				if len(attempts) < 3 {
+					return status.Error(codes.Unavailable, "unavailable")
+				}
+				return nil
+			})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"", "1", "2"}, attempts)
+	})
+
+	t.Run("max attempts", func(t *testing.T) {
+		var attempts int
+		err := interceptor(context.Background(), testMethod, nil, nil, nil,
+			func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				attempts++
+				return status.Error(codes.ResourceExhausted, "exhausted")
+			})
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+		assert.Equal(t, 3, attempts)
+	})
+
+	t.Run("non retryable code", func(t *testing.T) {
+		var attempts int
+		err := interceptor(context.Background(), testMethod, nil, nil, nil,
+			func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				attempts++
+				return status.Error(codes.InvalidArgument, "invalid")
+			})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		assert.Equal(t, 1, attempts)
+	})
+
+	t.Run("deadline exceeded", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		var attempts int
+		err := interceptor(ctx, testMethod, nil, nil, nil,
+			func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				attempts++
+				<-ctx.Done()
+				return status.Error(codes.Unavailable, "unavailable")
+			})
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+		assert.Equal(t, 1, attempts)
+	})
+
+	t.Run("method without policy", func(t *testing.T) {
+		var attempts int
+		err := interceptor(context.Background(), "/helloworld.Greeter/SayHi", nil, nil, nil,
+			func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				attempts++
+				return status.Error(codes.Unavailable, "unavailable")
+			})
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+		assert.Equal(t, 1, attempts)
+	})
+}
+
+func Test_retryUnaryClientInterceptor_hedging(t *testing.T) {
+	newInterceptor := func(delay time.Duration) grpc.UnaryClientInterceptor {
+		return retryUnaryClientInterceptor("test", newTestMethodConfigs(t, MethodConfig{
+			Methods: []string{testMethod},
+			Hedging: &HedgingPolicy{MaxAttempts: 3, HedgingDelay: delay},
+		}))
+	}
+
+	t.Run("hedged attempt wins", func(t *testing.T) {
+		var attempts int32
+		var slow sync.WaitGroup
+		slow.Add(1)
+		reply := &wrapperspb.StringValue{}
+		err := newInterceptor(10*time.Millisecond)(context.Background(), testMethod, nil, reply, nil,
+			func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				if atomic.AddInt32(&attempts, 1) == 1 {
+					// 第一次请求一直阻塞, 直到对冲请求返回后被取消
+					defer slow.Done()
+					<-ctx.Done()
+					return status.FromContextError(ctx.Err()).Err()
+				}
+				reply.(*wrapperspb.StringValue).Value = "hedged"
+				return nil
+			})
+		assert.NoError(t, err)
+		assert.Equal(t, "hedged", reply.Value)
+		slow.Wait()
+		assert.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+	})
+
+	t.Run("header and trailer of the winner", func(t *testing.T) {
+		var attempts int32
+		var slow sync.WaitGroup
+		slow.Add(1)
+		var header, trailer metadata.MD
+		err := newInterceptor(time.Millisecond)(context.Background(), testMethod, nil, &wrapperspb.StringValue{}, nil,
+			func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				attempt := atomic.AddInt32(&attempts, 1)
+				// 每次请求都会写入header及trailer, 共享写入目标时会产生数据竞争
+				for _, opt := range opts {
+					switch o := opt.(type) {
+					case grpc.HeaderCallOption:
+						*o.HeaderAddr = metadata.Pairs("attempt", strconv.Itoa(int(attempt)))
+					case grpc.TrailerCallOption:
+						*o.TrailerAddr = metadata.Pairs("attempt", strconv.Itoa(int(attempt)))
+					}
+				}
+				if attempt == 1 {
+					defer slow.Done()
+					<-ctx.Done()
+					return status.FromContextError(ctx.Err()).Err()
+				}
+				return nil
+			}, grpc.Header(&header), grpc.Trailer(&trailer))
+		assert.NoError(t, err)
+		slow.Wait()
+		assert.Equal(t, []string{"2"}, header.Get("attempt"))
+		assert.Equal(t, []string{"2"}, trailer.Get("attempt"))
+	})
+
+	t.Run("panic", func(t *testing.T) {
+		err := newInterceptor(time.Hour)(context.Background(), testMethod, nil, &wrapperspb.StringValue{}, nil,
+			func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				panic("attempt panic")
+			})
+		assert.Equal(t, codes.Internal, status.Code(err))
+	})
+
+	t.Run("non fatal error", func(t *testing.T) {
+		var attempts int32
+		reply := &wrapperspb.StringValue{}
+		err := newInterceptor(time.Hour)(context.Background(), testMethod, nil, reply, nil,
+			func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				if atomic.AddInt32(&attempts, 1) < 3 {
+					return status.Error(codes.Unavailable, "unavailable")
+				}
+				reply.(*wrapperspb.StringValue).Value = "ok"
+				return nil
+			})
+		assert.NoError(t, err)
+		assert.Equal(t, "ok", reply.Value)
+		assert.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+	})
+
+	t.Run("all attempts failed", func(t *testing.T) {
+		var attempts int32
+		err := newInterceptor(time.Hour)(context.Background(), testMethod, nil, &wrapperspb.StringValue{}, nil,
+			func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				atomic.AddInt32(&attempts, 1)
+				return status.Error(codes.Unavailable, "unavailable")
+			})
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+		assert.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+	})
+
+	t.Run("fatal error", func(t *testing.T) {
+		var attempts int32
+		err := newInterceptor(time.Hour)(context.Background(), testMethod, nil, &wrapperspb.StringValue{}, nil,
+			func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				atomic.AddInt32(&attempts, 1)
+				return status.Error(codes.InvalidArgument, "invalid")
+			})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+	})
+}
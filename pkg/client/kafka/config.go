@@ -0,0 +1,274 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kafka
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/zhengyansheng/jupiter/pkg"
+	"github.com/zhengyansheng/jupiter/pkg/conf"
+	"github.com/zhengyansheng/jupiter/pkg/core/constant"
+	"github.com/zhengyansheng/jupiter/pkg/util/xdebug"
+	"github.com/zhengyansheng/jupiter/pkg/xlog"
+)
+
+// Config kafka配置，生产者及消费者可以共用addr
+type Config struct {
+	Addresses []string        `json:"addr" toml:"addr"`
+	Consumer  *ConsumerConfig `json:"consumer" toml:"consumer"`
+	Producer  *ProducerConfig `json:"producer" toml:"producer"`
+}
+
+// ClientConfig 生产者及消费者通用的配置
+type ClientConfig struct {
+	Name string   `json:"name" toml:"name"`
+	Addr []string `json:"addr" toml:"addr"`
+	// kafka的版本，默认为2.1.0
+	Version string `json:"version" toml:"version"`
+	// 默认为应用名
+	ClientID    string        `json:"clientId" toml:"clientId"`
+	DialTimeout time.Duration `json:"dialTimeout" toml:"dialTimeout"`
+	// 慢日志阈值，为0时不记录
+	RwTimeout time.Duration `json:"rwTimeout" toml:"rwTimeout"`
+	// SASL认证，username为空时不开启
+	Username string `json:"username" toml:"username"`
+	Password string `json:"password" toml:"password"`
+	// PLAIN、SCRAM-SHA-256或SCRAM-SHA-512，默认为PLAIN
+	Mechanism   string `json:"mechanism" toml:"mechanism"`
+	EnableTrace bool   `json:"enableTrace" toml:"enableTrace"`
+}
+
+// ProducerConfig producer config
+type ProducerConfig struct {
+	ClientConfig
+	Topic string `json:"topic" toml:"topic"`
+	Retry int    `json:"retry" toml:"retry"`
+	// 0: 不等待响应，1: 等待leader写入，-1: 等待所有isr写入，默认为-1
+	RequiredAcks int16 `json:"requiredAcks" toml:"requiredAcks"`
+	// none、gzip、snappy、lz4或zstd，默认为none
+	Compression string `json:"compression" toml:"compression"`
+	// 分区策略，hash、random、roundrobin或manual，默认为hash，相同key的消息发送到同一个分区
+	Partitioner string `json:"partitioner" toml:"partitioner"`
+	// 幂等生产，需要kafka 0.11及以上版本
+	Idempotent bool `json:"idempotent" toml:"idempotent"`
+}
+
+// ConsumerConfig consumer group config
+type ConsumerConfig struct {
+	ClientConfig
+	Enable bool     `json:"enable" toml:"enable"`
+	Topics []string `json:"topics" toml:"topics"`
+	Group  string   `json:"group" toml:"group"`
+	// 没有提交过offset时的消费位置，newest或oldest，默认为newest
+	InitialOffset string `json:"initialOffset" toml:"initialOffset"`
+	// 分区分配策略，range、roundrobin或sticky，默认为range
+	Balance string `json:"balance" toml:"balance"`
+	// 自动提交时按照autoCommitInterval异步提交已消费的offset，否则每次消费成功后同步提交
+	AutoCommit         bool          `json:"autoCommit" toml:"autoCommit"`
+	AutoCommitInterval time.Duration `json:"autoCommitInterval" toml:"autoCommitInterval"`
+	// 批量消费的最大消息数量，默认为1
+	BatchSize int `json:"batchSize" toml:"batchSize"`
+	// 批量消费时等待凑满一批消息的最长时间，默认为100ms
+	BatchTimeout time.Duration `json:"batchTimeout" toml:"batchTimeout"`
+	// 消费失败的重试次数及间隔，重试耗尽后跳过消息，为-1时一直重试
+	Retry         int           `json:"retry" toml:"retry"`
+	RetryInterval time.Duration `json:"retryInterval" toml:"retryInterval"`
+	// 限流，rate及capacity都大于0时开启
+	Rate            float64       `json:"rate" toml:"rate"`
+	Capacity        int64         `json:"capacity" toml:"capacity"`
+	WaitMaxDuration time.Duration `json:"waitMaxDuration" toml:"waitMaxDuration"`
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		Addresses: make([]string, 0),
+		Producer: &ProducerConfig{
+			ClientConfig: ClientConfig{
+				Version:     "2.1.0",
+				DialTimeout: time.Second * 3,
+				EnableTrace: true,
+			},
+			Retry:        3,
+			RequiredAcks: int16(sarama.WaitForAll),
+			Compression:  "none",
+			Partitioner:  "hash",
+		},
+		Consumer: &ConsumerConfig{
+			ClientConfig: ClientConfig{
+				Version:     "2.1.0",
+				DialTimeout: time.Second * 3,
+				EnableTrace: true,
+			},
+			Enable:             true,
+			InitialOffset:      "newest",
+			Balance:            "range",
+			AutoCommit:         true,
+			AutoCommitInterval: time.Second,
+			BatchSize:          1,
+			BatchTimeout:       time.Millisecond * 100,
+			Retry:              3,
+			RetryInterval:      time.Second,
+			WaitMaxDuration:    time.Second * 60,
+		},
+	}
+}
+
+// StdProducerConfig ...
+func StdProducerConfig(name string) *ProducerConfig {
+	return RawProducerConfig(constant.ConfigKey("kafka." + name))
+}
+
+// StdConsumerConfig ...
+func StdConsumerConfig(name string) *ConsumerConfig {
+	return RawConsumerConfig(constant.ConfigKey("kafka." + name))
+}
+
+// RawProducerConfig 返回producer配置
+func RawProducerConfig(key string) *ProducerConfig {
+	var config = DefaultConfig()
+	var producerConfig = config.Producer
+	if err := conf.UnmarshalKey(key, &config, conf.TagName("toml")); err != nil ||
+		(len(producerConfig.Addr) == 0 && len(config.Addresses) == 0) {
+		xlog.Jupiter().Panic("RawProducerConfig fail", xlog.FieldErr(err), xlog.String("key", key), xlog.Any("config", producerConfig))
+	}
+	if len(producerConfig.Addr) == 0 {
+		producerConfig.Addr = config.Addresses
+	}
+	producerConfig.Name = key
+
+	if xdebug.IsDevelopmentMode() {
+		xdebug.PrettyJsonPrint(key, producerConfig)
+	}
+	return producerConfig
+}
+
+// RawConsumerConfig 返回consumer配置
+func RawConsumerConfig(key string) *ConsumerConfig {
+	var config = DefaultConfig()
+	var consumerConfig = config.Consumer
+	if err := conf.UnmarshalKey(key, &config, conf.TagName("toml")); err != nil ||
+		(len(consumerConfig.Addr) == 0 && len(config.Addresses) == 0) ||
+		len(consumerConfig.Topics) == 0 || consumerConfig.Group == "" {
+		xlog.Jupiter().Panic("RawConsumerConfig fail", xlog.FieldErr(err), xlog.String("key", key), xlog.Any("config", consumerConfig))
+	}
+	if len(consumerConfig.Addr) == 0 {
+		consumerConfig.Addr = config.Addresses
+	}
+	consumerConfig.Name = key
+
+	if xdebug.IsDevelopmentMode() {
+		xdebug.PrettyJsonPrint(key, consumerConfig)
+	}
+	return consumerConfig
+}
+
+func (config *ClientConfig) saramaConfig() (*sarama.Config, error) {
+	sc := sarama.NewConfig()
+	version, err := sarama.ParseKafkaVersion(config.Version)
+	if err != nil {
+		return nil, err
+	}
+	sc.Version = version
+	sc.ClientID = config.ClientID
+	if sc.ClientID == "" {
+		sc.ClientID = pkg.Name()
+	}
+	if config.DialTimeout > 0 {
+		sc.Net.DialTimeout = config.DialTimeout
+	}
+	if config.Username != "" {
+		sc.Net.SASL.Enable = true
+		sc.Net.SASL.User = config.Username
+		sc.Net.SASL.Password = config.Password
+		if config.Mechanism != "" {
+			sc.Net.SASL.Mechanism = sarama.SASLMechanism(config.Mechanism)
+		}
+		sc.Net.SASL.SCRAMClientGeneratorFunc = newSCRAMClientGenerator(sc.Net.SASL.Mechanism)
+	}
+	return sc, nil
+}
+
+func (config *ProducerConfig) saramaConfig() (*sarama.Config, error) {
+	sc, err := config.ClientConfig.saramaConfig()
+	if err != nil {
+		return nil, err
+	}
+	// SyncProducer需要返回发送结果
+	sc.Producer.Return.Successes = true
+	sc.Producer.Return.Errors = true
+	sc.Producer.Retry.Max = config.Retry
+	sc.Producer.RequiredAcks = sarama.RequiredAcks(config.RequiredAcks)
+	if err := sc.Producer.Compression.UnmarshalText([]byte(config.Compression)); err != nil {
+		return nil, err
+	}
+
+	switch config.Partitioner {
+	case "", "hash":
+		sc.Producer.Partitioner = sarama.NewHashPartitioner
+	case "random":
+		sc.Producer.Partitioner = sarama.NewRandomPartitioner
+	case "roundrobin":
+		sc.Producer.Partitioner = sarama.NewRoundRobinPartitioner
+	case "manual":
+		sc.Producer.Partitioner = sarama.NewManualPartitioner
+	default:
+		return nil, fmt.Errorf("kafka: unknown partitioner %q", config.Partitioner)
+	}
+
+	if config.Idempotent {
+		sc.Producer.Idempotent = true
+		sc.Producer.RequiredAcks = sarama.WaitForAll
+		sc.Net.MaxOpenRequests = 1
+	}
+	return sc, sc.Validate()
+}
+
+func (config *ConsumerConfig) saramaConfig() (*sarama.Config, error) {
+	sc, err := config.ClientConfig.saramaConfig()
+	if err != nil {
+		return nil, err
+	}
+	sc.Consumer.Return.Errors = true
+
+	switch strings.ToLower(config.InitialOffset) {
+	case "", "newest":
+		sc.Consumer.Offsets.Initial = sarama.OffsetNewest
+	case "oldest":
+		sc.Consumer.Offsets.Initial = sarama.OffsetOldest
+	default:
+		return nil, fmt.Errorf("kafka: unknown initial offset %q", config.InitialOffset)
+	}
+
+	switch config.Balance {
+	case "", "range":
+		sc.Consumer.Group.Rebalance.GroupStrategies = []sarama.BalanceStrategy{sarama.NewBalanceStrategyRange()}
+	case "roundrobin":
+		sc.Consumer.Group.Rebalance.GroupStrategies = []sarama.BalanceStrategy{sarama.NewBalanceStrategyRoundRobin()}
+	case "sticky":
+		sc.Consumer.Group.Rebalance.GroupStrategies = []sarama.BalanceStrategy{sarama.NewBalanceStrategySticky()}
+	default:
+		return nil, fmt.Errorf("kafka: unknown balance strategy %q", config.Balance)
+	}
+
+	sc.Consumer.Offsets.AutoCommit.Enable = config.AutoCommit
+	if config.AutoCommitInterval > 0 {
+		sc.Consumer.Offsets.AutoCommit.Interval = config.AutoCommitInterval
+	}
+	return sc, sc.Validate()
+}
@@ -0,0 +1,98 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kafka
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhengyansheng/jupiter/pkg/conf"
+)
+
+func TestConfig(t *testing.T) {
+	var configStr = `
+[jupiter.kafka.order]
+	addr=["127.0.0.1:9092"]
+	[jupiter.kafka.order.producer]
+		topic="order"
+		compression="snappy"
+		partitioner="roundrobin"
+		rwTimeout="1s"
+	[jupiter.kafka.order.consumer]
+		topics=["order"]
+		group="order_group"
+		initialOffset="oldest"
+		autoCommit=false
+		batchSize=10
+		batchTimeout="50ms"
+		rate=100
+		capacity=10
+		username="user"
+		password="secret"
+		mechanism="SCRAM-SHA-256"
+	`
+	assert.Nil(t, conf.LoadFromReader(bytes.NewBufferString(configStr), toml.Unmarshal))
+
+	t.Run("producer config", func(t *testing.T) {
+		config := StdProducerConfig("order")
+		assert.Equal(t, "jupiter.kafka.order", config.Name)
+		assert.Equal(t, []string{"127.0.0.1:9092"}, config.Addr)
+		assert.Equal(t, "order", config.Topic)
+		assert.Equal(t, time.Second, config.RwTimeout)
+		assert.Equal(t, 3, config.Retry)
+		assert.True(t, config.EnableTrace)
+
+		sc, err := config.saramaConfig()
+		assert.Nil(t, err)
+		assert.Equal(t, sarama.CompressionSnappy, sc.Producer.Compression)
+		assert.Equal(t, sarama.WaitForAll, sc.Producer.RequiredAcks)
+		assert.True(t, sc.Producer.Return.Successes)
+		assert.Equal(t, sarama.V2_1_0_0, sc.Version)
+	})
+
+	t.Run("consumer config", func(t *testing.T) {
+		config := StdConsumerConfig("order")
+		assert.Equal(t, []string{"order"}, config.Topics)
+		assert.Equal(t, "order_group", config.Group)
+		assert.False(t, config.AutoCommit)
+		assert.Equal(t, 10, config.BatchSize)
+		assert.Equal(t, 50*time.Millisecond, config.BatchTimeout)
+		assert.Equal(t, float64(100), config.Rate)
+		assert.Equal(t, int64(10), config.Capacity)
+
+		sc, err := config.saramaConfig()
+		assert.Nil(t, err)
+		assert.Equal(t, sarama.OffsetOldest, sc.Consumer.Offsets.Initial)
+		assert.False(t, sc.Consumer.Offsets.AutoCommit.Enable)
+		assert.True(t, sc.Net.SASL.Enable)
+		assert.Equal(t, sarama.SASLMechanism(sarama.SASLTypeSCRAMSHA256), sc.Net.SASL.Mechanism)
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		config := DefaultConfig().Consumer
+		config.Balance = "unknown"
+		_, err := config.saramaConfig()
+		assert.NotNil(t, err)
+
+		producer := DefaultConfig().Producer
+		producer.Compression = "unknown"
+		_, err = producer.saramaConfig()
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,373 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/juju/ratelimit"
+	"github.com/zhengyansheng/jupiter/pkg/core/xtrace"
+	"github.com/zhengyansheng/jupiter/pkg/util/xdebug"
+	"github.com/zhengyansheng/jupiter/pkg/worker"
+	"github.com/zhengyansheng/jupiter/pkg/xlog"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// Consumer 基于consumer group消费消息，实现了worker.Worker，通过Application.Schedule管理生命周期
+type Consumer struct {
+	sarama.ConsumerGroup
+	name string
+	ConsumerConfig
+
+	single       func(context.Context, *sarama.ConsumerMessage) error
+	batch        func(context.Context, ...*sarama.ConsumerMessage) error
+	interceptors []Interceptor
+	invoker      Invoker
+	bucket       *ratelimit.Bucket
+	tracer       *xtrace.Tracer
+
+	mu      sync.Mutex
+	started bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+var _ worker.Worker = (*Consumer)(nil)
+
+func StdNewConsumer(name string) *Consumer {
+	return StdConsumerConfig(name).Build()
+}
+
+func (conf *ConsumerConfig) Build() *Consumer {
+	name := conf.Name
+
+	if xdebug.IsDevelopmentMode() {
+		xdebug.PrettyJsonPrint("kafka's config: "+name, conf)
+	}
+
+	var bucket *ratelimit.Bucket
+	if conf.Rate > 0 && conf.Capacity > 0 {
+		bucket = ratelimit.NewBucketWithRate(conf.Rate, conf.Capacity)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cc := &Consumer{
+		name:           name,
+		ConsumerConfig: *conf,
+		interceptors:   []Interceptor{},
+		bucket:         bucket,
+		tracer:         xtrace.NewTracer(trace.SpanKindConsumer),
+		ctx:            ctx,
+		cancel:         cancel,
+	}
+	cc.interceptors = append(cc.interceptors,
+		consumerMetricInterceptor(cc),
+		consumerSlowInterceptor(cc),
+		consumerSentinelInterceptor(cc),
+	)
+
+	return cc
+}
+
+// WithInterceptor 需要在Run之前调用
+func (cc *Consumer) WithInterceptor(fs ...Interceptor) *Consumer {
+	cc.interceptors = append(cc.interceptors, fs...)
+	return cc
+}
+
+// RegisterSingleMessage 注册单条消息处理函数
+func (cc *Consumer) RegisterSingleMessage(f func(context.Context, *sarama.ConsumerMessage) error) *Consumer {
+	if cc.single != nil || cc.batch != nil {
+		xlog.Jupiter().Panic("duplicated register message handler", zap.String("name", cc.name))
+	}
+
+	cc.single = f
+	return cc
+}
+
+// RegisterBatchMessage 注册批量消息处理函数，同一个分区最多batchSize条消息为一批，
+// 等待batchTimeout后不足一批的消息也会被处理
+func (cc *Consumer) RegisterBatchMessage(f func(context.Context, ...*sarama.ConsumerMessage) error) *Consumer {
+	if cc.single != nil || cc.batch != nil {
+		xlog.Jupiter().Panic("duplicated register message handler", zap.String("name", cc.name))
+	}
+
+	cc.batch = f
+	return cc
+}
+
+// Start 创建consumer group，Run会自动调用
+func (cc *Consumer) Start() error {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.started {
+		return nil
+	}
+	if cc.single == nil && cc.batch == nil {
+		return errors.New("kafka: no message handler registered")
+	}
+
+	config, err := cc.ConsumerConfig.saramaConfig()
+	if err != nil {
+		return err
+	}
+
+	group, err := sarama.NewConsumerGroup(cc.Addr, cc.Group, config)
+	if err != nil {
+		return err
+	}
+
+	cc.started = true
+	cc.ConsumerGroup = group
+	cc.invoker = chainInterceptors(cc.interceptors, cc.handle)
+	return nil
+}
+
+// Run 阻塞消费消息，直到Stop被调用
+func (cc *Consumer) Run() error {
+	if !cc.Enable {
+		xlog.Jupiter().Info("kafka consumer disabled", zap.String("name", cc.name))
+		return nil
+	}
+	if err := cc.Start(); err != nil {
+		xlog.Jupiter().Error("kafka consumer start fail", zap.String("name", cc.name), zap.Error(err))
+		return err
+	}
+
+	go func() {
+		for err := range cc.ConsumerGroup.Errors() {
+			xlog.Jupiter().Error("kafka consumer group", zap.String("name", cc.name), zap.Error(err))
+		}
+	}()
+
+	handler := &groupHandler{consumer: cc}
+	for {
+		// rebalance后Consume会返回，需要重新加入consumer group
+		err := cc.ConsumerGroup.Consume(cc.ctx, cc.Topics, handler)
+		if errors.Is(err, sarama.ErrClosedConsumerGroup) || cc.ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			xlog.Jupiter().Error("kafka consume", zap.String("name", cc.name), zap.Error(err))
+			if !cc.sleep(cc.ctx, cc.RetryInterval) {
+				return nil
+			}
+		}
+	}
+}
+
+// Stop 停止消费并退出consumer group
+func (cc *Consumer) Stop() error {
+	cc.cancel()
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if !cc.started {
+		return nil
+	}
+	cc.started = false
+	return cc.ConsumerGroup.Close()
+}
+
+// process 处理一批消息，失败时按照retry配置重试，成功或者重试耗尽后提交offset，
+// 返回false表示session已经结束，offset没有提交，消息会在rebalance后重新消费
+func (cc *Consumer) process(session sarama.ConsumerGroupSession, msgs []*sarama.ConsumerMessage) bool {
+	ctx := session.Context()
+	if !cc.wait(ctx, int64(len(msgs))) {
+		return false
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := cc.invoker(ctx, msgs, nil)
+		if err == nil {
+			break
+		}
+		if cc.Retry >= 0 && attempt >= cc.Retry {
+			last := msgs[len(msgs)-1]
+			xlog.Jupiter().Error("consume retry exhausted, skip messages",
+				zap.String("name", cc.name),
+				zap.String("topic", last.Topic),
+				zap.Int32("partition", last.Partition),
+				zap.Int64("offset", last.Offset),
+				zap.Int("count", len(msgs)),
+				zap.Error(err))
+			break
+		}
+		if !cc.sleep(ctx, cc.RetryInterval) {
+			return false
+		}
+	}
+
+	for _, msg := range msgs {
+		session.MarkMessage(msg, "")
+	}
+	if !cc.AutoCommit {
+		session.Commit()
+	}
+	return true
+}
+
+// wait 限流，超过waitMaxDuration仍然没有令牌时告警并继续等待，直到session结束
+func (cc *Consumer) wait(ctx context.Context, count int64) bool {
+	if cc.bucket == nil {
+		return true
+	}
+	// Take预占令牌并返回需要等待的时间，等待过程中session结束时直接返回
+	for d := cc.bucket.Take(count); d > 0; {
+		step := d
+		if cc.WaitMaxDuration > 0 && step > cc.WaitMaxDuration {
+			step = cc.WaitMaxDuration
+		}
+		if !cc.sleep(ctx, step) {
+			return false
+		}
+		if d -= step; d > 0 {
+			xlog.Jupiter().Warn("too many messages, consume later", zap.String("name", cc.name), zap.Int64("count", count), zap.Duration("wait", d))
+		}
+	}
+	return true
+}
+
+func (cc *Consumer) sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// handle 调用注册的处理函数，作为拦截器链的最后一环
+func (cc *Consumer) handle(ctx context.Context, req, reply interface{}) (err error) {
+	msgs := req.([]*sarama.ConsumerMessage)
+
+	// the recover to prevent panic from causing the coroutine to exit when processing msg.
+	defer func() {
+		if r := recover(); r != nil {
+			xlog.Jupiter().Error("consumer message panic", zap.String("stack", string(debug.Stack())))
+			err = fmt.Errorf("consumer message panic: %v", r)
+		}
+	}()
+
+	if cc.EnableTrace {
+		var span trace.Span
+		ctx, span = startConsumeSpan(cc.tracer, ctx, cc.Group, msgs...)
+		defer func() {
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			span.End()
+		}()
+
+		traceID := span.SpanContext().TraceID().String()
+		ctx = xlog.NewContext(ctx, xlog.Default(), traceID)
+		ctx = xlog.NewContext(ctx, xlog.Jupiter(), traceID)
+	}
+
+	if cc.batch != nil {
+		return cc.batch(ctx, msgs...)
+	}
+	return cc.single(ctx, msgs[0])
+}
+
+// groupHandler 实现sarama.ConsumerGroupHandler，每个分区一个goroutine
+type groupHandler struct {
+	consumer *Consumer
+}
+
+func (h *groupHandler) Setup(session sarama.ConsumerGroupSession) error {
+	xlog.Jupiter().Info("kafka consumer group setup",
+		zap.String("name", h.consumer.name),
+		zap.String("member", session.MemberID()),
+		zap.Any("claims", session.Claims()))
+	return nil
+}
+
+func (h *groupHandler) Cleanup(session sarama.ConsumerGroupSession) error {
+	xlog.Jupiter().Info("kafka consumer group cleanup",
+		zap.String("name", h.consumer.name),
+		zap.String("member", session.MemberID()))
+	return nil
+}
+
+func (h *groupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	cc := h.consumer
+	// 单条消费时每条消息单独处理
+	if cc.batch == nil || cc.BatchSize <= 1 {
+		for {
+			select {
+			case msg, ok := <-claim.Messages():
+				if !ok || !cc.process(session, []*sarama.ConsumerMessage{msg}) {
+					return nil
+				}
+			case <-session.Context().Done():
+				return nil
+			}
+		}
+	}
+
+	batch := make([]*sarama.ConsumerMessage, 0, cc.BatchSize)
+	timer := time.NewTimer(cc.BatchTimeout)
+	defer timer.Stop()
+	flush := func() bool {
+		if len(batch) == 0 {
+			return true
+		}
+		ok := cc.process(session, batch)
+		batch = make([]*sarama.ConsumerMessage, 0, cc.BatchSize)
+		return ok
+	}
+
+	for {
+		select {
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				flush()
+				return nil
+			}
+			if len(batch) == 0 {
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(cc.BatchTimeout)
+			}
+			batch = append(batch, msg)
+			if len(batch) >= cc.BatchSize && !flush() {
+				return nil
+			}
+		case <-timer.C:
+			if !flush() {
+				return nil
+			}
+			timer.Reset(cc.BatchTimeout)
+		case <-session.Context().Done():
+			return nil
+		}
+	}
+}
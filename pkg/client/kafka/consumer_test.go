@@ -0,0 +1,228 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kafka
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+func newMockGroupBroker(t *testing.T, values ...string) *sarama.MockBroker {
+	broker := sarama.NewMockBroker(t, 1)
+
+	fetch := sarama.NewMockFetchResponse(t, len(values))
+	for i, value := range values {
+		fetch.SetMessage("order", 0, int64(i), sarama.StringEncoder(value))
+	}
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("order", 0, broker.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset("order", 0, sarama.OffsetOldest, 0).
+			SetOffset("order", 0, sarama.OffsetNewest, int64(len(values))),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, "order_group", broker),
+		"HeartbeatRequest": sarama.NewMockHeartbeatResponse(t),
+		"JoinGroupRequest": sarama.NewMockJoinGroupResponse(t).
+			SetGroupProtocol(sarama.RangeBalanceStrategyName),
+		"SyncGroupRequest": sarama.NewMockSyncGroupResponse(t).SetMemberAssignment(
+			&sarama.ConsumerGroupMemberAssignment{
+				Topics: map[string][]int32{"order": {0}},
+			}),
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+			SetOffset("order_group", "order", 0, 0, "", sarama.ErrNoError).
+			SetError(sarama.ErrNoError),
+		"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(t),
+		"LeaveGroupRequest":   sarama.NewMockLeaveGroupResponse(t),
+		"FetchRequest":        fetch,
+	})
+	return broker
+}
+
+func newTestConsumer(broker *sarama.MockBroker) *Consumer {
+	config := DefaultConfig().Consumer
+	config.Name = "test"
+	config.Addr = []string{broker.Addr()}
+	config.Topics = []string{"order"}
+	config.Group = "order_group"
+	config.InitialOffset = "oldest"
+	config.RetryInterval = time.Millisecond
+	return config.Build()
+}
+
+// runConsumer runs the consumer until stop is closed
+func runConsumer(t *testing.T, cc *Consumer, stop <-chan struct{}) {
+	done := make(chan error, 1)
+	go func() { done <- cc.Run() }()
+
+	select {
+	case <-stop:
+	case <-time.After(10 * time.Second):
+		t.Error("consume timeout")
+	}
+	assert.Nil(t, cc.Stop())
+	assert.Nil(t, <-done)
+}
+
+func committed(broker *sarama.MockBroker) int {
+	var count int
+	for _, item := range broker.History() {
+		if _, ok := item.Request.(*sarama.OffsetCommitRequest); ok {
+			count++
+		}
+	}
+	return count
+}
+
+func TestConsumerSingleMessage(t *testing.T) {
+	broker := newMockGroupBroker(t, "foo", "bar")
+	defer broker.Close()
+
+	var (
+		mu     sync.Mutex
+		values []string
+		stop   = make(chan struct{})
+	)
+	cc := newTestConsumer(broker)
+	cc.AutoCommit = false
+	cc.RegisterSingleMessage(func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		mu.Lock()
+		defer mu.Unlock()
+		values = append(values, string(msg.Value))
+		if len(values) == 2 {
+			close(stop)
+		}
+		return nil
+	})
+
+	runConsumer(t, cc, stop)
+	assert.Equal(t, []string{"foo", "bar"}, values)
+	assert.GreaterOrEqual(t, committed(broker), 1)
+}
+
+func TestConsumerBatchMessage(t *testing.T) {
+	broker := newMockGroupBroker(t, "a", "b", "c")
+	defer broker.Close()
+
+	var (
+		batches [][]string
+		stop    = make(chan struct{})
+	)
+	cc := newTestConsumer(broker)
+	cc.BatchSize = 3
+	cc.BatchTimeout = time.Second
+	cc.RegisterBatchMessage(func(ctx context.Context, msgs ...*sarama.ConsumerMessage) error {
+		var batch []string
+		for _, msg := range msgs {
+			batch = append(batch, string(msg.Value))
+		}
+		batches = append(batches, batch)
+		close(stop)
+		return nil
+	})
+
+	runConsumer(t, cc, stop)
+	assert.Equal(t, [][]string{{"a", "b", "c"}}, batches)
+}
+
+func TestConsumerRetry(t *testing.T) {
+	broker := newMockGroupBroker(t, "foo")
+	defer broker.Close()
+
+	var (
+		calls int
+		stop  = make(chan struct{})
+	)
+	cc := newTestConsumer(broker)
+	cc.Retry = 2
+	cc.RegisterSingleMessage(func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		calls++
+		switch calls {
+		case 1:
+			return errors.New("failed")
+		case 2:
+			panic("boom")
+		}
+		close(stop)
+		return nil
+	})
+
+	runConsumer(t, cc, stop)
+	assert.Equal(t, 3, calls)
+}
+
+func TestConsumerWithoutHandler(t *testing.T) {
+	broker := newMockGroupBroker(t)
+	defer broker.Close()
+
+	cc := newTestConsumer(broker)
+	assert.NotNil(t, cc.Run())
+	assert.Nil(t, cc.Stop())
+
+	cc = newTestConsumer(broker)
+	cc.Enable = false
+	assert.Nil(t, cc.Run())
+}
+
+func TestConsumerRateLimit(t *testing.T) {
+	broker := newMockGroupBroker(t)
+	defer broker.Close()
+
+	cc := newTestConsumer(broker)
+	cc.Rate, cc.Capacity = 1, 1
+	cc = cc.ConsumerConfig.Build()
+	assert.NotNil(t, cc.bucket)
+
+	cc.WaitMaxDuration = time.Millisecond
+	assert.True(t, cc.wait(context.Background(), 1))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.False(t, cc.wait(ctx, 1))
+}
+
+func TestConsumerRateLimitExhausted(t *testing.T) {
+	broker := newMockGroupBroker(t)
+	defer broker.Close()
+
+	cc := newTestConsumer(broker)
+	cc.Rate, cc.Capacity = 20, 1
+	cc = cc.ConsumerConfig.Build()
+	cc.WaitMaxDuration = 10 * time.Millisecond
+	assert.True(t, cc.wait(context.Background(), 1))
+
+	// 令牌耗尽后等待补充，超过waitMaxDuration时继续等待而不是空转
+	beg := time.Now()
+	assert.True(t, cc.wait(context.Background(), 1))
+	assert.GreaterOrEqual(t, time.Since(beg), 40*time.Millisecond)
+
+	// 令牌耗尽时session结束立即返回
+	cc.Rate = 0.1
+	cc = cc.ConsumerConfig.Build()
+	assert.True(t, cc.wait(context.Background(), 1))
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	beg = time.Now()
+	assert.False(t, cc.wait(ctx, 1))
+	assert.Less(t, time.Since(beg), time.Second)
+}
@@ -0,0 +1,311 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kafka
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/alibaba/sentinel-golang/core/base"
+	"github.com/zhengyansheng/jupiter/pkg/core/imeta"
+	"github.com/zhengyansheng/jupiter/pkg/core/metric"
+	"github.com/zhengyansheng/jupiter/pkg/core/sentinel"
+	"github.com/zhengyansheng/jupiter/pkg/core/xtrace"
+	"github.com/zhengyansheng/jupiter/pkg/util/xdebug"
+	"github.com/zhengyansheng/jupiter/pkg/xlog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Invoker 发送或消费消息，生产者的req为*sarama.ProducerMessage，reply为*SendResult，
+// 消费者的req为[]*sarama.ConsumerMessage，reply为nil
+type Invoker func(ctx context.Context, req, reply interface{}) error
+
+// Interceptor 与rocketmq的拦截器保持一致
+type Interceptor func(ctx context.Context, req, reply interface{}, next Invoker) error
+
+// SendResult 消息发送结果
+type SendResult struct {
+	Partition int32 `json:"partition"`
+	Offset    int64 `json:"offset"`
+}
+
+// chainInterceptors 按照注册顺序组合拦截器，第一个拦截器在最外层
+func chainInterceptors(interceptors []Interceptor, final Invoker) Invoker {
+	invoker := final
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoker
+		invoker = func(ctx context.Context, req, reply interface{}) error {
+			return interceptor(ctx, req, reply, next)
+		}
+	}
+	return invoker
+}
+
+// headerCarrier 将trace信息写入消息的headers
+type headerCarrier struct {
+	headers *[]sarama.RecordHeader
+}
+
+func (hc headerCarrier) Get(key string) string {
+	for _, header := range *hc.headers {
+		if string(header.Key) == key {
+			return string(header.Value)
+		}
+	}
+	return ""
+}
+
+func (hc headerCarrier) Set(key, value string) {
+	for i, header := range *hc.headers {
+		if string(header.Key) == key {
+			(*hc.headers)[i].Value = []byte(value)
+			return
+		}
+	}
+	*hc.headers = append(*hc.headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+}
+
+func (hc headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(*hc.headers))
+	for _, header := range *hc.headers {
+		keys = append(keys, string(header.Key))
+	}
+	return keys
+}
+
+// messageCarrier returns the propagation carrier of the message headers
+func messageCarrier(msg *sarama.ConsumerMessage) propagation.MapCarrier {
+	carrier := propagation.MapCarrier{}
+	for _, header := range msg.Headers {
+		if header != nil {
+			carrier[string(header.Key)] = string(header.Value)
+		}
+	}
+	return carrier
+}
+
+// startConsumeSpan starts a consumer span for msgs, the upstream span is
+// the parent if there is only one message, otherwise spans are linked.
+func startConsumeSpan(tracer *xtrace.Tracer, ctx context.Context, group string, msgs ...*sarama.ConsumerMessage) (context.Context, trace.Span) {
+	topic := msgs[0].Topic
+	opts := []trace.SpanStartOption{
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String("kafka"),
+			semconv.MessagingDestinationKey.String(topic),
+			semconv.MessagingOperationProcess,
+			semconv.MessagingKafkaConsumerGroupKey.String(group),
+		),
+	}
+
+	if len(msgs) == 1 {
+		msg := msgs[0]
+		opts = append(opts, trace.WithAttributes(
+			semconv.MessagingKafkaPartitionKey.Int64(int64(msg.Partition)),
+			semconv.MessagingKafkaMessageKeyKey.String(string(msg.Key)),
+		))
+		return tracer.Start(ctx, topic, messageCarrier(msg), opts...)
+	}
+
+	links := make([]trace.Link, 0, len(msgs))
+	for _, msg := range msgs {
+		upstream := otel.GetTextMapPropagator().Extract(ctx, messageCarrier(msg))
+		if sc := trace.SpanContextFromContext(upstream); sc.IsValid() {
+			links = append(links, trace.Link{SpanContext: sc})
+		}
+	}
+	opts = append(opts, trace.WithLinks(links...))
+	return tracer.Start(ctx, topic, nil, opts...)
+}
+
+func consumeResultStr(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+func consumerMetricInterceptor(consumer *Consumer) Interceptor {
+	peer := strings.Join(consumer.Addr, ",")
+	return func(ctx context.Context, req, reply interface{}, next Invoker) error {
+		beg := time.Now()
+		msgs, _ := req.([]*sarama.ConsumerMessage)
+
+		err := next(ctx, msgs, reply)
+		result := consumeResultStr(err)
+		xdebug.PrintObject("consume", map[string]interface{}{
+			"err":    err,
+			"count":  len(msgs),
+			"result": result,
+		})
+
+		// 消息处理结果统计
+		for _, msg := range msgs {
+			metric.ClientHandleCounter.Inc(metric.TypeKafka, msg.Topic, "consume", peer, result)
+			metric.ClientHandleHistogram.Observe(time.Since(beg).Seconds(), metric.TypeKafka, msg.Topic, "consume", peer)
+			if err != nil {
+				xlog.Jupiter().Error("consumer group",
+					xlog.String("topic", msg.Topic),
+					xlog.Int32("partition", msg.Partition),
+					xlog.Int64("offset", msg.Offset),
+					xlog.String("result", result),
+					xlog.Any("err", err))
+			} else {
+				xlog.Jupiter().Debug("consumer group",
+					xlog.String("topic", msg.Topic),
+					xlog.Int32("partition", msg.Partition),
+					xlog.Int64("offset", msg.Offset),
+					xlog.String("result", result),
+				)
+			}
+		}
+		return err
+	}
+}
+
+func consumerSlowInterceptor(consumer *Consumer) Interceptor {
+	return func(ctx context.Context, req, reply interface{}, next Invoker) error {
+		beg := time.Now()
+		msgs, _ := req.([]*sarama.ConsumerMessage)
+
+		err := next(ctx, msgs, reply)
+		if consumer.RwTimeout > time.Duration(0) && len(msgs) > 0 {
+			if time.Since(beg) > consumer.RwTimeout {
+				xlog.Jupiter().Error("slow",
+					xlog.String("topic", msgs[0].Topic),
+					xlog.Int("count", len(msgs)),
+					xlog.String("result", consumeResultStr(err)),
+					xlog.Any("cost", time.Since(beg).Seconds()),
+				)
+			}
+		}
+
+		return err
+	}
+}
+
+func consumerSentinelInterceptor(consumer *Consumer) Interceptor {
+	return func(ctx context.Context, req, reply interface{}, next Invoker) error {
+		entry, blockerr := sentinel.Entry(consumer.Addr[0],
+			sentinel.WithResourceType(base.ResTypeMQ),
+			sentinel.WithTrafficType(base.Inbound))
+		if blockerr != nil {
+			return blockerr
+		}
+
+		err := next(ctx, req, reply)
+		entry.Exit(sentinel.WithError(err))
+
+		return err
+	}
+}
+
+func producerDefaultInterceptor(producer *Producer) Interceptor {
+	tracer := xtrace.NewTracer(trace.SpanKindProducer)
+	peer := strings.Join(producer.Addr, ",")
+	return func(ctx context.Context, req, reply interface{}, next Invoker) error {
+		beg := time.Now()
+		realReq := req.(*sarama.ProducerMessage)
+		realReply, _ := reply.(*SendResult)
+
+		var span trace.Span
+		if producer.EnableTrace {
+			ctx, span = tracer.Start(ctx, realReq.Topic, headerCarrier{headers: &realReq.Headers},
+				trace.WithAttributes(
+					semconv.MessagingSystemKey.String("kafka"),
+					semconv.MessagingDestinationKey.String(realReq.Topic),
+				),
+			)
+			defer span.End()
+		}
+
+		err := next(ctx, realReq, realReply)
+		if span != nil && err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+
+		xdebug.PrintObject("produce", map[string]interface{}{
+			"err":     err,
+			"message": realReq,
+			"result":  realReply,
+		})
+
+		// 消息处理结果统计
+		if err != nil {
+			metric.ClientHandleCounter.Inc(metric.TypeKafka, realReq.Topic, "produce", peer, "error")
+			xlog.Jupiter().Error("produce",
+				xlog.String("topic", realReq.Topic),
+				xlog.Any("err", err),
+			)
+		} else {
+			metric.ClientHandleCounter.Inc(metric.TypeKafka, realReq.Topic, "produce", peer, "success")
+			xlog.Jupiter().Debug("produce",
+				xlog.String("topic", realReq.Topic),
+				xlog.Any("result", realReply),
+			)
+		}
+
+		metric.ClientHandleHistogram.Observe(time.Since(beg).Seconds(), metric.TypeKafka, realReq.Topic, "produce", peer)
+
+		if producer.RwTimeout > time.Duration(0) {
+			if time.Since(beg) > producer.RwTimeout {
+				xlog.Jupiter().Error("slow",
+					xlog.String("topic", realReq.Topic),
+					xlog.Any("result", realReply),
+					xlog.Any("cost", time.Since(beg).Seconds()),
+				)
+			}
+		}
+
+		return err
+	}
+}
+
+// 统一 metadata 传递.
+func producerMDInterceptor(producer *Producer) Interceptor {
+	return func(ctx context.Context, req, reply interface{}, next Invoker) error {
+		if md, ok := imeta.FromContext(ctx); ok {
+			realReq := req.(*sarama.ProducerMessage)
+			carrier := headerCarrier{headers: &realReq.Headers}
+			for k, v := range md {
+				carrier.Set(k, strings.Join(v, ","))
+			}
+		}
+		err := next(ctx, req, reply)
+		return err
+	}
+}
+
+func producerSentinelInterceptor(producer *Producer) Interceptor {
+	return func(ctx context.Context, req, reply interface{}, next Invoker) error {
+		entry, blockerr := sentinel.Entry(producer.Addr[0],
+			sentinel.WithResourceType(base.ResTypeMQ),
+			sentinel.WithTrafficType(base.Outbound))
+		if blockerr != nil {
+			return blockerr
+		}
+
+		err := next(ctx, req, reply)
+		entry.Exit(sentinel.WithError(err))
+
+		return err
+	}
+}
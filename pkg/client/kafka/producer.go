@@ -0,0 +1,170 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kafka
+
+import (
+	"context"
+	"errors"
+
+	"github.com/IBM/sarama"
+	"github.com/samber/lo"
+	"github.com/zhengyansheng/jupiter/pkg/core/hooks"
+	"github.com/zhengyansheng/jupiter/pkg/util/xdebug"
+	"github.com/zhengyansheng/jupiter/pkg/xlog"
+)
+
+type Producer struct {
+	started bool
+
+	sarama.SyncProducer
+	name string
+	ProducerConfig
+	interceptors []Interceptor
+	invoker      Invoker
+}
+
+// ErrProducerNotStarted 在Start之前发送消息
+var ErrProducerNotStarted = errors.New("kafka: producer is not started")
+
+func StdNewProducer(name string) *Producer {
+	return StdProducerConfig(name).Build()
+}
+
+func (conf *ProducerConfig) Build() *Producer {
+	name := conf.Name
+
+	if xdebug.IsDevelopmentMode() {
+		xdebug.PrettyJsonPrint("kafka's config: "+name, conf)
+	}
+
+	cc := &Producer{
+		name:           name,
+		ProducerConfig: *conf,
+		interceptors:   []Interceptor{},
+	}
+
+	cc.interceptors = append(cc.interceptors,
+		producerDefaultInterceptor(cc),
+		producerMDInterceptor(cc),
+		producerSentinelInterceptor(cc),
+	)
+
+	// 服务启动前先start
+	hooks.Register(hooks.Stage_BeforeRun, func() {
+		_ = cc.Start()
+	})
+
+	return cc
+}
+
+func (pc *Producer) Start() error {
+	if pc.started {
+		return nil
+	}
+
+	config, err := pc.ProducerConfig.saramaConfig()
+	if err != nil {
+		xlog.Jupiter().Panic("create producer",
+			xlog.FieldName(pc.name),
+			xlog.FieldExtMessage(pc.ProducerConfig),
+			xlog.Any("error", err),
+		)
+	}
+
+	client, err := sarama.NewSyncProducer(pc.Addr, config)
+	if err != nil {
+		xlog.Jupiter().Panic("start producer",
+			xlog.FieldName(pc.name),
+			xlog.FieldExtMessage(pc.ProducerConfig),
+			xlog.Any("error", err),
+		)
+	}
+
+	pc.started = true
+	pc.SyncProducer = client
+	pc.invoker = chainInterceptors(pc.interceptors, pc.send)
+	return nil
+}
+
+// MustStart panics when error found.
+func (pc *Producer) MustStart() {
+	lo.Must0(pc.Start())
+}
+
+// WithInterceptor 需要在Start之前调用
+func (pc *Producer) WithInterceptor(fs ...Interceptor) *Producer {
+	pc.interceptors = append(pc.interceptors, fs...)
+	return pc
+}
+
+func (pc *Producer) Close() error {
+	if !pc.started {
+		return nil
+	}
+	err := pc.SyncProducer.Close()
+	if err != nil {
+		xlog.Jupiter().Warn("producer close fail", xlog.Any("error", err.Error()))
+		return err
+	}
+	pc.started = false
+	return nil
+}
+
+// SendWithContext 发送消息到配置的topic
+func (pc *Producer) SendWithContext(ctx context.Context, value []byte) error {
+	_, err := pc.SendMsg(ctx, &sarama.ProducerMessage{
+		Value: sarama.ByteEncoder(value),
+	})
+	return err
+}
+
+// SendWithKey 发送消息到配置的topic，相同key的消息发送到同一个分区
+func (pc *Producer) SendWithKey(ctx context.Context, key string, value []byte) error {
+	_, err := pc.SendMsg(ctx, &sarama.ProducerMessage{
+		Key:   sarama.StringEncoder(key),
+		Value: sarama.ByteEncoder(value),
+	})
+	return err
+}
+
+// SendMsg 同步发送消息，msg的topic为空时使用配置的topic
+func (pc *Producer) SendMsg(ctx context.Context, msg *sarama.ProducerMessage) (*SendResult, error) {
+	if !pc.started {
+		return nil, ErrProducerNotStarted
+	}
+	if msg.Topic == "" {
+		msg.Topic = pc.Topic
+	}
+
+	result := &SendResult{}
+	err := pc.invoker(ctx, msg, result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (pc *Producer) send(ctx context.Context, req, reply interface{}) error {
+	msg := req.(*sarama.ProducerMessage)
+	partition, offset, err := pc.SyncProducer.SendMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	if result, ok := reply.(*SendResult); ok {
+		result.Partition, result.Offset = partition, offset
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kafka
+
+import (
+	"context"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhengyansheng/jupiter/pkg/core/imeta"
+)
+
+func newTestProducer(broker *sarama.MockBroker) *Producer {
+	config := DefaultConfig().Producer
+	config.Name = "test"
+	config.Addr = []string{broker.Addr()}
+	config.Topic = "order"
+	return &Producer{
+		name:           config.Name,
+		ProducerConfig: *config,
+	}
+}
+
+func TestProducer(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("order", 0, broker.BrokerID()),
+		"ProduceRequest": sarama.NewMockProduceResponse(t),
+	})
+
+	pc := newTestProducer(broker)
+	pc.interceptors = append(pc.interceptors,
+		producerDefaultInterceptor(pc),
+		producerMDInterceptor(pc),
+		producerSentinelInterceptor(pc),
+	)
+
+	var sent *sarama.ProducerMessage
+	pc.WithInterceptor(func(ctx context.Context, req, reply interface{}, next Invoker) error {
+		sent = req.(*sarama.ProducerMessage)
+		return next(ctx, req, reply)
+	})
+
+	_, err := pc.SendMsg(context.Background(), &sarama.ProducerMessage{Value: sarama.StringEncoder("hello")})
+	assert.Equal(t, ErrProducerNotStarted, err)
+
+	pc.MustStart()
+	defer pc.Close()
+
+	ctx := imeta.WithContext(context.Background(), map[string][]string{"x-uid": {"1"}})
+	result, err := pc.SendMsg(ctx, &sarama.ProducerMessage{Value: sarama.StringEncoder("hello")})
+	assert.Nil(t, err)
+	assert.Equal(t, int32(0), result.Partition)
+	assert.Equal(t, "order", sent.Topic)
+	assert.Equal(t, "1", headerCarrier{headers: &sent.Headers}.Get("x-uid"))
+
+	assert.Nil(t, pc.SendWithKey(context.Background(), "key", []byte("hello")))
+}
+
+func TestProducerError(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("order", 0, broker.BrokerID()),
+		"ProduceRequest": sarama.NewMockProduceResponse(t).
+			SetError("order", 0, sarama.ErrMessageSizeTooLarge),
+	})
+
+	pc := newTestProducer(broker)
+	pc.Retry = 0
+	pc.interceptors = append(pc.interceptors, producerDefaultInterceptor(pc))
+	pc.MustStart()
+	defer pc.Close()
+
+	err := pc.SendWithContext(context.Background(), []byte("hello"))
+	assert.ErrorIs(t, err, sarama.ErrMessageSizeTooLarge)
+}
+
+func TestChainInterceptors(t *testing.T) {
+	var calls []string
+	record := func(name string) Interceptor {
+		return func(ctx context.Context, req, reply interface{}, next Invoker) error {
+			calls = append(calls, name)
+			return next(ctx, req, reply)
+		}
+	}
+
+	invoker := chainInterceptors([]Interceptor{record("a"), record("b")}, func(ctx context.Context, req, reply interface{}) error {
+		calls = append(calls, "final")
+		return nil
+	})
+	assert.Nil(t, invoker(context.Background(), nil, nil))
+	assert.Equal(t, []string{"a", "b", "final"}, calls)
+}
+
+func TestHeaderCarrier(t *testing.T) {
+	var headers []sarama.RecordHeader
+	carrier := headerCarrier{headers: &headers}
+	carrier.Set("a", "1")
+	carrier.Set("b", "2")
+	carrier.Set("a", "3")
+
+	assert.Equal(t, "3", carrier.Get("a"))
+	assert.Equal(t, "", carrier.Get("c"))
+	assert.Equal(t, []string{"a", "b"}, carrier.Keys())
+
+	msg := &sarama.ConsumerMessage{Headers: []*sarama.RecordHeader{&headers[0], &headers[1]}}
+	assert.Equal(t, "3", messageCarrier(msg).Get("a"))
+}
@@ -0,0 +1,58 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kafka
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+
+	"github.com/IBM/sarama"
+	"github.com/xdg-go/scram"
+)
+
+// scramClient 实现sarama.SCRAMClient
+type scramClient struct {
+	*scram.Client
+	*scram.ClientConversation
+	scram.HashGeneratorFcn
+}
+
+func newSCRAMClientGenerator(mechanism sarama.SASLMechanism) func() sarama.SCRAMClient {
+	switch mechanism {
+	case sarama.SASLTypeSCRAMSHA256:
+		return func() sarama.SCRAMClient { return &scramClient{HashGeneratorFcn: sha256.New} }
+	case sarama.SASLTypeSCRAMSHA512:
+		return func() sarama.SCRAMClient { return &scramClient{HashGeneratorFcn: sha512.New} }
+	default:
+		return nil
+	}
+}
+
+func (sc *scramClient) Begin(userName, password, authzID string) (err error) {
+	sc.Client, err = sc.HashGeneratorFcn.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+	sc.ClientConversation = sc.Client.NewConversation()
+	return nil
+}
+
+func (sc *scramClient) Step(challenge string) (string, error) {
+	return sc.ClientConversation.Step(challenge)
+}
+
+func (sc *scramClient) Done() bool {
+	return sc.ClientConversation.Done()
+}
@@ -0,0 +1,323 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package memory 进程内的mq适配器，用于单元测试
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zhengyansheng/jupiter/pkg/client/mq"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// ErrNoTopic 发布的消息没有topic
+var ErrNoTopic = errors.New("mq/memory: topic is empty")
+
+// Broker 进程内的消息中间件，每个Subscriber都会收到订阅的topic的全部消息
+type Broker struct {
+	mu   sync.RWMutex
+	subs map[string][]*Subscriber
+	seq  int64
+}
+
+// NewBroker ...
+func NewBroker() *Broker {
+	return &Broker{
+		subs: make(map[string][]*Subscriber),
+	}
+}
+
+// Publisher 返回发布到broker的publisher，topic为默认topic
+func (b *Broker) Publisher(topic string) *Publisher {
+	return &Publisher{broker: b, topic: topic}
+}
+
+func (b *Broker) subscribe(topic string, s *Subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs[topic] = append(b.subs[topic], s)
+}
+
+func (b *Broker) unsubscribe(s *Subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for topic, subs := range b.subs {
+		remain := subs[:0]
+		for _, sub := range subs {
+			if sub != s {
+				remain = append(remain, sub)
+			}
+		}
+		b.subs[topic] = remain
+	}
+}
+
+func (b *Broker) dispatch(ctx context.Context, msg *mq.Message) error {
+	b.mu.RLock()
+	subs := append([]*Subscriber(nil), b.subs[msg.Topic]...)
+	b.mu.RUnlock()
+
+	for _, s := range subs {
+		if err := s.enqueue(ctx, clone(msg)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Publisher 实现mq.Publisher
+type Publisher struct {
+	broker *Broker
+	topic  string
+}
+
+var _ mq.Publisher = (*Publisher)(nil)
+
+// Publish 将消息投递给订阅了topic的Subscriber，缓冲区满时阻塞直到ctx结束
+func (p *Publisher) Publish(ctx context.Context, msgs ...*mq.Message) error {
+	for _, msg := range msgs {
+		if msg.Topic == "" {
+			msg.Topic = p.topic
+		}
+		if msg.Topic == "" {
+			return ErrNoTopic
+		}
+		msg.ID = strconv.FormatInt(atomic.AddInt64(&p.broker.seq, 1), 10)
+		msg.Timestamp = time.Now()
+		msg.Attempt = 1
+
+		carrier := propagation.MapCarrier{}
+		otel.GetTextMapPropagator().Inject(ctx, carrier)
+		for k, v := range carrier {
+			msg.SetHeader(k, v)
+		}
+
+		if err := p.broker.dispatch(ctx, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close ...
+func (p *Publisher) Close() error {
+	return nil
+}
+
+// Option 设置Subscriber
+type Option func(s *Subscriber)
+
+// WithConcurrency 并发处理的goroutine数量，默认为1，大于1时不保证消息的顺序
+func WithConcurrency(n int) Option {
+	return func(s *Subscriber) {
+		s.concurrency = n
+	}
+}
+
+// WithMaxAttempts 最多投递的次数，默认为3，超过后消息进入死信
+func WithMaxAttempts(n int) Option {
+	return func(s *Subscriber) {
+		s.maxAttempts = n
+	}
+}
+
+// WithBufferSize 缓冲的消息数量，默认为1024
+func WithBufferSize(n int) Option {
+	return func(s *Subscriber) {
+		s.bufferSize = n
+	}
+}
+
+// Subscriber 实现mq.Subscriber，处理失败的消息会重新投递，直到maxAttempts
+type Subscriber struct {
+	broker      *Broker
+	concurrency int
+	maxAttempts int
+	bufferSize  int
+
+	handlers map[string]mq.Handler
+	queue    chan *mq.Message
+	pending  int64
+
+	// mu 保护dead及workers的启动
+	mu   sync.Mutex
+	dead []*mq.Message
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	draining int32
+	workers  sync.WaitGroup
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+var _ mq.Subscriber = (*Subscriber)(nil)
+
+// NewSubscriber 创建订阅broker的Subscriber
+func (b *Broker) NewSubscriber(opts ...Option) *Subscriber {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Subscriber{
+		broker:      b,
+		concurrency: 1,
+		maxAttempts: 3,
+		bufferSize:  1024,
+		handlers:    make(map[string]mq.Handler),
+		ctx:         ctx,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.queue = make(chan *mq.Message, s.bufferSize)
+	return s
+}
+
+// Subscribe 注册topic的处理函数，注册后即可接收消息，消息在Serve后开始处理
+func (s *Subscriber) Subscribe(topic string, h mq.Handler, mws ...mq.Middleware) error {
+	if _, ok := s.handlers[topic]; ok {
+		return fmt.Errorf("mq/memory: duplicated subscribe topic %s", topic)
+	}
+	s.handlers[topic] = mq.Chain(h, mws...)
+	s.broker.subscribe(topic, s)
+	return nil
+}
+
+// Serve 开始处理消息，阻塞直到Stop或GracefulStop
+func (s *Subscriber) Serve() error {
+	s.mu.Lock()
+	if s.ctx.Err() == nil {
+		for i := 0; i < s.concurrency; i++ {
+			s.workers.Add(1)
+			go s.work()
+		}
+	}
+	s.mu.Unlock()
+	<-s.done
+	return nil
+}
+
+// Stop 立即停止，缓冲区中未处理的消息会被丢弃
+func (s *Subscriber) Stop() error {
+	s.stopOnce.Do(func() {
+		atomic.StoreInt32(&s.draining, 1)
+		s.broker.unsubscribe(s)
+		s.mu.Lock()
+		s.cancel()
+		s.mu.Unlock()
+		s.workers.Wait()
+		close(s.done)
+	})
+	return nil
+}
+
+// GracefulStop 不再接收新的消息，等待缓冲区中的消息处理完成或者ctx结束
+func (s *Subscriber) GracefulStop(ctx context.Context) error {
+	atomic.StoreInt32(&s.draining, 1)
+	s.broker.unsubscribe(s)
+	err := s.Flush(ctx)
+	_ = s.Stop()
+	return err
+}
+
+// Flush 等待已经投递的消息处理完成(包括重新投递)，用于在测试中断言处理结果
+func (s *Subscriber) Flush(ctx context.Context) error {
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	for atomic.LoadInt64(&s.pending) > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// DeadLetters 返回投递次数耗尽的消息
+func (s *Subscriber) DeadLetters() []*mq.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*mq.Message(nil), s.dead...)
+}
+
+func (s *Subscriber) enqueue(ctx context.Context, msg *mq.Message) error {
+	if atomic.LoadInt32(&s.draining) == 1 && msg.Attempt == 1 {
+		return nil
+	}
+	atomic.AddInt64(&s.pending, 1)
+	select {
+	case s.queue <- msg:
+		return nil
+	case <-ctx.Done():
+		atomic.AddInt64(&s.pending, -1)
+		return ctx.Err()
+	case <-s.ctx.Done():
+		atomic.AddInt64(&s.pending, -1)
+		return nil
+	}
+}
+
+func (s *Subscriber) work() {
+	defer s.workers.Done()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case msg := <-s.queue:
+			s.handle(msg)
+		}
+	}
+}
+
+func (s *Subscriber) handle(msg *mq.Message) {
+	defer atomic.AddInt64(&s.pending, -1)
+
+	err := mq.Chain(s.handlers[msg.Topic], mq.Recovery())(s.ctx, msg)
+	if err == nil || s.ctx.Err() != nil {
+		return
+	}
+	if msg.Attempt >= s.maxAttempts {
+		s.mu.Lock()
+		s.dead = append(s.dead, msg)
+		s.mu.Unlock()
+		return
+	}
+
+	retry := clone(msg)
+	retry.Attempt++
+	// 在新的goroutine中重新投递，避免缓冲区满时所有worker阻塞
+	atomic.AddInt64(&s.pending, 1)
+	go func() {
+		defer atomic.AddInt64(&s.pending, -1)
+		_ = s.enqueue(s.ctx, retry)
+	}()
+}
+
+func clone(msg *mq.Message) *mq.Message {
+	c := *msg
+	c.Headers = make(map[string]string, len(msg.Headers))
+	for k, v := range msg.Headers {
+		c.Headers[k] = v
+	}
+	return &c
+}
@@ -0,0 +1,149 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zhengyansheng/jupiter/pkg/client/mq"
+)
+
+func serve(t *testing.T, s *Subscriber) {
+	go func() {
+		assert.Nil(t, s.Serve())
+	}()
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	broker := NewBroker()
+	pub := broker.Publisher("order")
+
+	var (
+		mu  sync.Mutex
+		got = map[string][]string{}
+	)
+	record := func(name string) mq.Handler {
+		return func(ctx context.Context, msg *mq.Message) error {
+			mu.Lock()
+			defer mu.Unlock()
+			got[name] = append(got[name], string(msg.Body))
+			return nil
+		}
+	}
+
+	s1 := broker.NewSubscriber()
+	assert.Nil(t, s1.Subscribe("order", record("s1")))
+	assert.NotNil(t, s1.Subscribe("order", record("s1")))
+	s2 := broker.NewSubscriber(WithConcurrency(2))
+	assert.Nil(t, s2.Subscribe("order", record("s2")))
+	serve(t, s1)
+	serve(t, s2)
+
+	msg := &mq.Message{Key: "order-1", Body: []byte("a")}
+	assert.Nil(t, pub.Publish(context.Background(), msg, &mq.Message{Body: []byte("b")}))
+	assert.Equal(t, "order", msg.Topic)
+	assert.NotEmpty(t, msg.ID)
+	assert.Equal(t, ErrNoTopic, broker.Publisher("").Publish(context.Background(), &mq.Message{}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.Nil(t, s1.Flush(ctx))
+	assert.Nil(t, s2.Flush(ctx))
+	assert.Equal(t, []string{"a", "b"}, got["s1"])
+	assert.ElementsMatch(t, []string{"a", "b"}, got["s2"])
+
+	assert.Nil(t, s1.Stop())
+	assert.Nil(t, s2.Stop())
+	// 停止后不再接收消息
+	assert.Nil(t, pub.Publish(context.Background(), &mq.Message{Body: []byte("c")}))
+	assert.Len(t, got["s1"], 2)
+}
+
+func TestRedelivery(t *testing.T) {
+	broker := NewBroker()
+	s := broker.NewSubscriber(WithMaxAttempts(3))
+
+	var attempts []int
+	assert.Nil(t, s.Subscribe("order", func(ctx context.Context, msg *mq.Message) error {
+		attempts = append(attempts, msg.Attempt)
+		if msg.Key == "panic" {
+			panic("boom")
+		}
+		return errors.New("failed")
+	}))
+	serve(t, s)
+	defer s.Stop()
+
+	assert.Nil(t, broker.Publisher("order").Publish(context.Background(), &mq.Message{Key: "order-1"}))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.Nil(t, s.Flush(ctx))
+
+	assert.Equal(t, []int{1, 2, 3}, attempts)
+	assert.Len(t, s.DeadLetters(), 1)
+	assert.Equal(t, "order-1", s.DeadLetters()[0].Key)
+}
+
+func TestGracefulStop(t *testing.T) {
+	broker := NewBroker()
+	s := broker.NewSubscriber()
+
+	var (
+		mu   sync.Mutex
+		done []string
+	)
+	assert.Nil(t, s.Subscribe("order", func(ctx context.Context, msg *mq.Message) error {
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		done = append(done, string(msg.Body))
+		return nil
+	}))
+
+	pub := broker.Publisher("order")
+	assert.Nil(t, pub.Publish(context.Background(), &mq.Message{Body: []byte("a")}, &mq.Message{Body: []byte("b")}))
+
+	served := make(chan struct{})
+	go func() {
+		assert.Nil(t, s.Serve())
+		close(served)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.Nil(t, s.GracefulStop(ctx))
+	<-served
+	assert.Equal(t, []string{"a", "b"}, done)
+}
+
+func TestGracefulStopTimeout(t *testing.T) {
+	broker := NewBroker()
+	s := broker.NewSubscriber()
+	assert.Nil(t, s.Subscribe("order", func(ctx context.Context, msg *mq.Message) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}))
+	serve(t, s)
+	assert.Nil(t, broker.Publisher("order").Publish(context.Background(), &mq.Message{}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, s.GracefulStop(ctx))
+}
@@ -0,0 +1,169 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mq
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/zhengyansheng/jupiter/pkg/core/xtrace"
+	"github.com/zhengyansheng/jupiter/pkg/xlog"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Recovery 将handler的panic转换为error
+func Recovery() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg *Message) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					xlog.L(ctx).Error("mq handler panic",
+						xlog.String("topic", msg.Topic),
+						xlog.String("stack", string(debug.Stack())))
+					err = fmt.Errorf("mq handler panic: %v", r)
+				}
+			}()
+			return next(ctx, msg)
+		}
+	}
+}
+
+// Retry 处理失败时在进程内重试，attempts为最多执行的次数，
+// 重试耗尽后返回最后一次的错误，由中间件决定是否重新投递
+func Retry(attempts int, backoff time.Duration) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg *Message) (err error) {
+			for i := 0; i < attempts || i == 0; i++ {
+				if i > 0 {
+					timer := time.NewTimer(backoff)
+					select {
+					case <-ctx.Done():
+						timer.Stop()
+						return err
+					case <-timer.C:
+					}
+				}
+				if err = next(ctx, msg); err == nil {
+					return nil
+				}
+			}
+			return err
+		}
+	}
+}
+
+// Logging 记录处理失败及耗时超过slowThreshold的消息，slowThreshold为0时不记录慢日志
+func Logging(slowThreshold time.Duration) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg *Message) error {
+			beg := time.Now()
+			err := next(ctx, msg)
+			cost := time.Since(beg)
+
+			fields := []xlog.Field{
+				xlog.String("topic", msg.Topic),
+				xlog.String("id", msg.ID),
+				xlog.String("key", msg.Key),
+				xlog.Int("attempt", msg.Attempt),
+				xlog.FieldCost(cost),
+			}
+			switch {
+			case err != nil:
+				xlog.L(ctx).Error("mq handle", append(fields, xlog.FieldErr(err))...)
+			case slowThreshold > 0 && cost > slowThreshold:
+				xlog.L(ctx).Warn("mq handle slow", fields...)
+			default:
+				xlog.L(ctx).Debug("mq handle", fields...)
+			}
+			return err
+		}
+	}
+}
+
+// Tracing 从消息的headers中提取上游的trace，并开启consumer span
+func Tracing() Middleware {
+	tracer := xtrace.NewTracer(trace.SpanKindConsumer)
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg *Message) error {
+			ctx, span := tracer.Start(ctx, msg.Topic, propagation.MapCarrier(msg.Headers),
+				trace.WithAttributes(
+					semconv.MessagingDestinationKey.String(msg.Topic),
+					semconv.MessagingMessageIDKey.String(msg.ID),
+					semconv.MessagingOperationProcess,
+				),
+			)
+			defer span.End()
+
+			traceID := span.SpanContext().TraceID().String()
+			ctx = xlog.NewContext(ctx, xlog.Default(), traceID)
+			ctx = xlog.NewContext(ctx, xlog.Jupiter(), traceID)
+
+			err := next(ctx, msg)
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			return err
+		}
+	}
+}
+
+// DedupeStore 去重记录的存储，rocketmq.NewRedisIdempotencyStore及
+// rocketmq.NewLocalIdempotencyStore返回的store都可以直接使用
+type DedupeStore interface {
+	// SetNX 记录不存在时写入并返回true
+	SetNX(ctx context.Context, key string, ttl time.Duration) (bool, error)
+	Del(ctx context.Context, key string) error
+}
+
+// Dedupe 按照消息的key(为空时使用ID)去重，ttl内重复投递的消息直接确认，
+// 处理失败时删除记录以便重新投递的消息可以再次处理，store出错时不去重
+func Dedupe(store DedupeStore, ttl time.Duration) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg *Message) error {
+			id := msg.Key
+			if id == "" {
+				id = msg.ID
+			}
+			if id == "" {
+				return next(ctx, msg)
+			}
+
+			key := "mq:dedupe:" + msg.Topic + ":" + id
+			ok, err := store.SetNX(ctx, key, ttl)
+			if err != nil {
+				xlog.L(ctx).Warn("mq dedupe", xlog.String("key", key), xlog.FieldErr(err))
+				return next(ctx, msg)
+			}
+			if !ok {
+				xlog.L(ctx).Info("mq duplicated message", xlog.String("key", key))
+				return nil
+			}
+
+			if err := next(ctx, msg); err != nil {
+				if e := store.Del(ctx, key); e != nil {
+					xlog.L(ctx).Warn("mq dedupe release", xlog.String("key", key), xlog.FieldErr(e))
+				}
+				return err
+			}
+			return nil
+		}
+	}
+}
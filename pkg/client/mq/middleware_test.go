@@ -0,0 +1,167 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mq
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var errHandle = errors.New("handle failed")
+
+func TestChain(t *testing.T) {
+	var calls []string
+	record := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(ctx context.Context, msg *Message) error {
+				calls = append(calls, name)
+				return next(ctx, msg)
+			}
+		}
+	}
+
+	h := Chain(func(ctx context.Context, msg *Message) error {
+		calls = append(calls, "handler")
+		return nil
+	}, record("a"), record("b"))
+	assert.Nil(t, h(context.Background(), &Message{}))
+	assert.Equal(t, []string{"a", "b", "handler"}, calls)
+}
+
+func TestRetry(t *testing.T) {
+	var calls int
+	h := Chain(func(ctx context.Context, msg *Message) error {
+		calls++
+		if calls < 3 {
+			return errHandle
+		}
+		return nil
+	}, Retry(3, time.Millisecond))
+	assert.Nil(t, h(context.Background(), &Message{}))
+	assert.Equal(t, 3, calls)
+
+	calls = 0
+	h = Chain(func(ctx context.Context, msg *Message) error {
+		calls++
+		return errHandle
+	}, Retry(2, time.Millisecond))
+	assert.Equal(t, errHandle, h(context.Background(), &Message{}))
+	assert.Equal(t, 2, calls)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls = 0
+	assert.Equal(t, errHandle, h(ctx, &Message{}))
+	assert.Equal(t, 1, calls)
+}
+
+func TestRecoveryAndLogging(t *testing.T) {
+	h := Chain(func(ctx context.Context, msg *Message) error {
+		panic("boom")
+	}, Logging(time.Millisecond), Recovery())
+	err := h(context.Background(), &Message{Topic: "order"})
+	assert.EqualError(t, err, "mq handler panic: boom")
+}
+
+func TestTracing(t *testing.T) {
+	otel.SetTracerProvider(sdktrace.NewTracerProvider())
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	ctx, span := otel.Tracer("test").Start(context.Background(), "produce")
+	span.End()
+	msg := &Message{Topic: "order"}
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	for k, v := range carrier {
+		msg.SetHeader(k, v)
+	}
+
+	var got trace.SpanContext
+	h := Chain(func(ctx context.Context, msg *Message) error {
+		got = trace.SpanContextFromContext(ctx)
+		return nil
+	}, Tracing())
+	assert.Nil(t, h(context.Background(), msg))
+	assert.Equal(t, span.SpanContext().TraceID(), got.TraceID())
+	assert.NotEqual(t, span.SpanContext().SpanID(), got.SpanID())
+}
+
+type fakeDedupeStore struct {
+	mu   sync.Mutex
+	keys map[string]bool
+	err  error
+}
+
+func (s *fakeDedupeStore) SetNX(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return false, s.err
+	}
+	if s.keys[key] {
+		return false, nil
+	}
+	s.keys[key] = true
+	return true, nil
+}
+
+func (s *fakeDedupeStore) Del(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, key)
+	return nil
+}
+
+func TestDedupe(t *testing.T) {
+	store := &fakeDedupeStore{keys: make(map[string]bool)}
+	var calls int
+	fail := true
+	h := Chain(func(ctx context.Context, msg *Message) error {
+		calls++
+		if fail {
+			return errHandle
+		}
+		return nil
+	}, Dedupe(store, time.Minute))
+
+	msg := &Message{Topic: "order", ID: "1", Key: "order-1"}
+	// 失败后释放记录，重新投递的消息可以再次处理
+	assert.Equal(t, errHandle, h(context.Background(), msg))
+	assert.False(t, store.keys["mq:dedupe:order:order-1"])
+
+	fail = false
+	assert.Nil(t, h(context.Background(), msg))
+	assert.True(t, store.keys["mq:dedupe:order:order-1"])
+	assert.Nil(t, h(context.Background(), &Message{Topic: "order", ID: "2", Key: "order-1"}))
+	assert.Equal(t, 2, calls)
+
+	// 没有key时使用ID
+	assert.Nil(t, h(context.Background(), &Message{Topic: "order", ID: "3"}))
+	assert.True(t, store.keys["mq:dedupe:order:3"])
+
+	// store出错时不去重
+	store.err = errors.New("store down")
+	assert.Nil(t, h(context.Background(), &Message{Topic: "order", ID: "3"}))
+	assert.Equal(t, 4, calls)
+}
@@ -0,0 +1,86 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mq 定义与具体消息中间件无关的消息、发布及订阅接口，
+// 业务代码只依赖本包，通过适配器(如mq/rocketmq、mq/memory)切换中间件
+package mq
+
+import (
+	"context"
+	"time"
+)
+
+// Message 与中间件无关的消息
+type Message struct {
+	// ID 消息ID，发布时由中间件生成
+	ID    string
+	Topic string
+	// Key 业务key，用于查询、分区及去重
+	Key string
+	// Tag 消息标签，不支持的中间件会忽略
+	Tag     string
+	Body    []byte
+	Headers map[string]string
+	// Attempt 第几次投递，从1开始
+	Attempt   int
+	Timestamp time.Time
+	// Raw 中间件的原始消息，如*primitive.MessageExt
+	Raw interface{}
+}
+
+// Header 返回header的值
+func (m *Message) Header(key string) string {
+	return m.Headers[key]
+}
+
+// SetHeader 设置header
+func (m *Message) SetHeader(key, value string) {
+	if m.Headers == nil {
+		m.Headers = make(map[string]string)
+	}
+	m.Headers[key] = value
+}
+
+// Handler 消息处理函数，返回error时由中间件决定是否重新投递
+type Handler func(ctx context.Context, msg *Message) error
+
+// Middleware 包装Handler
+type Middleware func(Handler) Handler
+
+// Chain 组合中间件，第一个中间件在最外层
+func Chain(h Handler, mws ...Middleware) Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// Publisher 发布消息
+type Publisher interface {
+	// Publish 同步发布消息，msg的topic为空时使用publisher的默认topic
+	Publish(ctx context.Context, msgs ...*Message) error
+	Close() error
+}
+
+// Subscriber 订阅消息，通过Application.Subscribe注册后随应用启动及停止
+type Subscriber interface {
+	// Subscribe 注册topic的处理函数，需要在Serve之前调用
+	Subscribe(topic string, h Handler, mws ...Middleware) error
+	// Serve 开始消费，阻塞直到Stop或GracefulStop
+	Serve() error
+	// Stop 立即停止消费
+	Stop() error
+	// GracefulStop 停止拉取新的消息，等待处理中的消息完成或者ctx结束
+	GracefulStop(ctx context.Context) error
+}
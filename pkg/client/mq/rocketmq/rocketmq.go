@@ -0,0 +1,190 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rocketmq 将pkg/client/rocketmq的Producer及PushConsumer适配为mq.Publisher及mq.Subscriber
+package rocketmq
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apache/rocketmq-client-go/v2/consumer"
+	"github.com/apache/rocketmq-client-go/v2/primitive"
+	"github.com/zhengyansheng/jupiter/pkg/client/mq"
+	rmq "github.com/zhengyansheng/jupiter/pkg/client/rocketmq"
+)
+
+// ErrDraining GracefulStop之后收到的消息返回该错误，由broker稍后重新投递
+var ErrDraining = errors.New("mq/rocketmq: subscriber is draining")
+
+// Publisher 实现mq.Publisher
+type Publisher struct {
+	producer *rmq.Producer
+}
+
+var _ mq.Publisher = (*Publisher)(nil)
+
+// NewPublisher ...
+func NewPublisher(producer *rmq.Producer) *Publisher {
+	return &Publisher{producer: producer}
+}
+
+// Publish 同步发送消息，发送成功后设置消息的ID
+func (p *Publisher) Publish(ctx context.Context, msgs ...*mq.Message) error {
+	for _, msg := range msgs {
+		if msg.Topic == "" {
+			msg.Topic = p.producer.Topic
+		}
+		result, err := p.producer.SendMsg(ctx, toMessage(msg))
+		if err != nil {
+			return err
+		}
+		msg.ID = result.MsgID
+	}
+	return nil
+}
+
+// Close ...
+func (p *Publisher) Close() error {
+	return p.producer.Close()
+}
+
+// Subscriber 实现mq.Subscriber，消费失败的消息按照consumer的reconsume配置重新投递
+type Subscriber struct {
+	consumer *rmq.PushConsumer
+
+	mu       sync.Mutex
+	draining bool
+	inflight sync.WaitGroup
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+var _ mq.Subscriber = (*Subscriber)(nil)
+
+// NewSubscriber ...
+func NewSubscriber(consumer *rmq.PushConsumer) *Subscriber {
+	return &Subscriber{
+		consumer: consumer,
+		done:     make(chan struct{}),
+	}
+}
+
+// Subscribe 订阅topic的全部消息，需要按照tag过滤时可以在handler中判断msg.Tag
+func (s *Subscriber) Subscribe(topic string, h mq.Handler, mws ...mq.Middleware) (err error) {
+	// PushConsumer重复订阅时panic
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.New("mq/rocketmq: duplicated subscribe topic " + topic)
+		}
+	}()
+
+	selector := consumer.MessageSelector{Type: consumer.TAG, Expression: "*"}
+	s.consumer.Subscribe(topic, selector, s.wrap(mq.Chain(h, mws...)))
+	return nil
+}
+
+func (s *Subscriber) wrap(h mq.Handler) func(context.Context, *primitive.MessageExt) error {
+	return func(ctx context.Context, ext *primitive.MessageExt) error {
+		s.mu.Lock()
+		if s.draining {
+			s.mu.Unlock()
+			return ErrDraining
+		}
+		s.inflight.Add(1)
+		s.mu.Unlock()
+		defer s.inflight.Done()
+
+		return h(ctx, fromMessageExt(ext))
+	}
+}
+
+// Serve 启动consumer，阻塞直到Stop或GracefulStop
+func (s *Subscriber) Serve() error {
+	if err := s.consumer.Start(); err != nil {
+		return err
+	}
+	<-s.done
+	return nil
+}
+
+// Stop 关闭consumer
+func (s *Subscriber) Stop() error {
+	s.stopOnce.Do(func() {
+		if s.consumer.PushConsumer != nil {
+			s.consumer.Close()
+		}
+		close(s.done)
+	})
+	return nil
+}
+
+// GracefulStop 新收到的消息不再处理，等待处理中的消息完成或者ctx结束后关闭consumer
+func (s *Subscriber) GracefulStop(ctx context.Context) error {
+	s.mu.Lock()
+	s.draining = true
+	s.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		s.inflight.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	_ = s.Stop()
+	return err
+}
+
+func toMessage(msg *mq.Message) *primitive.Message {
+	m := primitive.NewMessage(msg.Topic, msg.Body)
+	for k, v := range msg.Headers {
+		m.WithProperty(k, v)
+	}
+	if msg.Tag != "" {
+		m.WithTag(msg.Tag)
+	}
+	if msg.Key != "" {
+		m.WithKeys([]string{msg.Key})
+	}
+	return m
+}
+
+func fromMessageExt(ext *primitive.MessageExt) *mq.Message {
+	msg := &mq.Message{
+		ID:        ext.MsgId,
+		Topic:     ext.Topic,
+		Tag:       ext.GetTags(),
+		Body:      ext.Body,
+		Headers:   make(map[string]string),
+		Attempt:   int(ext.ReconsumeTimes) + 1,
+		Timestamp: time.UnixMilli(ext.BornTimestamp),
+		Raw:       ext,
+	}
+	for k, v := range ext.GetProperties() {
+		msg.Headers[k] = v
+	}
+	if keys := ext.GetKeys(); keys != "" {
+		msg.Key = strings.Fields(keys)[0]
+	}
+	return msg
+}
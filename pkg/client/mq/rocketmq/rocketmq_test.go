@@ -0,0 +1,100 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rocketmq
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/apache/rocketmq-client-go/v2/primitive"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhengyansheng/jupiter/pkg/client/mq"
+	rmq "github.com/zhengyansheng/jupiter/pkg/client/rocketmq"
+)
+
+func TestMessageConversion(t *testing.T) {
+	msg := toMessage(&mq.Message{
+		Topic:   "order",
+		Key:     "order-1",
+		Tag:     "created",
+		Body:    []byte("hello"),
+		Headers: map[string]string{"x-uid": "1"},
+	})
+	assert.Equal(t, "order", msg.Topic)
+	assert.Equal(t, "order-1", msg.GetKeys())
+	assert.Equal(t, "created", msg.GetTags())
+	assert.Equal(t, "1", msg.GetProperty("x-uid"))
+
+	ext := &primitive.MessageExt{
+		Message:        primitive.Message{Topic: msg.Topic, Body: msg.Body},
+		MsgId:          "msg-1",
+		ReconsumeTimes: 2,
+		BornTimestamp:  1700000000000,
+	}
+	ext.WithProperties(msg.GetProperties())
+	got := fromMessageExt(ext)
+	assert.Equal(t, "msg-1", got.ID)
+	assert.Equal(t, "order", got.Topic)
+	assert.Equal(t, "order-1", got.Key)
+	assert.Equal(t, "created", got.Tag)
+	assert.Equal(t, "1", got.Header("x-uid"))
+	assert.Equal(t, 3, got.Attempt)
+	assert.Equal(t, time.UnixMilli(1700000000000), got.Timestamp)
+	assert.Same(t, ext, got.Raw)
+}
+
+func TestSubscriberDrain(t *testing.T) {
+	config := rmq.DefaultConfig().PushConsumer
+	config.Name = "test"
+	config.Addr = []string{"127.0.0.1:9876"}
+	s := NewSubscriber(config.Build())
+
+	release := make(chan struct{})
+	handling := make(chan struct{})
+	h := s.wrap(func(ctx context.Context, msg *mq.Message) error {
+		close(handling)
+		<-release
+		return nil
+	})
+	ext := &primitive.MessageExt{Message: primitive.Message{Topic: "order"}}
+
+	go func() {
+		assert.Nil(t, h(context.Background(), ext))
+	}()
+	<-handling
+
+	stopped := make(chan error)
+	go func() {
+		stopped <- s.GracefulStop(context.Background())
+	}()
+
+	// 等待draining生效后，新的消息不再处理
+	assert.Eventually(t, func() bool {
+		return h(context.Background(), ext) == ErrDraining
+	}, time.Second, time.Millisecond)
+
+	close(release)
+	assert.Nil(t, <-stopped)
+	assert.Nil(t, s.Subscribe("order", func(ctx context.Context, msg *mq.Message) error { return nil }))
+	assert.NotNil(t, s.Subscribe("order", func(ctx context.Context, msg *mq.Message) error { return nil }))
+}
+
+func TestIdempotencyStoreAsDedupeStore(t *testing.T) {
+	var store mq.DedupeStore = rmq.NewLocalIdempotencyStore(1024 * 1024)
+	ok, err := store.SetNX(context.Background(), "order-1", time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
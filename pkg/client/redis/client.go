@@ -3,12 +3,14 @@ package redis
 import (
 	"context"
 	"errors"
-	"math/rand"
+	"sync"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/samber/lo"
 	"go.uber.org/zap"
 
+	cfg "github.com/zhengyansheng/jupiter/pkg/conf"
 	"github.com/zhengyansheng/jupiter/pkg/core/constant"
 	"github.com/zhengyansheng/jupiter/pkg/core/singleton"
 	"github.com/zhengyansheng/jupiter/pkg/util/xdebug"
@@ -16,9 +18,26 @@ import (
 )
 
 type Client struct {
-	master *redis.Client
-	slave  []*redis.Client
-	config *Config
+	master   *redis.Client
+	replicas *replicaSet
+	config   *Config
+
+	// discoverer 哨兵模式下用于刷新主从拓扑
+	discoverer discoverer
+	mu         sync.RWMutex
+	masterAddr string
+	closeOnce  sync.Once
+	done       chan struct{}
+}
+
+// Topology 主从拓扑及从节点的健康状态
+type Topology struct {
+	Name       string         `json:"name"`
+	Mode       string         `json:"mode"`
+	MasterName string         `json:"masterName,omitempty"`
+	Sentinels  []string       `json:"sentinels,omitempty"`
+	Master     string         `json:"master"`
+	Replicas   []ReplicaState `json:"replicas"`
 }
 
 func (ins *Client) CmdOnMaster() *redis.Client {
@@ -27,11 +46,57 @@ func (ins *Client) CmdOnMaster() *redis.Client {
 	}
 	return ins.master
 }
+
+// CmdOnSlave 返回健康且延迟较低的从节点，没有健康的从节点时返回主节点
 func (ins *Client) CmdOnSlave() *redis.Client {
-	if len(ins.slave) == 0 {
-		ins.config.logger.Panic("redis:no slave for "+ins.config.name, xlog.FieldExtMessage(ins.config))
+	if cli := ins.replicas.pick(); cli != nil {
+		return cli
+	}
+	if ins.master != nil {
+		return ins.master
+	}
+	if cli := ins.replicas.any(); cli != nil {
+		return cli
+	}
+	ins.config.logger.Panic("redis:no slave for "+ins.config.name, xlog.FieldExtMessage(ins.config))
+	return nil
+}
+
+// Topology 返回当前的主从拓扑
+func (ins *Client) Topology() Topology {
+	ins.mu.RLock()
+	master := ins.masterAddr
+	ins.mu.RUnlock()
+
+	topology := Topology{
+		Name:     ins.config.name,
+		Mode:     "master-slave",
+		Master:   master,
+		Replicas: ins.replicas.states(),
+	}
+	if ins.config.IsSentinel() {
+		topology.Mode = "sentinel"
+		topology.MasterName = ins.config.Sentinel.MasterName
+		topology.Sentinels = ins.config.Sentinel.Addr
 	}
-	return ins.slave[rand.Intn(len(ins.slave))]
+	return topology
+}
+
+// Close 停止健康检查并关闭所有连接
+func (ins *Client) Close() error {
+	var err error
+	ins.closeOnce.Do(func() {
+		close(ins.done)
+		clients.Delete(ins.config.name)
+		ins.replicas.close()
+		if ins.discoverer != nil {
+			ins.discoverer.close()
+		}
+		if ins.master != nil {
+			err = ins.master.Close()
+		}
+	})
+	return err
 }
 
 // Singleton returns a singleton client conn.
@@ -61,38 +126,156 @@ func (config *Config) MustBuild() *Client {
 
 // Build ..
 func (config *Config) Build() (*Client, error) {
-	ins := new(Client)
+	if err := cfg.ValidateStruct(config, cfg.TagName("toml")); err != nil {
+		return nil, err
+	}
+	ins := &Client{
+		config: config,
+		replicas: newReplicaSet(config, func(addr string) *redis.Client {
+			return config.newClient(addr, config.Username, config.Password)
+		}),
+		done: make(chan struct{}),
+	}
 	var err error
 	if xdebug.IsDevelopmentMode() {
 		xdebug.PrettyJsonPrint("redis's config: "+config.name, config)
 	}
+	if config.IsSentinel() {
+		if err := ins.buildSentinel(); err != nil {
+			return ins, err
+		}
+		clients.Store(config.name, ins)
+		go ins.watch()
+		return ins, nil
+	}
+
 	if config.Master.Addr != "" {
 		addr, user, pass := getUsernameAndPassword(config.Master.Addr)
 		ins.master, err = config.build(addr, user, pass)
 		if err != nil {
 			return ins, err
 		}
+		ins.masterAddr = addr
 	}
-	if len(config.Slaves.Addr) > 0 {
-		ins.slave = []*redis.Client{}
-		for _, slave := range config.Slaves.Addr {
-			addr, user, pass := getUsernameAndPassword(slave)
-			cli, err := config.build(addr, user, pass)
-			if err != nil {
-				return ins, err
-			}
-			ins.slave = append(ins.slave, cli)
+	for _, slave := range config.Slaves.Addr {
+		addr, user, pass := getUsernameAndPassword(slave)
+		cli, err := config.build(addr, user, pass)
+		if err != nil {
+			return ins, err
 		}
+		ins.replicas.add(addr, cli)
 	}
 
-	if ins.master == nil && len(ins.slave) == 0 {
+	if ins.master == nil && ins.replicas.len() == 0 {
 		return ins, errors.New("no master or slaves for " + config.name)
 	}
+	clients.Store(config.name, ins)
+	go ins.watch()
 	return ins, nil
 }
 
+// buildSentinel 通过哨兵创建可以自动切换的主节点，并发现从节点
+func (ins *Client) buildSentinel() error {
+	config := ins.config
+	master := redis.NewFailoverClient(&redis.FailoverOptions{
+		MasterName:       config.Sentinel.MasterName,
+		SentinelAddrs:    config.Sentinel.Addr,
+		SentinelUsername: config.Sentinel.Username,
+		SentinelPassword: config.Sentinel.Password,
+		Username:         config.Username,
+		Password:         config.Password,
+		DB:               config.DB,
+		MaxRetries:       config.MaxRetries,
+		DialTimeout:      config.DialTimeout,
+		ReadTimeout:      config.ReadTimeout,
+		WriteTimeout:     config.WriteTimeout,
+		PoolSize:         config.PoolSize,
+		MinIdleConns:     config.MinIdleConns,
+		IdleTimeout:      config.IdleTimeout,
+	})
+	config.addHooks(master, "sentinel/"+config.Sentinel.MasterName)
+	if err := config.ping(master); err != nil {
+		return err
+	}
+	instances.Store(config.name, &storeRedis{
+		ClientStub: master,
+	})
+	ins.master = master
+
+	ins.discoverer = newSentinelDiscoverer(config)
+	masterAddr, replicas, err := ins.discoverer.discover(context.Background())
+	if err != nil {
+		config.logger.Error("redis sentinel discover", xlog.FieldName(config.name), xlog.FieldErr(err))
+		return nil
+	}
+	ins.masterAddr = masterAddr
+	if config.ReadOnMaster {
+		replicas = append(replicas, masterAddr)
+	}
+	for _, addr := range replicas {
+		ins.replicas.add(addr, config.newClient(addr, config.Username, config.Password))
+	}
+	return nil
+}
+
+// watch 定期刷新哨兵拓扑并检查从节点的健康状态
+func (ins *Client) watch() {
+	if ins.config.HealthCheckInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(ins.config.HealthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ins.done:
+			return
+		case <-ticker.C:
+			ins.refresh(context.Background())
+		}
+	}
+}
+
+func (ins *Client) refresh(ctx context.Context) {
+	if ins.discoverer != nil {
+		masterAddr, replicas, err := ins.discoverer.discover(ctx)
+		if err != nil {
+			ins.config.logger.Error("redis sentinel discover", xlog.FieldName(ins.config.name), xlog.FieldErr(err))
+		} else {
+			ins.setMaster(masterAddr)
+			if ins.config.ReadOnMaster {
+				replicas = append(replicas, masterAddr)
+			}
+			ins.replicas.update(replicas)
+		}
+	}
+	ins.replicas.check(ctx)
+}
+
+// setMaster 记录主节点的切换，切换由FailoverClient自动完成
+func (ins *Client) setMaster(addr string) {
+	ins.mu.Lock()
+	old := ins.masterAddr
+	ins.masterAddr = addr
+	ins.mu.Unlock()
+
+	if old != "" && old != addr {
+		ins.replicas.changed(addr, "switch_master", xlog.String("from", old))
+	}
+}
+
 func (config *Config) build(addr, user, pass string) (*redis.Client, error) {
+	stubClient := config.newClient(addr, user, pass)
+	if err := config.ping(stubClient); err != nil {
+		return nil, err
+	}
 
+	instances.Store(config.name, &storeRedis{
+		ClientStub: stubClient,
+	})
+	return stubClient, nil
+}
+
+func (config *Config) newClient(addr, user, pass string) *redis.Client {
 	stubClient := redis.NewClient(&redis.Options{
 		Addr:         addr,
 		Username:     user,
@@ -106,6 +289,11 @@ func (config *Config) build(addr, user, pass string) (*redis.Client, error) {
 		MinIdleConns: config.MinIdleConns,
 		IdleTimeout:  config.IdleTimeout,
 	})
+	config.addHooks(stubClient, addr)
+	return stubClient
+}
+
+func (config *Config) addHooks(stubClient *redis.Client, addr string) {
 	stubClient.AddHook(fixedInterceptor(config.name, addr, config, config.logger))
 	if config.EnableMetricInterceptor {
 		stubClient.AddHook(metricInterceptor(config.name, addr, config, config.logger))
@@ -123,17 +311,15 @@ func (config *Config) build(addr, user, pass string) (*redis.Client, error) {
 	if config.EnableSentinel {
 		stubClient.AddHook(sentinelInterceptor(config.name, addr, config, config.logger))
 	}
+}
 
+func (config *Config) ping(stubClient *redis.Client) error {
 	if err := stubClient.Ping(context.Background()).Err(); err != nil {
 		if config.OnDialError == "panic" {
 			config.logger.Panic("redis stub client start err: " + err.Error())
 		}
 		config.logger.Error("redis stub client start err", xlog.FieldErr(err))
-		return nil, err
+		return err
 	}
-
-	instances.Store(config.name, &storeRedis{
-		ClientStub: stubClient,
-	})
-	return stubClient, nil
+	return nil
 }
@@ -1,6 +1,7 @@
 package redis
 
 import (
+	"errors"
 	"strings"
 	"time"
 
@@ -25,21 +26,29 @@ type Config struct {
 		Addr []string `json:"addr" toml:"addr"`
 	} `json:"slaves" toml:"slaves"`
 
+	// Sentinel 哨兵模式，通过哨兵发现主从节点，主节点故障时自动切换，配置后忽略master及slaves
+	Sentinel struct {
+		MasterName string   `json:"masterName" toml:"masterName"`
+		Addr       []string `json:"addr" toml:"addr"`
+		Username   string   `json:"username" toml:"username"`
+		Password   string   `json:"password" toml:"password"`
+	} `json:"sentinel" toml:"sentinel"`
+
 	Addr     []string `json:"addr" toml:"addr"`
 	Username string   `json:"username" toml:"username"`
 	Password string   `json:"password" toml:"password"`
 
 	/****** for github.com/go-redis/redis/v8 ******/
 	// DB default 0,not recommend
-	DB int `json:"db" toml:"db"`
+	DB int `json:"db" toml:"db" validate:"min=0"`
 	// PoolSize applies per Stub node and not for the whole Stub.
-	PoolSize int `json:"poolSize" toml:"poolSize"`
+	PoolSize int `json:"poolSize" toml:"poolSize" validate:"min=1"`
 	// Maximum number of retries before giving up.
 	// Default is 3 retries; -1 (not 0) disables retries.
-	MaxRetries int `json:"maxRetries" toml:"maxRetries"`
+	MaxRetries int `json:"maxRetries" toml:"maxRetries" validate:"min=-1"`
 	// Minimum number of idle connections which is useful when establishing
 	// new connection is slow.
-	MinIdleConns int `json:"minIdleConns" toml:"minIdleConns"`
+	MinIdleConns int `json:"minIdleConns" toml:"minIdleConns" validate:"min=0"`
 	// Dial timeout for establishing new connections.
 	// Default is 5 seconds.
 	DialTimeout time.Duration `json:"dialTimeout" toml:"dialTimeout"`
@@ -58,6 +67,8 @@ type Config struct {
 
 	/****** for jupiter ******/
 	ReadOnMaster bool `json:"readOnMaster" toml:"readOnMaster"`
+	// HealthCheckInterval 从节点健康检查及哨兵拓扑刷新的间隔，默认为1s
+	HealthCheckInterval time.Duration `json:"healthCheckInterval" toml:"healthCheckInterval"`
 	// nice option
 	Debug bool `json:"debug" toml:"debug"`
 	// a require will be recorded if cost bigger than this
@@ -71,7 +82,7 @@ type Config struct {
 	// EnableSentinel .. default true
 	EnableSentinel bool `json:"enableSentinel" toml:"enableSentinel"`
 	// OnDialError panic|error
-	OnDialError string `json:"level"`
+	OnDialError string `json:"level" toml:"level" validate:"oneof=panic error"`
 	logger      *zap.Logger
 	name        string
 }
@@ -88,6 +99,7 @@ func DefaultConfig() *Config {
 		WriteTimeout:            cast.ToDuration("1s"),
 		IdleTimeout:             cast.ToDuration("60s"),
 		ReadOnMaster:            true,
+		HealthCheckInterval:     cast.ToDuration("1s"),
 		Debug:                   false,
 		EnableMetricInterceptor: true,
 		EnableTraceInterceptor:  true,
@@ -98,7 +110,7 @@ func DefaultConfig() *Config {
 	}
 }
 
-// StdConfig 哨兵模式，支持主从结构redis集群
+// StdConfig 支持主从结构及哨兵模式的redis
 func StdConfig(name string) *Config {
 	return RawConfig(constant.ConfigKey("redis", name, "stub"))
 }
@@ -106,9 +118,18 @@ func StdConfig(name string) *Config {
 func RawConfig(key string) *Config {
 	var config = DefaultConfig()
 
-	if err := cfg.UnmarshalKey(key, &config, cfg.TagName("toml")); err != nil {
+	if err := cfg.UnmarshalKey(key, &config, cfg.TagName("toml"), cfg.Strict()); err != nil {
 		config.logger.Panic("unmarshal config:"+key, xlog.FieldErr(err), xlog.FieldName(key), xlog.FieldExtMessage(config))
 	}
+	cfg.RegisterValidation(key, func() interface{} { return DefaultConfig() }, cfg.TagName("toml"))
+
+	if config.IsSentinel() {
+		config.name = key
+		if xdebug.IsDevelopmentMode() {
+			xdebug.PrettyJsonPrint(key, config)
+		}
+		return config
+	}
 
 	if config.Master.Addr != "" && config.ReadOnMaster {
 		config.Slaves.Addr = append(config.Slaves.Addr, config.Master.Addr)
@@ -124,6 +145,20 @@ func RawConfig(key string) *Config {
 	return config
 
 }
+
+// Validate 校验哨兵配置, 主从节点为空时由Build返回错误
+func (config *Config) Validate() error {
+	if config.IsSentinel() && len(config.Sentinel.Addr) == 0 {
+		return errors.New("no sentinel addr set")
+	}
+	return nil
+}
+
+// IsSentinel 是否为哨兵模式
+func (config *Config) IsSentinel() bool {
+	return config.Sentinel.MasterName != ""
+}
+
 func getUsernameAndPassword(addr string) (realAddr string, username, password string) {
 	addr = strings.TrimPrefix(addr, "redis://")
 	addr = strings.TrimPrefix(addr, "rediss://")
@@ -48,6 +48,7 @@ func TestConfig(t *testing.T) {
             dialTimeout="2s"
             readTimeout="5s"
             idleTimeout="60s"
+            level="error"
             [jupiter.redis.test.stub.master]
                 addr="redis://:user111:password222@127.0.0.1:6379"
             [jupiter.redis.test.stub.slaves]
@@ -68,6 +69,7 @@ func TestConfig(t *testing.T) {
 		assert.Equal(t, config.EnableTraceInterceptor, true)
 		assert.Equal(t, config.EnableAccessLogInterceptor, false)
 		assert.Equal(t, config.Debug, false)
+		assert.Equal(t, config.OnDialError, "error")
 
 		assert.Equal(t, config.Master.Addr, "redis://:user111:password222@127.0.0.1:6379")
 		assert.Equal(t, len(config.Slaves.Addr), 2)
@@ -75,3 +77,70 @@ func TestConfig(t *testing.T) {
 	})
 
 }
+
+func TestConfig_sentinel(t *testing.T) {
+	var configStr = `
+[jupiter.redis]
+    [jupiter.redis.sentinel]
+        [jupiter.redis.sentinel.stub]
+            healthCheckInterval="2s"
+            [jupiter.redis.sentinel.stub.sentinel]
+                masterName="mymaster"
+                addr=["127.0.0.1:26379","127.0.0.1:26380"]
+                password="sentinel"
+	`
+	assert.Nil(t, conf.LoadFromReader(bytes.NewBufferString(configStr), toml.Unmarshal))
+
+	config := StdConfig("sentinel")
+	assert.True(t, config.IsSentinel())
+	assert.Equal(t, "mymaster", config.Sentinel.MasterName)
+	assert.Equal(t, []string{"127.0.0.1:26379", "127.0.0.1:26380"}, config.Sentinel.Addr)
+	assert.Equal(t, "sentinel", config.Sentinel.Password)
+	assert.Equal(t, time.Second*2, config.HealthCheckInterval)
+	assert.Equal(t, 0, len(config.Slaves.Addr))
+}
+
+func TestConfig_validate(t *testing.T) {
+	var configStr = `
+[jupiter.redis]
+    [jupiter.redis.validate.stub]
+        poolSize=100
+        slowThresold="1s"
+        [jupiter.redis.validate.stub.master]
+            addr="127.0.0.1:6379"
+    [jupiter.redis.reload.stub]
+        poolSize=100
+        [jupiter.redis.reload.stub.master]
+            addr="127.0.0.1:6379"
+	`
+	assert.Nil(t, conf.LoadFromReader(bytes.NewBufferString(configStr), toml.Unmarshal))
+
+	t.Run("unknown key", func(t *testing.T) {
+		config := DefaultConfig()
+		err := conf.UnmarshalKey("jupiter.redis.validate.stub", &config, conf.TagName("toml"), conf.Strict())
+		assert.ErrorIs(t, err, conf.ErrInvalidConfig)
+		assert.Contains(t, err.Error(), "unknown keys: jupiter.redis.validate.stub.slowThresold")
+		assert.Panics(t, func() { StdConfig("validate") })
+	})
+
+	t.Run("build", func(t *testing.T) {
+		config := DefaultConfig()
+		config.Master.Addr = "127.0.0.1:6379"
+		config.PoolSize = 0
+		_, err := config.Build()
+		assert.ErrorIs(t, err, conf.ErrInvalidConfig)
+		assert.Contains(t, err.Error(), "poolSize must be >= 1")
+	})
+
+	t.Run("reject invalid reload", func(t *testing.T) {
+		config := StdConfig("reload")
+		assert.Equal(t, 100, config.PoolSize)
+
+		err := conf.LoadFromReader(bytes.NewBufferString(`
+[jupiter.redis.reload.stub]
+    poolSize=0
+		`), toml.Unmarshal)
+		assert.ErrorIs(t, err, conf.ErrInvalidConfig)
+		assert.Equal(t, 100, conf.GetInt("jupiter.redis.reload.stub.poolSize"))
+	})
+}
@@ -7,12 +7,15 @@ import (
 
 	"github.com/go-redis/redis/v8"
 	jsoniter "github.com/json-iterator/go"
-
+	"github.com/zhengyansheng/jupiter/pkg/core/metric"
 	"github.com/zhengyansheng/jupiter/pkg/server/governor"
 )
 
 var instances = sync.Map{}
 
+// clients 记录主从及哨兵模式的客户端，用于输出拓扑
+var clients = sync.Map{}
+
 type storeRedis struct {
 	ClientCluster *redis.ClusterClient
 	ClientStub    *redis.Client
@@ -22,6 +25,9 @@ func init() {
 	governor.HandleFunc("/debug/redis/stats", func(w http.ResponseWriter, r *http.Request) {
 		_ = jsoniter.NewEncoder(w).Encode(stats())
 	})
+	governor.HandleFunc("/debug/redis/topology", func(w http.ResponseWriter, r *http.Request) {
+		_ = jsoniter.NewEncoder(w).Encode(topologies())
+	})
 	go monitor()
 }
 func monitor() {
@@ -36,6 +42,14 @@ func monitor() {
 			if obj.ClientCluster != nil {
 				poolStats = obj.ClientCluster.PoolStats()
 			}
+			if poolStats != nil {
+				metric.LibHandleSummary.WithLabelValues("redis", name, "Hits").Observe(float64(poolStats.Hits))
+				metric.LibHandleSummary.WithLabelValues("redis", name, "Misses").Observe(float64(poolStats.Misses))
+				metric.LibHandleSummary.WithLabelValues("redis", name, "Timeouts").Observe(float64(poolStats.Timeouts))
+				metric.LibHandleSummary.WithLabelValues("redis", name, "TotalConns").Observe(float64(poolStats.TotalConns))
+				metric.LibHandleSummary.WithLabelValues("redis", name, "IdleConns").Observe(float64(poolStats.IdleConns))
+				metric.LibHandleSummary.WithLabelValues("redis", name, "StaleConns").Observe(float64(poolStats.StaleConns))
+			}
 
 			return true
 		})
@@ -59,3 +73,13 @@ func stats() (stats map[string]interface{}) {
 	})
 	return
 }
+
+// topologies 返回所有主从及哨兵模式客户端的拓扑
+func topologies() map[string]Topology {
+	topologies := make(map[string]Topology)
+	clients.Range(func(key, val interface{}) bool {
+		topologies[key.(string)] = val.(*Client).Topology()
+		return true
+	})
+	return topologies
+}
@@ -4,8 +4,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"go.opentelemetry.io/otel/codes"
-	"go.opentelemetry.io/otel/trace"
 	"strings"
 	"time"
 
@@ -13,9 +11,14 @@ import (
 	"github.com/fatih/color"
 	"github.com/go-redis/redis/v8"
 
+	"github.com/zhengyansheng/jupiter/pkg/core/metric"
 	"github.com/zhengyansheng/jupiter/pkg/core/sentinel"
+	"github.com/zhengyansheng/jupiter/pkg/core/xtrace"
 	"github.com/zhengyansheng/jupiter/pkg/util/xstring"
 	"github.com/zhengyansheng/jupiter/pkg/xlog"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type redigoContextKeyType struct{}
@@ -146,11 +149,35 @@ func debugInterceptor(compName string, addr string, config *Config, logger *xlog
 		})
 }
 func metricInterceptor(compName string, addr string, config *Config, logger *xlog.Logger) *interceptor {
-
 	return newInterceptor(compName, config, logger).
-	
-		return nil
-	})
+		setAfterProcess(func(ctx context.Context, cmd redis.Cmder) error {
+			cost := time.Since(ctx.Value(ctxBegKey).(time.Time))
+			err := cmd.Err()
+			metric.ClientHandleHistogram.WithLabelValues(metric.TypeRedis, compName, cmd.Name(), addr).Observe(cost.Seconds())
+			if err != nil {
+				if errors.Is(err, redis.Nil) {
+					metric.ClientHandleCounter.Inc(metric.TypeRedis, compName, cmd.Name(), addr, "Empty")
+					return nil
+				}
+				metric.ClientHandleCounter.Inc(metric.TypeRedis, compName, cmd.Name(), addr, "Error")
+				return nil
+			}
+			metric.ClientHandleCounter.Inc(metric.TypeRedis, compName, cmd.Name(), addr, "OK")
+			return nil
+		}).
+		setAfterProcessPipeline(func(ctx context.Context, cmds []redis.Cmder) error {
+			name := "pipeline_" + getCmdsName(cmds)
+			cost := time.Since(ctx.Value(ctxBegKey).(time.Time))
+			metric.ClientHandleHistogram.WithLabelValues(metric.TypeRedis, compName, name, addr).Observe(cost.Seconds())
+			for _, cmd := range cmds {
+				if err := cmd.Err(); err != nil && !errors.Is(err, redis.Nil) {
+					metric.ClientHandleCounter.Inc(metric.TypeRedis, compName, name, addr, "Error")
+					return nil
+				}
+			}
+			metric.ClientHandleCounter.Inc(metric.TypeRedis, compName, name, addr, "OK")
+			return nil
+		})
 }
 func accessInterceptor(compName string, addr string, config *Config, logger *xlog.Logger) *interceptor {
 	return newInterceptor(compName, config, logger).
@@ -210,14 +237,30 @@ func accessInterceptor(compName string, addr string, config *Config, logger *xlo
 	})
 }
 func traceInterceptor(compName string, addr string, config *Config, logger *xlog.Logger) *interceptor {
+	tracer := xtrace.NewTracer(trace.SpanKindClient)
+	attrs := trace.WithAttributes(
+		semconv.DBSystemRedis,
+		semconv.NetPeerNameKey.String(addr),
+		semconv.PeerServiceKey.String(compName),
+	)
 	return newInterceptor(compName, config, logger).
 		setBeforeProcess(func(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+			ctx, span := tracer.Start(ctx, cmd.Name(), nil, attrs)
+			span.SetAttributes(semconv.DBOperationKey.String(cmd.Name()))
 			return ctx, nil
 		}).
 		setAfterProcess(func(ctx context.Context, cmd redis.Cmder) error {
+			span := trace.SpanFromContext(ctx)
+			if err := cmd.Err(); err != nil && err != redis.Nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			span.End()
 			return nil
 		}).
 		setBeforeProcessPipeline(func(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+			ctx, span := tracer.Start(ctx, "pipeline", nil, attrs)
+			span.SetAttributes(semconv.DBOperationKey.String(getCmdsName(cmds)))
 			return ctx, nil
 		}).
 		setAfterProcessPipeline(func(ctx context.Context, cmds []redis.Cmder) error {
@@ -226,11 +269,9 @@ func traceInterceptor(compName string, addr string, config *Config, logger *xlog
 				if err := cmd.Err(); err != nil && err != redis.Nil {
 					span.RecordError(err)
 					span.SetStatus(codes.Error, err.Error())
-					span.End()
-					return nil
+					break
 				}
 			}
-			span.SetStatus(codes.Ok, "ok")
 			span.End()
 			return nil
 		})
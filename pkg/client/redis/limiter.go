@@ -0,0 +1,174 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var (
+	// 令牌桶的状态保存在hash中，按照上次更新时间补充令牌
+	tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local n = tonumber(ARGV[4])
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / 1000)
+
+local allowed = 0
+local retry = 0
+if tokens >= n then
+	tokens = tokens - n
+	allowed = 1
+else
+	retry = math.ceil((n - tokens) * 1000 / rate)
+end
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "ts", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst * 1000 / rate) + 1000)
+return {allowed, math.floor(tokens), retry}`)
+
+	// 滑动窗口使用有序集合记录窗口内的每一次请求
+	slidingWindowScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local n = tonumber(ARGV[4])
+
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now - window)
+local count = redis.call("ZCARD", KEYS[1])
+if count + n <= limit then
+	for i = 1, n do
+		redis.call("ZADD", KEYS[1], now, ARGV[5] .. ":" .. i)
+	end
+	redis.call("PEXPIRE", KEYS[1], window)
+	return {1, limit - count - n, 0}
+end
+
+local retry = window
+local idx = count + n - limit - 1
+if idx < count then
+	local oldest = redis.call("ZRANGE", KEYS[1], idx, idx, "WITHSCORES")
+	if oldest[2] then
+		retry = tonumber(oldest[2]) + window - now
+	end
+end
+return {0, limit - count, retry}`)
+)
+
+// LimitResult 限流结果
+type LimitResult struct {
+	// Allowed 是否允许本次请求
+	Allowed bool
+	// Remaining 剩余可用的请求数
+	Remaining int
+	// RetryAfter 被拒绝时，距离可以再次请求的时间
+	RetryAfter time.Duration
+}
+
+// Limiter 分布式限流器，同一个key在所有实例间共享配额
+type Limiter interface {
+	Allow(ctx context.Context, key string) (*LimitResult, error)
+	AllowN(ctx context.Context, key string, n int) (*LimitResult, error)
+}
+
+// TokenBucketLimiter 令牌桶限流，允许burst大小的突发流量
+type TokenBucketLimiter struct {
+	client redis.Scripter
+	rate   float64
+	burst  int
+	now    func() time.Time
+}
+
+// NewTokenBucketLimiter 创建令牌桶限流器，rate为每秒补充的令牌数，burst为桶的容量
+func NewTokenBucketLimiter(client redis.Scripter, rate float64, burst int) *TokenBucketLimiter {
+	return &TokenBucketLimiter{
+		client: client,
+		rate:   rate,
+		burst:  burst,
+		now:    time.Now,
+	}
+}
+
+// Allow ...
+func (l *TokenBucketLimiter) Allow(ctx context.Context, key string) (*LimitResult, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+// AllowN ...
+func (l *TokenBucketLimiter) AllowN(ctx context.Context, key string, n int) (*LimitResult, error) {
+	values, err := tokenBucketScript.Run(ctx, l.client, []string{key},
+		strconv.FormatFloat(l.rate, 'f', -1, 64), l.burst, l.now().UnixMilli(), n).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+	return newLimitResult(values), nil
+}
+
+// SlidingWindowLimiter 滑动窗口限流，任意window时间内最多允许limit次请求
+type SlidingWindowLimiter struct {
+	client redis.Scripter
+	limit  int
+	window time.Duration
+	now    func() time.Time
+}
+
+// NewSlidingWindowLimiter 创建滑动窗口限流器
+func NewSlidingWindowLimiter(client redis.Scripter, limit int, window time.Duration) *SlidingWindowLimiter {
+	return &SlidingWindowLimiter{
+		client: client,
+		limit:  limit,
+		window: window,
+		now:    time.Now,
+	}
+}
+
+// Allow ...
+func (l *SlidingWindowLimiter) Allow(ctx context.Context, key string) (*LimitResult, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+// AllowN ...
+func (l *SlidingWindowLimiter) AllowN(ctx context.Context, key string, n int) (*LimitResult, error) {
+	member, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	values, err := slidingWindowScript.Run(ctx, l.client, []string{key},
+		l.limit, l.window.Milliseconds(), l.now().UnixMilli(), n, member).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+	return newLimitResult(values), nil
+}
+
+func newLimitResult(values []int64) *LimitResult {
+	return &LimitResult{
+		Allowed:    values[0] == 1,
+		Remaining:  int(values[1]),
+		RetryAfter: time.Duration(values[2]) * time.Millisecond,
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucketLimiter(t *testing.T) {
+	_, nodes := newTestNodes(t, 1)
+	now := time.Now()
+	limiter := NewTokenBucketLimiter(nodes[0], 10, 5)
+	limiter.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		res, err := limiter.Allow(ctx, "bucket")
+		assert.Nil(t, err)
+		assert.True(t, res.Allowed)
+		assert.Equal(t, 4-i, res.Remaining)
+	}
+	res, err := limiter.Allow(ctx, "bucket")
+	assert.Nil(t, err)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 100*time.Millisecond, res.RetryAfter)
+
+	now = now.Add(200 * time.Millisecond)
+	res, err = limiter.AllowN(ctx, "bucket", 2)
+	assert.Nil(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+
+	// 补充的令牌不超过桶的容量
+	now = now.Add(time.Minute)
+	res, err = limiter.AllowN(ctx, "bucket", 6)
+	assert.Nil(t, err)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 5, res.Remaining)
+}
+
+func TestSlidingWindowLimiter(t *testing.T) {
+	_, nodes := newTestNodes(t, 1)
+	now := time.Now()
+	limiter := NewSlidingWindowLimiter(nodes[0], 3, time.Second)
+	limiter.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		res, err := limiter.Allow(ctx, "window")
+		assert.Nil(t, err)
+		assert.True(t, res.Allowed)
+		assert.Equal(t, 2-i, res.Remaining)
+		now = now.Add(100 * time.Millisecond)
+	}
+	res, err := limiter.Allow(ctx, "window")
+	assert.Nil(t, err)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 700*time.Millisecond, res.RetryAfter)
+
+	now = now.Add(700 * time.Millisecond)
+	res, err = limiter.Allow(ctx, "window")
+	assert.Nil(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+
+	res, err = limiter.AllowN(ctx, "window", 2)
+	assert.Nil(t, err)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 200*time.Millisecond, res.RetryAfter)
+}
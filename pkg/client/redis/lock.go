@@ -0,0 +1,368 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/zhengyansheng/jupiter/pkg/core/ecode"
+	"github.com/zhengyansheng/jupiter/pkg/util/xgo"
+	"github.com/zhengyansheng/jupiter/pkg/xlog"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrLockNotObtained 在context结束前未能获取到锁
+	ErrLockNotObtained = errors.New("redis: lock not obtained")
+	// ErrLockNotHeld 锁已过期或被其他持有者获取
+	ErrLockNotHeld = errors.New("redis: lock not held")
+)
+
+var (
+	// 加锁成功后递增fencing token，token所在的key不过期以保证单调递增
+	obtainScript = redis.NewScript(`
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return redis.call("INCR", KEYS[2])
+end
+return 0`)
+	refreshScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+	releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+// clockDriftFactor Redlock算法中的时钟漂移系数
+const clockDriftFactor = 0.01
+
+// LockOption 分布式锁的选项
+type LockOption func(*lockOptions)
+
+type lockOptions struct {
+	ttl           time.Duration
+	retryInterval time.Duration
+	watchdog      bool
+}
+
+// WithLockTTL 锁的过期时间，默认10s
+func WithLockTTL(ttl time.Duration) LockOption {
+	return func(o *lockOptions) {
+		o.ttl = ttl
+	}
+}
+
+// WithLockRetryInterval 加锁失败后的重试间隔，默认100ms
+func WithLockRetryInterval(interval time.Duration) LockOption {
+	return func(o *lockOptions) {
+		o.retryInterval = interval
+	}
+}
+
+// WithLockWatchdog 是否开启自动续期，默认开启，每隔ttl/3续期一次
+func WithLockWatchdog(enable bool) LockOption {
+	return func(o *lockOptions) {
+		o.watchdog = enable
+	}
+}
+
+// Locker Redlock风格的分布式锁
+// 传入多个相互独立的主节点时，需要在多数节点上加锁成功才认为获取到锁
+type Locker struct {
+	nodes  []redis.Scripter
+	opts   lockOptions
+	logger *zap.Logger
+}
+
+// NewLocker 创建分布式锁，nodes一般为CmdOnMaster()返回的客户端
+func NewLocker(nodes []redis.Scripter, opts ...LockOption) *Locker {
+	locker := &Locker{
+		nodes: nodes,
+		opts: lockOptions{
+			ttl:           10 * time.Second,
+			retryInterval: 100 * time.Millisecond,
+			watchdog:      true,
+		},
+		logger: xlog.Jupiter().Named(ecode.ModClientRedis),
+	}
+	for _, opt := range opts {
+		opt(&locker.opts)
+	}
+	return locker
+}
+
+// NewLocker 基于主节点创建分布式锁
+func (ins *Client) NewLocker(opts ...LockOption) *Locker {
+	return NewLocker([]redis.Scripter{ins.CmdOnMaster()}, opts...)
+}
+
+// Obtain 获取锁，获取失败时按照重试间隔重试，直到ctx结束
+// ctx只控制获取的过程，开启自动续期时会一直续期直到Release或锁丢失，使用完毕后必须调用Release
+func (l *Locker) Obtain(ctx context.Context, key string) (*Lock, error) {
+	value, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	ticker := time.NewTicker(l.opts.retryInterval)
+	defer ticker.Stop()
+	for {
+		lock, err := l.obtain(ctx, key, value)
+		if err == nil {
+			return lock, nil
+		}
+		if !errors.Is(err, ErrLockNotObtained) {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ErrLockNotObtained
+		case <-ticker.C:
+		}
+	}
+}
+
+// TryObtain 尝试获取一次锁，失败时返回ErrLockNotObtained
+func (l *Locker) TryObtain(ctx context.Context, key string) (*Lock, error) {
+	value, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	return l.obtain(ctx, key, value)
+}
+
+func (l *Locker) obtain(ctx context.Context, key, value string) (*Lock, error) {
+	beg := time.Now()
+	var (
+		token   int64
+		success int
+		lastErr error
+	)
+	for _, node := range l.nodes {
+		fence, err := obtainScript.Run(ctx, node, []string{key, fenceKey(key)}, value, l.opts.ttl.Milliseconds()).Int64()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if fence > 0 {
+			success++
+			if fence > token {
+				token = fence
+			}
+		}
+	}
+
+	validity := l.opts.ttl - time.Since(beg) - time.Duration(float64(l.opts.ttl)*clockDriftFactor)
+	if success >= l.quorum() && validity > 0 {
+		lock := &Lock{
+			locker:    l,
+			key:       key,
+			value:     value,
+			token:     token,
+			expiredAt: beg.Add(validity),
+			done:      make(chan struct{}),
+			stop:      make(chan struct{}),
+		}
+		if l.opts.watchdog {
+			xgo.Go(lock.watchdog)
+		}
+		return lock, nil
+	}
+
+	// 未达到多数节点，释放已经获取到的部分
+	l.release(context.Background(), key, value)
+	if success == 0 && lastErr != nil && ctx.Err() == nil {
+		return nil, lastErr
+	}
+	return nil, ErrLockNotObtained
+}
+
+func (l *Locker) quorum() int {
+	return len(l.nodes)/2 + 1
+}
+
+func (l *Locker) release(ctx context.Context, key, value string) int {
+	var success int
+	for _, node := range l.nodes {
+		n, err := releaseScript.Run(ctx, node, []string{key}, value).Int64()
+		if err == nil && n > 0 {
+			success++
+		}
+	}
+	return success
+}
+
+// Lock 已获取到的锁
+type Lock struct {
+	locker *Locker
+	key    string
+	value  string
+	token  int64
+
+	mu        sync.Mutex
+	expiredAt time.Time
+	released  bool
+	once      sync.Once
+	done      chan struct{}
+	stop      chan struct{}
+}
+
+// Key 锁的key
+func (lock *Lock) Key() string {
+	return lock.key
+}
+
+// Token fencing token，同一个key每次加锁单调递增
+// 写入下游存储时带上该token，下游拒绝比已见过的token更小的写入，避免锁过期后的并发写
+func (lock *Lock) Token() int64 {
+	return lock.token
+}
+
+// TTL 锁的剩余有效时间
+func (lock *Lock) TTL() time.Duration {
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+	if lock.released {
+		return 0
+	}
+	if ttl := time.Until(lock.expiredAt); ttl > 0 {
+		return ttl
+	}
+	return 0
+}
+
+// Done 锁被释放或丢失(续期失败)时关闭
+func (lock *Lock) Done() <-chan struct{} {
+	return lock.done
+}
+
+// Refresh 续期锁，锁已经不在多数节点上时返回ErrLockNotHeld，网络错误时返回原始错误
+func (lock *Lock) Refresh(ctx context.Context) error {
+	l := lock.locker
+	beg := time.Now()
+	var (
+		success, rejected int
+		lastErr           error
+	)
+	for _, node := range l.nodes {
+		n, err := refreshScript.Run(ctx, node, []string{lock.key}, lock.value, l.opts.ttl.Milliseconds()).Int64()
+		switch {
+		case err != nil:
+			lastErr = err
+		case n > 0:
+			success++
+		default:
+			rejected++
+		}
+	}
+	if success < l.quorum() {
+		if rejected > len(l.nodes)-l.quorum() || lastErr == nil {
+			return ErrLockNotHeld
+		}
+		return lastErr
+	}
+
+	validity := l.opts.ttl - time.Since(beg) - time.Duration(float64(l.opts.ttl)*clockDriftFactor)
+	lock.mu.Lock()
+	lock.expiredAt = beg.Add(validity)
+	lock.mu.Unlock()
+	return nil
+}
+
+// Release 释放锁，锁已经过期或被其他持有者获取时返回ErrLockNotHeld
+func (lock *Lock) Release(ctx context.Context) error {
+	lock.mu.Lock()
+	if lock.released {
+		lock.mu.Unlock()
+		return ErrLockNotHeld
+	}
+	lock.released = true
+	lock.mu.Unlock()
+
+	close(lock.stop)
+	lock.finish()
+	if lock.locker.release(ctx, lock.key, lock.value) == 0 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+func (lock *Lock) finish() {
+	lock.once.Do(func() {
+		close(lock.done)
+	})
+}
+
+// watchdog 每隔ttl/3续期一次，直到锁被释放或超过有效期仍未续期成功
+func (lock *Lock) watchdog() {
+	l := lock.locker
+	ticker := time.NewTicker(l.opts.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-lock.stop:
+			return
+		case <-ticker.C:
+			err := lock.Refresh(context.Background())
+			if err == nil {
+				continue
+			}
+			if errors.Is(err, ErrLockNotHeld) || lock.TTL() <= 0 {
+				l.logger.Warn("redis lock lost", xlog.FieldKey(lock.key), xlog.FieldErr(err))
+				lock.finish()
+				return
+			}
+		}
+	}
+}
+
+// fenceKey 与key位于同一个slot，避免集群模式下脚本访问的key跨slot(CROSSSLOT)
+// key中有}但没有{...}时无法构造同一slot的key，集群模式下需要为这类key加上{...}
+func fenceKey(key string) string {
+	if hasHashTag(key) || strings.IndexByte(key, '}') >= 0 {
+		return key + ":fence"
+	}
+	return "{" + key + "}:fence"
+}
+
+// hasHashTag key中存在非空的{...}时，集群模式下只使用其中的内容计算slot
+func hasHashTag(key string) bool {
+	start := strings.IndexByte(key, '{')
+	if start < 0 {
+		return false
+	}
+	end := strings.IndexByte(key[start+1:], '}')
+	return end > 0
+}
+
+func randomToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,184 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestNodes(t *testing.T, n int) ([]*miniredis.Miniredis, []redis.Scripter) {
+	servers := make([]*miniredis.Miniredis, 0, n)
+	nodes := make([]redis.Scripter, 0, n)
+	for i := 0; i < n; i++ {
+		mr := miniredis.RunT(t)
+		cli := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		t.Cleanup(func() { _ = cli.Close() })
+		servers = append(servers, mr)
+		nodes = append(nodes, cli)
+	}
+	return servers, nodes
+}
+
+func TestLocker_Obtain(t *testing.T) {
+	servers, nodes := newTestNodes(t, 1)
+	locker := NewLocker(nodes, WithLockWatchdog(false))
+	ctx := context.Background()
+
+	lock, err := locker.TryObtain(ctx, "lock")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), lock.Token())
+	assert.True(t, lock.TTL() > 0)
+
+	_, err = locker.TryObtain(ctx, "lock")
+	assert.Equal(t, ErrLockNotObtained, err)
+
+	t.Run("obtain until context done", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(ctx, 150*time.Millisecond)
+		defer cancel()
+		_, err := locker.Obtain(ctx, "lock")
+		assert.Equal(t, ErrLockNotObtained, err)
+	})
+
+	t.Run("fencing token increases", func(t *testing.T) {
+		assert.Nil(t, lock.Release(ctx))
+		assert.Equal(t, ErrLockNotHeld, lock.Release(ctx))
+		_, ok := <-lock.Done()
+		assert.False(t, ok)
+
+		next, err := locker.Obtain(ctx, "lock")
+		assert.Nil(t, err)
+		assert.Equal(t, int64(2), next.Token())
+
+		servers[0].FastForward(10 * time.Second)
+		assert.Equal(t, ErrLockNotHeld, next.Refresh(ctx))
+		assert.Equal(t, ErrLockNotHeld, next.Release(ctx))
+	})
+}
+
+func TestLocker_Redlock(t *testing.T) {
+	servers, nodes := newTestNodes(t, 3)
+	locker := NewLocker(nodes, WithLockWatchdog(false))
+	ctx := context.Background()
+
+	assert.Nil(t, servers[0].Set("lock", "other"))
+	lock, err := locker.TryObtain(ctx, "lock")
+	assert.Nil(t, err)
+	assert.Nil(t, lock.Release(ctx))
+
+	assert.Nil(t, servers[1].Set("lock", "other"))
+	_, err = locker.TryObtain(ctx, "lock")
+	assert.Equal(t, ErrLockNotObtained, err)
+	// 未达到多数节点时释放已获取的部分
+	assert.False(t, servers[2].Exists("lock"))
+}
+
+func TestLock_watchdog(t *testing.T) {
+	servers, nodes := newTestNodes(t, 1)
+	mr := servers[0]
+	locker := NewLocker(nodes, WithLockTTL(300*time.Millisecond))
+
+	t.Run("renew lease", func(t *testing.T) {
+		lock, err := locker.TryObtain(context.Background(), "renew")
+		assert.Nil(t, err)
+		for i := 0; i < 6; i++ {
+			time.Sleep(100 * time.Millisecond)
+			mr.FastForward(100 * time.Millisecond)
+		}
+		assert.True(t, mr.Exists("renew"))
+		assert.Nil(t, lock.Release(context.Background()))
+		assert.False(t, mr.Exists("renew"))
+	})
+
+	t.Run("lock lost", func(t *testing.T) {
+		lock, err := locker.TryObtain(context.Background(), "lost")
+		assert.Nil(t, err)
+		mr.Del("lost")
+		select {
+		case <-lock.Done():
+		case <-time.After(time.Second):
+			t.Fatal("lock lost not detected")
+		}
+	})
+
+	t.Run("keep renewing after obtain context done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		lock, err := locker.Obtain(ctx, "cancel")
+		assert.Nil(t, err)
+		// ctx只控制获取的过程, 不影响已获取到的锁
+		cancel()
+		for i := 0; i < 6; i++ {
+			time.Sleep(100 * time.Millisecond)
+			mr.FastForward(100 * time.Millisecond)
+		}
+		assert.True(t, mr.Exists("cancel"))
+		select {
+		case <-lock.Done():
+			t.Fatal("lock released on obtain context done")
+		default:
+		}
+		assert.Nil(t, lock.Release(context.Background()))
+		assert.False(t, mr.Exists("cancel"))
+	})
+}
+
+// keySlot 按照redis集群规范计算key所在的slot
+func keySlot(key string) uint16 {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	var crc uint16
+	for i := 0; i < len(key); i++ {
+		crc ^= uint16(key[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc % 16384
+}
+
+func Test_fenceKey(t *testing.T) {
+	// 123456789的CRC16为0x31C3
+	assert.Equal(t, uint16(0x31C3%16384), keySlot("123456789"))
+
+	for _, key := range []string{"lock", "jupiter:lock:order", "{order}:lock", "lock:{order}", "a{b", "a}b{c}"} {
+		assert.Equal(t, keySlot(key), keySlot(fenceKey(key)), key)
+	}
+
+	t.Run("cluster client", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		cli := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{mr.Addr()}})
+		t.Cleanup(func() { _ = cli.Close() })
+
+		lock, err := NewLocker([]redis.Scripter{cli}, WithLockWatchdog(false)).TryObtain(context.Background(), "order")
+		assert.Nil(t, err)
+		fence, err := mr.Get("{order}:fence")
+		assert.Nil(t, err)
+		assert.Equal(t, "1", fence)
+		assert.Nil(t, lock.Release(context.Background()))
+	})
+}
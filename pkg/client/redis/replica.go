@@ -0,0 +1,287 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/zhengyansheng/jupiter/pkg/core/metric"
+	"github.com/zhengyansheng/jupiter/pkg/xlog"
+)
+
+// ReplicaState 从节点的健康状态，用于governor展示
+type ReplicaState struct {
+	Addr      string    `json:"addr"`
+	Healthy   bool      `json:"healthy"`
+	Latency   string    `json:"latency"`
+	Failures  int       `json:"failures"`
+	LastError string    `json:"lastError,omitempty"`
+	LastCheck time.Time `json:"lastCheck"`
+}
+
+type replica struct {
+	addr      string
+	client    *redis.Client
+	healthy   bool
+	latency   time.Duration
+	failures  int
+	lastErr   error
+	lastCheck time.Time
+}
+
+// minCloseDelay 被移除的从节点延迟关闭的最短时间
+const minCloseDelay = 5 * time.Second
+
+// replicaSet 维护从节点的健康状态及延迟，读请求只会路由到健康的从节点
+type replicaSet struct {
+	config    *Config
+	newClient func(addr string) *redis.Client
+	// closeDelay 被移除的从节点延迟关闭，等待已经通过pick取得该节点的请求完成
+	closeDelay time.Duration
+
+	mu       sync.RWMutex
+	nodes    map[string]*replica
+	healthy  []*replica
+	retiring map[*redis.Client]*time.Timer
+}
+
+func newReplicaSet(config *Config, newClient func(addr string) *redis.Client) *replicaSet {
+	closeDelay := config.DialTimeout + config.ReadTimeout + config.WriteTimeout
+	if closeDelay < minCloseDelay {
+		closeDelay = minCloseDelay
+	}
+	return &replicaSet{
+		config:     config,
+		newClient:  newClient,
+		closeDelay: closeDelay,
+		nodes:      make(map[string]*replica),
+		retiring:   make(map[*redis.Client]*time.Timer),
+	}
+}
+
+// add 添加已经创建好的从节点，新节点在第一次健康检查之前认为是健康的
+func (rs *replicaSet) add(addr string, client *redis.Client) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.nodes[addr] = &replica{addr: addr, client: client, healthy: true}
+	rs.rebuild()
+}
+
+// update 按照哨兵返回的从节点列表增删节点
+func (rs *replicaSet) update(addrs []string) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	want := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		want[addr] = true
+		if _, ok := rs.nodes[addr]; ok {
+			continue
+		}
+		rs.nodes[addr] = &replica{addr: addr, client: rs.newClient(addr), healthy: true}
+		rs.changed(addr, "replica_added")
+	}
+	for addr, node := range rs.nodes {
+		if want[addr] {
+			continue
+		}
+		delete(rs.nodes, addr)
+		rs.retire(node.client)
+		rs.changed(addr, "replica_removed")
+	}
+	rs.rebuild()
+}
+
+// check PING所有从节点，更新健康状态及延迟
+func (rs *replicaSet) check(ctx context.Context) {
+	rs.mu.RLock()
+	nodes := make([]*replica, 0, len(rs.nodes))
+	for _, node := range rs.nodes {
+		nodes = append(nodes, node)
+	}
+	rs.mu.RUnlock()
+
+	type result struct {
+		node    *replica
+		latency time.Duration
+		err     error
+	}
+	results := make(chan result, len(nodes))
+	for _, node := range nodes {
+		go func(node *replica) {
+			ctx, cancel := context.WithTimeout(ctx, rs.timeout())
+			defer cancel()
+			beg := time.Now()
+			err := node.client.Ping(ctx).Err()
+			results <- result{node: node, latency: time.Since(beg), err: err}
+		}(node)
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for range nodes {
+		res := <-results
+		node := res.node
+		node.lastCheck = time.Now()
+		node.lastErr = res.err
+		if res.err != nil {
+			node.failures++
+			if node.healthy {
+				node.healthy = false
+				rs.changed(node.addr, "replica_down", xlog.FieldErr(res.err))
+			}
+			continue
+		}
+
+		node.failures = 0
+		if node.latency == 0 {
+			node.latency = res.latency
+		} else {
+			// 指数加权平均，避免单次抖动影响路由
+			node.latency = (node.latency*7 + res.latency*3) / 10
+		}
+		if !node.healthy {
+			node.healthy = true
+			rs.changed(node.addr, "replica_up")
+		}
+	}
+	rs.rebuild()
+}
+
+func (rs *replicaSet) timeout() time.Duration {
+	if rs.config.ReadTimeout > 0 {
+		return rs.config.ReadTimeout
+	}
+	return time.Second
+}
+
+// pick 从两个随机的健康节点中选择延迟较低的一个，没有健康节点时返回nil
+func (rs *replicaSet) pick() *redis.Client {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	switch n := len(rs.healthy); n {
+	case 0:
+		return nil
+	case 1:
+		return rs.healthy[0].client
+	default:
+		i, j := rand.Intn(n), rand.Intn(n-1)
+		if j >= i {
+			j++
+		}
+		a, b := rs.healthy[i], rs.healthy[j]
+		if b.latency < a.latency {
+			return b.client
+		}
+		return a.client
+	}
+}
+
+// any 返回任意一个节点，所有节点都不健康时使用
+func (rs *replicaSet) any() *redis.Client {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	for _, node := range rs.nodes {
+		return node.client
+	}
+	return nil
+}
+
+func (rs *replicaSet) len() int {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	return len(rs.nodes)
+}
+
+func (rs *replicaSet) states() []ReplicaState {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	states := make([]ReplicaState, 0, len(rs.nodes))
+	for _, node := range rs.nodes {
+		state := ReplicaState{
+			Addr:      node.addr,
+			Healthy:   node.healthy,
+			Latency:   node.latency.String(),
+			Failures:  node.failures,
+			LastCheck: node.lastCheck,
+		}
+		if node.lastErr != nil {
+			state.LastError = node.lastErr.Error()
+		}
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Addr < states[j].Addr
+	})
+	return states
+}
+
+// retire 延迟关闭被移除的从节点，需要持有写锁
+func (rs *replicaSet) retire(client *redis.Client) {
+	rs.retiring[client] = time.AfterFunc(rs.closeDelay, func() {
+		rs.mu.Lock()
+		defer rs.mu.Unlock()
+		if _, ok := rs.retiring[client]; ok {
+			delete(rs.retiring, client)
+			_ = client.Close()
+		}
+	})
+}
+
+func (rs *replicaSet) close() {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for _, node := range rs.nodes {
+		_ = node.client.Close()
+	}
+	for client, timer := range rs.retiring {
+		timer.Stop()
+		_ = client.Close()
+	}
+	rs.nodes = make(map[string]*replica)
+	rs.retiring = make(map[*redis.Client]*time.Timer)
+	rs.rebuild()
+}
+
+// rebuild 重建健康节点的快照，需要持有写锁
+func (rs *replicaSet) rebuild() {
+	healthy := make([]*replica, 0, len(rs.nodes))
+	for _, node := range rs.nodes {
+		if node.healthy {
+			healthy = append(healthy, node)
+		}
+	}
+	rs.healthy = healthy
+
+	metric.ClientReplicaGauge.Set(float64(len(healthy)), metric.TypeRedis, rs.config.name, "healthy")
+	metric.ClientReplicaGauge.Set(float64(len(rs.nodes)-len(healthy)), metric.TypeRedis, rs.config.name, "unhealthy")
+}
+
+// changed 记录拓扑变化的日志及监控
+func (rs *replicaSet) changed(addr, event string, fields ...xlog.Field) {
+	metric.ClientTopologyChangeCounter.Inc(metric.TypeRedis, rs.config.name, addr, event)
+	rs.config.logger.Warn("redis topology changed", append([]xlog.Field{
+		xlog.FieldName(rs.config.name),
+		xlog.FieldAddr(addr),
+		xlog.FieldEvent(event),
+	}, fields...)...)
+}
@@ -0,0 +1,198 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+// deadAddr 没有监听的地址，用于模拟宕机的从节点
+const deadAddr = "127.0.0.1:1"
+
+func newTestConfig(name string) *Config {
+	config := DefaultConfig()
+	config.name = name
+	config.EnableMetricInterceptor = false
+	config.EnableTraceInterceptor = false
+	config.OnDialError = "error"
+	return config
+}
+
+func newTestReplicaSet(config *Config) *replicaSet {
+	return newReplicaSet(config, func(addr string) *redis.Client {
+		return config.newClient(addr, "", "")
+	})
+}
+
+func TestReplicaSet_check(t *testing.T) {
+	mr := miniredis.RunT(t)
+	config := newTestConfig("test.replica.check")
+	rs := newTestReplicaSet(config)
+	defer rs.close()
+
+	rs.add(mr.Addr(), config.newClient(mr.Addr(), "", ""))
+	rs.add(deadAddr, config.newClient(deadAddr, "", ""))
+	assert.Equal(t, 2, len(rs.healthy))
+
+	rs.check(context.Background())
+	states := rs.states()
+	assert.Equal(t, 2, len(states))
+	assert.Equal(t, deadAddr, states[0].Addr)
+	assert.False(t, states[0].Healthy)
+	assert.Equal(t, 1, states[0].Failures)
+	assert.NotEmpty(t, states[0].LastError)
+	assert.Equal(t, mr.Addr(), states[1].Addr)
+	assert.True(t, states[1].Healthy)
+
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, mr.Addr(), rs.pick().Options().Addr)
+	}
+
+	t.Run("replica recovered", func(t *testing.T) {
+		mr.Close()
+		rs.check(context.Background())
+		assert.Nil(t, rs.pick())
+		assert.NotNil(t, rs.any())
+
+		assert.Nil(t, mr.Restart())
+		rs.check(context.Background())
+		assert.Equal(t, mr.Addr(), rs.pick().Options().Addr)
+	})
+}
+
+func TestReplicaSet_update(t *testing.T) {
+	config := newTestConfig("test.replica.update")
+	rs := newTestReplicaSet(config)
+	defer rs.close()
+
+	rs.update([]string{"127.0.0.1:6380", "127.0.0.1:6381"})
+	assert.Equal(t, 2, rs.len())
+
+	rs.update([]string{"127.0.0.1:6381", "127.0.0.1:6382"})
+	states := rs.states()
+	assert.Equal(t, 2, len(states))
+	assert.Equal(t, "127.0.0.1:6381", states[0].Addr)
+	assert.Equal(t, "127.0.0.1:6382", states[1].Addr)
+
+	rs.update(nil)
+	assert.Equal(t, 0, rs.len())
+	assert.Nil(t, rs.pick())
+}
+
+func TestReplicaSet_updateDelayClose(t *testing.T) {
+	mr := miniredis.RunT(t)
+	config := newTestConfig("test.replica.delay")
+	rs := newTestReplicaSet(config)
+	rs.closeDelay = 50 * time.Millisecond
+	defer rs.close()
+
+	rs.update([]string{mr.Addr()})
+	client := rs.pick()
+	assert.NotNil(t, client)
+
+	// 移除后已经取得该节点的请求仍然可以完成
+	rs.update(nil)
+	assert.Nil(t, rs.pick())
+	assert.Nil(t, client.Ping(context.Background()).Err())
+
+	assert.Eventually(t, func() bool {
+		return errors.Is(client.Ping(context.Background()).Err(), redis.ErrClosed)
+	}, time.Second, 10*time.Millisecond)
+}
+
+type fakeDiscoverer struct {
+	master   string
+	replicas []string
+	err      error
+}
+
+func (d *fakeDiscoverer) discover(ctx context.Context) (string, []string, error) {
+	return d.master, d.replicas, d.err
+}
+
+func (d *fakeDiscoverer) close() {}
+
+func TestClient_refresh(t *testing.T) {
+	mr1 := miniredis.RunT(t)
+	mr2 := miniredis.RunT(t)
+
+	config := newTestConfig("test.sentinel.refresh")
+	config.Sentinel.MasterName = "mymaster"
+	config.Sentinel.Addr = []string{"127.0.0.1:26379"}
+	config.ReadOnMaster = false
+	d := &fakeDiscoverer{master: mr1.Addr(), replicas: []string{mr2.Addr(), deadAddr}}
+	ins := &Client{
+		config:     config,
+		replicas:   newTestReplicaSet(config),
+		discoverer: d,
+		done:       make(chan struct{}),
+	}
+	defer ins.Close()
+
+	ins.refresh(context.Background())
+	topology := ins.Topology()
+	assert.Equal(t, "sentinel", topology.Mode)
+	assert.Equal(t, "mymaster", topology.MasterName)
+	assert.Equal(t, mr1.Addr(), topology.Master)
+	assert.Equal(t, 2, len(topology.Replicas))
+	assert.Equal(t, mr2.Addr(), ins.CmdOnSlave().Options().Addr)
+
+	t.Run("switch master", func(t *testing.T) {
+		d.master, d.replicas = mr2.Addr(), []string{mr1.Addr()}
+		ins.refresh(context.Background())
+		topology := ins.Topology()
+		assert.Equal(t, mr2.Addr(), topology.Master)
+		assert.Equal(t, 1, len(topology.Replicas))
+		assert.Equal(t, mr1.Addr(), ins.CmdOnSlave().Options().Addr)
+	})
+
+	t.Run("keep topology on discover error", func(t *testing.T) {
+		d.err = errors.New("sentinel down")
+		ins.refresh(context.Background())
+		assert.Equal(t, mr2.Addr(), ins.Topology().Master)
+		assert.Equal(t, 1, len(ins.Topology().Replicas))
+	})
+}
+
+func TestBuild_sentinel(t *testing.T) {
+	master := miniredis.RunT(t)
+	config := newTestConfig("test.sentinel.build")
+	config.Sentinel.MasterName = "mymaster"
+	config.Sentinel.Addr = []string{deadAddr}
+
+	_, err := config.Build()
+	assert.NotNil(t, err)
+
+	t.Run("master-slave", func(t *testing.T) {
+		config := newTestConfig("test.master.build")
+		config.Master.Addr = master.Addr()
+		config.Slaves.Addr = []string{master.Addr()}
+		ins, err := config.Build()
+		assert.Nil(t, err)
+		defer ins.Close()
+
+		topologies := topologies()
+		assert.Equal(t, "master-slave", topologies[config.name].Mode)
+		assert.Equal(t, master.Addr(), topologies[config.name].Master)
+		assert.Nil(t, ins.CmdOnSlave().Ping(context.Background()).Err())
+	})
+}
@@ -0,0 +1,111 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// discoverer 返回当前的主节点及可用的从节点地址
+type discoverer interface {
+	discover(ctx context.Context) (master string, replicas []string, err error)
+	close()
+}
+
+// sentinelDiscoverer 通过哨兵发现主从节点，依次尝试配置的哨兵直到成功
+type sentinelDiscoverer struct {
+	masterName string
+	sentinels  []*redis.SentinelClient
+}
+
+func newSentinelDiscoverer(config *Config) *sentinelDiscoverer {
+	d := &sentinelDiscoverer{masterName: config.Sentinel.MasterName}
+	for _, addr := range config.Sentinel.Addr {
+		d.sentinels = append(d.sentinels, redis.NewSentinelClient(&redis.Options{
+			Addr:         addr,
+			Username:     config.Sentinel.Username,
+			Password:     config.Sentinel.Password,
+			DialTimeout:  config.DialTimeout,
+			ReadTimeout:  config.ReadTimeout,
+			WriteTimeout: config.WriteTimeout,
+			PoolSize:     1,
+		}))
+	}
+	return d
+}
+
+func (d *sentinelDiscoverer) discover(ctx context.Context) (string, []string, error) {
+	lastErr := errors.New("redis: no sentinel for " + d.masterName)
+	for _, sentinel := range d.sentinels {
+		addr, err := sentinel.GetMasterAddrByName(ctx, d.masterName).Result()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		slaves, err := sentinel.Slaves(ctx, d.masterName).Result()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return net.JoinHostPort(addr[0], addr[1]), parseReplicaAddrs(slaves), nil
+	}
+	return "", nil, lastErr
+}
+
+func (d *sentinelDiscoverer) close() {
+	for _, sentinel := range d.sentinels {
+		_ = sentinel.Close()
+	}
+}
+
+// parseReplicaAddrs 解析SENTINEL SLAVES的返回，跳过下线及与主节点断开连接的节点
+func parseReplicaAddrs(slaves []interface{}) []string {
+	addrs := make([]string, 0, len(slaves))
+	for _, slave := range slaves {
+		fields, ok := slave.([]interface{})
+		if !ok {
+			continue
+		}
+
+		var ip, port string
+		var down bool
+		for i := 0; i+1 < len(fields); i += 2 {
+			key, _ := fields[i].(string)
+			value, _ := fields[i+1].(string)
+			switch key {
+			case "ip":
+				ip = value
+			case "port":
+				port = value
+			case "flags":
+				for _, flag := range strings.Split(value, ",") {
+					switch flag {
+					case "s_down", "o_down", "disconnected":
+						down = true
+					}
+				}
+			}
+		}
+		if ip != "" && port != "" && !down {
+			addrs = append(addrs, net.JoinHostPort(ip, port))
+		}
+	}
+	return addrs
+}
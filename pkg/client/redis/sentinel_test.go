@@ -0,0 +1,32 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseReplicaAddrs(t *testing.T) {
+	slaves := []interface{}{
+		[]interface{}{"name", "10.0.0.2:6379", "ip", "10.0.0.2", "port", "6379", "flags", "slave"},
+		[]interface{}{"name", "10.0.0.3:6379", "ip", "10.0.0.3", "port", "6379", "flags", "s_down,slave"},
+		[]interface{}{"name", "10.0.0.4:6379", "ip", "10.0.0.4", "port", "6379", "flags", "slave,disconnected"},
+		[]interface{}{"ip", "10.0.0.5"},
+		"invalid",
+	}
+	assert.Equal(t, []string{"10.0.0.2:6379"}, parseReplicaAddrs(slaves))
+}
@@ -0,0 +1,327 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resty
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+	"github.com/zhengyansheng/jupiter/pkg/conf"
+	"github.com/zhengyansheng/jupiter/pkg/registry"
+	_ "github.com/zhengyansheng/jupiter/pkg/registry/etcdv3" // etcd is the default registry
+	"github.com/zhengyansheng/jupiter/pkg/server"
+	"github.com/zhengyansheng/jupiter/pkg/util/xgo"
+	"github.com/zhengyansheng/jupiter/pkg/xlog"
+	"go.uber.org/zap"
+)
+
+const (
+	// BalancerRoundRobin ...
+	BalancerRoundRobin = "round_robin"
+	// BalancerP2C power of two choices, picks the node with less inflight requests
+	BalancerP2C = "p2c"
+
+	initialWatchTimeout = time.Second
+	minRetryInterval    = time.Second
+	maxRetryInterval    = 30 * time.Second
+
+	// discoveryScheme 服务发现请求的scheme, client的http.Transport将该scheme的请求交给discovery处理
+	discoveryScheme = "discovery"
+)
+
+var errNoAvailableNode = errors.New("no available node")
+
+// parseTarget returns the registry kind and service name of addr in the form of
+// etcd:///svc-name, ok is false if addr is not a registry address
+func parseTarget(addr string) (scheme string, service string, ok bool) {
+	u, err := url.Parse(addr)
+	if err != nil || !lo.Contains(registry.Kinds(), u.Scheme) {
+		return "", "", false
+	}
+	service = strings.Trim(u.Path, "/")
+	return u.Scheme, service, service != ""
+}
+
+type node struct {
+	addr     string
+	info     server.ServiceInfo
+	inflight int64
+	// unix nano, the node is ejected until this time after request failed
+	ejectedUntil int64
+}
+
+func (n *node) healthy(now time.Time) bool {
+	return atomic.LoadInt64(&n.ejectedUntil) <= now.UnixNano()
+}
+
+// discovery resolves the nodes of service through registry, and picks a node for each request
+type discovery struct {
+	target   string
+	service  string
+	reg      registry.Registry
+	balancer string
+	ejection time.Duration
+	failover int
+	base     http.RoundTripper
+	logger   *zap.Logger
+	ctx      context.Context
+	cancel   context.CancelFunc
+	mu       sync.RWMutex
+	nodes    []*node
+	next     uint64
+}
+
+// newDiscovery base为client原有的transport, 选择节点后由base发送请求
+func newDiscovery(config *Config, scheme, service string, base http.RoundTripper) (*discovery, error) {
+	// read the kind from registry config, the scheme is used if kind is not configured
+	kind := cast.ToString(cast.ToStringMap(conf.Get(config.RegistryConfig))["kind"])
+	if kind == "" {
+		kind = scheme
+	}
+
+	reg, err := registry.Singleton(kind, config.RegistryConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &discovery{
+		target:   config.Addr,
+		service:  service,
+		reg:      reg,
+		balancer: config.Balancer,
+		ejection: config.EjectionTime,
+		failover: config.FailoverCount,
+		base:     base,
+		logger:   config.logger.With(xlog.FieldName(config.Addr)),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	if err := d.watch(ctx); err != nil {
+		cancel()
+		return nil, err
+	}
+	return d, nil
+}
+
+// close stops watching the nodes of service
+func (d *discovery) close() {
+	d.cancel()
+}
+
+// prefix returns the prefix of service in registry, eg: http:svc-name:v1:live/
+func (d *discovery) prefix() string {
+	if strings.Contains(d.service, ":") {
+		return strings.TrimSuffix(d.service, "/") + "/"
+	}
+	info := server.ServiceInfo{Scheme: "http", Name: d.service}
+	return info.ServicePrefix()
+}
+
+// watch watches the nodes of service, the first watch fails fast and the
+// following watches are retried with backoff
+func (d *discovery) watch(ctx context.Context) error {
+	endpoints, err := d.reg.WatchServices(ctx, d.prefix())
+	if err != nil {
+		return err
+	}
+
+	// 等待首次结果, 避免构建后的第一个请求没有可用节点
+	select {
+	case eps, ok := <-endpoints:
+		if ok {
+			d.update(eps)
+		}
+	case <-time.After(initialWatchTimeout):
+	}
+
+	xgo.Go(func() {
+		interval := minRetryInterval
+		for {
+			for eps := range endpoints {
+				d.update(eps)
+				interval = minRetryInterval
+			}
+			// 注册中心异常时保留已知节点, 重新watch
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(interval):
+				}
+				if endpoints, err = d.reg.WatchServices(ctx, d.prefix()); err == nil {
+					break
+				}
+				d.logger.Error("watch services failed", xlog.FieldErr(err), zap.Duration("retry", interval))
+				if interval *= 2; interval > maxRetryInterval {
+					interval = maxRetryInterval
+				}
+			}
+		}
+	})
+	return nil
+}
+
+func (d *discovery) update(endpoints registry.Endpoints) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// keep the state of existing nodes
+	existing := make(map[string]*node, len(d.nodes))
+	for _, n := range d.nodes {
+		existing[n.addr] = n
+	}
+
+	nodes := make([]*node, 0, len(endpoints.Nodes))
+	for addr, info := range endpoints.Nodes {
+		// 被禁用的节点不再接收请求
+		if !info.Enable {
+			continue
+		}
+		n, ok := existing[addr]
+		if !ok {
+			n = &node{addr: addr}
+		}
+		n.info = info
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].addr < nodes[j].addr
+	})
+	d.nodes = nodes
+}
+
+// pick picks a healthy node which is not tried, and falls back to unhealthy nodes
+func (d *discovery) pick(tried map[string]struct{}) (*node, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	now := time.Now()
+	candidates := make([]*node, 0, len(d.nodes))
+	var fallback []*node
+	for _, n := range d.nodes {
+		if _, ok := tried[n.addr]; ok {
+			continue
+		}
+		if n.healthy(now) {
+			candidates = append(candidates, n)
+		} else {
+			fallback = append(fallback, n)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = fallback
+	}
+
+	switch len(candidates) {
+	case 0:
+		return nil, fmt.Errorf("%w: %s", errNoAvailableNode, d.target)
+	case 1:
+		return candidates[0], nil
+	}
+
+	if d.balancer == BalancerP2C {
+		i := rand.Intn(len(candidates))
+		j := rand.Intn(len(candidates) - 1)
+		if j >= i {
+			j++
+		}
+		a, b := candidates[i], candidates[j]
+		if atomic.LoadInt64(&b.inflight) < atomic.LoadInt64(&a.inflight) {
+			return b, nil
+		}
+		return a, nil
+	}
+	return candidates[atomic.AddUint64(&d.next, 1)%uint64(len(candidates))], nil
+}
+
+// RoundTrip sends request to the picked node, and retries on another node if the node is unhealthy
+func (d *discovery) RoundTrip(req *http.Request) (*http.Response, error) {
+	var tried = make(map[string]struct{})
+	var lastErr error
+	for attempt := 0; attempt <= d.failover; attempt++ {
+		n, err := d.pick(tried)
+		if err != nil {
+			if lastErr != nil {
+				return nil, lastErr
+			}
+			return nil, err
+		}
+		tried[n.addr] = struct{}{}
+
+		r := req.Clone(req.Context())
+		r.URL.Scheme = "http"
+		if n.info.Scheme == "https" {
+			r.URL.Scheme = n.info.Scheme
+		}
+		r.URL.Host = n.addr
+		r.Host = ""
+		if attempt > 0 && req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return nil, lastErr
+			}
+			if r.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+
+		atomic.AddInt64(&n.inflight, 1)
+		resp, err := d.base.RoundTrip(r)
+		atomic.AddInt64(&n.inflight, -1)
+		if err == nil {
+			return resp, nil
+		}
+
+		// 调用方取消或超时, 不是节点异常
+		if req.Context().Err() != nil {
+			return nil, err
+		}
+		atomic.StoreInt64(&n.ejectedUntil, time.Now().Add(d.ejection).UnixNano())
+		d.logger.Warn("node unhealthy", xlog.FieldAddr(n.addr), xlog.FieldErr(err))
+		lastErr = err
+
+		// 非幂等请求只有在连接失败时才能重试
+		if !isIdempotent(req.Method) && !isDialError(err) {
+			return nil, err
+		}
+	}
+	return nil, lastErr
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
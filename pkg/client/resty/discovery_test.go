@@ -0,0 +1,243 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resty
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zhengyansheng/jupiter/pkg"
+	"github.com/zhengyansheng/jupiter/pkg/conf"
+	"github.com/zhengyansheng/jupiter/pkg/registry"
+	"github.com/zhengyansheng/jupiter/pkg/server"
+)
+
+// fakeRegistry returns the watch channel set by test
+type fakeRegistry struct {
+	registry.Registry
+	prefix  chan string
+	watches chan chan registry.Endpoints
+	// ctx of the last watch
+	ctx atomic.Value
+}
+
+var testRegistry = &fakeRegistry{
+	prefix:  make(chan string, 10),
+	watches: make(chan chan registry.Endpoints, 10),
+}
+
+func init() {
+	registry.RegisterBuilder("resty-fake", func(string) (registry.Registry, error) {
+		return testRegistry, nil
+	})
+}
+
+func (reg *fakeRegistry) WatchServices(ctx context.Context, prefix string) (chan registry.Endpoints, error) {
+	reg.prefix <- prefix
+	reg.ctx.Store(ctx)
+	return <-reg.watches, nil
+}
+
+func newEndpoints(addrs ...string) registry.Endpoints {
+	endpoints := registry.NewEndpoints()
+	for _, addr := range addrs {
+		endpoints.Nodes[addr] = server.ServiceInfo{Address: addr, Enable: true}
+	}
+	return *endpoints
+}
+
+func newTestServer(name string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = w.Write(append([]byte(name+":"), body...))
+	}))
+}
+
+func newTestDiscoveryClient(t *testing.T, addr string, nodes ...string) (*Client, chan registry.Endpoints) {
+	watch := make(chan registry.Endpoints, 10)
+	watch <- newEndpoints(nodes...)
+	testRegistry.watches <- watch
+
+	config := DefaultConfig()
+	config.Addr = addr
+	config.EnableSentinel = false
+	config.EjectionTime = time.Minute
+	client, err := config.Build()
+	require.NoError(t, err)
+	return client, watch
+}
+
+func Test_parseTarget(t *testing.T) {
+	scheme, service, ok := parseTarget("resty-fake:///svc-name")
+	assert.True(t, ok)
+	assert.Equal(t, "resty-fake", scheme)
+	assert.Equal(t, "svc-name", service)
+
+	_, _, ok = parseTarget("http://localhost:8001")
+	assert.False(t, ok)
+	_, _, ok = parseTarget("resty-fake:///")
+	assert.False(t, ok)
+}
+
+func TestDiscovery(t *testing.T) {
+	s1, s2 := newTestServer("s1"), newTestServer("s2")
+	defer s1.Close()
+	defer s2.Close()
+	addr1, addr2 := s1.Listener.Addr().String(), s2.Listener.Addr().String()
+
+	// 没有服务监听的地址
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	down := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	t.Run("round robin", func(t *testing.T) {
+		client, _ := newTestDiscoveryClient(t, "resty-fake:///svc-name", addr1, addr2)
+		assert.Equal(t, "http:svc-name:v1:"+pkg.AppMode()+"/", <-testRegistry.prefix)
+
+		got := map[string]int{}
+		for i := 0; i < 10; i++ {
+			res, err := client.R().Get("/")
+			require.NoError(t, err)
+			got[res.String()]++
+		}
+		assert.Equal(t, map[string]int{"s1:": 5, "s2:": 5}, got)
+	})
+
+	t.Run("failover", func(t *testing.T) {
+		client, _ := newTestDiscoveryClient(t, "resty-fake:///http:svc-name:v1:live", down, addr1)
+		assert.Equal(t, "http:svc-name:v1:live/", <-testRegistry.prefix)
+
+		for i := 0; i < 4; i++ {
+			res, err := client.R().SetBody(bytes.NewBufferString("hello")).Post("/")
+			require.NoError(t, err)
+			assert.Equal(t, "s1:hello", res.String())
+		}
+	})
+
+	t.Run("update nodes", func(t *testing.T) {
+		client, watch := newTestDiscoveryClient(t, "resty-fake:///svc-name", addr1)
+		<-testRegistry.prefix
+
+		res, err := client.R().Get("/")
+		require.NoError(t, err)
+		assert.Equal(t, "s1:", res.String())
+
+		watch <- newEndpoints(addr2)
+		assert.Eventually(t, func() bool {
+			res, err := client.R().Get("/")
+			return err == nil && res.String() == "s2:"
+		}, time.Second, 10*time.Millisecond)
+
+		watch <- newEndpoints()
+		assert.Eventually(t, func() bool {
+			_, err := client.R().Get("/")
+			return err != nil && strings.Contains(err.Error(), errNoAvailableNode.Error())
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("disabled node", func(t *testing.T) {
+		watch := make(chan registry.Endpoints, 10)
+		endpoints := newEndpoints(addr1, addr2)
+		endpoints.Nodes[addr1] = server.ServiceInfo{Address: addr1, Enable: false}
+		watch <- endpoints
+		testRegistry.watches <- watch
+
+		config := DefaultConfig()
+		config.Addr = "resty-fake:///svc-name"
+		config.EnableSentinel = false
+		client, err := config.Build()
+		require.NoError(t, err)
+		<-testRegistry.prefix
+
+		for i := 0; i < 4; i++ {
+			res, err := client.R().Get("/")
+			require.NoError(t, err)
+			assert.Equal(t, "s2:", res.String())
+		}
+	})
+
+	t.Run("keep transport", func(t *testing.T) {
+		client, _ := newTestDiscoveryClient(t, "resty-fake:///svc-name", addr1)
+		<-testRegistry.prefix
+
+		// client的transport仍然是http.Transport, TLS等设置可以正常修改
+		client.SetTLSClientConfig(&tls.Config{MinVersion: tls.VersionTLS12})
+		transport, ok := client.GetClient().Transport.(*http.Transport)
+		require.True(t, ok)
+		assert.Equal(t, uint16(tls.VersionTLS12), transport.TLSClientConfig.MinVersion)
+
+		res, err := client.R().Get("/")
+		require.NoError(t, err)
+		assert.Equal(t, "s1:", res.String())
+	})
+
+	t.Run("stop watch", func(t *testing.T) {
+		client, _ := newTestDiscoveryClient(t, "resty-fake:///svc-name", addr1)
+		<-testRegistry.prefix
+		ctx := testRegistry.ctx.Load().(context.Context)
+		assert.Nil(t, ctx.Err())
+
+		res, err := client.R().Get("/")
+		require.NoError(t, err)
+		assert.Equal(t, "s1:", res.String())
+
+		// client被回收后停止watch
+		client = nil
+		assert.Eventually(t, func() bool {
+			runtime.GC()
+			return ctx.Err() != nil
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("p2c", func(t *testing.T) {
+		watch := make(chan registry.Endpoints, 10)
+		watch <- newEndpoints(addr1, addr2)
+		testRegistry.watches <- watch
+
+		assert.NoError(t, conf.LoadFromReader(bytes.NewBufferString(`
+[jupiter.resty.p2c]
+	addr = "resty-fake:///svc-name"
+	balancer = "p2c"
+	enableSentinel = false
+`), toml.Unmarshal))
+		config := StdConfig("p2c")
+		assert.Equal(t, BalancerP2C, config.Balancer)
+		client, err := config.Build()
+		require.NoError(t, err)
+		<-testRegistry.prefix
+
+		got := map[string]int{}
+		for i := 0; i < 10; i++ {
+			res, err := client.R().Get("/")
+			require.NoError(t, err)
+			got[res.String()]++
+		}
+		assert.Equal(t, 10, got["s1:"]+got["s2:"])
+	})
+}
@@ -15,9 +15,9 @@
 package resty
 
 import (
-	"context"
 	"errors"
 	"net/http"
+	"runtime"
 	"time"
 
 	"github.com/alibaba/sentinel-golang/api"
@@ -28,11 +28,15 @@ import (
 	"github.com/zhengyansheng/jupiter/pkg/conf"
 	"github.com/zhengyansheng/jupiter/pkg/core/constant"
 	"github.com/zhengyansheng/jupiter/pkg/core/ecode"
+	"github.com/zhengyansheng/jupiter/pkg/core/metric"
 	"github.com/zhengyansheng/jupiter/pkg/core/sentinel"
 	"github.com/zhengyansheng/jupiter/pkg/core/singleton"
+	"github.com/zhengyansheng/jupiter/pkg/core/xtrace"
+	"github.com/zhengyansheng/jupiter/pkg/registry"
 	"github.com/zhengyansheng/jupiter/pkg/util/xdebug"
 	"github.com/zhengyansheng/jupiter/pkg/xlog"
 	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
 	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
 	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
@@ -59,8 +63,16 @@ type (
 		RetryWaitTime time.Duration `json:"retryWaitTime" toml:"retryWaitTime"` // 重试间隔时间
 		// 失败重试的最贱等待时间
 		RetryMaxWaitTime time.Duration `json:"retryMaxWaitTime" toml:"retryMaxWaitTime"` // 重试最大间隔时间
-		// 目标服务地址
+		// 目标服务地址, 也可以是注册中心地址, 如 etcd:///svc-name
 		Addr string `json:"addr" toml:"addr"` // 目标地址
+		// 注册中心配置, Addr 为注册中心地址时生效
+		RegistryConfig string `json:"registryConfig" toml:"registryConfig"`
+		// 负载均衡算法: round_robin, p2c
+		Balancer string `json:"balancer" toml:"balancer"`
+		// 节点异常时切换到其他节点重试的次数
+		FailoverCount int `json:"failoverCount" toml:"failoverCount"`
+		// 节点异常后的摘除时长
+		EjectionTime time.Duration `json:"ejectionTime" toml:"ejectionTime"`
 		// 请求超时时间
 		Timeout time.Duration `json:"timeout" toml:"timeout" `
 		// 收到响应以后是否立即关闭连接
@@ -110,6 +122,10 @@ func DefaultConfig() *Config {
 		RetryWaitTime:    cast.ToDuration("100ms"),
 		RetryMaxWaitTime: cast.ToDuration("100ms"),
 		Addr:             "",
+		RegistryConfig:   constant.ConfigKey("registry.default"),
+		Balancer:         BalancerRoundRobin,
+		FailoverCount:    1,
+		EjectionTime:     cast.ToDuration("10s"),
 		SlowThreshold:    cast.ToDuration("500ms"),
 		Timeout:          cast.ToDuration("3000ms"),
 		EnableAccessLog:  false,
@@ -125,7 +141,27 @@ func (config *Config) Build() (*resty.Client, error) {
 	}
 
 	client := resty.New()
+	tracer := xtrace.NewTracer(trace.SpanKindClient)
 	client.SetBaseURL(config.Addr)
+
+	// 通过注册中心发现服务节点, 请求时由discovery选择节点
+	if scheme, service, ok := parseTarget(config.Addr); ok {
+		// 保留client的http.Transport, SetTLSClientConfig、SetProxy等设置对发往节点的请求仍然生效
+		transport, ok := client.GetClient().Transport.(*http.Transport)
+		if !ok {
+			return nil, errors.New("resty transport is not an *http.Transport")
+		}
+		d, err := newDiscovery(config, scheme, service, transport)
+		if err != nil {
+			config.logger.Error("build discovery failed", xlog.FieldErr(err), xlog.FieldAddr(config.Addr))
+			return nil, err
+		}
+		transport.RegisterProtocol(discoveryScheme, d)
+		client.SetBaseURL(discoveryScheme + "://" + registry.ParseServiceKey(service).Name)
+		// client被回收时停止watch
+		runtime.SetFinalizer(client, func(*resty.Client) { d.close() })
+	}
+	baseURL := client.BaseURL
 	client.SetTimeout(config.Timeout)
 	client.SetDebug(config.Debug)
 	client.SetRetryCount(config.RetryCount)
@@ -150,7 +186,8 @@ func (config *Config) Build() (*resty.Client, error) {
 			span.End()
 		}
 		if config.EnableMetric {
-			metric.ClientHandleCounter.WithLabelValues(metric.TypeHTTP, "resty", r.Method, r.RawRequest.Host, "error").Inc()
+			// peer统一使用BaseURL, 与OnAfterResponse保持一致, 出错时RawRequest可能为nil
+			metric.ClientHandleCounter.WithLabelValues(metric.TypeHTTP, "resty", r.Method, baseURL, "error").Inc()
 		}
 
 		if config.EnableSentinel {
@@ -163,8 +200,15 @@ func (config *Config) Build() (*resty.Client, error) {
 
 	client.OnBeforeRequest(func(c *resty.Client, r *resty.Request) error {
 		if config.EnableTrace {
-
-			r.SetContext(context.TODO())
+			// span is ended in OnAfterResponse or OnError
+			ctx, _ := tracer.Start(r.Context(), r.Method, propagation.HeaderCarrier(r.Header),
+				trace.WithAttributes(
+					semconv.HTTPMethodKey.String(r.Method),
+					semconv.HTTPURLKey.String(r.URL),
+					semconv.PeerServiceKey.String(config.Name),
+				),
+			)
+			r.SetContext(ctx)
 		}
 
 		if config.EnableSentinel {
@@ -187,8 +231,8 @@ func (config *Config) Build() (*resty.Client, error) {
 		cost := r.Time()
 
 		if config.EnableMetric {
-			metric.ClientHandleCounter.WithLabelValues(metric.TypeHTTP, "resty", r.Request.Method, c.HostURL, r.Status()).Inc()
-			metric.ClientHandleHistogram.WithLabelValues(metric.TypeHTTP, "resty", r.Request.Method, c.HostURL).Observe(cost.Seconds())
+			metric.ClientHandleCounter.WithLabelValues(metric.TypeHTTP, "resty", r.Request.Method, c.BaseURL, r.Status()).Inc()
+			metric.ClientHandleHistogram.WithLabelValues(metric.TypeHTTP, "resty", r.Request.Method, c.BaseURL).Observe(cost.Seconds())
 		}
 
 		if config.EnableTrace {
@@ -0,0 +1,100 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rocketmq
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/apache/rocketmq-client-go/v2/primitive"
+	"github.com/zhengyansheng/jupiter/pkg/core/metric"
+	"github.com/zhengyansheng/jupiter/pkg/xlog"
+)
+
+// 死信消息的属性
+const (
+	PropertyDeadLetterReason         = "DLQ_REASON"
+	PropertyDeadLetterOriginTopic    = "DLQ_ORIGIN_TOPIC"
+	PropertyDeadLetterOriginMsgID    = "DLQ_ORIGIN_MSG_ID"
+	PropertyDeadLetterReconsumeTimes = "DLQ_RECONSUME_TIMES"
+)
+
+// DeadLetterSink 接收重试次数耗尽仍然消费失败的消息
+type DeadLetterSink interface {
+	Put(ctx context.Context, msg *primitive.MessageExt, reason error) error
+}
+
+// NewTopicDeadLetterSink 将死信消息及失败原因发送到指定的topic
+func NewTopicDeadLetterSink(producer *Producer, topic string) DeadLetterSink {
+	return &topicDeadLetterSink{producer: producer, topic: topic}
+}
+
+type topicDeadLetterSink struct {
+	producer *Producer
+	topic    string
+}
+
+// Put ...
+func (s *topicDeadLetterSink) Put(ctx context.Context, msg *primitive.MessageExt, reason error) error {
+	m := primitive.NewMessage(s.topic, msg.Body)
+	if tags := msg.GetTags(); tags != "" {
+		m.WithTag(tags)
+	}
+	if keys := strings.Fields(msg.GetKeys()); len(keys) > 0 {
+		m.WithKeys(keys)
+	}
+	m.WithProperty(PropertyDeadLetterReason, reason.Error())
+	m.WithProperty(PropertyDeadLetterOriginTopic, msg.Topic)
+	m.WithProperty(PropertyDeadLetterOriginMsgID, msg.MsgId)
+	m.WithProperty(PropertyDeadLetterReconsumeTimes, strconv.Itoa(int(msg.ReconsumeTimes)))
+
+	_, err := s.producer.SendMsg(ctx, m)
+	return err
+}
+
+// WithDeadLetterSink 设置死信消息的处理，优先于deadLetterTopic配置
+func (cc *PushConsumer) WithDeadLetterSink(sink DeadLetterSink) *PushConsumer {
+	cc.deadLetter = sink
+	return cc
+}
+
+// exhausted 返回消息的重试次数是否已经耗尽，再次失败后broker会将消息投递到%DLQ%
+func (cc *PushConsumer) exhausted(msg *primitive.MessageExt) bool {
+	return cc.Reconsume >= 0 && msg.ReconsumeTimes >= cc.Reconsume
+}
+
+// putDeadLetters 将重试次数耗尽的消息投递到死信，全部投递成功时返回nil，消息不再重试
+func (cc *PushConsumer) putDeadLetters(ctx context.Context, reason error, msgs ...*primitive.MessageExt) error {
+	if cc.deadLetter == nil {
+		return reason
+	}
+	for _, msg := range msgs {
+		if !cc.exhausted(msg) {
+			return reason
+		}
+	}
+
+	for _, msg := range msgs {
+		if err := cc.deadLetter.Put(ctx, msg, reason); err != nil {
+			metric.ClientHandleCounter.Inc(metric.TypeRocketMQ, msg.Topic, "deadLetter", msg.StoreHost, "error")
+			xlog.Jupiter().Error("put dead letter", xlog.FieldErr(err), xlog.String("reason", reason.Error()), xlog.Any("ext", msg))
+			return reason
+		}
+		metric.ClientHandleCounter.Inc(metric.TypeRocketMQ, msg.Topic, "deadLetter", msg.StoreHost, "success")
+		xlog.Jupiter().Warn("put dead letter", xlog.String("reason", reason.Error()), xlog.Any("ext", msg))
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rocketmq
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/apache/rocketmq-client-go/v2/consumer"
+	"github.com/apache/rocketmq-client-go/v2/primitive"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhengyansheng/jupiter/pkg/core/xtrace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type fakeDeadLetterSink struct {
+	err     error
+	msgs    []*primitive.MessageExt
+	reasons []error
+}
+
+func (s *fakeDeadLetterSink) Put(ctx context.Context, msg *primitive.MessageExt, reason error) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.msgs = append(s.msgs, msg)
+	s.reasons = append(s.reasons, reason)
+	return nil
+}
+
+func newTestPushConsumer() *PushConsumer {
+	config := DefaultConfig().PushConsumer
+	config.Topic = "order"
+	config.Group = "order_group"
+	config.Reconsume = 2
+	return &PushConsumer{
+		PushConsumerConfig: *config,
+		subscribers:        make(map[string]subscription),
+		tracer:             xtrace.NewTracer(trace.SpanKindConsumer),
+	}
+}
+
+func TestPushConsumer_deadLetter(t *testing.T) {
+	sink := &fakeDeadLetterSink{}
+	cc := newTestPushConsumer().WithDeadLetterSink(sink)
+	errConsume := errors.New("consume failed")
+	fn := cc.singleMessageHandler(func(ctx context.Context, msg *primitive.MessageExt) error {
+		return errConsume
+	})
+
+	msg := newMessageExt("paid")
+	msg.ReconsumeTimes = 1
+	result, err := fn(context.Background(), msg)
+	assert.Equal(t, errConsume, err)
+	assert.Equal(t, consumer.ConsumeRetryLater, result)
+	assert.Empty(t, sink.msgs)
+
+	msg.ReconsumeTimes = 2
+	result, err = fn(context.Background(), msg)
+	assert.Nil(t, err)
+	assert.Equal(t, consumer.ConsumeSuccess, result)
+	assert.Equal(t, []*primitive.MessageExt{msg}, sink.msgs)
+	assert.Equal(t, []error{errConsume}, sink.reasons)
+
+	sink.err = errors.New("sink failed")
+	result, err = fn(context.Background(), msg)
+	assert.Equal(t, errConsume, err)
+	assert.Equal(t, consumer.ConsumeRetryLater, result)
+}
+
+func TestPushConsumer_deadLetterBatch(t *testing.T) {
+	sink := &fakeDeadLetterSink{}
+	cc := newTestPushConsumer().WithDeadLetterSink(sink)
+	cc.RegisterBatchMessage(func(ctx context.Context, msgs ...*primitive.MessageExt) error {
+		panic("consume failed")
+	})
+	fn := cc.subscribers[cc.Topic].fn
+
+	exhausted, retrying := newMessageExt("paid"), newMessageExt("paid")
+	exhausted.ReconsumeTimes, retrying.ReconsumeTimes = 2, 1
+	result, err := fn(context.Background(), exhausted, retrying)
+	assert.NotNil(t, err)
+	assert.Equal(t, consumer.ConsumeRetryLater, result)
+	assert.Empty(t, sink.msgs)
+
+	retrying.ReconsumeTimes = 2
+	result, err = fn(context.Background(), exhausted, retrying)
+	assert.Nil(t, err)
+	assert.Equal(t, consumer.ConsumeSuccess, result)
+	assert.Len(t, sink.msgs, 2)
+	assert.EqualError(t, sink.reasons[0], "consumer message panic: consume failed")
+}
+
+func TestPushConsumer_idempotency(t *testing.T) {
+	cc := newTestPushConsumer()
+	cc.idempotency = newIdempotencyGuard(cc.Group, IdempotencyConfig{Enable: true})
+
+	var consumed []string
+	fail := true
+	fn := cc.singleMessageHandler(func(ctx context.Context, msg *primitive.MessageExt) error {
+		consumed = append(consumed, msg.GetKeys())
+		if fail {
+			return errors.New("consume failed")
+		}
+		return nil
+	})
+
+	// 消费失败后重试的消息需要重新消费
+	_, err := fn(context.Background(), newMessageExt("paid", "order-1"))
+	assert.NotNil(t, err)
+	fail = false
+	result, err := fn(context.Background(), newMessageExt("paid", "order-1"))
+	assert.Nil(t, err)
+	assert.Equal(t, consumer.ConsumeSuccess, result)
+
+	// 消费成功后重复投递的消息被跳过
+	result, err = fn(context.Background(), newMessageExt("paid", "order-1"))
+	assert.Nil(t, err)
+	assert.Equal(t, consumer.ConsumeSuccess, result)
+	assert.Equal(t, []string{"order-1", "order-1"}, consumed)
+
+	_, _ = fn(context.Background(), newMessageExt("paid", "order-2"))
+	assert.Equal(t, []string{"order-1", "order-1", "order-2"}, consumed)
+}
+
+func TestPushConsumer_idempotencyAcquiredNeverReleased(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := goredis.NewClient(&goredis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	cc := newTestPushConsumer()
+	cc.idempotency = &idempotencyGuard{
+		store:         NewRedisIdempotencyStore(client),
+		group:         cc.Group,
+		ttl:           time.Hour,
+		processingTTL: time.Minute,
+	}
+
+	// 模拟消费过程中进程退出：写入了消费中标记，但没有完成也没有释放
+	ctx := context.Background()
+	_, ok, err := cc.idempotency.acquire(ctx, newMessageExt("paid", "order-1"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	var consumed []string
+	fn := cc.singleMessageHandler(func(ctx context.Context, msg *primitive.MessageExt) error {
+		consumed = append(consumed, msg.GetKeys())
+		return nil
+	})
+
+	// 消费中标记未过期时重新投递的消息稍后重试，不会被当作重复消息确认
+	result, err := fn(ctx, newMessageExt("paid", "order-1"))
+	assert.ErrorIs(t, err, errMessageProcessing)
+	assert.Equal(t, consumer.ConsumeRetryLater, result)
+	assert.Empty(t, consumed)
+
+	// 消费中标记过期后消息重新消费
+	mr.FastForward(time.Minute)
+	result, err = fn(ctx, newMessageExt("paid", "order-1"))
+	assert.Nil(t, err)
+	assert.Equal(t, consumer.ConsumeSuccess, result)
+	assert.Equal(t, []string{"order-1"}, consumed)
+
+	// 消费完成的标记使用完整的ttl
+	mr.FastForward(time.Minute)
+	result, err = fn(ctx, newMessageExt("paid", "order-1"))
+	assert.Nil(t, err)
+	assert.Equal(t, consumer.ConsumeSuccess, result)
+	assert.Equal(t, []string{"order-1"}, consumed)
+	assert.Equal(t, time.Hour-time.Minute, mr.TTL("rocketmq:idempotency:"+cc.Group+":test:order-1"))
+}
+
+func Test_idempotencyGuard_key(t *testing.T) {
+	guard := &idempotencyGuard{group: "group", by: IdempotencyByKey}
+	msg := newMessageExt("paid", "order-1", "user-1")
+	msg.MsgId = "msg-1"
+	assert.Equal(t, "rocketmq:idempotency:group:test:order-1", guard.key(msg))
+
+	guard.by = IdempotencyByMsgID
+	assert.Equal(t, "rocketmq:idempotency:group:test:msg-1", guard.key(msg))
+
+	msg.WithProperty(primitive.PropertyUniqueClientMessageIdKeyIndex, "uniq-1")
+	assert.Equal(t, "rocketmq:idempotency:group:test:uniq-1", guard.key(msg))
+}
+
+func Test_localIdempotencyStore(t *testing.T) {
+	store := NewLocalIdempotencyStore(1024 * 1024)
+	ctx := context.Background()
+
+	ok, err := store.SetNX(ctx, "key", time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, _ = store.SetNX(ctx, "key", time.Minute)
+	assert.False(t, ok)
+
+	assert.Nil(t, store.Del(ctx, "key"))
+	ok, _ = store.SetNX(ctx, "key", time.Minute)
+	assert.True(t, ok)
+
+	acquired, done, err := store.Lease(ctx, "lease", time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, acquired)
+	acquired, done, _ = store.Lease(ctx, "lease", time.Minute)
+	assert.False(t, acquired)
+	assert.False(t, done)
+
+	assert.Nil(t, store.Done(ctx, "lease", time.Hour))
+	acquired, done, _ = store.Lease(ctx, "lease", time.Minute)
+	assert.False(t, acquired)
+	assert.True(t, done)
+}
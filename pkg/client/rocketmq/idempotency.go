@@ -0,0 +1,240 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rocketmq
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/apache/rocketmq-client-go/v2/primitive"
+	"github.com/coocood/freecache"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/zhengyansheng/jupiter/pkg/client/redis"
+	"github.com/zhengyansheng/jupiter/pkg/core/metric"
+	"github.com/zhengyansheng/jupiter/pkg/xlog"
+)
+
+const (
+	// IdempotencyByKey 按照消息的第一个key去重，没有key时使用msgId
+	IdempotencyByKey = "key"
+	// IdempotencyByMsgID 按照消息的msgId去重
+	IdempotencyByMsgID = "msgId"
+
+	idempotencyKeyPrefix = "rocketmq:idempotency:"
+
+	// 去重记录的值，消费完成的值与SetNX写入的值相同
+	markerProcessing = "processing"
+	markerDone       = "1"
+)
+
+// errMessageProcessing 相同的消息正在被消费，稍后重试
+var errMessageProcessing = errors.New("message is processing")
+
+// IdempotencyConfig 幂等消费配置，ttl内相同key或msgId的消息只消费一次
+type IdempotencyConfig struct {
+	Enable bool `json:"enable" toml:"enable"`
+	// 去重依据，key或msgId，默认为key
+	By string `json:"by" toml:"by"`
+	// 去重的有效期，默认为1h
+	TTL time.Duration `json:"ttl" toml:"ttl"`
+	// 消费中标记的有效期，需大于消息的消费耗时，消费过程中进程退出时超过该时间后消息可以重新消费，默认为1min
+	ProcessingTTL time.Duration `json:"processingTTL" toml:"processingTTL"`
+	// redis的配置名，对应jupiter.redis.{name}.stub，为空时使用本地缓存
+	Redis string `json:"redis" toml:"redis"`
+	// 本地缓存的大小，单位byte，默认为16MB
+	LocalCacheSize int `json:"localCacheSize" toml:"localCacheSize"`
+}
+
+// IdempotencyStore 记录已经消费的消息，消费前写入消费中标记，消费成功后标记为已完成
+type IdempotencyStore interface {
+	// SetNX key不存在时写入已完成标记并返回true，已存在时返回false
+	SetNX(ctx context.Context, key string, ttl time.Duration) (bool, error)
+	// Lease key不存在时写入消费中标记并返回true，已存在时返回false及是否已完成
+	Lease(ctx context.Context, key string, ttl time.Duration) (acquired bool, done bool, err error)
+	// Done 将key标记为已完成
+	Done(ctx context.Context, key string, ttl time.Duration) error
+	// Del 消费失败时删除key，使消息可以重新消费
+	Del(ctx context.Context, key string) error
+}
+
+// NewRedisIdempotencyStore 基于redis的IdempotencyStore，多个实例间共享去重记录
+func NewRedisIdempotencyStore(client goredis.Cmdable) IdempotencyStore {
+	return &redisIdempotencyStore{client: client}
+}
+
+type redisIdempotencyStore struct {
+	client goredis.Cmdable
+}
+
+// SetNX ...
+func (s *redisIdempotencyStore) SetNX(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return s.client.SetNX(ctx, key, markerDone, ttl).Result()
+}
+
+// Lease ...
+func (s *redisIdempotencyStore) Lease(ctx context.Context, key string, ttl time.Duration) (bool, bool, error) {
+	ok, err := s.client.SetNX(ctx, key, markerProcessing, ttl).Result()
+	if err != nil || ok {
+		return ok, false, err
+	}
+	marker, err := s.client.Get(ctx, key).Result()
+	if errors.Is(err, goredis.Nil) {
+		// 消费中标记恰好过期，按照消费中处理，稍后重试
+		return false, false, nil
+	}
+	return false, marker == markerDone, err
+}
+
+// Done ...
+func (s *redisIdempotencyStore) Done(ctx context.Context, key string, ttl time.Duration) error {
+	return s.client.Set(ctx, key, markerDone, ttl).Err()
+}
+
+// Del ...
+func (s *redisIdempotencyStore) Del(ctx context.Context, key string) error {
+	return s.client.Del(ctx, key).Err()
+}
+
+// NewLocalIdempotencyStore 基于本地缓存的IdempotencyStore，只能对同一个实例重复投递的消息去重
+func NewLocalIdempotencyStore(size int) IdempotencyStore {
+	return &localIdempotencyStore{cache: freecache.NewCache(size)}
+}
+
+type localIdempotencyStore struct {
+	cache *freecache.Cache
+}
+
+// SetNX ...
+func (s *localIdempotencyStore) SetNX(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	old, err := s.cache.GetOrSet([]byte(key), []byte(markerDone), expireSeconds(ttl))
+	return old == nil, err
+}
+
+// Lease ...
+func (s *localIdempotencyStore) Lease(ctx context.Context, key string, ttl time.Duration) (bool, bool, error) {
+	old, err := s.cache.GetOrSet([]byte(key), []byte(markerProcessing), expireSeconds(ttl))
+	return old == nil, string(old) == markerDone, err
+}
+
+// Done ...
+func (s *localIdempotencyStore) Done(ctx context.Context, key string, ttl time.Duration) error {
+	return s.cache.Set([]byte(key), []byte(markerDone), expireSeconds(ttl))
+}
+
+// expireSeconds freecache的失效时间单位为秒，不足1s按1s处理
+func expireSeconds(ttl time.Duration) int {
+	expire := int(ttl.Seconds())
+	if expire < 1 {
+		expire = 1
+	}
+	return expire
+}
+
+// Del ...
+func (s *localIdempotencyStore) Del(ctx context.Context, key string) error {
+	s.cache.Del([]byte(key))
+	return nil
+}
+
+type idempotencyGuard struct {
+	store         IdempotencyStore
+	group         string
+	by            string
+	ttl           time.Duration
+	processingTTL time.Duration
+}
+
+func newIdempotencyGuard(group string, config IdempotencyConfig) *idempotencyGuard {
+	if !config.Enable {
+		return nil
+	}
+	if config.TTL <= 0 {
+		config.TTL = time.Hour
+	}
+	if config.ProcessingTTL <= 0 {
+		config.ProcessingTTL = time.Minute
+	}
+	if config.LocalCacheSize <= 0 {
+		config.LocalCacheSize = 16 * 1024 * 1024
+	}
+
+	var store IdempotencyStore
+	if config.Redis != "" {
+		store = NewRedisIdempotencyStore(redis.StdConfig(config.Redis).MustSingleton().CmdOnMaster())
+	} else {
+		store = NewLocalIdempotencyStore(config.LocalCacheSize)
+	}
+	return &idempotencyGuard{store: store, group: group, by: config.By, ttl: config.TTL, processingTTL: config.ProcessingTTL}
+}
+
+// key 返回消息的去重key，不同的消费组分别去重
+func (g *idempotencyGuard) key(msg *primitive.MessageExt) string {
+	id := ""
+	if g.by != IdempotencyByMsgID {
+		if keys := strings.Fields(msg.GetKeys()); len(keys) > 0 {
+			id = keys[0]
+		}
+	}
+	if id == "" {
+		// 重试消息的MsgId会变化，UNIQ_KEY保持不变
+		id = msg.GetProperty(primitive.PropertyUniqueClientMessageIdKeyIndex)
+	}
+	if id == "" {
+		id = msg.MsgId
+	}
+	return idempotencyKeyPrefix + g.group + ":" + msg.Topic + ":" + id
+}
+
+// acquire 写入消费中标记并返回消息是否需要消费，已消费完成的消息跳过，
+// 正在被消费(或消费中进程退出且标记未过期)的消息返回errMessageProcessing，存储异常时放行消息
+func (g *idempotencyGuard) acquire(ctx context.Context, msg *primitive.MessageExt) (string, bool, error) {
+	key := g.key(msg)
+	acquired, done, err := g.store.Lease(ctx, key, g.processingTTL)
+	if err != nil {
+		xlog.Jupiter().Warn("idempotency guard", xlog.String("key", key), xlog.FieldErr(err))
+		return "", true, nil
+	}
+	if acquired {
+		return key, true, nil
+	}
+	if !done {
+		return "", false, errMessageProcessing
+	}
+	metric.ClientHandleCounter.Inc(metric.TypeRocketMQ, msg.Topic, "idempotency", msg.StoreHost, "duplicate")
+	xlog.Jupiter().Info("skip duplicated message", xlog.String("key", key), xlog.String("msgId", msg.MsgId))
+	return "", false, nil
+}
+
+// commit 消费成功后将消费中标记转为已完成，在ttl内跳过重复的消息
+func (g *idempotencyGuard) commit(ctx context.Context, key string) {
+	if key == "" {
+		return
+	}
+	if err := g.store.Done(ctx, key, g.ttl); err != nil {
+		xlog.Jupiter().Warn("idempotency guard commit", xlog.String("key", key), xlog.FieldErr(err))
+	}
+}
+
+// release 消费失败时释放key
+func (g *idempotencyGuard) release(ctx context.Context, key string) {
+	if key == "" {
+		return
+	}
+	if err := g.store.Del(ctx, key); err != nil {
+		xlog.Jupiter().Warn("idempotency guard release", xlog.String("key", key), xlog.FieldErr(err))
+	}
+}
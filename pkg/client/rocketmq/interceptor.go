@@ -24,12 +24,16 @@ import (
 	"github.com/apache/rocketmq-client-go/v2/primitive"
 	"github.com/zhengyansheng/jupiter/pkg/core/imeta"
 	"github.com/zhengyansheng/jupiter/pkg/core/istats"
+	"github.com/zhengyansheng/jupiter/pkg/core/metric"
 	"github.com/zhengyansheng/jupiter/pkg/core/sentinel"
+	"github.com/zhengyansheng/jupiter/pkg/core/xtrace"
 	"github.com/zhengyansheng/jupiter/pkg/util/xdebug"
 	"github.com/zhengyansheng/jupiter/pkg/xlog"
+	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
 	"go.opentelemetry.io/otel/trace"
-	"google.golang.org/grpc/metadata"
 )
 
 type FlowInfo struct {
@@ -58,6 +62,46 @@ func consumeResultStr(result consumer.ConsumeResult) string {
 	}
 }
 
+// messageCarrier returns the propagation carrier of the message properties
+func messageCarrier(msg *primitive.MessageExt) propagation.MapCarrier {
+	carrier := propagation.MapCarrier{}
+	for key, value := range msg.GetProperties() {
+		carrier[key] = value
+	}
+	return carrier
+}
+
+// startConsumeSpan starts a consumer span for msgs, the upstream span is
+// the parent if there is only one message, otherwise spans are linked.
+func startConsumeSpan(tracer *xtrace.Tracer, ctx context.Context, topic string, msgs ...*primitive.MessageExt) (context.Context, trace.Span) {
+	opts := []trace.SpanStartOption{
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String("rocketmq"),
+			semconv.MessagingDestinationKey.String(topic),
+			semconv.MessagingOperationProcess,
+		),
+	}
+
+	if len(msgs) == 1 {
+		msg := msgs[0]
+		opts = append(opts, trace.WithAttributes(
+			semconv.MessagingMessageIDKey.String(msg.MsgId),
+			semconv.MessagingRocketmqMessageTagKey.String(msg.GetTags()),
+		))
+		return tracer.Start(ctx, topic, messageCarrier(msg), opts...)
+	}
+
+	links := make([]trace.Link, 0, len(msgs))
+	for _, msg := range msgs {
+		upstream := otel.GetTextMapPropagator().Extract(ctx, messageCarrier(msg))
+		if sc := trace.SpanContextFromContext(upstream); sc.IsValid() {
+			links = append(links, trace.Link{SpanContext: sc})
+		}
+	}
+	opts = append(opts, trace.WithLinks(links...))
+	return tracer.Start(ctx, topic, nil, opts...)
+}
+
 func consumerMetricInterceptor() primitive.Interceptor {
 	return func(ctx context.Context, req, reply interface{}, next primitive.Invoker) error {
 		beg := time.Now()
@@ -80,6 +124,8 @@ func consumerMetricInterceptor() primitive.Interceptor {
 			host := msg.StoreHost
 			topic := msg.Topic
 			result := consumeResultStr(holder.ConsumeResult)
+			metric.ClientHandleCounter.Inc(metric.TypeRocketMQ, topic, "consume", host, result)
+			metric.ClientHandleHistogram.Observe(time.Since(beg).Seconds(), metric.TypeRocketMQ, topic, "consume", host)
 			if err != nil {
 				xlog.Jupiter().Error("push consumer",
 					xlog.String("topic", topic),
@@ -163,27 +209,44 @@ func produceResultStr(result primitive.SendStatus) string {
 }
 
 func producerDefaultInterceptor(producer *Producer) primitive.Interceptor {
-
+	tracer := xtrace.NewTracer(trace.SpanKindProducer)
 	return func(ctx context.Context, req, reply interface{}, next primitive.Invoker) error {
 		beg := time.Now()
 		realReq := req.(*primitive.Message)
-		realReply := reply.(*primitive.SendResult)
+		// 异步及单向发送时reply为nil
+		realReply, _ := reply.(*primitive.SendResult)
 
 		var span trace.Span
-
 		if producer.EnableTrace {
-
-			md := metadata.New(nil)
-
+			carrier := propagation.MapCarrier{}
+			ctx, span = tracer.Start(ctx, realReq.Topic, carrier,
+				trace.WithAttributes(
+					semconv.MessagingSystemKey.String("rocketmq"),
+					semconv.MessagingDestinationKey.String(realReq.Topic),
+					semconv.MessagingRocketmqMessageTagKey.String(realReq.GetTags()),
+				),
+			)
 			defer span.End()
 
-			for k, v := range md {
-				realReq.WithProperty(strings.ToLower(k), strings.Join(v, ","))
+			for k, v := range carrier {
+				realReq.WithProperty(k, v)
 			}
 		}
 
 		err := next(ctx, realReq, realReply)
-		if realReply == nil || realReply.MessageQueue == nil {
+		if span != nil && err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+
+		if realReply == nil {
+			// 异步发送成功的结果在回调中统计
+			if err != nil || primitive.GetMethod(ctx) == primitive.SendOneway {
+				recordProduce(producer, beg, nil, err)
+			}
+			return err
+		}
+		if realReply.MessageQueue == nil {
 			return err
 		}
 
@@ -192,43 +255,53 @@ func producerDefaultInterceptor(producer *Producer) primitive.Interceptor {
 			"message": realReq,
 			"result":  realReply.String(),
 		})
+		recordProduce(producer, beg, realReply, err)
 
-		if producer.EnableTrace {
-			if err != nil {
-				span := trace.SpanFromContext(ctx)
-				span.RecordError(err)
-				span.SetStatus(codes.Error, err.Error())
-			}
-		}
+		return err
+	}
+}
 
-		// 消息处理结果统计
-		topic := producer.Topic
-		if err != nil {
-			xlog.Jupiter().Error("produce",
-				xlog.String("topic", topic),
-				xlog.String("queue", ""),
-				xlog.String("result", realReply.String()),
-				xlog.Any("err", err),
-			)
-		} else {
-			xlog.Jupiter().Debug("produce",
+// recordProduce 统计发送结果, 单向发送及异步发送失败时result为nil
+func recordProduce(producer *Producer, beg time.Time, result *primitive.SendResult, err error) {
+	topic := producer.Topic
+	var (
+		queue  *primitive.MessageQueue
+		broker string
+		status = "sendOneway"
+		detail string
+	)
+	if result != nil && result.MessageQueue != nil {
+		queue, broker, status, detail = result.MessageQueue, result.MessageQueue.BrokerName, produceResultStr(result.Status), result.String()
+	}
+
+	// 消息处理结果统计
+	if err != nil {
+		metric.ClientHandleCounter.Inc(metric.TypeRocketMQ, topic, "produce", broker, "error")
+		xlog.Jupiter().Error("produce",
+			xlog.String("topic", topic),
+			xlog.String("queue", ""),
+			xlog.String("result", detail),
+			xlog.Any("err", err),
+		)
+	} else {
+		metric.ClientHandleCounter.Inc(metric.TypeRocketMQ, topic, "produce", broker, status)
+		xlog.Jupiter().Debug("produce",
+			xlog.String("topic", topic),
+			xlog.Any("queue", queue),
+			xlog.String("result", status),
+		)
+	}
+
+	metric.ClientHandleHistogram.Observe(time.Since(beg).Seconds(), metric.TypeRocketMQ, topic, "produce", broker)
+
+	if producer.RwTimeout > time.Duration(0) {
+		if time.Since(beg) > producer.RwTimeout {
+			xlog.Jupiter().Error("slow",
 				xlog.String("topic", topic),
-				xlog.Any("queue", realReply.MessageQueue),
-				xlog.String("result", produceResultStr(realReply.Status)),
+				xlog.String("result", detail),
+				xlog.Any("cost", time.Since(beg).Seconds()),
 			)
 		}
-
-		if producer.RwTimeout > time.Duration(0) {
-			if time.Since(beg) > producer.RwTimeout {
-				xlog.Jupiter().Error("slow",
-					xlog.String("topic", topic),
-					xlog.String("result", realReply.String()),
-					xlog.Any("cost", time.Since(beg).Seconds()),
-				)
-			}
-		}
-
-		return err
 	}
 }
 
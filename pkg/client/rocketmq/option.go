@@ -69,6 +69,14 @@ type PushConsumerConfig struct {
 	WaitMaxDuration time.Duration `json:"waitMaxDuration" toml:"waitMaxDuration"`
 	// 消费消息的协程数，默认为20
 	ConsumeGoroutineNums int `json:"consumeGoroutineNums" toml:"consumeGoroutineNums"`
+	// 顺序消费，同一个队列的消息串行消费，消费失败时挂起队列并重试，不会跳过失败的消息
+	Orderly bool `json:"orderly" toml:"orderly"`
+	// 顺序消费失败时队列的挂起时间，默认为1s
+	SuspendCurrentQueueTime time.Duration `json:"suspendCurrentQueueTime" toml:"suspendCurrentQueueTime"`
+	// 死信topic，重试次数耗尽仍然消费失败的消息及失败原因发送到该topic，也可以通过WithDeadLetterSink自定义
+	DeadLetterTopic string `json:"deadLetterTopic" toml:"deadLetterTopic"`
+	// 幂等消费，按照消息的key或msgId去重
+	Idempotency IdempotencyConfig `json:"idempotency" toml:"idempotency"`
 }
 
 // PullConsumerConfig pull consumer config
@@ -93,6 +101,9 @@ type ProducerConfig struct {
 	// client实例名，默认会基于Addr字段生成md5，支持多集群
 	InstanceName string `json:"instanceName" toml:"instanceName"`
 	EnableTrace  bool   `json:"enableTrace" toml:"enableTrace"`
+	// 事务消息的producer group，不为空时开启事务消息，不能与Group相同
+	// 需要在Start前通过WithTransactionListener设置本地事务及回查回调
+	TransactionGroup string `json:"transactionGroup" toml:"transactionGroup"`
 }
 
 // DefaultConfig ...
@@ -113,9 +124,10 @@ func DefaultConfig() *Config {
 				EnableTrace:  true,
 				MessageModel: "Clustering",
 			},
-			RwTimeout:            time.Second * 10,
-			WaitMaxDuration:      60 * time.Second,
-			ConsumeGoroutineNums: 20,
+			RwTimeout:               time.Second * 10,
+			WaitMaxDuration:         60 * time.Second,
+			ConsumeGoroutineNums:    20,
+			SuspendCurrentQueueTime: time.Second,
 		},
 		PullConsumer: &PullConsumerConfig{
 			ConsumerDefaultConfig: ConsumerDefaultConfig{
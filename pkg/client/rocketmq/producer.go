@@ -16,6 +16,9 @@ package rocketmq
 
 import (
 	"context"
+	"errors"
+	"strconv"
+	"time"
 
 	"github.com/apache/rocketmq-client-go/v2"
 	"github.com/apache/rocketmq-client-go/v2/primitive"
@@ -33,8 +36,17 @@ type Producer struct {
 	name string
 	ProducerConfig
 	interceptors []primitive.Interceptor
+
+	txProducer rocketmq.TransactionProducer
+	txListener primitive.TransactionListener
 }
 
+// ErrTransactionDisabled 未配置transactionGroup时发送事务消息
+var ErrTransactionDisabled = errors.New("rocketmq: transaction is not enabled, transactionGroup is empty")
+
+// PropertyTimerDeliverMs 定时消息的投递时间(毫秒时间戳)，需要RocketMQ 5.x的broker
+const PropertyTimerDeliverMs = "TIMER_DELIVER_MS"
+
 func StdNewProducer(name string) *Producer {
 	return StdProducerConfig(name).Build()
 }
@@ -78,6 +90,7 @@ func (pc *Producer) Start() error {
 		producer.WithRetry(pc.Retry),
 		producer.WithInterceptor(pc.interceptors...),
 		producer.WithInstanceName(pc.InstanceName),
+		producer.WithQueueSelector(newShardingKeyQueueSelector()),
 		producer.WithCredentials(primitive.Credentials{
 			AccessKey: pc.AccessKey,
 			SecretKey: pc.SecretKey,
@@ -99,6 +112,10 @@ func (pc *Producer) Start() error {
 		)
 	}
 
+	if pc.TransactionGroup != "" {
+		pc.startTransactionProducer()
+	}
+
 	pc.started = true
 	pc.Producer = client
 	// 进程退出时，producer不Close，避免消息发失败
@@ -117,6 +134,11 @@ func (pc *Producer) WithInterceptor(fs ...primitive.Interceptor) *Producer {
 }
 
 func (pc *Producer) Close() error {
+	if pc.txProducer != nil {
+		if err := pc.txProducer.Shutdown(); err != nil {
+			xlog.Jupiter().Warn("transaction producer close fail", xlog.Any("error", err.Error()))
+		}
+	}
 	err := pc.Shutdown()
 	if err != nil {
 		xlog.Jupiter().Warn("consumer close fail", xlog.Any("error", err.Error()))
@@ -171,3 +193,85 @@ func (pc *Producer) SendMsg(ctx context.Context, msg *primitive.Message) (*primi
 	}
 	return res, nil
 }
+
+// SendWithDelayLevel 发送延时消息，level对应broker的messageDelayLevel，默认为
+// 1s 5s 10s 30s 1m 2m 3m 4m 5m 6m 7m 8m 9m 10m 20m 30m 1h 2h
+func (pc *Producer) SendWithDelayLevel(ctx context.Context, msg *primitive.Message, level int) (*primitive.SendResult, error) {
+	msg.WithDelayTimeLevel(level)
+	return pc.sendSync(ctx, msg)
+}
+
+// SendWithDeliverTime 发送定时消息，在deliverTime投递给消费者，需要RocketMQ 5.x的broker
+func (pc *Producer) SendWithDeliverTime(ctx context.Context, msg *primitive.Message, deliverTime time.Time) (*primitive.SendResult, error) {
+	msg.WithProperty(PropertyTimerDeliverMs, strconv.FormatInt(deliverTime.UnixMilli(), 10))
+	return pc.sendSync(ctx, msg)
+}
+
+// SendOrderly 发送顺序消息，shardingKey相同的消息发送到同一个队列
+func (pc *Producer) SendOrderly(ctx context.Context, msg *primitive.Message, shardingKey string) (*primitive.SendResult, error) {
+	msg.WithShardingKey(shardingKey)
+	return pc.sendSync(ctx, msg)
+}
+
+// SendMsgAsync 异步发送消息，发送结果通过callback返回
+func (pc *Producer) SendMsgAsync(ctx context.Context, msg *primitive.Message, callback func(context.Context, *primitive.SendResult, error)) error {
+	if msg.Topic == "" {
+		msg.Topic = pc.Topic
+	}
+	beg := time.Now()
+	err := pc.SendAsync(ctx, func(ctx context.Context, result *primitive.SendResult, err error) {
+		recordProduce(pc, beg, result, err)
+		if callback != nil {
+			callback(ctx, result, err)
+		}
+	}, msg)
+	if err != nil {
+		xlog.Jupiter().Error("send message async error", xlog.Any("msg", msg), xlog.FieldErr(err))
+	}
+	return err
+}
+
+// SendMsgOneWay 单向发送消息，不等待broker的响应
+func (pc *Producer) SendMsgOneWay(ctx context.Context, msg *primitive.Message) error {
+	if msg.Topic == "" {
+		msg.Topic = pc.Topic
+	}
+	err := pc.SendOneWay(ctx, msg)
+	if err != nil {
+		xlog.Jupiter().Error("send message oneway error", xlog.Any("msg", msg), xlog.FieldErr(err))
+	}
+	return err
+}
+
+func (pc *Producer) sendSync(ctx context.Context, msg *primitive.Message) (*primitive.SendResult, error) {
+	if msg.Topic == "" {
+		msg.Topic = pc.Topic
+	}
+	res, err := pc.SendSync(ctx, msg)
+	if err != nil {
+		xlog.Jupiter().Error("send message error", xlog.Any("msg", msg), xlog.FieldErr(err))
+		return res, err
+	}
+	return res, nil
+}
+
+// shardingKeyQueueSelector 带有shardingKey的消息按照hash选择队列，其余消息轮询
+type shardingKeyQueueSelector struct {
+	hash       producer.QueueSelector
+	roundRobin producer.QueueSelector
+}
+
+func newShardingKeyQueueSelector() producer.QueueSelector {
+	return &shardingKeyQueueSelector{
+		hash:       producer.NewHashQueueSelector(),
+		roundRobin: producer.NewRoundRobinQueueSelector(),
+	}
+}
+
+// Select ...
+func (s *shardingKeyQueueSelector) Select(msg *primitive.Message, mqs []*primitive.MessageQueue, lastBrokerName string) *primitive.MessageQueue {
+	if msg.GetShardingKey() != "" {
+		return s.hash.Select(msg, mqs, lastBrokerName)
+	}
+	return s.roundRobin.Select(msg, mqs, lastBrokerName)
+}
@@ -0,0 +1,88 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rocketmq
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/apache/rocketmq-client-go/v2/primitive"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_shardingKeyQueueSelector(t *testing.T) {
+	mqs := []*primitive.MessageQueue{
+		{Topic: "test", BrokerName: "broker-a", QueueId: 0},
+		{Topic: "test", BrokerName: "broker-a", QueueId: 1},
+		{Topic: "test", BrokerName: "broker-b", QueueId: 0},
+		{Topic: "test", BrokerName: "broker-b", QueueId: 1},
+	}
+	selector := newShardingKeyQueueSelector()
+
+	t.Run("same sharding key to same queue", func(t *testing.T) {
+		msg := primitive.NewMessage("test", []byte("hello")).WithShardingKey("order-1")
+		mq := selector.Select(msg, mqs, "")
+		for i := 0; i < 10; i++ {
+			assert.Equal(t, mq, selector.Select(msg, mqs, "broker-a"))
+		}
+	})
+
+	t.Run("round robin without sharding key", func(t *testing.T) {
+		msg := primitive.NewMessage("test", []byte("hello"))
+		selected := make(map[*primitive.MessageQueue]bool)
+		for i := 0; i < len(mqs); i++ {
+			selected[selector.Select(msg, mqs, "")] = true
+		}
+		assert.Len(t, selected, len(mqs))
+	})
+}
+
+func TestProducer_SendInTransaction(t *testing.T) {
+	pc := &Producer{ProducerConfig: ProducerConfig{Topic: "test"}}
+	_, err := pc.SendInTransaction(context.Background(), primitive.NewMessage("", []byte("hello")))
+	assert.ErrorIs(t, err, ErrTransactionDisabled)
+}
+
+func TestNewTransactionListener(t *testing.T) {
+	listener := NewTransactionListener(
+		func(msg *primitive.Message) primitive.LocalTransactionState {
+			return primitive.CommitMessageState
+		},
+		func(msg *primitive.MessageExt) primitive.LocalTransactionState {
+			return primitive.RollbackMessageState
+		},
+	)
+	assert.Equal(t, primitive.CommitMessageState, listener.ExecuteLocalTransaction(&primitive.Message{}))
+	assert.Equal(t, primitive.RollbackMessageState, listener.CheckLocalTransaction(&primitive.MessageExt{}))
+}
+
+func Test_producerDefaultInterceptor_withoutReply(t *testing.T) {
+	pc := &Producer{ProducerConfig: ProducerConfig{Topic: "test", EnableTrace: true}}
+	interceptor := producerDefaultInterceptor(pc)
+	msg := primitive.NewMessage("test", []byte("hello"))
+
+	for _, method := range []primitive.CommunicationMode{primitive.SendAsync, primitive.SendOneway} {
+		ctx := primitive.WithMethod(context.Background(), method)
+		assert.Nil(t, interceptor(ctx, msg, nil, func(ctx context.Context, req, reply interface{}) error {
+			return nil
+		}))
+
+		sendErr := errors.New("send failed")
+		assert.Equal(t, sendErr, interceptor(ctx, msg, nil, func(ctx context.Context, req, reply interface{}) error {
+			return sendErr
+		}))
+	}
+}
@@ -10,9 +10,10 @@ import (
 	"github.com/apache/rocketmq-client-go/v2/rlog"
 	"github.com/samber/lo"
 	"github.com/zhengyansheng/jupiter/pkg/core/hooks"
+	"github.com/zhengyansheng/jupiter/pkg/core/xtrace"
 	"github.com/zhengyansheng/jupiter/pkg/util/xgo"
 	"github.com/zhengyansheng/jupiter/pkg/xlog"
-	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
@@ -24,6 +25,7 @@ type PullConsumer struct {
 
 	subscribers  map[string]func()
 	interceptors []primitive.Interceptor
+	tracer       *xtrace.Tracer
 	started      *atomic.Bool
 	done         chan struct{}
 }
@@ -38,6 +40,7 @@ func (conf *PullConsumerConfig) Build() *PullConsumer {
 		PullConsumerConfig: *conf,
 		subscribers:        make(map[string]func()),
 		interceptors:       []primitive.Interceptor{},
+		tracer:             xtrace.NewTracer(trace.SpanKindConsumer),
 		done:               make(chan struct{}, 1),
 		started:            new(atomic.Bool),
 	}
@@ -163,21 +166,9 @@ func (cc *PullConsumer) Poll(ctx context.Context, f func(context.Context, []*pri
 						xlog.Jupiter().Error("poll error", xlog.FieldErr(err))
 						continue
 					}
-					if f(ctx, pullResult.GetMsgList()) != nil {
+					if cc.consume(ctx, pullResult.GetMsgList(), f) != nil {
 						continue
 					}
-					if cc.EnableTrace {
-						for _, msg := range pullResult.GetMsgList() {
-							var (
-								span trace.Span
-							)
-							carrier := propagation.MapCarrier{}
-							for key, value := range msg.GetProperties() {
-								carrier[key] = value
-							}
-							defer span.End()
-						}
-					}
 
 					cc.PullConsumer.ACK(context.TODO(), pullResult, consumer.ConsumeSuccess)
 
@@ -188,6 +179,27 @@ func (cc *PullConsumer) Poll(ctx context.Context, f func(context.Context, []*pri
 	cc.subscribers[cc.Topic] = fn
 }
 
+// consume handles the polled messages within a consumer span
+func (cc *PullConsumer) consume(ctx context.Context, msgs []*primitive.MessageExt, f func(context.Context, []*primitive.MessageExt) error) error {
+	if !cc.EnableTrace || len(msgs) == 0 {
+		return f(ctx, msgs)
+	}
+
+	ctx, span := startConsumeSpan(cc.tracer, ctx, cc.Topic, msgs...)
+	defer span.End()
+
+	traceID := span.SpanContext().TraceID().String()
+	ctx = xlog.NewContext(ctx, xlog.Default(), traceID)
+	ctx = xlog.NewContext(ctx, xlog.Jupiter(), traceID)
+
+	err := f(ctx, msgs)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
 func (cc *PullConsumer) Close() {
 	close(cc.done)
 	err := cc.Shutdown()
@@ -17,6 +17,7 @@ package rocketmq
 import (
 	"context"
 	"errors"
+	"fmt"
 	"runtime/debug"
 
 	"github.com/apache/rocketmq-client-go/v2"
@@ -25,9 +26,9 @@ import (
 	"github.com/juju/ratelimit"
 	"github.com/samber/lo"
 	"github.com/zhengyansheng/jupiter/pkg/core/hooks"
+	"github.com/zhengyansheng/jupiter/pkg/core/xtrace"
 	"github.com/zhengyansheng/jupiter/pkg/xlog"
-	"go.opentelemetry.io/otel/propagation"
-	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
@@ -37,10 +38,13 @@ type PushConsumer struct {
 	name string
 	PushConsumerConfig
 
-	subscribers  map[string]func(context.Context, ...*primitive.MessageExt) (consumer.ConsumeResult, error)
+	subscribers  map[string]subscription
 	interceptors []primitive.Interceptor
 	bucket       *ratelimit.Bucket
+	tracer       *xtrace.Tracer
 	started      bool
+	deadLetter   DeadLetterSink
+	idempotency  *idempotencyGuard
 }
 
 func (conf *PushConsumerConfig) Build() *PushConsumer {
@@ -56,9 +60,11 @@ func (conf *PushConsumerConfig) Build() *PushConsumer {
 	cc := &PushConsumer{
 		name:               name,
 		PushConsumerConfig: *conf,
-		subscribers:        make(map[string]func(context.Context, ...*primitive.MessageExt) (consumer.ConsumeResult, error)),
+		subscribers:        make(map[string]subscription),
 		interceptors:       []primitive.Interceptor{},
 		bucket:             bucket,
+		tracer:             xtrace.NewTracer(trace.SpanKindConsumer),
+		idempotency:        newIdempotencyGuard(conf.Group, conf.Idempotency),
 	}
 	cc.interceptors = append(cc.interceptors,
 		consumerMetricInterceptor(),
@@ -89,64 +95,132 @@ func (cc *PushConsumer) WithInterceptor(fs ...primitive.Interceptor) *PushConsum
 	return cc
 }
 
+type subscription struct {
+	// selector为nil时使用配置的subExpression
+	selector *consumer.MessageSelector
+	fn       func(context.Context, ...*primitive.MessageExt) (consumer.ConsumeResult, error)
+}
+
+// RegisterSingleMessage 注册配置的topic的单条消息处理函数
 func (cc *PushConsumer) RegisterSingleMessage(f func(context.Context, *primitive.MessageExt) error) *PushConsumer {
 	if _, ok := cc.subscribers[cc.Topic]; ok {
 		xlog.Jupiter().Panic("duplicated register single message", zap.String("topic", cc.Topic))
 	}
 
-	fn := func(ctx context.Context, msgs ...*primitive.MessageExt) (result consumer.ConsumeResult, err error) {
+	cc.subscribers[cc.Topic] = subscription{fn: cc.singleMessageHandler(f)}
+	return cc
+}
+
+// Subscribe 订阅topic，一个consumer可以订阅多个topic，
+// selector可以按照tag过滤消息，配合MessageRouter可以将不同tag或key的消息分发给不同的handler
+func (cc *PushConsumer) Subscribe(topic string, selector consumer.MessageSelector, f func(context.Context, *primitive.MessageExt) error) *PushConsumer {
+	if _, ok := cc.subscribers[topic]; ok {
+		xlog.Jupiter().Panic("duplicated subscribe topic", zap.String("topic", topic))
+	}
+
+	cc.subscribers[topic] = subscription{selector: &selector, fn: cc.singleMessageHandler(f)}
+	return cc
+}
+
+func (cc *PushConsumer) singleMessageHandler(f func(context.Context, *primitive.MessageExt) error) func(context.Context, ...*primitive.MessageExt) (consumer.ConsumeResult, error) {
+	return func(ctx context.Context, msgs ...*primitive.MessageExt) (result consumer.ConsumeResult, err error) {
 		// the recover to prevent panic from causing the coroutine to exit when processing msg.
 		defer func() {
 			if r := recover(); r != nil {
 				xlog.Jupiter().Error("consumer message panic", zap.String("stack", string(debug.Stack())))
-				result, err = consumer.ConsumeRetryLater, errors.New("consumer message panic")
+				result, err = cc.retryLater(ctx), errors.New("consumer message panic")
 			}
 		}()
 		for _, msg := range msgs {
-			var (
-				span trace.Span
-			)
-
-			if cc.EnableTrace {
-				carrier := propagation.MapCarrier{}
-
-				for key, value := range msg.GetProperties() {
-					carrier[key] = value
-				}
-
-				span.SetAttributes(
-					semconv.MessagingRocketmqNamespaceKey.String(msg.Topic),
-					semconv.MessagingRocketmqMessageTagKey.String(msg.GetTags()),
-				)
-
-				ctx = xlog.NewContext(ctx, xlog.Default(), span.SpanContext().TraceID().String())
-				ctx = xlog.NewContext(ctx, xlog.Jupiter(), span.SpanContext().TraceID().String())
-
-				defer span.End()
-			}
-
 			if cc.bucket != nil {
 				if ok := cc.bucket.WaitMaxDuration(1, cc.WaitMaxDuration); !ok {
-					xlog.Jupiter().Warn("too many messages, reconsume later", zap.String("body", string(msg.Body)), zap.String("topic", cc.Topic))
-					return consumer.ConsumeRetryLater, nil
+					xlog.Jupiter().Warn("too many messages, reconsume later", zap.String("body", string(msg.Body)), zap.String("topic", msg.Topic))
+					return cc.retryLater(ctx), nil
 				}
 			}
 
-			if err := f(ctx, msg); err != nil {
+			if err := cc.consumeGuarded(ctx, msg, f); err != nil {
 				xlog.Jupiter().Error("consumer message", zap.Error(err), zap.String("field", cc.name), zap.Any("ext", msg))
-				if cc.EnableTrace && span != nil {
-					span.RecordError(err)
-				}
-				return consumer.ConsumeRetryLater, err
+				return cc.retryLater(ctx), err
 			}
 		}
 
 		return consumer.ConsumeSuccess, nil
 	}
-	cc.subscribers[cc.Topic] = fn
-	return cc
 }
 
+// retryLater 返回消费失败时的结果，顺序消费时挂起当前队列并重试当前消息，保证队列内消息的顺序
+func (cc *PushConsumer) retryLater(ctx context.Context) consumer.ConsumeResult {
+	if !cc.Orderly {
+		return consumer.ConsumeRetryLater
+	}
+	if orderlyCtx, ok := primitive.GetOrderlyCtx(ctx); ok {
+		orderlyCtx.SuspendCurrentQueueTimeMillis = int(cc.SuspendCurrentQueueTime.Milliseconds())
+	}
+	return consumer.SuspendCurrentQueueAMoment
+}
+
+// consumeGuarded 消费前按照幂等配置跳过重复的消息，消费失败时释放幂等记录，
+// 重试次数耗尽时将消息投递到死信
+func (cc *PushConsumer) consumeGuarded(ctx context.Context, msg *primitive.MessageExt, f func(context.Context, *primitive.MessageExt) error) error {
+	var key string
+	if cc.idempotency != nil {
+		var (
+			ok  bool
+			err error
+		)
+		// 相同的消息正在被消费时稍后重试，不投递到死信
+		if key, ok, err = cc.idempotency.acquire(ctx, msg); err != nil || !ok {
+			return err
+		}
+	}
+
+	err := cc.safeCall(func() error { return cc.consume(ctx, msg, f) })
+	if err == nil {
+		if cc.idempotency != nil {
+			cc.idempotency.commit(ctx, key)
+		}
+		return nil
+	}
+	if cc.idempotency != nil {
+		cc.idempotency.release(ctx, key)
+	}
+	return cc.putDeadLetters(ctx, err, msg)
+}
+
+// safeCall converts the panic of handler to error, so the failure can be handled as usual
+func (cc *PushConsumer) safeCall(f func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			xlog.Jupiter().Error("consumer message panic", zap.String("stack", string(debug.Stack())))
+			err = fmt.Errorf("consumer message panic: %v", r)
+		}
+	}()
+	return f()
+}
+
+// consume handles a single message within its consumer span
+func (cc *PushConsumer) consume(ctx context.Context, msg *primitive.MessageExt, f func(context.Context, *primitive.MessageExt) error) error {
+	if !cc.EnableTrace {
+		return f(ctx, msg)
+	}
+
+	ctx, span := startConsumeSpan(cc.tracer, ctx, msg.Topic, msg)
+	defer span.End()
+
+	traceID := span.SpanContext().TraceID().String()
+	ctx = xlog.NewContext(ctx, xlog.Default(), traceID)
+	ctx = xlog.NewContext(ctx, xlog.Jupiter(), traceID)
+
+	err := f(ctx, msg)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+// RegisterBatchMessage 注册配置的topic的批量消息处理函数
 func (cc *PushConsumer) RegisterBatchMessage(f func(context.Context, ...*primitive.MessageExt) error) *PushConsumer {
 	if _, ok := cc.subscribers[cc.Topic]; ok {
 		xlog.Jupiter().Panic("duplicated register batch message", zap.String("topic", cc.Topic))
@@ -157,38 +231,72 @@ func (cc *PushConsumer) RegisterBatchMessage(f func(context.Context, ...*primiti
 		defer func() {
 			if r := recover(); r != nil {
 				xlog.Jupiter().Error("consumer message panic", zap.String("stack", string(debug.Stack())))
-				result, err = consumer.ConsumeRetryLater, errors.New("consumer message panic")
+				result, err = cc.retryLater(ctx), errors.New("consumer message panic")
 			}
 		}()
+		if cc.bucket != nil {
+			if ok := cc.bucket.WaitMaxDuration(int64(len(msgs)), cc.WaitMaxDuration); !ok {
+				xlog.Jupiter().Warn("too many messages, reconsume later", zap.String("topic", cc.Topic))
+				return cc.retryLater(ctx), nil
+			}
+		}
+
 		if cc.EnableTrace {
-			for _, msg := range msgs {
-				var (
-					span trace.Span
-				)
+			var span trace.Span
+			ctx, span = startConsumeSpan(cc.tracer, ctx, cc.Topic, msgs...)
+			defer span.End()
 
-				carrier := propagation.MapCarrier{}
-				for key, value := range msg.GetProperties() {
-					carrier[key] = value
-				}
-				defer span.End()
-			}
+			traceID := span.SpanContext().TraceID().String()
+			ctx = xlog.NewContext(ctx, xlog.Default(), traceID)
+			ctx = xlog.NewContext(ctx, xlog.Jupiter(), traceID)
 		}
 
-		if cc.bucket != nil {
-			if ok := cc.bucket.WaitMaxDuration(int64(len(msgs)), cc.WaitMaxDuration); !ok {
-				xlog.Jupiter().Warn("too many messages, reconsume later", zap.String("topic", cc.Topic))
-				return consumer.ConsumeRetryLater, nil
+		var keys []string
+		if cc.idempotency != nil {
+			pending := make([]*primitive.MessageExt, 0, len(msgs))
+			for _, msg := range msgs {
+				key, ok, err := cc.idempotency.acquire(ctx, msg)
+				if err != nil {
+					// 批量消息中有正在被消费的消息时整批稍后重试
+					for _, key := range keys {
+						cc.idempotency.release(ctx, key)
+					}
+					return cc.retryLater(ctx), err
+				}
+				if ok {
+					keys = append(keys, key)
+					pending = append(pending, msg)
+				}
 			}
+			if len(pending) == 0 {
+				return consumer.ConsumeSuccess, nil
+			}
+			msgs = pending
 		}
 
-		if err := f(ctx, msgs...); err != nil {
+		if err := cc.safeCall(func() error { return f(ctx, msgs...) }); err != nil {
+			if cc.EnableTrace {
+				span := trace.SpanFromContext(ctx)
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			for _, key := range keys {
+				cc.idempotency.release(ctx, key)
+			}
+			// 批量消息全部耗尽重试次数时才投递到死信
+			if err = cc.putDeadLetters(ctx, err, msgs...); err == nil {
+				return consumer.ConsumeSuccess, nil
+			}
 			xlog.Jupiter().Error("consumer batch message", zap.Error(err), zap.String("field", cc.name))
-			return consumer.ConsumeRetryLater, err
+			return cc.retryLater(ctx), err
 		}
 
+		for _, key := range keys {
+			cc.idempotency.commit(ctx, key)
+		}
 		return consumer.ConsumeSuccess, nil
 	}
-	cc.subscribers[cc.Topic] = fn
+	cc.subscribers[cc.Topic] = subscription{fn: fn}
 	return cc
 }
 
@@ -206,6 +314,7 @@ func (cc *PushConsumer) Start() error {
 		consumer.WithConsumeMessageBatchMaxSize(cc.ConsumeMessageBatchMaxSize),
 		consumer.WithPullBatchSize(cc.PullBatchSize),
 		consumer.WithConsumeGoroutineNums(cc.ConsumeGoroutineNums),
+		consumer.WithConsumerOrder(cc.Orderly),
 		consumer.WithCredentials(primitive.Credentials{
 			AccessKey: cc.AccessKey,
 			SecretKey: cc.SecretKey,
@@ -230,6 +339,10 @@ func (cc *PushConsumer) Start() error {
 
 	cc.PushConsumer = client
 
+	if cc.deadLetter == nil && cc.DeadLetterTopic != "" {
+		cc.deadLetter = NewTopicDeadLetterSink(cc.deadLetterProducer(), cc.DeadLetterTopic)
+	}
+
 	selector := consumer.MessageSelector{
 		Type:       consumer.TAG,
 		Expression: "",
@@ -238,8 +351,12 @@ func (cc *PushConsumer) Start() error {
 		selector.Expression = cc.PushConsumerConfig.SubExpression
 	}
 
-	for topic, fn := range cc.subscribers {
-		if err := cc.PushConsumer.Subscribe(topic, selector, fn); err != nil {
+	for topic, sub := range cc.subscribers {
+		topicSelector := selector
+		if sub.selector != nil {
+			topicSelector = *sub.selector
+		}
+		if err := cc.PushConsumer.Subscribe(topic, topicSelector, sub.fn); err != nil {
 			return err
 		}
 	}
@@ -268,3 +385,20 @@ func (cc *PushConsumer) Start() error {
 func (cc *PushConsumer) MustStart() {
 	lo.Must0(cc.Start())
 }
+
+// deadLetterProducer 创建发送死信消息的producer，与consumer使用相同的集群及group
+func (cc *PushConsumer) deadLetterProducer() *Producer {
+	pc := (&ProducerConfig{
+		Name:         cc.name,
+		Addr:         cc.Addr,
+		Topic:        cc.DeadLetterTopic,
+		Group:        cc.Group,
+		Retry:        DefaultConfig().Producer.Retry,
+		AccessKey:    cc.AccessKey,
+		SecretKey:    cc.SecretKey,
+		InstanceName: cc.InstanceName,
+		EnableTrace:  cc.EnableTrace,
+	}).Build()
+	lo.Must0(pc.Start())
+	return pc
+}
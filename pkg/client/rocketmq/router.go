@@ -0,0 +1,94 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rocketmq
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/apache/rocketmq-client-go/v2/consumer"
+	"github.com/apache/rocketmq-client-go/v2/primitive"
+)
+
+// MessageHandler 单条消息的处理函数
+type MessageHandler func(context.Context, *primitive.MessageExt) error
+
+// MessageRouter 将同一个topic的消息按照key或tag分发给不同的handler，
+// 优先按照key匹配，其次按照tag匹配，都没有匹配时使用默认handler
+type MessageRouter struct {
+	tags     map[string]MessageHandler
+	keys     map[string]MessageHandler
+	fallback MessageHandler
+}
+
+// NewMessageRouter ...
+func NewMessageRouter() *MessageRouter {
+	return &MessageRouter{
+		tags: make(map[string]MessageHandler),
+		keys: make(map[string]MessageHandler),
+	}
+}
+
+// Tag 注册tag的handler
+func (r *MessageRouter) Tag(tag string, f MessageHandler) *MessageRouter {
+	r.tags[tag] = f
+	return r
+}
+
+// Key 注册key的handler，消息有多个key时任意一个匹配即可
+func (r *MessageRouter) Key(key string, f MessageHandler) *MessageRouter {
+	r.keys[key] = f
+	return r
+}
+
+// Default 注册没有匹配任何key及tag时的handler
+func (r *MessageRouter) Default(f MessageHandler) *MessageRouter {
+	r.fallback = f
+	return r
+}
+
+// Selector 返回订阅的tag表达式，只按照tag路由时只订阅注册的tag，否则订阅全部消息
+func (r *MessageRouter) Selector() consumer.MessageSelector {
+	selector := consumer.MessageSelector{Type: consumer.TAG, Expression: "*"}
+	if r.fallback != nil || len(r.keys) > 0 || len(r.tags) == 0 {
+		return selector
+	}
+
+	tags := make([]string, 0, len(r.tags))
+	for tag := range r.tags {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	selector.Expression = strings.Join(tags, " || ")
+	return selector
+}
+
+// Handle 将消息分发给匹配的handler，没有匹配的handler时返回错误，消息稍后重新消费
+func (r *MessageRouter) Handle(ctx context.Context, msg *primitive.MessageExt) error {
+	for _, key := range strings.Fields(msg.GetKeys()) {
+		if f, ok := r.keys[key]; ok {
+			return f(ctx, msg)
+		}
+	}
+	if f, ok := r.tags[msg.GetTags()]; ok {
+		return f(ctx, msg)
+	}
+	if r.fallback != nil {
+		return r.fallback(ctx, msg)
+	}
+	return fmt.Errorf("rocketmq: no handler for message, topic: %s, tags: %s, keys: %s", msg.Topic, msg.GetTags(), msg.GetKeys())
+}
@@ -0,0 +1,103 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rocketmq
+
+import (
+	"context"
+	"testing"
+
+	"github.com/apache/rocketmq-client-go/v2/consumer"
+	"github.com/apache/rocketmq-client-go/v2/primitive"
+	"github.com/stretchr/testify/assert"
+)
+
+func newMessageExt(tag string, keys ...string) *primitive.MessageExt {
+	msg := &primitive.MessageExt{Message: primitive.Message{Topic: "test", Body: []byte("hello")}}
+	msg.WithTag(tag).WithKeys(keys)
+	return msg
+}
+
+func TestMessageRouter(t *testing.T) {
+	var handled []string
+	handler := func(name string) MessageHandler {
+		return func(ctx context.Context, msg *primitive.MessageExt) error {
+			handled = append(handled, name)
+			return nil
+		}
+	}
+
+	router := NewMessageRouter().
+		Tag("created", handler("created")).
+		Tag("paid", handler("paid")).
+		Key("order-vip", handler("vip"))
+
+	assert.Nil(t, router.Handle(context.Background(), newMessageExt("created")))
+	assert.Nil(t, router.Handle(context.Background(), newMessageExt("paid", "order-1")))
+	assert.Nil(t, router.Handle(context.Background(), newMessageExt("paid", "order-2", "order-vip")))
+	assert.NotNil(t, router.Handle(context.Background(), newMessageExt("refund")))
+	assert.Equal(t, []string{"created", "paid", "vip"}, handled)
+
+	router.Default(handler("default"))
+	assert.Nil(t, router.Handle(context.Background(), newMessageExt("refund")))
+	assert.Equal(t, "default", handled[len(handled)-1])
+}
+
+func TestMessageRouter_Selector(t *testing.T) {
+	noop := func(ctx context.Context, msg *primitive.MessageExt) error { return nil }
+
+	router := NewMessageRouter().Tag("paid", noop).Tag("created", noop)
+	assert.Equal(t, "created || paid", router.Selector().Expression)
+
+	router.Key("order-vip", noop)
+	assert.Equal(t, "*", router.Selector().Expression)
+
+	assert.Equal(t, "*", NewMessageRouter().Default(noop).Selector().Expression)
+}
+
+func TestPushConsumer_Subscribe(t *testing.T) {
+	noop := func(ctx context.Context, msg *primitive.MessageExt) error { return nil }
+	cc := &PushConsumer{
+		PushConsumerConfig: PushConsumerConfig{ConsumerDefaultConfig: ConsumerDefaultConfig{Topic: "order"}},
+		subscribers:        make(map[string]subscription),
+	}
+
+	router := NewMessageRouter().Tag("paid", noop)
+	cc.RegisterSingleMessage(noop).Subscribe("refund", router.Selector(), router.Handle)
+	assert.Len(t, cc.subscribers, 2)
+	assert.Nil(t, cc.subscribers["order"].selector)
+	assert.Equal(t, "paid", cc.subscribers["refund"].selector.Expression)
+
+	assert.Panics(t, func() {
+		cc.Subscribe("refund", router.Selector(), noop)
+	})
+}
+
+func TestPushConsumer_retryLater(t *testing.T) {
+	cc := newTestPushConsumer()
+	fn := cc.singleMessageHandler(func(ctx context.Context, msg *primitive.MessageExt) error {
+		panic("consume failed")
+	})
+
+	result, err := fn(context.Background(), newMessageExt("paid"))
+	assert.NotNil(t, err)
+	assert.Equal(t, consumer.ConsumeRetryLater, result)
+
+	cc.Orderly = true
+	orderlyCtx := primitive.NewConsumeOrderlyContext()
+	result, err = fn(primitive.WithOrderlyCtx(context.Background(), orderlyCtx), newMessageExt("paid"))
+	assert.NotNil(t, err)
+	assert.Equal(t, consumer.SuspendCurrentQueueAMoment, result)
+	assert.Equal(t, 1000, orderlyCtx.SuspendCurrentQueueTimeMillis)
+}
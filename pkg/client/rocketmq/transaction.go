@@ -0,0 +1,110 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rocketmq
+
+import (
+	"context"
+
+	"github.com/apache/rocketmq-client-go/v2"
+	"github.com/apache/rocketmq-client-go/v2/primitive"
+	"github.com/apache/rocketmq-client-go/v2/producer"
+	"github.com/zhengyansheng/jupiter/pkg/xlog"
+)
+
+// transactionListener 由本地事务及回查回调组成的TransactionListener
+type transactionListener struct {
+	execute func(*primitive.Message) primitive.LocalTransactionState
+	check   func(*primitive.MessageExt) primitive.LocalTransactionState
+}
+
+// NewTransactionListener 通过本地事务执行及回查回调创建TransactionListener
+func NewTransactionListener(
+	execute func(*primitive.Message) primitive.LocalTransactionState,
+	check func(*primitive.MessageExt) primitive.LocalTransactionState,
+) primitive.TransactionListener {
+	return &transactionListener{execute: execute, check: check}
+}
+
+// ExecuteLocalTransaction ...
+func (l *transactionListener) ExecuteLocalTransaction(msg *primitive.Message) primitive.LocalTransactionState {
+	return l.execute(msg)
+}
+
+// CheckLocalTransaction ...
+func (l *transactionListener) CheckLocalTransaction(msg *primitive.MessageExt) primitive.LocalTransactionState {
+	return l.check(msg)
+}
+
+// WithTransactionListener 设置事务消息的本地事务执行及回查回调，需要在Start前调用
+func (pc *Producer) WithTransactionListener(listener primitive.TransactionListener) *Producer {
+	pc.txListener = listener
+	return pc
+}
+
+// SendInTransaction 发送事务消息，half消息发送成功后执行本地事务，并根据本地事务的结果提交或回滚
+func (pc *Producer) SendInTransaction(ctx context.Context, msg *primitive.Message) (*primitive.TransactionSendResult, error) {
+	if pc.txProducer == nil {
+		return nil, ErrTransactionDisabled
+	}
+	if msg.Topic == "" {
+		msg.Topic = pc.Topic
+	}
+	res, err := pc.txProducer.SendMessageInTransaction(ctx, msg)
+	if err != nil {
+		xlog.Jupiter().Error("send transaction message error", xlog.Any("msg", msg), xlog.FieldErr(err))
+		return res, err
+	}
+	return res, nil
+}
+
+// startTransactionProducer 事务消息使用独立的producer group及客户端实例，
+// 避免broker的回查请求被普通producer接收
+func (pc *Producer) startTransactionProducer() {
+	if pc.TransactionGroup == pc.Group || pc.txListener == nil {
+		xlog.Jupiter().Panic("create transaction producer",
+			xlog.FieldName(pc.name),
+			xlog.FieldExtMessage(pc.ProducerConfig),
+			xlog.String("error", "transactionGroup must differ from group and transaction listener is required"),
+		)
+	}
+
+	client, err := rocketmq.NewTransactionProducer(pc.txListener,
+		producer.WithGroupName(pc.TransactionGroup),
+		producer.WithNameServer(pc.Addr),
+		producer.WithRetry(pc.Retry),
+		producer.WithInterceptor(pc.interceptors...),
+		producer.WithInstanceName(pc.InstanceName+"@transaction"),
+		producer.WithCredentials(primitive.Credentials{
+			AccessKey: pc.AccessKey,
+			SecretKey: pc.SecretKey,
+		}),
+	)
+	if err != nil {
+		xlog.Jupiter().Panic("create transaction producer",
+			xlog.FieldName(pc.name),
+			xlog.FieldExtMessage(pc.ProducerConfig),
+			xlog.Any("error", err),
+		)
+	}
+
+	if err := client.Start(); err != nil {
+		xlog.Jupiter().Panic("start transaction producer",
+			xlog.FieldName(pc.name),
+			xlog.FieldExtMessage(pc.ProducerConfig),
+			xlog.Any("error", err),
+		)
+	}
+	pc.txProducer = client
+}
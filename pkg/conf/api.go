@@ -16,6 +16,7 @@ package conf
 
 import (
 	"io"
+	"time"
 
 	"github.com/davecgh/go-spew/spew"
 )
@@ -34,6 +35,31 @@ func OnLoaded(fn func(*Configuration)) {
 	defaultConfiguration.OnLoaded(fn)
 }
 
+// RegisterValidation 注册key对应配置的校验规则, 热更新后校验失败时拒绝本次更新
+func RegisterValidation(key string, newConfig func() interface{}, opts ...GetOption) {
+	defaultConfiguration.RegisterValidation(key, newConfig, opts...)
+}
+
+// SetDecryptor sets decryptor of ENC(...) with default defaultConfiguration
+func SetDecryptor(decryptor Decryptor) {
+	defaultConfiguration.SetDecryptor(decryptor)
+}
+
+// AddSecretProvider adds provider of ${secret:name} with default defaultConfiguration
+func AddSecretProvider(provider SecretProvider) {
+	defaultConfiguration.AddSecretProvider(provider)
+}
+
+// SetSecretCacheTTL sets cache ttl of secrets from providers with default defaultConfiguration
+func SetSecretCacheTTL(ttl time.Duration) {
+	defaultConfiguration.SetSecretCacheTTL(ttl)
+}
+
+// Watch 监听prefix下的配置变化, 回调参数为发生变化的key变更前后的值
+func Watch(prefix string, fn func(old, new map[string]interface{})) {
+	defaultConfiguration.Watch(prefix, fn)
+}
+
 // LoadFromDataSource load configuration from data source
 // if data source supports dynamic config, a monitor goroutinue
 // would be
@@ -41,6 +67,11 @@ func LoadFromDataSource(ds DataSource, unmarshaller Unmarshaller) error {
 	return defaultConfiguration.LoadFromDataSource(ds, unmarshaller)
 }
 
+// LoadFromLayers load configuration from layers in order, the later layer takes precedence
+func LoadFromLayers(layers ...Layer) error {
+	return defaultConfiguration.LoadFromLayers(layers...)
+}
+
 // Load loads configuration from provided provider with default defaultConfiguration.
 func LoadFromReader(r io.Reader, unmarshaller Unmarshaller) error {
 	return defaultConfiguration.LoadFromReader(r, unmarshaller)
@@ -56,9 +87,46 @@ func Reset() {
 	defaultConfiguration = New()
 }
 
-// Traverse ...
+// Traverse returns all leaf keys and values, encrypted values are masked
 func Traverse(sep string) map[string]interface{} {
-	return defaultConfiguration.traverse(sep)
+	defaultConfiguration.mu.RLock()
+	defer defaultConfiguration.mu.RUnlock()
+
+	data := defaultConfiguration.traverse(sep)
+	for k, v := range data {
+		data[k] = mask(v)
+	}
+	return data
+}
+
+// Layers returns layer names of default defaultConfiguration from low to high precedence
+func Layers() []string {
+	return defaultConfiguration.Layers()
+}
+
+// Explain returns which layer supplied the key
+func Explain(key string) []Explanation {
+	return defaultConfiguration.Explain(key)
+}
+
+// SetHistorySize sets the number of snapshots kept by default defaultConfiguration
+func SetHistorySize(size int) {
+	defaultConfiguration.SetHistorySize(size)
+}
+
+// History returns config snapshots of default defaultConfiguration from old to new
+func History() []Snapshot {
+	return defaultConfiguration.History()
+}
+
+// Diff returns changes between two snapshot versions of default defaultConfiguration
+func Diff(from, to int64) ([]Change, error) {
+	return defaultConfiguration.Diff(from, to)
+}
+
+// Rollback rolls default defaultConfiguration back to snapshot version
+func Rollback(version int64) error {
+	return defaultConfiguration.Rollback(version)
 }
 
 // Debug ...
@@ -73,7 +141,7 @@ func Get(key string) interface{} {
 
 // Exists returns whether key exists
 func Exists(key string) bool {
-	return defaultConfiguration.Get(key) != nil
+	return defaultConfiguration.find(key) != nil
 }
 
 // Set set config value for key
@@ -37,12 +37,25 @@ type Configuration struct {
 	mu       sync.RWMutex
 	override map[string]interface{}
 	keyDelim string
+	// layers 按优先级从低到高排列, override为各层合并后的结果
+	layers []*layer
+	// stopWatches 各层数据源监听的停止函数, 同名的层再次加载时停止原有监听
+	stopWatches map[string]func()
 
 	keyMap    *sync.Map
 	onChanges []func(*Configuration)
 	onLoadeds []func(*Configuration)
 
-	watchers map[string][]func(*Configuration)
+	// history 最近的配置快照, 按版本从旧到新排列
+	history     []*Snapshot
+	historySize int
+	version     int64
+	// secrets 解析配置中的加密值
+	secrets *secretResolver
+	// schemas 热更新时需要校验的配置
+	schemas map[string]schema
+	// watchers 按key前缀注册的监听, 注册时整体替换以便通知时无锁读取
+	watchers map[string][]watcher
 	// TODO: concurrency protect
 	loaded bool
 }
@@ -54,13 +67,15 @@ const (
 // New constructs a new Configuration with provider.
 func New() *Configuration {
 	return &Configuration{
-		override:  make(map[string]interface{}),
-		keyDelim:  defaultKeyDelim,
-		keyMap:    &sync.Map{},
-		onChanges: make([]func(*Configuration), 0),
-		onLoadeds: make([]func(*Configuration), 0),
-		watchers:  make(map[string][]func(*Configuration)),
-		loaded:    false,
+		override:    make(map[string]interface{}),
+		keyDelim:    defaultKeyDelim,
+		keyMap:      &sync.Map{},
+		secrets:     newSecretResolver(),
+		historySize: DefaultHistorySize,
+		onChanges:   make([]func(*Configuration), 0),
+		onLoadeds:   make([]func(*Configuration), 0),
+		watchers:    make(map[string][]watcher),
+		loaded:      false,
 	}
 }
 
@@ -74,6 +89,7 @@ func (c *Configuration) Sub(key string) *Configuration {
 	return &Configuration{
 		keyDelim: c.keyDelim,
 		override: c.GetStringMap(key),
+		secrets:  c.secrets,
 	}
 }
 
@@ -99,66 +115,53 @@ func (c *Configuration) OnLoaded(fn func(*Configuration)) {
 
 // LoadEnvironments reads os environments with prefix such as APP_
 // PREFIX_FIELD1_FIELD2 will be translated into prefix.field1.field2
+// 环境变量作为独立的一层, 优先级高于所有数据源
 func (c *Configuration) LoadEnvironments(prefix string) {
+	data := make(map[string]interface{})
 	for _, env := range os.Environ() {
 		if !strings.HasPrefix(env, prefix) {
 			continue
 		}
-		key := strings.ToLower(strings.ReplaceAll(env, "_", "."))
-		val := os.Getenv(env)
-		_ = c.Set(key, val)
+		name, val, _ := strings.Cut(env, "=")
+		paths := strings.Split(strings.ToLower(strings.ReplaceAll(name, "_", c.keyDelim)), c.keyDelim)
+		deepSearch(data, paths[:len(paths)-1])[paths[len(paths)-1]] = val
 	}
+	_ = c.updateLayer(LayerEnv, rankEnv, func(env map[string]interface{}) {
+		for k := range env {
+			delete(env, k)
+		}
+		mergeLayer(env, data)
+	})
 }
 
 // LoadFromDataSource ...
 func (c *Configuration) LoadFromDataSource(ds DataSource, unmarshaller Unmarshaller) error {
-	content, err := ds.ReadConfig()
-	if err != nil {
-		return err
-	}
-
-	if err := c.Load(content, unmarshaller); err != nil {
-		return err
-	}
-	if ds.IsConfigChanged() != nil {
-		go func() {
-			for range ds.IsConfigChanged() {
-				if content, err := ds.ReadConfig(); err == nil {
-					_ = c.reflush(content, unmarshaller)
-					for _, change := range c.onChanges {
-						change(c)
-					}
-				}
-			}
-		}()
-	}
-
-	return nil
+	return c.LoadFromLayers(Layer{DataSource: ds, Unmarshaller: unmarshaller})
 }
 
-func (c *Configuration) reflush(content []byte, unmarshal Unmarshaller) error {
+// reflush 用content替换数据源层name的数据
+func (c *Configuration) reflush(name string, content []byte, unmarshal Unmarshaller) error {
 	configuration := make(map[string]interface{})
 	if err := unmarshal(content, &configuration); err != nil {
 		return err
 	}
-	if err := c.apply(configuration); err != nil {
-		return err
-	}
 
-	return nil
+	return c.updateLayer(name, rankSource, func(data map[string]interface{}) {
+		for k := range data {
+			delete(data, k)
+		}
+		mergeLayer(data, configuration)
+	})
 }
 
-// Load ...
+// Load 用content替换LayerSource层的数据
 func (c *Configuration) Load(content []byte, unmarshal Unmarshaller) error {
-	if err := c.reflush(content, unmarshal); err != nil {
+	c.replaceWatch(LayerSource, nil)
+	if err := c.reflush(LayerSource, content, unmarshal); err != nil {
 		return err
 	}
 
-	log.Print("load config successfully")
-	c.loaded = true
-	for _, loadHook := range c.onLoadeds {
-		loadHook(c)
-	}
+	c.markLoaded()
 	return nil
 }
 
@@ -171,55 +174,59 @@ func (c *Configuration) LoadFromReader(reader io.Reader, unmarshaller Unmarshall
 	return c.Load(content, unmarshaller)
 }
 
+// apply 将conf合并到覆盖层
 func (c *Configuration) apply(conf map[string]interface{}) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	var changes = make(map[string]interface{})
-
-	xmap.MergeStringMap(c.override, conf)
-	for k, v := range c.traverse(c.keyDelim) {
-		orig, ok := c.keyMap.Load(k)
-		if ok && !reflect.DeepEqual(orig, v) {
-			changes[k] = v
+	return c.updateLayer(LayerOverride, rankOverride, func(data map[string]interface{}) {
+		mergeLayer(data, conf)
+	})
+}
+
+// diffKeys 返回新增、修改及删除的key
+func diffKeys(old, new map[string]interface{}, sep string) []string {
+	var (
+		changes   []string
+		oldLeaves = make(map[string]interface{})
+		newLeaves = make(map[string]interface{})
+	)
+	lookup("", old, oldLeaves, sep)
+	lookup("", new, newLeaves, sep)
+
+	for k, v := range newLeaves {
+		if orig, ok := oldLeaves[k]; !ok || !reflect.DeepEqual(orig, v) {
+			changes = append(changes, k)
 		}
-		c.keyMap.Store(k, v)
 	}
-
-	if len(changes) > 0 {
-		c.notifyChanges(changes)
+	for k := range oldLeaves {
+		if _, ok := newLeaves[k]; !ok {
+			changes = append(changes, k)
+		}
 	}
-
-	return nil
+	return changes
 }
 
-func (c *Configuration) notifyChanges(changes map[string]interface{}) {
-	var changedWatchPrefixMap = map[string]struct{}{}
-
-	for watchPrefix := range c.watchers {
-		for key := range changes {
-			// 前缀匹配即可
-			// todo 可能产生错误匹配
-			if strings.HasPrefix(key, watchPrefix) {
-				changedWatchPrefixMap[watchPrefix] = struct{}{}
-			}
-		}
+// refreshKeys 依据合并后的配置刷新keyMap, 调用方需持有写锁
+func (c *Configuration) refreshKeys() {
+	leaves := c.traverse(c.keyDelim)
+	for k, v := range leaves {
+		c.keyMap.Store(k, v)
 	}
-
-	for changedWatchPrefix := range changedWatchPrefixMap {
-		for _, handle := range c.watchers[changedWatchPrefix] {
-			go handle(c)
+	// 已删除的key及非叶子节点的缓存可能已过期, 交由find重新查找
+	c.keyMap.Range(func(k, _ interface{}) bool {
+		if _, ok := leaves[k.(string)]; !ok {
+			c.keyMap.Delete(k)
 		}
-	}
+		return true
+	})
 }
 
 // Set ...
 func (c *Configuration) Set(key string, val interface{}) error {
 	paths := strings.Split(key, c.keyDelim)
 	lastKey := paths[len(paths)-1]
-	m := deepSearch(c.override, paths[:len(paths)-1])
-	m[lastKey] = val
-	return c.apply(m)
-	// c.keyMap.Store(key, val)
+	return c.updateLayer(LayerOverride, rankOverride, func(data map[string]interface{}) {
+		m := deepSearch(data, paths[:len(paths)-1])
+		m[lastKey] = val
+	})
 }
 
 func deepSearch(m map[string]interface{}, path []string) map[string]interface{} {
@@ -241,9 +248,32 @@ func deepSearch(m map[string]interface{}, path []string) map[string]interface{}
 	return m
 }
 
-// Get returns the value associated with the key
+// Get returns the value associated with the key, 加密值会被解密, 解密失败时返回nil
 func (c *Configuration) Get(key string) interface{} {
-	return c.find(key)
+	value, err := c.GetE(key)
+	if err != nil {
+		log.Printf("resolve secret of key[%s] failed: %v", key, err)
+		return nil
+	}
+	return value
+}
+
+// GetE returns the value associated with the key with default defaultConfiguration.
+func GetE(key string) (interface{}, error) {
+	return defaultConfiguration.GetE(key)
+}
+
+// GetE returns the value associated with the key, 加密值解密失败时返回错误
+func (c *Configuration) GetE(key string) (interface{}, error) {
+	value, err := c.get(key)
+	if err != nil {
+		return nil, errors.Wrap(err, key)
+	}
+	return value, nil
+}
+
+func (c *Configuration) get(key string) (interface{}, error) {
+	return c.secrets.resolve(c.find(key))
 }
 
 // GetString returns the value associated with the key as a string with default defaultConfiguration.
@@ -400,33 +430,55 @@ var ErrInvalidKey = errors.New("invalid key, maybe not exist in config")
 
 // UnmarshalKey takes a single key and unmarshal it into a Struct.
 func (c *Configuration) UnmarshalKey(key string, rawVal interface{}, opts ...GetOption) error {
+	opts = append(opts, func(o *GetOptions) { o.key = key })
+	if key == "" {
+		c.mu.RLock()
+		defer c.mu.RUnlock()
+		value, err := c.secrets.resolve(c.override)
+		if err != nil {
+			return err
+		}
+		return decode(value, rawVal, opts...)
+	}
+
+	value, err := c.get(key)
+	if err != nil {
+		return errors.Wrap(err, key)
+	}
+	if value == nil {
+		return errors.Wrap(ErrInvalidKey, key)
+	}
+
+	return decode(value, rawVal, opts...)
+}
+
+func decode(value interface{}, rawVal interface{}, opts ...GetOption) error {
 	var options = defaultGetOptions
 	for _, opt := range opts {
 		opt(&options)
 	}
 
+	var metadata mapstructure.Metadata
 	config := mapstructure.DecoderConfig{
 		DecodeHook: mapstructure.StringToTimeDurationHookFunc(),
 		Result:     rawVal,
 		TagName:    options.TagName,
 		Squash:     true,
 	}
+	if options.Strict {
+		config.Metadata = &metadata
+	}
 	decoder, err := mapstructure.NewDecoder(&config)
 	if err != nil {
 		return err
 	}
-	if key == "" {
-		c.mu.RLock()
-		defer c.mu.RUnlock()
-		return decoder.Decode(c.override)
+	if err := decoder.Decode(value); err != nil {
+		return err
 	}
-
-	value := c.Get(key)
-	if value == nil {
-		return errors.Wrap(ErrInvalidKey, key)
+	if options.Strict {
+		return validateDecoded(options.key, rawVal, metadata.Unused, options.TagName)
 	}
-
-	return decoder.Decode(value)
+	return nil
 }
 
 func (c *Configuration) find(key string) interface{} {
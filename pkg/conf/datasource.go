@@ -11,13 +11,17 @@ var (
 	ErrConfigAddr = errors.New("no config... ")
 	// ErrInvalidDataSource defines an error that the scheme has been registered
 	ErrInvalidDataSource = errors.New("invalid data source, please make sure the scheme has been registered")
-	datasourceBuilders   = make(map[string]DataSourceCreatorFunc)
+	datasourceBuilders   = make(map[string]DataSourceBuilderFunc)
 	// configDecoder        = make(map[string]Unmarshaller)
 )
 
 // DataSourceCreatorFunc represents a dataSource creator function
 type DataSourceCreatorFunc func() DataSource
 
+// DataSourceBuilderFunc represents a dataSource builder function with config address,
+// 多个配置层同时使用同一scheme时, 各层依据自己的地址构建数据源
+type DataSourceBuilderFunc func(configAddr string) DataSource
+
 // DataSource ...
 type DataSource interface {
 	ReadConfig() ([]byte, error)
@@ -27,7 +31,14 @@ type DataSource interface {
 
 // Register registers a dataSource creator function to the registry
 func Register(scheme string, creator DataSourceCreatorFunc) {
-	datasourceBuilders[scheme] = creator
+	datasourceBuilders[scheme] = func(string) DataSource {
+		return creator()
+	}
+}
+
+// RegisterBuilder registers a dataSource builder function to the registry
+func RegisterBuilder(scheme string, builder DataSourceBuilderFunc) {
+	datasourceBuilders[scheme] = builder
 }
 
 // CreateDataSource creates a dataSource witch has been registered
@@ -54,9 +65,9 @@ func NewDataSource(configAddr string) (DataSource, error) {
 		scheme = "file"
 	}
 
-	creatorFunc, exist := datasourceBuilders[scheme]
+	builderFunc, exist := datasourceBuilders[scheme]
 	if !exist {
 		return nil, ErrInvalidDataSource
 	}
-	return creatorFunc(), nil
+	return builderFunc(configAddr), nil
 }
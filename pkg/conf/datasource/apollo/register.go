@@ -5,7 +5,6 @@ import (
 
 	"github.com/philchia/agollo/v4"
 	"github.com/zhengyansheng/jupiter/pkg/conf"
-	"github.com/zhengyansheng/jupiter/pkg/flag"
 	"github.com/zhengyansheng/jupiter/pkg/xlog"
 )
 
@@ -13,10 +12,7 @@ import (
 const DataSourceApollo = "apollo"
 
 func init() {
-	conf.Register(DataSourceApollo, func() conf.DataSource {
-		var (
-			configAddr = flag.String("config")
-		)
+	conf.RegisterBuilder(DataSourceApollo, func(configAddr string) conf.DataSource {
 		if configAddr == "" {
 			xlog.Jupiter().Panic("new apollo dataSource, configAddr is empty")
 			return nil
@@ -12,10 +12,9 @@ import (
 const DataSourceEtcdv3 = "etcdv3"
 
 func init() {
-	conf.Register(DataSourceEtcdv3, func() conf.DataSource {
+	conf.RegisterBuilder(DataSourceEtcdv3, func(configAddr string) conf.DataSource {
 		var (
-			configAddr = flag.String("config")
-			watch      = flag.Bool("watch")
+			watch = flag.Bool("watch")
 		)
 		if configAddr == "" {
 			xlog.Jupiter().Panic("new apollo dataSource, configAddr is empty")
@@ -10,10 +10,9 @@ import (
 const DataSourceFile = "file"
 
 func init() {
-	conf.Register(DataSourceFile, func() conf.DataSource {
+	conf.RegisterBuilder(DataSourceFile, func(configAddr string) conf.DataSource {
 		var (
 			watchConfig = flag.Bool("watch")
-			configAddr  = flag.String("config")
 		)
 		if configAddr == "" {
 			xlog.Jupiter().Panic("new file dataSource, configAddr is empty")
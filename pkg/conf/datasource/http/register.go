@@ -13,10 +13,9 @@ const (
 )
 
 func init() {
-	dataSourceCreator := func() conf.DataSource {
+	dataSourceCreator := func(configAddr string) conf.DataSource {
 		var (
 			watchConfig = flag.Bool("watch")
-			configAddr  = flag.String("config")
 		)
 		if configAddr == "" {
 			xlog.Jupiter().Panic("new http dataSource, configAddr is empty")
@@ -24,6 +23,6 @@ func init() {
 		}
 		return NewDataSource(configAddr, watchConfig)
 	}
-	conf.Register(DataSourceHttp, dataSourceCreator)
-	conf.Register(DataSourceHttps, dataSourceCreator)
+	conf.RegisterBuilder(DataSourceHttp, dataSourceCreator)
+	conf.RegisterBuilder(DataSourceHttps, dataSourceCreator)
 }
@@ -0,0 +1,169 @@
+// Copyright 2020 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conf
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrSnapshotNotFound 快照不存在或已被淘汰
+var ErrSnapshotNotFound = errors.New("config snapshot not found")
+
+// DefaultHistorySize 默认保留的快照数量
+const DefaultHistorySize = 20
+
+// 变更类型
+const (
+	ChangeAdd    = "add"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
+
+// Change 单个key的变更, 加密值会被屏蔽
+type Change struct {
+	Key  string      `json:"key"`
+	Type string      `json:"type"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+// Snapshot 每次配置变更后的快照
+type Snapshot struct {
+	Version int64 `json:"version"`
+	// Source 触发变更的层名称, 回滚时为rollback:版本号
+	Source    string    `json:"source"`
+	Timestamp time.Time `json:"timestamp"`
+	// Diff 相对上一次配置的变更
+	Diff []Change `json:"diff"`
+
+	layers   []*layer
+	override map[string]interface{}
+}
+
+// SetHistorySize 设置保留的快照数量, 超出后淘汰最早的快照, 小于1时使用DefaultHistorySize
+func (c *Configuration) SetHistorySize(size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if size < 1 {
+		size = DefaultHistorySize
+	}
+	c.historySize = size
+	c.trimHistory()
+}
+
+// History 返回保留的快照, 按版本从旧到新排列
+func (c *Configuration) History() []Snapshot {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	history := make([]Snapshot, 0, len(c.history))
+	for _, snapshot := range c.history {
+		history = append(history, *snapshot)
+	}
+	return history
+}
+
+// Diff 返回从版本from到版本to的变更
+func (c *Configuration) Diff(from, to int64) ([]Change, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	fromSnapshot, err := c.snapshot(from)
+	if err != nil {
+		return nil, err
+	}
+	toSnapshot, err := c.snapshot(to)
+	if err != nil {
+		return nil, err
+	}
+	return c.changes(fromSnapshot.override, toSnapshot.override, diffKeys(fromSnapshot.override, toSnapshot.override, c.keyDelim)), nil
+}
+
+// Rollback 将配置回滚到指定版本的快照, 并触发正常的变更回调,
+// 回滚仅在本地生效, 数据源再次推送变更时仍会覆盖对应的层
+func (c *Configuration) Rollback(version int64) error {
+	changed, err := c.commit(fmt.Sprintf("rollback:%d", version), func([]*layer) ([]*layer, error) {
+		snapshot, err := c.snapshot(version)
+		if err != nil {
+			return nil, err
+		}
+		return snapshot.layers, nil
+	})
+	if err != nil || !changed {
+		return err
+	}
+	for _, change := range c.onChanges {
+		change(c)
+	}
+	return nil
+}
+
+// record 记录快照, 调用方需持有写锁
+func (c *Configuration) record(source string, layers []*layer, old, new map[string]interface{}, keys []string) {
+	c.version++
+	c.history = append(c.history, &Snapshot{
+		Version:   c.version,
+		Source:    source,
+		Timestamp: time.Now(),
+		Diff:      c.changes(old, new, keys),
+		layers:    layers,
+		override:  new,
+	})
+	c.trimHistory()
+}
+
+func (c *Configuration) trimHistory() {
+	size := c.historySize
+	if size < 1 {
+		size = DefaultHistorySize
+	}
+	if len(c.history) > size {
+		c.history = append([]*Snapshot(nil), c.history[len(c.history)-size:]...)
+	}
+}
+
+func (c *Configuration) snapshot(version int64) (*Snapshot, error) {
+	for _, snapshot := range c.history {
+		if snapshot.Version == version {
+			return snapshot, nil
+		}
+	}
+	return nil, errors.Wrapf(ErrSnapshotNotFound, "version %d", version)
+}
+
+func (c *Configuration) changes(old, new map[string]interface{}, keys []string) []Change {
+	changes := make([]Change, 0, len(keys))
+	for _, key := range keys {
+		paths := strings.Split(key, c.keyDelim)
+		oldValue, oldOk := searchValue(old, paths)
+		newValue, newOk := searchValue(new, paths)
+		change := Change{Key: key, Type: ChangeUpdate, Old: mask(oldValue), New: mask(newValue)}
+		if !oldOk {
+			change.Type = ChangeAdd
+		} else if !newOk {
+			change.Type = ChangeDelete
+		}
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+	return changes
+}
@@ -0,0 +1,119 @@
+// Copyright 2020 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conf
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfiguration_History(t *testing.T) {
+	remote := newMemDataSource(`
+	[app]
+		name = "demo"
+		timeout = "1s"
+		password = "ENC(xxx)"
+	`)
+
+	c := New()
+	err := c.LoadFromLayers(Layer{Name: "remote", DataSource: remote, Unmarshaller: toml.Unmarshal})
+	assert.Nil(t, err)
+
+	changed := make(chan struct{}, 1)
+	c.OnChange(func(*Configuration) { changed <- struct{}{} })
+	remote.update(`
+	[app]
+		name = "demo"
+		timeout = "0s"
+		password = "ENC(yyy)"
+		debug = true
+	`)
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("change not notified")
+	}
+
+	history := c.History()
+	assert.Len(t, history, 2)
+	assert.Equal(t, int64(1), history[0].Version)
+	assert.Equal(t, "remote", history[0].Source)
+	assert.Equal(t, int64(2), history[1].Version)
+	assert.Equal(t, []Change{
+		{Key: "app.debug", Type: ChangeAdd, New: true},
+		{Key: "app.password", Type: ChangeUpdate, Old: MaskedValue, New: MaskedValue},
+		{Key: "app.timeout", Type: ChangeUpdate, Old: "1s", New: "0s"},
+	}, history[1].Diff)
+
+	t.Run("diff", func(t *testing.T) {
+		changes, err := c.Diff(2, 1)
+		assert.Nil(t, err)
+		assert.Equal(t, []Change{
+			{Key: "app.debug", Type: ChangeDelete, Old: true},
+			{Key: "app.password", Type: ChangeUpdate, Old: MaskedValue, New: MaskedValue},
+			{Key: "app.timeout", Type: ChangeUpdate, Old: "0s", New: "1s"},
+		}, changes)
+
+		_, err = c.Diff(1, 100)
+		assert.True(t, errors.Is(err, ErrSnapshotNotFound))
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		var timeouts []string
+		c.Watch("app.timeout", func(_, new map[string]interface{}) {
+			timeouts = append(timeouts, new["app.timeout"].(string))
+		})
+
+		assert.Nil(t, c.Rollback(1))
+		<-changed
+		assert.Equal(t, time.Second, c.GetDuration("app.timeout"))
+		assert.False(t, c.GetBool("app.debug"))
+		assert.Equal(t, []string{"1s"}, timeouts)
+
+		history := c.History()
+		assert.Len(t, history, 3)
+		assert.Equal(t, "rollback:1", history[2].Source)
+
+		// 回滚到与当前配置相同的快照不产生新版本
+		assert.Nil(t, c.Rollback(3))
+		assert.Len(t, c.History(), 3)
+	})
+
+	t.Run("bounded", func(t *testing.T) {
+		c.SetHistorySize(2)
+		history := c.History()
+		assert.Len(t, history, 2)
+		assert.Equal(t, int64(2), history[0].Version)
+		assert.True(t, errors.Is(c.Rollback(1), ErrSnapshotNotFound))
+
+		assert.Nil(t, c.Set("app.name", "demo2"))
+		history = c.History()
+		assert.Len(t, history, 2)
+		assert.Equal(t, int64(4), history[1].Version)
+		assert.Equal(t, LayerOverride, history[1].Source)
+
+		// 小于1时使用默认数量, 不会无限增长
+		c.SetHistorySize(0)
+		for i := 0; i < DefaultHistorySize+5; i++ {
+			assert.Nil(t, c.Set("app.name", fmt.Sprintf("demo%d", i)))
+		}
+		assert.Len(t, c.History(), DefaultHistorySize)
+	})
+}
@@ -16,9 +16,13 @@ package conf
 
 import (
 	"encoding/json"
+	stdflag "flag"
+	"fmt"
 	"log"
 	"net/url"
 	"path/filepath"
+	"strings"
+	"sync"
 
 	"github.com/BurntSushi/toml"
 	"github.com/zhengyansheng/jupiter/pkg/core/hooks"
@@ -29,55 +33,140 @@ import (
 const DefaultEnvPrefix = "APP_"
 
 func init() {
-	flag.Register(&flag.StringFlag{Name: "envPrefix", Usage: "--envPrefix=APP_", Default: DefaultEnvPrefix, Action: func(key string, fs *flag.FlagSet) {
-		var envPrefix = fs.String(key)
-		defaultConfiguration.LoadEnvironments(envPrefix)
+	flag.Register(&flag.StringFlag{Name: "envPrefix", Usage: "--envPrefix=APP_", Default: DefaultEnvPrefix, Action: loadFlags})
+	flag.Register(&flag.StringFlag{Name: "config", Usage: "--config=config.toml, 多个配置以逗号分隔, 越靠后优先级越高", Action: loadFlags})
+	flag.Register(&flag.StringFlag{Name: "config-set", Usage: "--config-set=app.mode=dev,app.name=demo, 命令行覆盖配置, 优先级最高", Action: loadFlags})
+	flag.Register(&flag.StringFlag{Name: "config-secret-key", Usage: "--config-secret-key=secret.key, 解密ENC(...)配置的AES密钥文件, 内容为base64编码的密钥", EnvVar: SecretKeyEnv, Action: loadFlags})
+	flag.Register(&flag.StringFlag{Name: "config-tag", Usage: "--config-tag=mapstructure", Default: "mapstructure", Action: loadFlags})
+	flag.Register(&flag.StringFlag{Name: "config-namespace", Usage: "--config-namespace=jupiter, 配置内建组件的默认命名空间, 默认是jupiter", Default: "jupiter", Action: loadFlags})
+
+	flag.Register(&flag.BoolFlag{Name: "watch", Usage: "--watch, watch config change event", Default: false, EnvVar: "JUPITER_CONFIG_WATCH", Action: func(key string, fs *flag.FlagSet) {
+		log.Printf("load config watch: %v", fs.Bool(key))
 	}})
+}
+
+// flagOptions 命令行中与加载配置相关的参数
+type flagOptions struct {
+	envPrefix string
+	configs   string
+	sets      string
+	secretKey string
+	tagName   string
+	namespace string
+}
+
+var loadFlagsOnce sync.Once
 
-	flag.Register(&flag.StringFlag{Name: "config", Usage: "--config=config.toml", Action: func(key string, fs *flag.FlagSet) {
+// loadFlags 配置相关参数共用的Action, FlagSet按参数名的顺序执行Action,
+// 因此在第一个Action中统一读取所有参数后再加载, 之后的Action不再处理
+func loadFlags(_ string, fs *flag.FlagSet) {
+	loadFlagsOnce.Do(func() {
+		set := make(map[string]bool)
+		fs.Visit(func(f *stdflag.Flag) {
+			set[f.Name] = true
+		})
+		lookup := func(name string) string {
+			if !set[name] {
+				return ""
+			}
+			return fs.String(name)
+		}
+		opts := flagOptions{
+			envPrefix: lookup("envPrefix"),
+			configs:   lookup("config"),
+			sets:      lookup("config-set"),
+			// 未在命令行中指定时从环境变量中读取
+			secretKey: fs.String("config-secret-key"),
+			tagName:   lookup("config-tag"),
+			namespace: lookup("config-namespace"),
+		}
+		if err := loadFromFlags(defaultConfiguration, opts); err != nil {
+			log.Fatal(err)
+		}
+	})
+}
+
+// loadFromFlags 依次设置解密器及解析选项、加载环境变量及命令行覆盖, 最后加载配置层,
+// 保证OnLoaded及AfterLoadConfig的回调能够读取到完整的配置
+func loadFromFlags(c *Configuration, opts flagOptions) error {
+	if opts.configs != "" {
 		hooks.Do(hooks.Stage_BeforeLoadConfig)
+	}
 
-		var configAddr = fs.String(key)
-		log.Printf("read config: %s", configAddr)
-		datasource, err := NewDataSource(configAddr)
+	if opts.secretKey != "" {
+		decryptor, err := NewAESKeyFileDecryptor(opts.secretKey)
 		if err != nil {
-			log.Fatalf("build datasource[%s] failed: %v", configAddr, err)
+			return fmt.Errorf("load config secret key failed: %w", err)
 		}
-
-		path := configAddr
-		if uri, err := url.ParseRequestURI(configAddr); err == nil {
-			path = uri.Path
+		c.SetDecryptor(decryptor)
+	}
+	if opts.tagName != "" {
+		defaultGetOptions.TagName = opts.tagName
+	}
+	if opts.namespace != "" {
+		defaultGetOptions.Namespace = opts.namespace
+	}
+	if opts.envPrefix != "" {
+		c.LoadEnvironments(opts.envPrefix)
+	}
+	// 覆盖层的优先级始终最高, 先于配置层写入
+	if opts.sets != "" {
+		for _, pair := range strings.Split(opts.sets, ",") {
+			k, v, ok := strings.Cut(pair, "=")
+			if !ok || strings.TrimSpace(k) == "" {
+				return fmt.Errorf("invalid config-set: %s", pair)
+			}
+			if err := c.Set(strings.TrimSpace(k), strings.TrimSpace(v)); err != nil {
+				return fmt.Errorf("config-set[%s] failed: %w", pair, err)
+			}
 		}
+	}
 
-		unmarshaler := toml.Unmarshal
-		switch filepath.Ext(path) {
-		case ".toml":
-			// default config type
-		case ".yaml", ".yml":
-			unmarshaler = yaml.Unmarshal
-		case ".json":
-			unmarshaler = json.Unmarshal
-		default:
-			log.Fatalf("unsupported config type: %s", filepath.Ext(configAddr))
+	if opts.configs == "" {
+		return nil
+	}
+	log.Printf("read config: %s", opts.configs)
+	var layers []Layer
+	for _, configAddr := range strings.Split(opts.configs, ",") {
+		configAddr = strings.TrimSpace(configAddr)
+		if configAddr == "" {
+			continue
 		}
-
-		if err := LoadFromDataSource(datasource, unmarshaler); err != nil {
-			log.Fatalf("load config from datasource[%s] failed: %v", configAddr, err)
+		datasource, err := NewDataSource(configAddr)
+		if err != nil {
+			return fmt.Errorf("build datasource[%s] failed: %w", configAddr, err)
 		}
-		log.Printf("load config from datasource[%s] completely!", configAddr)
+		unmarshaler, err := unmarshallerOf(configAddr)
+		if err != nil {
+			return fmt.Errorf("build datasource[%s] failed: %w", configAddr, err)
+		}
+		layers = append(layers, Layer{Name: configAddr, DataSource: datasource, Unmarshaller: unmarshaler})
+	}
 
-		hooks.Do(hooks.Stage_AfterLoadConfig)
-	}})
+	if err := c.LoadFromLayers(layers...); err != nil {
+		return fmt.Errorf("load config from datasource[%s] failed: %w", opts.configs, err)
+	}
+	log.Printf("load config from datasource[%s] completely!", opts.configs)
 
-	flag.Register(&flag.StringFlag{Name: "config-tag", Usage: "--config-tag=mapstructure", Default: "mapstructure", Action: func(key string, fs *flag.FlagSet) {
-		defaultGetOptions.TagName = fs.String("config-tag")
-	}})
+	hooks.Do(hooks.Stage_AfterLoadConfig)
+	return nil
+}
 
-	flag.Register(&flag.StringFlag{Name: "config-namespace", Usage: "--config-namespace=jupiter, 配置内建组件的默认命名空间, 默认是jupiter", Default: "jupiter", Action: func(key string, fs *flag.FlagSet) {
-		defaultGetOptions.Namespace = fs.String("config-namespace")
-	}})
+// unmarshallerOf 根据配置地址的扩展名选择解析方式
+func unmarshallerOf(configAddr string) (Unmarshaller, error) {
+	path := configAddr
+	if uri, err := url.ParseRequestURI(configAddr); err == nil {
+		path = uri.Path
+	}
 
-	flag.Register(&flag.BoolFlag{Name: "watch", Usage: "--watch, watch config change event", Default: false, EnvVar: "JUPITER_CONFIG_WATCH", Action: func(key string, fs *flag.FlagSet) {
-		log.Printf("load config watch: %v", fs.Bool(key))
-	}})
+	switch filepath.Ext(path) {
+	case ".toml":
+		return toml.Unmarshal, nil
+	case ".yaml", ".yml":
+		return yaml.Unmarshal, nil
+	case ".json":
+		return json.Unmarshal, nil
+	default:
+		return nil, fmt.Errorf("unsupported config type: %s", filepath.Ext(path))
+	}
 }
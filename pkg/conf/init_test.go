@@ -0,0 +1,63 @@
+// Copyright 2020 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conf
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zhengyansheng/jupiter/pkg/core/hooks"
+)
+
+func Test_loadFromFlags(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	keyFile := filepath.Join(t.TempDir(), "secret.key")
+	assert.Nil(t, os.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(key)), 0600))
+	password, err := EncryptAES(key, "redis-password")
+	assert.Nil(t, err)
+	RegisterBuilder("mem", func(string) DataSource {
+		return newMemDataSource(fmt.Sprintf(`
+		[app]
+			mode = "dev"
+			password = "%s"
+		`, password))
+	})
+
+	c := New()
+	var loadedMode, hookMode, hookPassword string
+	c.OnLoaded(func(c *Configuration) {
+		loadedMode = c.GetString("app.mode")
+	})
+	hooks.Register(hooks.Stage_AfterLoadConfig, func() {
+		hookMode = c.GetString("app.mode")
+		hookPassword = c.GetString("app.password")
+	})
+
+	// 命令行覆盖及解密器在AfterLoadConfig之前生效
+	assert.Nil(t, loadFromFlags(c, flagOptions{
+		configs:   "mem://config/app.toml",
+		sets:      "app.mode=prod",
+		secretKey: keyFile,
+	}))
+	assert.Equal(t, "prod", loadedMode)
+	assert.Equal(t, "prod", hookMode)
+	assert.Equal(t, "redis-password", hookPassword)
+
+	assert.NotNil(t, loadFromFlags(New(), flagOptions{sets: "app.mode"}))
+}
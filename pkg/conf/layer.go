@@ -0,0 +1,307 @@
+// Copyright 2020 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conf
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/zhengyansheng/jupiter/pkg/util/xmap"
+)
+
+const (
+	// LayerSource 未指定名称的数据源共用的层名称, 再次加载时替换该层
+	LayerSource = "source"
+	// LayerEnv 环境变量层的名称
+	LayerEnv = "env"
+	// LayerOverride 命令行及Set/Apply写入的覆盖层名称, 优先级最高
+	LayerOverride = "override"
+)
+
+// 层的优先级分段, 同一分段内越晚加入的层优先级越高
+const (
+	rankSource = iota
+	rankEnv
+	rankOverride
+)
+
+// Layer 配置层, 每一层拥有独立的数据源、解析方式与监听
+type Layer struct {
+	// Name 层名称, 同名的层会被替换, 为空时使用LayerSource
+	Name         string
+	DataSource   DataSource
+	Unmarshaller Unmarshaller
+}
+
+// LayerValue 某一层中某个key的值
+type LayerValue struct {
+	Layer string      `json:"layer"`
+	Value interface{} `json:"value"`
+}
+
+// Explanation 说明某个key的最终取值来自哪一层
+type Explanation struct {
+	Key   string      `json:"key"`
+	Value interface{} `json:"value"`
+	Layer string      `json:"layer"`
+	// Layers 提供了该key的所有层, 按优先级从低到高排列
+	Layers []LayerValue `json:"layers"`
+}
+
+type layer struct {
+	name string
+	rank int
+	data map[string]interface{}
+}
+
+// LoadFromLayers 按顺序加载多个配置层, 越靠后的层优先级越高,
+// 数据源层的优先级始终低于环境变量层与覆盖层
+func (c *Configuration) LoadFromLayers(layers ...Layer) error {
+	for _, l := range layers {
+		if l.Name == "" {
+			l.Name = LayerSource
+		}
+		if err := c.loadLayer(l); err != nil {
+			return fmt.Errorf("load layer[%s]: %w", l.Name, err)
+		}
+	}
+
+	c.markLoaded()
+	return nil
+}
+
+// markLoaded 首次加载完成时执行OnLoaded回调, 之后的加载通过OnChange及Watch通知
+func (c *Configuration) markLoaded() {
+	log.Print("load config successfully")
+	if c.loaded {
+		return
+	}
+	c.loaded = true
+	for _, loadHook := range c.onLoadeds {
+		loadHook(c)
+	}
+}
+
+// loadLayer 加载数据源层, 同名的层原有的数据源监听会被停止, 避免其变更覆盖新的数据
+func (c *Configuration) loadLayer(l Layer) error {
+	c.replaceWatch(l.Name, nil)
+	content, err := l.DataSource.ReadConfig()
+	if err != nil {
+		return err
+	}
+	if err := c.reflush(l.Name, content, l.Unmarshaller); err != nil {
+		return err
+	}
+
+	changed := l.DataSource.IsConfigChanged()
+	if changed == nil {
+		return nil
+	}
+	done, exited := make(chan struct{}), make(chan struct{})
+	c.replaceWatch(l.Name, func() {
+		close(done)
+		<-exited
+	})
+	go func() {
+		defer close(exited)
+		for {
+			select {
+			case <-done:
+				return
+			case _, ok := <-changed:
+				if !ok {
+					return
+				}
+				content, err := l.DataSource.ReadConfig()
+				if err != nil {
+					continue
+				}
+				// 校验失败时拒绝本次更新, 保留原有配置
+				if err := c.reflush(l.Name, content, l.Unmarshaller); err != nil {
+					log.Printf("reload config layer[%s] rejected: %v", l.Name, err)
+					continue
+				}
+				for _, change := range c.onChanges {
+					change(c)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// replaceWatch 停止层name原有的数据源监听并等待其退出, 记录新的停止函数, stop为nil时表示该层不再监听
+func (c *Configuration) replaceWatch(name string, stop func()) {
+	c.mu.Lock()
+	old := c.stopWatches[name]
+	delete(c.stopWatches, name)
+	if stop != nil {
+		if c.stopWatches == nil {
+			c.stopWatches = make(map[string]func())
+		}
+		c.stopWatches[name] = stop
+	}
+	c.mu.Unlock()
+
+	// 监听中的更新需要持有锁, 在释放锁之后等待
+	if old != nil {
+		old()
+	}
+}
+
+// Layers 返回当前所有层的名称, 按优先级从低到高排列
+func (c *Configuration) Layers() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	names := make([]string, 0, len(c.layers))
+	for _, l := range c.layers {
+		names = append(names, l.name)
+	}
+	return names
+}
+
+// Explain 说明key的取值来源, key为非叶子节点时说明其下所有叶子节点, 加密值会被屏蔽
+func (c *Configuration) Explain(key string) []Explanation {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var (
+		values  = make(map[string][]LayerValue)
+		merged  = make(map[string]interface{})
+		visible = func(k string) bool {
+			return key == "" || k == key || strings.HasPrefix(k, key+c.keyDelim)
+		}
+	)
+	lookup("", c.override, merged, c.keyDelim)
+	for _, l := range c.layers {
+		flat := make(map[string]interface{})
+		lookup("", l.data, flat, c.keyDelim)
+		for k, v := range flat {
+			if visible(k) {
+				values[k] = append(values[k], LayerValue{Layer: l.name, Value: v})
+			}
+		}
+	}
+
+	explanations := make([]Explanation, 0, len(values))
+	for k, lvs := range values {
+		for i := range lvs {
+			lvs[i].Value = mask(lvs[i].Value)
+		}
+		explanations = append(explanations, Explanation{
+			Key:    k,
+			Value:  mask(merged[k]),
+			Layer:  lvs[len(lvs)-1].Layer,
+			Layers: lvs,
+		})
+	}
+	sort.Slice(explanations, func(i, j int) bool {
+		return explanations[i].Key < explanations[j].Key
+	})
+	return explanations
+}
+
+// updateLayer 修改指定层的数据后重新合并所有层, 合并结果校验失败时保留原有配置
+func (c *Configuration) updateLayer(name string, rank int, update func(data map[string]interface{})) error {
+	_, err := c.commit(name, func(current []*layer) ([]*layer, error) {
+		var (
+			data   = make(map[string]interface{})
+			target = &layer{name: name, rank: rank, data: data}
+			layers = make([]*layer, 0, len(current)+1)
+			found  bool
+		)
+		// 层数据提交后不再修改, 更新时基于副本生成新的层
+		for _, l := range current {
+			if l.name == name {
+				mergeLayer(data, l.data)
+				target.rank = l.rank
+				layers = append(layers, target)
+				found = true
+				continue
+			}
+			layers = append(layers, l)
+		}
+		update(data)
+		if !found {
+			layers = append(layers, target)
+			sort.SliceStable(layers, func(i, j int) bool {
+				return layers[i].rank < layers[j].rank
+			})
+		}
+		return layers, nil
+	})
+	return err
+}
+
+// commit 合并build生成的层并替换当前配置, 记录快照后通知监听者, 返回配置是否发生变化
+func (c *Configuration) commit(source string, build func(current []*layer) ([]*layer, error)) (bool, error) {
+	c.mu.Lock()
+
+	layers, err := build(c.layers)
+	if err != nil {
+		c.mu.Unlock()
+		return false, err
+	}
+	override := make(map[string]interface{})
+	for _, l := range layers {
+		mergeLayer(override, l.data)
+	}
+	old := c.override
+	changes := diffKeys(old, override, c.keyDelim)
+	if err := c.validateChanges(override, changes); err != nil {
+		c.mu.Unlock()
+		return false, err
+	}
+	c.layers = layers
+	c.override = override
+	c.refreshKeys()
+	// 重新加载后从SecretProvider读取最新的秘密
+	c.secrets.reset()
+	if len(changes) > 0 {
+		c.record(source, layers, old, override, changes)
+	}
+	watchers := c.watchers
+	c.mu.Unlock()
+
+	// 回调中可能读取配置, 需在释放锁之后通知
+	if len(changes) > 0 {
+		c.notifyChanges(watchers, old, override, changes)
+	}
+	return len(changes) > 0, nil
+}
+
+// mergeLayer 将src深拷贝合并到dest, 类型不同时src直接覆盖dest
+func mergeLayer(dest, src map[string]interface{}) {
+	for k, v := range src {
+		switch sv := v.(type) {
+		case map[interface{}]interface{}:
+			v = xmap.ToMapStringInterface(sv)
+		}
+		sv, ok := v.(map[string]interface{})
+		if !ok {
+			dest[k] = v
+			continue
+		}
+		dv, ok := dest[k].(map[string]interface{})
+		if !ok {
+			dv = make(map[string]interface{})
+			dest[k] = dv
+		}
+		mergeLayer(dv, sv)
+	}
+}
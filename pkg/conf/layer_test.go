@@ -0,0 +1,186 @@
+// Copyright 2020 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conf
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/assert"
+)
+
+type memDataSource struct {
+	mu      sync.Mutex
+	content string
+	changed chan struct{}
+}
+
+func newMemDataSource(content string) *memDataSource {
+	return &memDataSource{content: content, changed: make(chan struct{}, 1)}
+}
+
+func (ds *memDataSource) ReadConfig() ([]byte, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	return []byte(ds.content), nil
+}
+
+func (ds *memDataSource) IsConfigChanged() <-chan struct{} {
+	return ds.changed
+}
+
+func (ds *memDataSource) Close() error {
+	return nil
+}
+
+func (ds *memDataSource) update(content string) {
+	ds.mu.Lock()
+	ds.content = content
+	ds.mu.Unlock()
+	ds.changed <- struct{}{}
+}
+
+func TestConfiguration_LoadFromLayers(t *testing.T) {
+	base := newMemDataSource(`
+	[app]
+		name = "base"
+		mode = "dev"
+		port = 8080
+	`)
+	prod := newMemDataSource(`
+	[app]
+		mode = "prod"
+	`)
+
+	c := New()
+	err := c.LoadFromLayers(
+		Layer{Name: "base", DataSource: base, Unmarshaller: toml.Unmarshal},
+		Layer{Name: "prod", DataSource: prod, Unmarshaller: toml.Unmarshal},
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"base", "prod"}, c.Layers())
+	assert.Equal(t, "base", c.GetString("app.name"))
+	assert.Equal(t, "prod", c.GetString("app.mode"))
+	assert.Equal(t, 8080, c.GetInt("app.port"))
+
+	t.Run("override", func(t *testing.T) {
+		assert.Nil(t, c.Set("app.port", 9090))
+		assert.Equal(t, 9090, c.GetInt("app.port"))
+		assert.Equal(t, []string{"base", "prod", LayerOverride}, c.Layers())
+	})
+
+	t.Run("reload lower layer", func(t *testing.T) {
+		changed := make(chan struct{}, 1)
+		c.OnChange(func(*Configuration) { changed <- struct{}{} })
+
+		base.update(`
+		[app]
+			name = "base2"
+			mode = "test"
+			port = 7070
+		`)
+		select {
+		case <-changed:
+		case <-time.After(time.Second):
+			t.Fatal("change not notified")
+		}
+		assert.Equal(t, "base2", c.GetString("app.name"))
+		// 高优先级层的值不受低优先级层重载影响
+		assert.Equal(t, "prod", c.GetString("app.mode"))
+		assert.Equal(t, 9090, c.GetInt("app.port"))
+
+		base.update(`
+		[app]
+			mode = "test"
+		`)
+		<-changed
+		assert.Nil(t, c.Get("app.name"))
+	})
+
+	t.Run("explain", func(t *testing.T) {
+		explanations := c.Explain("app.port")
+		assert.Len(t, explanations, 1)
+		assert.Equal(t, LayerOverride, explanations[0].Layer)
+		assert.Equal(t, 9090, explanations[0].Value)
+		assert.Equal(t, []LayerValue{{Layer: LayerOverride, Value: 9090}}, explanations[0].Layers)
+
+		explanations = c.Explain("app")
+		assert.Len(t, explanations, 2)
+		assert.Equal(t, "app.mode", explanations[0].Key)
+		assert.Equal(t, "prod", explanations[0].Layer)
+		assert.Equal(t, []LayerValue{
+			{Layer: "base", Value: "test"},
+			{Layer: "prod", Value: "prod"},
+		}, explanations[0].Layers)
+
+		assert.Empty(t, c.Explain("app.none"))
+	})
+}
+
+func TestConfiguration_LoadEnvironments(t *testing.T) {
+	t.Setenv("JUPITER_TEST_APP_MODE", "env")
+
+	c := New()
+	assert.Nil(t, c.LoadFromLayers(Layer{DataSource: newMemDataSource(`
+	[jupiter.test.app]
+		mode = "dev"
+		name = "demo"
+	`), Unmarshaller: toml.Unmarshal}))
+	c.LoadEnvironments("JUPITER_TEST_")
+
+	assert.Equal(t, "env", c.GetString("jupiter.test.app.mode"))
+	assert.Equal(t, "demo", c.GetString("jupiter.test.app.name"))
+	assert.Equal(t, LayerEnv, c.Explain("jupiter.test.app.mode")[0].Layer)
+	assert.Equal(t, LayerSource, c.Explain("jupiter.test.app.name")[0].Layer)
+
+	// 覆盖层优先级高于环境变量
+	assert.Nil(t, c.Set("jupiter.test.app.mode", "flag"))
+	assert.Equal(t, "flag", c.GetString("jupiter.test.app.mode"))
+}
+
+func TestConfiguration_ReloadLayer(t *testing.T) {
+	c := New()
+	var loaded int
+	c.OnLoaded(func(*Configuration) { loaded++ })
+
+	assert.Nil(t, c.Load([]byte(`a = 1`), toml.Unmarshal))
+	assert.Nil(t, c.Load([]byte(`b = 2`), toml.Unmarshal))
+	assert.Equal(t, []string{LayerSource}, c.Layers())
+	assert.Equal(t, 0, c.GetInt("a"))
+	assert.Equal(t, 2, c.GetInt("b"))
+	assert.Equal(t, 1, loaded)
+
+	// 同名的层再次加载时停止原有数据源的监听
+	old := newMemDataSource(`name = "old"`)
+	assert.Nil(t, c.LoadFromLayers(Layer{Name: "remote", DataSource: old, Unmarshaller: toml.Unmarshal}))
+	current := newMemDataSource(`name = "current"`)
+	assert.Nil(t, c.LoadFromLayers(Layer{Name: "remote", DataSource: current, Unmarshaller: toml.Unmarshal}))
+	assert.Equal(t, []string{LayerSource, "remote"}, c.Layers())
+	assert.Equal(t, 1, loaded)
+
+	changed := make(chan struct{}, 2)
+	c.OnChange(func(*Configuration) { changed <- struct{}{} })
+	old.update(`name = "stale"`)
+	current.update(`name = "fresh"`)
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("change not notified")
+	}
+	assert.Equal(t, "fresh", c.GetString("name"))
+	assert.Len(t, changed, 0)
+}
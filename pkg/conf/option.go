@@ -21,6 +21,9 @@ type (
 		TagName   string
 		Namespace string
 		Module    string
+		// Strict 严格模式, 解析后校验未知的key、validate标签及Validate方法
+		Strict bool
+		key    string
 	}
 )
 
@@ -65,3 +68,17 @@ func Module(module string) GetOption {
 		o.Module = module
 	}
 }
+
+// Strict 严格模式, 配置中存在未知的key或校验失败时返回ErrInvalidConfig
+func Strict() GetOption {
+	return func(o *GetOptions) {
+		o.Strict = true
+	}
+}
+
+func withStrict(key string) GetOption {
+	return func(o *GetOptions) {
+		o.Strict = true
+		o.key = key
+	}
+}
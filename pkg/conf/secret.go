@@ -0,0 +1,404 @@
+// Copyright 2020 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conf
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNoDecryptor 未设置解密器时无法解密ENC(...)
+	ErrNoDecryptor = errors.New("no decryptor for encrypted config value")
+	// ErrSecretNotFound 秘密不存在, 此时会继续尝试下一个SecretProvider
+	ErrSecretNotFound = errors.New("secret not found")
+
+	secretPattern = regexp.MustCompile(`\$\{secret:([^}]+)\}`)
+
+	// secretEnvPrefixes NewEnvSecretProvider使用的环境变量前缀, 在治理接口中屏蔽
+	secretEnvPrefixes = sync.Map{}
+)
+
+const (
+	// MaskedValue 加密配置在治理接口中展示的值
+	MaskedValue = "******"
+	// SecretKeyEnv 指定--config-secret-key的环境变量
+	SecretKeyEnv = "JUPITER_CONFIG_SECRET_KEY"
+	// DefaultSecretEnvPrefix NewEnvSecretProvider未指定前缀时使用的环境变量前缀
+	DefaultSecretEnvPrefix = "JUPITER_SECRET_"
+	// DefaultSecretCacheTTL SecretProvider查找结果的默认缓存时间
+	DefaultSecretCacheTTL = time.Minute
+)
+
+// Decryptor 解密形如ENC(ciphertext)的配置值
+type Decryptor interface {
+	Decrypt(ciphertext string) (string, error)
+}
+
+// SecretProvider 提供形如${secret:name}引用的秘密, 可嵌入在字符串中, 如dsn
+type SecretProvider interface {
+	Secret(name string) (string, error)
+}
+
+// VaultClient Vault风格的KV读取接口, 返回path下的所有字段
+type VaultClient interface {
+	Read(path string) (map[string]interface{}, error)
+}
+
+// secretResolver 解析配置中的加密值, 结果按原始值缓存,
+// ENC(...)的解密结果一直有效, SecretProvider的查找结果在ttl后失效以便读取到轮换后的秘密
+type secretResolver struct {
+	mu        sync.RWMutex
+	decryptor Decryptor
+	providers []SecretProvider
+	ttl       time.Duration
+	cache     *sync.Map
+}
+
+type secretEntry struct {
+	plain string
+	// expireAt 为零值时不失效
+	expireAt time.Time
+}
+
+func newSecretResolver() *secretResolver {
+	return &secretResolver{
+		ttl:   DefaultSecretCacheTTL,
+		cache: &sync.Map{},
+	}
+}
+
+// reset 清空缓存, 配置重新加载时调用
+func (r *secretResolver) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cache = &sync.Map{}
+}
+
+// SetDecryptor 设置ENC(...)的解密器
+func (c *Configuration) SetDecryptor(decryptor Decryptor) {
+	c.secrets.mu.Lock()
+	defer c.secrets.mu.Unlock()
+	c.secrets.decryptor = decryptor
+	c.secrets.cache = &sync.Map{}
+}
+
+// AddSecretProvider 添加${secret:name}的提供者, 按添加顺序查找, 默认没有提供者
+func (c *Configuration) AddSecretProvider(provider SecretProvider) {
+	c.secrets.mu.Lock()
+	defer c.secrets.mu.Unlock()
+	c.secrets.providers = append(c.secrets.providers, provider)
+	c.secrets.cache = &sync.Map{}
+}
+
+// SetSecretCacheTTL 设置SecretProvider查找结果的缓存时间, 小于等于0时不缓存
+func (c *Configuration) SetSecretCacheTTL(ttl time.Duration) {
+	c.secrets.mu.Lock()
+	defer c.secrets.mu.Unlock()
+	c.secrets.ttl = ttl
+	c.secrets.cache = &sync.Map{}
+}
+
+func isEncrypted(s string) bool {
+	return strings.HasPrefix(s, "ENC(") && strings.HasSuffix(s, ")")
+}
+
+func isSecret(s string) bool {
+	return isEncrypted(s) || secretPattern.MatchString(s)
+}
+
+// containsSecret 判断配置值中是否包含加密值
+func containsSecret(value interface{}) bool {
+	switch v := value.(type) {
+	case string:
+		return isSecret(v)
+	case map[string]interface{}:
+		for _, item := range v {
+			if containsSecret(item) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if containsSecret(item) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// mask 返回将加密值替换为MaskedValue后的副本
+func mask(value interface{}) interface{} {
+	if !containsSecret(value) {
+		return value
+	}
+	switch v := value.(type) {
+	case string:
+		return MaskedValue
+	case map[string]interface{}:
+		masked := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			masked[k] = mask(item)
+		}
+		return masked
+	case []interface{}:
+		masked := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			masked = append(masked, mask(item))
+		}
+		return masked
+	}
+	return value
+}
+
+// resolve 返回将加密值解密后的副本, 不包含加密值时原样返回
+func (r *secretResolver) resolve(value interface{}) (interface{}, error) {
+	if r == nil || !containsSecret(value) {
+		return value, nil
+	}
+	switch v := value.(type) {
+	case string:
+		return r.resolveString(v)
+	case map[string]interface{}:
+		resolved := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			val, err := r.resolve(item)
+			if err != nil {
+				return nil, err
+			}
+			resolved[k] = val
+		}
+		return resolved, nil
+	case []interface{}:
+		resolved := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			val, err := r.resolve(item)
+			if err != nil {
+				return nil, err
+			}
+			resolved = append(resolved, val)
+		}
+		return resolved, nil
+	}
+	return value, nil
+}
+
+func (r *secretResolver) resolveString(s string) (string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if val, ok := r.cache.Load(s); ok {
+		entry := val.(secretEntry)
+		if entry.expireAt.IsZero() || time.Now().Before(entry.expireAt) {
+			return entry.plain, nil
+		}
+	}
+
+	if isEncrypted(s) {
+		if r.decryptor == nil {
+			return "", ErrNoDecryptor
+		}
+		plain, err := r.decryptor.Decrypt(s[len("ENC(") : len(s)-1])
+		if err != nil {
+			return "", errors.Wrap(err, "decrypt config value")
+		}
+		r.cache.Store(s, secretEntry{plain: plain})
+		return plain, nil
+	}
+
+	var err error
+	plain := secretPattern.ReplaceAllStringFunc(s, func(ref string) string {
+		name := secretPattern.FindStringSubmatch(ref)[1]
+		secret, e := r.secret(name)
+		if e != nil && err == nil {
+			err = e
+		}
+		return secret
+	})
+	if err != nil {
+		return "", err
+	}
+	if r.ttl > 0 {
+		r.cache.Store(s, secretEntry{plain: plain, expireAt: time.Now().Add(r.ttl)})
+	}
+	return plain, nil
+}
+
+func (r *secretResolver) secret(name string) (string, error) {
+	for _, provider := range r.providers {
+		secret, err := provider.Secret(name)
+		if errors.Is(err, ErrSecretNotFound) {
+			continue
+		}
+		if err != nil {
+			return "", errors.Wrapf(err, "get secret[%s]", name)
+		}
+		return secret, nil
+	}
+	return "", errors.Wrap(ErrSecretNotFound, name)
+}
+
+type aesDecryptor struct {
+	aead cipher.AEAD
+}
+
+// NewAESDecryptor 使用AES-GCM解密, key长度为16、24或32字节,
+// 密文为base64编码的nonce与加密结果的拼接
+func NewAESDecryptor(key []byte) (Decryptor, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &aesDecryptor{aead: aead}, nil
+}
+
+// NewAESKeyFileDecryptor 从文件中读取base64编码的AES密钥
+func NewAESKeyFileDecryptor(path string) (Decryptor, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil {
+		return nil, errors.Wrapf(err, "decode key file[%s]", path)
+	}
+	return NewAESDecryptor(key)
+}
+
+// Decrypt implements Decryptor
+func (d *aesDecryptor) Decrypt(ciphertext string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	nonceSize := d.aead.NonceSize()
+	if len(data) < nonceSize {
+		return "", errors.New("ciphertext too short")
+	}
+	plain, err := d.aead.Open(nil, data[:nonceSize], data[nonceSize:], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// EncryptAES 使用AES-GCM加密, 返回可直接写入配置的ENC(...)
+func EncryptAES(key []byte, plaintext string) (string, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return fmt.Sprintf("ENC(%s)", base64.StdEncoding.EncodeToString(sealed)), nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+type envSecretProvider struct {
+	prefix string
+}
+
+// NewEnvSecretProvider 从名为prefix+name的环境变量中读取秘密, prefix为空时使用DefaultSecretEnvPrefix,
+// 避免配置通过${secret:name}读取任意环境变量
+func NewEnvSecretProvider(prefix string) SecretProvider {
+	if prefix == "" {
+		prefix = DefaultSecretEnvPrefix
+	}
+	secretEnvPrefixes.Store(prefix, struct{}{})
+	return &envSecretProvider{prefix: prefix}
+}
+
+// MaskEnviron 返回将秘密相关环境变量的值替换为MaskedValue后的副本, environ的格式与os.Environ相同,
+// 包括SecretKeyEnv、DefaultSecretEnvPrefix及NewEnvSecretProvider指定的前缀
+func MaskEnviron(environ []string) []string {
+	masked := make([]string, 0, len(environ))
+	for _, env := range environ {
+		name, _, _ := strings.Cut(env, "=")
+		if isSecretEnv(name) {
+			env = name + "=" + MaskedValue
+		}
+		masked = append(masked, env)
+	}
+	return masked
+}
+
+func isSecretEnv(name string) bool {
+	if name == SecretKeyEnv || strings.HasPrefix(name, DefaultSecretEnvPrefix) {
+		return true
+	}
+	secret := false
+	secretEnvPrefixes.Range(func(prefix, _ interface{}) bool {
+		secret = strings.HasPrefix(name, prefix.(string))
+		return !secret
+	})
+	return secret
+}
+
+// Secret implements SecretProvider
+func (p *envSecretProvider) Secret(name string) (string, error) {
+	secret, ok := os.LookupEnv(p.prefix + name)
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+	return secret, nil
+}
+
+type vaultSecretProvider struct {
+	client VaultClient
+}
+
+// NewVaultSecretProvider 从Vault风格的KV存储中读取秘密, name形如path#field
+func NewVaultSecretProvider(client VaultClient) SecretProvider {
+	return &vaultSecretProvider{client: client}
+}
+
+// Secret implements SecretProvider
+func (p *vaultSecretProvider) Secret(name string) (string, error) {
+	path, field, ok := strings.Cut(name, "#")
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+	data, err := p.client.Read(path)
+	if err != nil {
+		return "", err
+	}
+	secret, ok := data[field]
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+	return fmt.Sprint(secret), nil
+}
@@ -0,0 +1,163 @@
+// Copyright 2020 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conf
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeVault map[string]map[string]interface{}
+
+func (v fakeVault) Read(path string) (map[string]interface{}, error) {
+	data, ok := v[path]
+	if !ok {
+		return nil, ErrSecretNotFound
+	}
+	return data, nil
+}
+
+func TestConfiguration_secret(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	keyFile := filepath.Join(t.TempDir(), "secret.key")
+	assert.Nil(t, os.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600))
+	password, err := EncryptAES(key, "redis-password")
+	assert.Nil(t, err)
+	t.Setenv(DefaultSecretEnvPrefix+"ACCESS_KEY", "env-secret")
+	t.Setenv("ACCESS_KEY", "unprefixed")
+
+	c := New()
+	assert.Nil(t, c.LoadFromReader(bytes.NewBufferString(fmt.Sprintf(`
+	[redis]
+		addr = "127.0.0.1:6379"
+		password = "%s"
+	[mysql]
+		dsn = "root:${secret:db/mysql#password}@tcp(127.0.0.1:3306)/test"
+	[tablestore]
+		accessKeySecret = "${secret:ACCESS_KEY}"
+	`, password)), toml.Unmarshal))
+
+	t.Run("no decryptor", func(t *testing.T) {
+		var config struct{ Password string }
+		err := c.UnmarshalKey("redis", &config)
+		assert.ErrorIs(t, err, ErrNoDecryptor)
+		_, err = c.GetE("redis.password")
+		assert.ErrorIs(t, err, ErrNoDecryptor)
+		// 解密失败时不返回密文
+		assert.Equal(t, "", c.GetString("redis.password"))
+	})
+
+	t.Run("no provider by default", func(t *testing.T) {
+		_, err := c.GetE("tablestore.accessKeySecret")
+		assert.ErrorIs(t, err, ErrSecretNotFound)
+		assert.Nil(t, c.Get("tablestore.accessKeySecret"))
+	})
+
+	decryptor, err := NewAESKeyFileDecryptor(keyFile)
+	assert.Nil(t, err)
+	c.SetDecryptor(decryptor)
+	vault := fakeVault{
+		"db/mysql": {"password": "mysql-password"},
+	}
+	c.AddSecretProvider(NewEnvSecretProvider(""))
+	c.AddSecretProvider(NewVaultSecretProvider(vault))
+
+	t.Run("get", func(t *testing.T) {
+		assert.Equal(t, "redis-password", c.GetString("redis.password"))
+		assert.Equal(t, "root:mysql-password@tcp(127.0.0.1:3306)/test", c.GetString("mysql.dsn"))
+		assert.Equal(t, "env-secret", c.GetString("tablestore.accessKeySecret"))
+		assert.Equal(t, "redis-password", c.GetStringMap("redis")["password"])
+	})
+
+	t.Run("unmarshal", func(t *testing.T) {
+		var config struct {
+			Redis struct {
+				Addr     string
+				Password string
+			}
+			Mysql struct {
+				DSN string
+			}
+		}
+		assert.Nil(t, c.UnmarshalKey("", &config))
+		assert.Equal(t, "redis-password", config.Redis.Password)
+		assert.Equal(t, "root:mysql-password@tcp(127.0.0.1:3306)/test", config.Mysql.DSN)
+	})
+
+	t.Run("mask", func(t *testing.T) {
+		explanations := c.Explain("redis")
+		assert.Equal(t, "redis.password", explanations[1].Key)
+		assert.Equal(t, MaskedValue, explanations[1].Value)
+		assert.Equal(t, MaskedValue, explanations[1].Layers[0].Value)
+		assert.Equal(t, "127.0.0.1:6379", explanations[0].Value)
+	})
+
+	t.Run("rotation", func(t *testing.T) {
+		vault["db/mysql"] = map[string]interface{}{"password": "rotated-password"}
+		// 缓存未失效且配置未重新加载时仍为旧值
+		assert.Equal(t, "root:mysql-password@tcp(127.0.0.1:3306)/test", c.GetString("mysql.dsn"))
+		assert.Nil(t, c.Set("mysql.timeout", "1s"))
+		assert.Equal(t, "root:rotated-password@tcp(127.0.0.1:3306)/test", c.GetString("mysql.dsn"))
+
+		c.SetSecretCacheTTL(0)
+		vault["db/mysql"] = map[string]interface{}{"password": "mysql-password"}
+		assert.Equal(t, "root:mysql-password@tcp(127.0.0.1:3306)/test", c.GetString("mysql.dsn"))
+	})
+
+	t.Run("secret not found", func(t *testing.T) {
+		assert.Nil(t, c.Set("mysql.dsn", "${secret:db/none#password}"))
+		var config struct{ DSN string }
+		assert.ErrorIs(t, c.UnmarshalKey("mysql", &config), ErrSecretNotFound)
+	})
+}
+
+func TestMaskEnviron(t *testing.T) {
+	NewEnvSecretProvider("MY_SECRET_")
+	assert.Equal(t, []string{
+		"APP_NAME=demo",
+		SecretKeyEnv + "=" + MaskedValue,
+		"JUPITER_SECRET_DB=" + MaskedValue,
+		"MY_SECRET_TOKEN=" + MaskedValue,
+		"EMPTY",
+	}, MaskEnviron([]string{
+		"APP_NAME=demo",
+		SecretKeyEnv + "=/etc/secret.key",
+		"JUPITER_SECRET_DB=password",
+		"MY_SECRET_TOKEN=a=b",
+		"EMPTY",
+	}))
+}
+
+func TestTraverse_mask(t *testing.T) {
+	defer Reset()
+	assert.Nil(t, LoadFromReader(bytes.NewBufferString(`
+	[app]
+		name = "demo"
+		token = "ENC(xxx)"
+		urls = ["${secret:url}", "http://127.0.0.1"]
+	`), toml.Unmarshal))
+
+	data := Traverse(".")
+	assert.Equal(t, "demo", data["app.name"])
+	assert.Equal(t, MaskedValue, data["app.token"])
+	assert.Equal(t, []interface{}{MaskedValue, "http://127.0.0.1"}, data["app.urls"])
+}
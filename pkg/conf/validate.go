@@ -0,0 +1,224 @@
+// Copyright 2020 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conf
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidConfig 配置校验失败
+var ErrInvalidConfig = errors.New("invalid config")
+
+// Validator 配置结构体实现该接口时, 校验过程中会调用Validate进行自定义校验
+type Validator interface {
+	Validate() error
+}
+
+type schema struct {
+	newConfig func() interface{}
+	opts      []GetOption
+}
+
+// RegisterValidation 注册key对应配置的校验规则, 热更新后该key的配置校验失败时拒绝本次更新,
+// newConfig 返回带默认值的配置结构体指针
+func (c *Configuration) RegisterValidation(key string, newConfig func() interface{}, opts ...GetOption) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	schemas := make(map[string]schema, len(c.schemas)+1)
+	for k, v := range c.schemas {
+		schemas[k] = v
+	}
+	schemas[key] = schema{newConfig: newConfig, opts: opts}
+	c.schemas = schemas
+}
+
+// validateChanges 校验受本次变更影响的已注册配置, 调用方需持有锁
+func (c *Configuration) validateChanges(conf map[string]interface{}, changes []string) error {
+	for key, s := range c.schemas {
+		if !hasPrefixKey(changes, key, c.keyDelim) {
+			continue
+		}
+		value, ok := searchValue(conf, strings.Split(key, c.keyDelim))
+		if !ok {
+			continue
+		}
+		if err := decode(value, s.newConfig(), append(s.opts, withStrict(key))...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hasPrefixKey(keys []string, prefix string, sep string) bool {
+	for _, key := range keys {
+		if key == prefix || strings.HasPrefix(key, prefix+sep) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateStruct 依据validate标签及Validate方法校验配置结构体, 支持的标签:
+// required 不能为零值; min=1,max=10 数值(含time.Duration)的范围或字符串、数组、map的长度; oneof=a b 枚举值
+func ValidateStruct(rawVal interface{}, opts ...GetOption) error {
+	var options = defaultGetOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	var problems []string
+	validateValue(reflect.ValueOf(rawVal), "", options.TagName, &problems)
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.Wrap(ErrInvalidConfig, strings.Join(problems, "; "))
+}
+
+func validateValue(v reflect.Value, path string, tagName string, problems *[]string) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return
+	}
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		fieldPath := path
+		if !field.Anonymous {
+			fieldPath = joinPath(path, fieldName(field, tagName))
+		}
+		if rules := field.Tag.Get("validate"); rules != "" {
+			for _, rule := range strings.Split(rules, ",") {
+				if msg := checkRule(v.Field(i), strings.TrimSpace(rule)); msg != "" {
+					*problems = append(*problems, fieldPath+" "+msg)
+				}
+			}
+		}
+		validateValue(v.Field(i), fieldPath, tagName, problems)
+	}
+
+	if v.CanAddr() {
+		v = v.Addr()
+	}
+	if validator, ok := v.Interface().(Validator); ok {
+		if err := validator.Validate(); err != nil {
+			*problems = append(*problems, strings.TrimSpace(path+" "+err.Error()))
+		}
+	}
+}
+
+func fieldName(field reflect.StructField, tagName string) string {
+	if name, _, _ := strings.Cut(field.Tag.Get(tagName), ","); name != "" && name != "-" {
+		return name
+	}
+	return field.Name
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func checkRule(v reflect.Value, rule string) string {
+	name, arg, _ := strings.Cut(rule, "=")
+	switch name {
+	case "required":
+		if v.IsZero() {
+			return "is required"
+		}
+	case "min", "max":
+		value, bound, ok := compareValue(v, arg)
+		if !ok {
+			return fmt.Sprintf("invalid rule %s", rule)
+		}
+		if name == "min" && value < bound {
+			return fmt.Sprintf("must be >= %s", arg)
+		}
+		if name == "max" && value > bound {
+			return fmt.Sprintf("must be <= %s", arg)
+		}
+	case "oneof":
+		value := fmt.Sprint(v.Interface())
+		for _, option := range strings.Fields(arg) {
+			if value == option {
+				return ""
+			}
+		}
+		return fmt.Sprintf("must be one of [%s]", arg)
+	default:
+		return fmt.Sprintf("unknown rule %s", rule)
+	}
+	return ""
+}
+
+// compareValue 返回可比较的值及边界, 字符串、数组、map比较长度
+func compareValue(v reflect.Value, arg string) (float64, float64, bool) {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		bound, err := time.ParseDuration(arg)
+		return float64(v.Int()), float64(bound), err == nil
+	}
+
+	bound, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), bound, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), bound, true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), bound, true
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		return float64(v.Len()), bound, true
+	}
+	return 0, 0, false
+}
+
+// validateDecoded 校验严格模式下解析后的配置, unused为配置中未被结构体使用的key
+func validateDecoded(key string, rawVal interface{}, unused []string, tagName string) error {
+	var problems []string
+	if len(unused) > 0 {
+		keys := make([]string, 0, len(unused))
+		for _, k := range unused {
+			keys = append(keys, joinPath(key, k))
+		}
+		sort.Strings(keys)
+		problems = append(problems, "unknown keys: "+strings.Join(keys, ", "))
+	}
+	validateValue(reflect.ValueOf(rawVal), key, tagName, &problems)
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.Wrap(ErrInvalidConfig, strings.Join(problems, "; "))
+}
@@ -0,0 +1,137 @@
+// Copyright 2020 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conf
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/assert"
+)
+
+type validateConfig struct {
+	Addr          string        `toml:"addr" validate:"required"`
+	PoolSize      int           `toml:"poolSize" validate:"min=1,max=100"`
+	SlowThreshold time.Duration `toml:"slowThreshold" validate:"min=1ms"`
+	Level         string        `toml:"level" validate:"oneof=panic error"`
+	Slaves        []string      `toml:"slaves"`
+}
+
+func (config *validateConfig) Validate() error {
+	for _, slave := range config.Slaves {
+		if slave == config.Addr {
+			return errors.New("slaves should not contain addr")
+		}
+	}
+	return nil
+}
+
+func defaultValidateConfig() *validateConfig {
+	return &validateConfig{
+		PoolSize:      10,
+		SlowThreshold: time.Second,
+		Level:         "panic",
+	}
+}
+
+func TestValidateStruct(t *testing.T) {
+	config := defaultValidateConfig()
+	config.Addr = "127.0.0.1:6379"
+	assert.Nil(t, ValidateStruct(config, TagNameTOML()))
+
+	config = defaultValidateConfig()
+	config.PoolSize = 101
+	config.SlowThreshold = time.Microsecond
+	config.Level = "warn"
+	err := ValidateStruct(config, TagNameTOML())
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.Contains(t, err.Error(), "addr is required")
+	assert.Contains(t, err.Error(), "poolSize must be <= 100")
+	assert.Contains(t, err.Error(), "slowThreshold must be >= 1ms")
+	assert.Contains(t, err.Error(), "level must be one of [panic error]")
+
+	config = defaultValidateConfig()
+	config.Addr = "127.0.0.1:6379"
+	config.Slaves = []string{"127.0.0.1:6379"}
+	assert.ErrorContains(t, ValidateStruct(config), "slaves should not contain addr")
+}
+
+func TestConfiguration_UnmarshalKeyStrict(t *testing.T) {
+	c := New()
+	assert.Nil(t, c.LoadFromReader(bytes.NewBufferString(`
+	[redis]
+		addr = "127.0.0.1:6379"
+		poolSize = 0
+		slowThresold = "1s"
+	`), toml.Unmarshal))
+
+	config := defaultValidateConfig()
+	assert.Nil(t, c.UnmarshalKey("redis", config, TagNameTOML()))
+
+	err := c.UnmarshalKey("redis", defaultValidateConfig(), TagNameTOML(), Strict())
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.Contains(t, err.Error(), "unknown keys: redis.slowThresold")
+	assert.Contains(t, err.Error(), "redis.poolSize must be >= 1")
+}
+
+func TestConfiguration_RegisterValidation(t *testing.T) {
+	ds := newMemDataSource(`
+	[redis]
+		addr = "127.0.0.1:6379"
+		poolSize = 20
+	`)
+	c := New()
+	assert.Nil(t, c.LoadFromLayers(Layer{Name: "base", DataSource: ds, Unmarshaller: toml.Unmarshal}))
+	c.RegisterValidation("redis", func() interface{} { return defaultValidateConfig() }, TagNameTOML())
+
+	changed := make(chan struct{}, 1)
+	c.OnChange(func(*Configuration) { changed <- struct{}{} })
+
+	// 校验失败的热更新被拒绝, 保留原有配置
+	ds.update(`
+	[redis]
+		addr = "127.0.0.1:6379"
+		poolSize = 200
+	`)
+	assert.Eventually(t, func() bool { return len(ds.changed) == 0 }, time.Second, time.Millisecond)
+	select {
+	case <-changed:
+		t.Fatal("invalid config should be rejected")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.Equal(t, 20, c.GetInt("redis.poolSize"))
+
+	err := c.Set("redis.level", "warn")
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.Nil(t, c.Get("redis.level"))
+
+	// 未注册校验的key不受影响
+	assert.Nil(t, c.Set("app.poolSize", 200))
+
+	ds.update(`
+	[redis]
+		addr = "127.0.0.1:6380"
+		poolSize = 30
+	`)
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("change not notified")
+	}
+	assert.Equal(t, 30, c.GetInt("redis.poolSize"))
+}
@@ -0,0 +1,125 @@
+// Copyright 2020 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conf
+
+import (
+	"reflect"
+	"strings"
+)
+
+// watcher 接收变更前后完整的配置树及发生变化的key
+type watcher func(old, new map[string]interface{}, changes []string)
+
+// Watch 监听prefix下的配置变化, old/new为发生变化的key(完整路径)变更前后的值,
+// 新增的key不在old中, 删除的key不在new中
+func (c *Configuration) Watch(prefix string, fn func(old, new map[string]interface{})) {
+	c.watch(prefix, func(oldConf, newConf map[string]interface{}, changes []string) {
+		var (
+			oldDiff = make(map[string]interface{})
+			newDiff = make(map[string]interface{})
+		)
+		for _, key := range changes {
+			paths := strings.Split(key, c.keyDelim)
+			if v, ok := searchValue(oldConf, paths); ok {
+				oldDiff[key] = v
+			}
+			if v, ok := searchValue(newConf, paths); ok {
+				newDiff[key] = v
+			}
+		}
+		fn(oldDiff, newDiff)
+	})
+}
+
+// WatchKey 监听key对应的配置, 变更前后的值解析为T后不相等时才回调
+func WatchKey[T any](key string, fn func(old, new T), opts ...GetOption) {
+	watchKey(defaultConfiguration, key, fn, opts...)
+}
+
+func watchKey[T any](c *Configuration, key string, fn func(old, new T), opts ...GetOption) {
+	c.watch(key, func(oldConf, newConf map[string]interface{}, _ []string) {
+		var (
+			paths    = strings.Split(key, c.keyDelim)
+			oldValue T
+			newValue T
+		)
+		if v, ok := searchValue(oldConf, paths); ok {
+			if err := c.decodeSecret(v, &oldValue, opts...); err != nil {
+				return
+			}
+		}
+		if v, ok := searchValue(newConf, paths); ok {
+			if err := c.decodeSecret(v, &newValue, opts...); err != nil {
+				return
+			}
+		}
+		if !reflect.DeepEqual(oldValue, newValue) {
+			fn(oldValue, newValue)
+		}
+	})
+}
+
+func (c *Configuration) watch(prefix string, fn watcher) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	watchers := make(map[string][]watcher, len(c.watchers)+1)
+	for k, v := range c.watchers {
+		watchers[k] = v
+	}
+	watchers[prefix] = append(append([]watcher{}, c.watchers[prefix]...), fn)
+	c.watchers = watchers
+}
+
+func (c *Configuration) notifyChanges(watchers map[string][]watcher, old, new map[string]interface{}, changes []string) {
+	for prefix, handles := range watchers {
+		var matched []string
+		for _, key := range changes {
+			if prefix == "" || key == prefix || strings.HasPrefix(key, prefix+c.keyDelim) {
+				matched = append(matched, key)
+			}
+		}
+		if len(matched) == 0 {
+			continue
+		}
+		for _, handle := range handles {
+			handle(old, new, matched)
+		}
+	}
+}
+
+// searchValue 只读地查找paths对应的值
+func searchValue(m map[string]interface{}, paths []string) (interface{}, bool) {
+	var value interface{} = m
+	for _, k := range paths {
+		sub, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = sub[k]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// decodeSecret 解密value中的加密值后解析到rawVal
+func (c *Configuration) decodeSecret(value interface{}, rawVal interface{}, opts ...GetOption) error {
+	value, err := c.secrets.resolve(value)
+	if err != nil {
+		return err
+	}
+	return decode(value, rawVal, opts...)
+}
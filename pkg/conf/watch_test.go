@@ -0,0 +1,101 @@
+// Copyright 2020 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conf
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfiguration_Watch(t *testing.T) {
+	ds := newMemDataSource(`
+	[app]
+		name = "demo"
+		mode = "dev"
+	[application]
+		name = "other"
+	`)
+	c := New()
+	assert.Nil(t, c.LoadFromLayers(Layer{Name: "base", DataSource: ds, Unmarshaller: toml.Unmarshal}))
+
+	type diff struct {
+		old, new map[string]interface{}
+	}
+	diffs := make(chan diff, 10)
+	c.Watch("app", func(old, new map[string]interface{}) {
+		diffs <- diff{old: old, new: new}
+	})
+
+	ds.update(`
+	[app]
+		mode = "prod"
+		port = 8080
+	[application]
+		name = "changed"
+	`)
+	select {
+	case d := <-diffs:
+		assert.Equal(t, map[string]interface{}{"app.name": "demo", "app.mode": "dev"}, d.old)
+		assert.Equal(t, map[string]interface{}{"app.mode": "prod", "app.port": int64(8080)}, d.new)
+	case <-time.After(time.Second):
+		t.Fatal("watch not notified")
+	}
+
+	// 前缀相同的其他key及未变化的值不会触发回调
+	assert.Nil(t, c.Set("application.name", "again"))
+	assert.Nil(t, c.Set("app.mode", "prod"))
+	assert.Empty(t, diffs)
+}
+
+func TestWatchKey(t *testing.T) {
+	type Server struct {
+		Port int    `toml:"port"`
+		Host string `toml:"host"`
+	}
+
+	c := New()
+	assert.Nil(t, c.LoadFromReader(bytes.NewBufferString(`
+	[server]
+		port = 8080
+		host = "localhost"
+	`), toml.Unmarshal))
+
+	var (
+		servers [][2]Server
+		ports   [][2]int
+	)
+	watchKey(c, "server", func(old, new Server) {
+		servers = append(servers, [2]Server{old, new})
+	})
+	watchKey(c, "server.port", func(old, new int) {
+		ports = append(ports, [2]int{old, new})
+	})
+
+	assert.Nil(t, c.Set("server.host", "127.0.0.1"))
+	assert.Equal(t, [][2]Server{{{Port: 8080, Host: "localhost"}, {Port: 8080, Host: "127.0.0.1"}}}, servers)
+	assert.Empty(t, ports)
+
+	assert.Nil(t, c.Set("server.port", 9090))
+	assert.Equal(t, [][2]int{{8080, 9090}}, ports)
+
+	// 值相同不触发回调
+	assert.Nil(t, c.Set("server.port", 9090))
+	assert.Len(t, ports, 1)
+	assert.Len(t, servers, 2)
+}
@@ -27,6 +27,7 @@ import (
 	_ "github.com/zhengyansheng/jupiter/pkg/core/autoproc"
 	_ "github.com/zhengyansheng/jupiter/pkg/core/rocketmq"
 	_ "github.com/zhengyansheng/jupiter/pkg/core/xgrpclog"
+	_ "github.com/zhengyansheng/jupiter/pkg/core/xtrace"
 	_ "github.com/zhengyansheng/jupiter/pkg/registry/etcdv3"
 
 	"github.com/BurntSushi/toml"
@@ -49,6 +50,16 @@ import (
 	"golang.org/x/sync/errgroup"
 )
 
+// Subscriber 消息订阅者, 如mq.Subscriber, 通过Subscribe注册后随应用启动及停止
+type Subscriber interface {
+	// Serve 开始消费, 阻塞直到Stop或GracefulStop
+	Serve() error
+	// Stop 立即停止消费
+	Stop() error
+	// GracefulStop 停止拉取新的消息, 等待处理中的消息完成或者ctx结束
+	GracefulStop(ctx context.Context) error
+}
+
 // Application is the framework's instance, it contains the servers, workers, client and configuration settings.
 // Create an instance of Application, by using &Application{}
 type Application struct {
@@ -67,6 +78,8 @@ type Application struct {
 	HideBanner   bool
 	stopped      chan struct{}
 	components   []component.Component
+	// subscribers 消息订阅者，与servers一样随应用启动，GracefulStop时等待处理中的消息完成
+	subscribers []Subscriber
 }
 
 // New create a new Application instance
@@ -102,6 +115,7 @@ func (app *Application) initialize() {
 		app.smu = &sync.RWMutex{}
 		app.servers = make([]server.Server, 0)
 		app.workers = make([]worker.Worker, 0)
+		app.subscribers = make([]Subscriber, 0)
 		app.jobs = make(map[string]job.Runner)
 		app.logger = xlog.Jupiter()
 		app.configParser = toml.Unmarshal
@@ -184,6 +198,14 @@ func (app *Application) Schedule(w worker.Worker) error {
 	return nil
 }
 
+// Subscribe register message subscribers
+func (app *Application) Subscribe(s ...Subscriber) error {
+	app.smu.Lock()
+	defer app.smu.Unlock()
+	app.subscribers = append(app.subscribers, s...)
+	return nil
+}
+
 // Job ..
 func (app *Application) Job(runner job.Runner) error {
 	namedJob, ok := runner.(interface{ GetJobName() string })
@@ -247,6 +269,8 @@ func (app *Application) Run(servers ...server.Server) error {
 	app.cycle.Run(app.startServers)
 	// start workers
 	app.cycle.Run(app.startWorkers)
+	// start subscribers
+	app.cycle.Run(app.startSubscribers)
 	// start executors
 	app.cycle.Run(app.startExecutors)
 	//blocking and wait quit
@@ -290,6 +314,12 @@ func (app *Application) Stop() (err error) {
 				app.cycle.Run(w.Stop)
 			}(w)
 		}
+		//stop subscribers
+		for _, s := range app.subscribers {
+			func(s Subscriber) {
+				app.cycle.Run(s.Stop)
+			}(s)
+		}
 		app.cycle.Run(executor.Stop)
 
 		<-app.cycle.Done()
@@ -328,6 +358,14 @@ func (app *Application) GracefulStop(ctx context.Context) (err error) {
 				app.cycle.Run(w.Stop)
 			}(w)
 		}
+		//drain subscribers
+		for _, s := range app.subscribers {
+			func(s Subscriber) {
+				app.cycle.Run(func() error {
+					return s.GracefulStop(ctx)
+				})
+			}(s)
+		}
 		// stop executor
 		app.cycle.Run(executor.GracefulStop)
 		<-app.cycle.Done()
@@ -389,6 +427,20 @@ func (app *Application) startWorkers() error {
 	return eg.Wait()
 }
 
+func (app *Application) startSubscribers() error {
+	var eg errgroup.Group
+	app.smu.RLock()
+	// start multi subscribers
+	for _, s := range app.subscribers {
+		s := s
+		eg.Go(func() error {
+			return s.Serve()
+		})
+	}
+	app.smu.RUnlock()
+	return eg.Wait()
+}
+
 // todo handle error
 func (app *Application) startJobs() error {
 	if len(app.jobs) == 0 {
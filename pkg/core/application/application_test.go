@@ -19,12 +19,15 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/BurntSushi/toml"
 	. "github.com/smartystreets/goconvey/convey"
 	"github.com/stretchr/testify/assert"
+	"github.com/zhengyansheng/jupiter/pkg/client/mq"
+	"github.com/zhengyansheng/jupiter/pkg/client/mq/memory"
 	"github.com/zhengyansheng/jupiter/pkg/conf"
 	"github.com/zhengyansheng/jupiter/pkg/core/hooks"
 	"github.com/zhengyansheng/jupiter/pkg/executor"
@@ -395,6 +398,33 @@ func Test_Unit_Application_startWorkers(t *testing.T) {
 	})
 }
 
+func Test_Unit_Application_Subscribe(t *testing.T) {
+	broker := memory.NewBroker()
+	sub := broker.NewSubscriber()
+
+	handling := make(chan struct{})
+	var handled atomic.Bool
+	assert.Nil(t, sub.Subscribe("order", func(ctx context.Context, msg *mq.Message) error {
+		close(handling)
+		time.Sleep(100 * time.Millisecond)
+		handled.Store(true)
+		return nil
+	}))
+
+	app := &Application{}
+	app.initialize()
+	assert.Nil(t, app.Subscribe(sub))
+
+	go func() {
+		assert.Nil(t, broker.Publisher("order").Publish(context.Background(), &mq.Message{}))
+		<-handling
+		// GracefulStop waits for the in-flight message
+		assert.Nil(t, app.GracefulStop(context.Background()))
+	}()
+	assert.Nil(t, app.Run())
+	assert.True(t, handled.Load())
+}
+
 /*
 
 func newFakeRegistry() registry.Registry {
@@ -17,4 +17,6 @@ const (
 	ModuleStoreGorm
 	ModuleStoreTableStore
 	ModuleClusterRedis
+
+	ModuleRegistry
 )
@@ -109,6 +109,8 @@ const (
 	ModApp = "app"
 	// ModProc ...
 	ModProc = "proc"
+	// ModTrace ...
+	ModTrace = "trace"
 
 	// ModGrpcServer ...
 	ModGrpcServer = "server.grpc"
@@ -119,6 +121,14 @@ const (
 
 	// ModRegistryETCD ...
 	ModRegistryETCD = "registry.etcd"
+	// ModRegistryConsul ...
+	ModRegistryConsul = "registry.consul"
+	// ModRegistryNacos ...
+	ModRegistryNacos = "registry.nacos"
+	// ModRegistryK8s ...
+	ModRegistryK8s = "registry.k8s"
+	// ModRegistryFile ...
+	ModRegistryFile = "registry.file"
 
 	// ModClientETCD ...
 	ModClientETCD = "client.etcd"
@@ -126,6 +136,8 @@ const (
 	ModClientGrpc = "client.grpc"
 	// ModeClientRocketMQ ...
 	ModeClientRocketMQ = "client.rocketmq"
+	// ModClientKafka ...
+	ModClientKafka = "client.kafka"
 	// ModClientRedis ...
 	ModClientRedis = "client.redis"
 	// ModeClientResty ...
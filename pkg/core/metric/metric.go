@@ -0,0 +1,270 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metric
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/zhengyansheng/jupiter/pkg"
+	"github.com/zhengyansheng/jupiter/pkg/core/hooks"
+	"github.com/zhengyansheng/jupiter/pkg/server/governor"
+)
+
+var (
+	// TypeHTTP ...
+	TypeHTTP = "http"
+	// TypeGRPC ...
+	TypeGRPC = "grpc"
+	// TypeGRPCUnary ...
+	TypeGRPCUnary = "unary"
+	// TypeGRPCStream ...
+	TypeGRPCStream = "stream"
+	// TypeRedis ...
+	TypeRedis = "redis"
+	// TypeGorm ...
+	TypeGorm = "gorm"
+	// TypeRocketMQ ...
+	TypeRocketMQ = "rocketmq"
+	// TypeKafka ...
+	TypeKafka = "kafka"
+	// TypeWebsocket ...
+	TypeWebsocket = "ws"
+
+	// CodeJobSuccess ...
+	CodeJobSuccess = "ok"
+	// CodeJobFail ...
+	CodeJobFail = "fail"
+	// CodeJobReentry ...
+	CodeJobReentry = "reentry"
+
+	// CodeCacheMiss ...
+	CodeCacheMiss = "miss"
+	// CodeCacheHit ...
+	CodeCacheHit = "hit"
+
+	// DefaultNamespace ...
+	DefaultNamespace = "jupiter"
+)
+
+var (
+	// appLabels 区分不同应用的请求指标，appID在进程启动时由环境变量或编译参数确定
+	appLabels = prometheus.Labels{"aid": pkg.AppID()}
+
+	// ServerHandleCounter ...
+	ServerHandleCounter = CounterVecOpts{
+		Namespace:   DefaultNamespace,
+		Name:        "server_handle_total",
+		Help:        "server handled requests, partitioned by type, method, peer and code",
+		Labels:      []string{"type", "method", "peer", "code"},
+		ConstLabels: appLabels,
+	}.Build()
+
+	// ServerHandleHistogram ...
+	ServerHandleHistogram = HistogramVecOpts{
+		Namespace:   DefaultNamespace,
+		Name:        "server_handle_seconds",
+		Help:        "server handled latency in seconds, partitioned by type, method and peer",
+		Labels:      []string{"type", "method", "peer"},
+		ConstLabels: appLabels,
+	}.Build()
+
+	// ServerInflightGauge ...
+	ServerInflightGauge = GaugeVecOpts{
+		Namespace: DefaultNamespace,
+		Name:      "server_inflight_requests",
+		Help:      "server requests being handled, partitioned by type and method",
+		Labels:    []string{"type", "method"},
+	}.Build()
+
+	// ClientHandleCounter ...
+	ClientHandleCounter = CounterVecOpts{
+		Namespace:   DefaultNamespace,
+		Name:        "client_handle_total",
+		Help:        "client handled requests, partitioned by type, name, method, peer and code",
+		Labels:      []string{"type", "name", "method", "peer", "code"},
+		ConstLabels: appLabels,
+	}.Build()
+
+	// ClientHandleHistogram ...
+	ClientHandleHistogram = HistogramVecOpts{
+		Namespace:   DefaultNamespace,
+		Name:        "client_handle_seconds",
+		Help:        "client handled latency in seconds, partitioned by type, name, method and peer",
+		Labels:      []string{"type", "name", "method", "peer"},
+		ConstLabels: appLabels,
+	}.Build()
+
+	// ClientStreamMsgCounter ...
+	ClientStreamMsgCounter = CounterVecOpts{
+		Namespace: DefaultNamespace,
+		Name:      "client_stream_msg_total",
+		Help:      "client stream messages, partitioned by type, name, method, peer and direction",
+		Labels:    []string{"type", "name", "method", "peer", "direction"},
+	}.Build()
+
+	// ClientRetryCounter ...
+	ClientRetryCounter = CounterVecOpts{
+		Namespace: DefaultNamespace,
+		Name:      "client_retry_total",
+		Help:      "client retried or hedged attempts, partitioned by type, name, method and policy",
+		Labels:    []string{"type", "name", "method", "policy"},
+	}.Build()
+
+	// ClientOutlierEjectionCounter ...
+	ClientOutlierEjectionCounter = CounterVecOpts{
+		Namespace: DefaultNamespace,
+		Name:      "client_outlier_ejection_total",
+		Help:      "client outlier ejections, partitioned by type, name, peer and reason",
+		Labels:    []string{"type", "name", "peer", "reason"},
+	}.Build()
+
+	// ClientOutlierEjectedGauge ...
+	ClientOutlierEjectedGauge = GaugeVecOpts{
+		Namespace: DefaultNamespace,
+		Name:      "client_outlier_ejected",
+		Help:      "client nodes being ejected, partitioned by type and name",
+		Labels:    []string{"type", "name"},
+	}.Build()
+
+	// ClientTopologyChangeCounter ...
+	ClientTopologyChangeCounter = CounterVecOpts{
+		Namespace: DefaultNamespace,
+		Name:      "client_topology_change_total",
+		Help:      "client topology changes, partitioned by type, name, peer and event",
+		Labels:    []string{"type", "name", "peer", "event"},
+	}.Build()
+
+	// ClientReplicaGauge ...
+	ClientReplicaGauge = GaugeVecOpts{
+		Namespace: DefaultNamespace,
+		Name:      "client_replicas",
+		Help:      "client read replicas, partitioned by type, name and state",
+		Labels:    []string{"type", "name", "state"},
+	}.Build()
+
+	// JobHandleCounter ...
+	JobHandleCounter = CounterVecOpts{
+		Namespace: DefaultNamespace,
+		Name:      "job_handle_total",
+		Help:      "job executions, partitioned by type, name and code",
+		Labels:    []string{"type", "name", "code"},
+	}.Build()
+
+	// JobHandleHistogram ...
+	JobHandleHistogram = HistogramVecOpts{
+		Namespace: DefaultNamespace,
+		Name:      "job_handle_seconds",
+		Help:      "job execution latency in seconds, partitioned by type, name and code",
+		Labels:    []string{"type", "name", "code"},
+	}.Build()
+
+	// LibHandleHistogram ...
+	LibHandleHistogram = HistogramVecOpts{
+		Namespace: DefaultNamespace,
+		Name:      "lib_handle_seconds",
+		Help:      "library call latency in seconds, partitioned by type, method and address",
+		Labels:    []string{"type", "method", "address"},
+	}.Build()
+
+	// LibHandleCounter ...
+	LibHandleCounter = CounterVecOpts{
+		Namespace: DefaultNamespace,
+		Name:      "lib_handle_total",
+		Help:      "library calls, partitioned by type, method, address and code",
+		Labels:    []string{"type", "method", "address", "code"},
+	}.Build()
+
+	// LibHandleSummary ...
+	LibHandleSummary = SummaryVecOpts{
+		Namespace: DefaultNamespace,
+		Name:      "lib_handle_stats",
+		Help:      "library internal stats, partitioned by type, name and status",
+		Labels:    []string{"type", "name", "status"},
+	}.Build()
+
+	// CacheHandleCounter ...
+	CacheHandleCounter = CounterVecOpts{
+		Namespace: DefaultNamespace,
+		Name:      "cache_handle_total",
+		Help:      "cache lookups, partitioned by type, name, action and code",
+		Labels:    []string{"type", "name", "action", "code"},
+	}.Build()
+
+	// CacheStatsGauge ...
+	CacheStatsGauge = GaugeVecOpts{
+		Namespace: DefaultNamespace,
+		Name:      "cache_stats",
+		Help:      "cache entries, evictions and hit rate, partitioned by type, name and stat",
+		Labels:    []string{"type", "name", "stat"},
+	}.Build()
+
+	// CacheHandleHistogram ...
+	CacheHandleHistogram = HistogramVecOpts{
+		Namespace: DefaultNamespace,
+		Name:      "cache_handle_seconds",
+		Help:      "cache operation latency in seconds, partitioned by type, name and action",
+		Labels:    []string{"type", "name", "action"},
+	}.Build()
+
+	// BuildInfoGauge ...
+	BuildInfoGauge = GaugeVecOpts{
+		Namespace: DefaultNamespace,
+		Name:      "build_info",
+		Help:      "build information of the running application",
+		Labels:    []string{"name", "aid", "mode", "region", "zone", "app_version", "jupiter_version", "start_time", "build_time", "go_version"},
+	}.Build()
+)
+
+func init() {
+	// 应用名称、版本、地域等信息在加载配置后才能确定
+	hooks.Register(hooks.Stage_AfterLoadConfig, setBuildInfo)
+
+	governor.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		promhttp.Handler().ServeHTTP(w, r)
+	})
+}
+
+func setBuildInfo() {
+	BuildInfoGauge.Reset()
+	BuildInfoGauge.WithLabelValues(
+		pkg.Name(),
+		pkg.AppID(),
+		pkg.AppMode(),
+		pkg.AppRegion(),
+		pkg.AppZone(),
+		pkg.AppVersion(),
+		pkg.JupiterVersion(),
+		pkg.StartTime(),
+		pkg.BuildTime(),
+		pkg.GoVersion(),
+	).Set(float64(1))
+}
+
+// mustRegister registers the collector to the default registerer,
+// returns the already registered one if a collector with the same
+// descriptor exists.
+func mustRegister(c prometheus.Collector) prometheus.Collector {
+	if err := prometheus.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			return are.ExistingCollector
+		}
+		panic(err)
+	}
+	return c
+}
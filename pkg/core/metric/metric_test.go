@@ -0,0 +1,75 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metric
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhengyansheng/jupiter/pkg"
+	"github.com/zhengyansheng/jupiter/pkg/core/hooks"
+	"github.com/zhengyansheng/jupiter/pkg/server/governor"
+)
+
+func TestCounterVec(t *testing.T) {
+	counter := NewCounterVec("test_counter_total", []string{"type", "code"})
+	counter.Inc(TypeHTTP, "OK")
+	counter.Add(2, TypeHTTP, "OK")
+	assert.Equal(t, float64(3), testutil.ToFloat64(counter.WithLabelValues(TypeHTTP, "OK")))
+
+	// registering the same metric twice returns the existing collector
+	again := NewCounterVec("test_counter_total", []string{"type", "code"})
+	assert.Equal(t, float64(3), testutil.ToFloat64(again.WithLabelValues(TypeHTTP, "OK")))
+}
+
+func TestGaugeVec(t *testing.T) {
+	gauge := NewGaugeVec("test_gauge", []string{"name"})
+	gauge.Set(10, "a")
+	gauge.Inc("a")
+	gauge.Dec("a")
+	gauge.Add(5, "a")
+	assert.Equal(t, float64(15), testutil.ToFloat64(gauge.WithLabelValues("a")))
+}
+
+func TestHistogramVec(t *testing.T) {
+	histogram := NewHistogramVec("test_histogram_seconds", []string{"name"})
+	histogram.Observe(0.1, "a")
+	histogram.Observe(0.2, "a")
+	assert.Equal(t, 1, testutil.CollectAndCount(histogram, "jupiter_test_histogram_seconds"))
+}
+
+func TestSummaryVec(t *testing.T) {
+	summary := NewSummaryVec("test_summary", []string{"name"})
+	summary.Observe(1, "a")
+	assert.Equal(t, 1, testutil.CollectAndCount(summary, "jupiter_test_summary"))
+}
+
+func TestGovernorMetrics(t *testing.T) {
+	ServerHandleCounter.Inc(TypeHTTP, "GET./ping", "1234", "OK")
+	pkg.SetAppRegion("test-region")
+	hooks.Do(hooks.Stage_AfterLoadConfig)
+
+	w := httptest.NewRecorder()
+	governor.DefaultServeMux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, strings.Contains(w.Body.String(), `jupiter_server_handle_total{aid="`+pkg.AppID()+`"`))
+	assert.True(t, strings.Contains(w.Body.String(), "jupiter_build_info"))
+	assert.True(t, strings.Contains(w.Body.String(), `region="test-region"`))
+}
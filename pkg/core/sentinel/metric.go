@@ -0,0 +1,72 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sentinel
+
+import (
+	"github.com/zhengyansheng/jupiter/pkg/core/metric"
+)
+
+var (
+	sentinelLabels = []string{"resource", "language", "app", "aid", "region", "zone", "instance", "mode"}
+
+	sentinelReqeust = metric.CounterVecOpts{
+		Namespace: metric.DefaultNamespace,
+		Subsystem: "sentinel",
+		Name:      "request_total",
+		Help:      "sentinel entries, partitioned by resource",
+		Labels:    sentinelLabels,
+	}.Build()
+
+	sentinelBlocked = metric.CounterVecOpts{
+		Namespace: metric.DefaultNamespace,
+		Subsystem: "sentinel",
+		Name:      "blocked_total",
+		Help:      "sentinel blocked entries, partitioned by resource",
+		Labels:    sentinelLabels,
+	}.Build()
+
+	sentinelSuccess = metric.CounterVecOpts{
+		Namespace: metric.DefaultNamespace,
+		Subsystem: "sentinel",
+		Name:      "success_total",
+		Help:      "sentinel passed entries exited without error, partitioned by resource",
+		Labels:    sentinelLabels,
+	}.Build()
+
+	sentinelExceptionsThrown = metric.CounterVecOpts{
+		Namespace: metric.DefaultNamespace,
+		Subsystem: "sentinel",
+		Name:      "exceptions_total",
+		Help:      "sentinel passed entries exited with error, partitioned by resource",
+		Labels:    sentinelLabels,
+	}.Build()
+
+	sentinelRt = metric.HistogramVecOpts{
+		Namespace: metric.DefaultNamespace,
+		Subsystem: "sentinel",
+		Name:      "rt_seconds",
+		Help:      "sentinel entries response time in seconds, partitioned by resource",
+		Labels:    sentinelLabels,
+		Buckets:   []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+	}.Build()
+
+	sentinelState = metric.GaugeVecOpts{
+		Namespace: metric.DefaultNamespace,
+		Subsystem: "sentinel",
+		Name:      "circuit_breaker_state",
+		Help:      "sentinel circuit breaker state, 0 closed, 1 half-open, 2 open",
+		Labels:    sentinelLabels,
+	}.Build()
+)
@@ -15,7 +15,6 @@
 package sentinel
 
 import (
-	"github.com/alibaba/sentinel-golang/api"
 	sentinel "github.com/alibaba/sentinel-golang/api"
 	"github.com/alibaba/sentinel-golang/core/base"
 	"github.com/zhengyansheng/jupiter/pkg/core/hooks"
@@ -35,6 +34,12 @@ type (
 	EntryOption   = sentinel.EntryOption
 )
 
+var (
+	WithResourceType = sentinel.WithResourceType
+	WithTrafficType  = sentinel.WithTrafficType
+	WithError        = base.WithError
+)
+
 var (
 	stdConfig Config
 )
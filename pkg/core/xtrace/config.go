@@ -0,0 +1,226 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xtrace
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cast"
+	"github.com/zhengyansheng/jupiter/pkg"
+	"github.com/zhengyansheng/jupiter/pkg/conf"
+	"github.com/zhengyansheng/jupiter/pkg/core/constant"
+	"github.com/zhengyansheng/jupiter/pkg/core/ecode"
+	"github.com/zhengyansheng/jupiter/pkg/xlog"
+	"go.opentelemetry.io/contrib/propagators/b3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+)
+
+const (
+	// ExporterOTLP exports spans by otlp over grpc
+	ExporterOTLP = "otlp"
+	// ExporterOTLPHTTP exports spans by otlp over http
+	ExporterOTLPHTTP = "otlphttp"
+	// ExporterStdout prints spans to stdout, for debugging
+	ExporterStdout = "stdout"
+	// ExporterMemory keeps spans in memory, for testing
+	ExporterMemory = "memory"
+	// ExporterNone drops all spans
+	ExporterNone = "none"
+
+	// SamplerAlways samples every span
+	SamplerAlways = "always"
+	// SamplerNever samples nothing
+	SamplerNever = "never"
+	// SamplerRatio samples spans by SamplerRatio
+	SamplerRatio = "ratio"
+)
+
+// Config trace config
+type Config struct {
+	Enable bool `json:"enable" toml:"enable"`
+	// Name 服务名, 默认为应用名
+	Name string `json:"name" toml:"name"`
+	// Exporter otlp, otlphttp, stdout, memory, none
+	Exporter string `json:"exporter" toml:"exporter"`
+	// Endpoint otlp collector地址, 如 127.0.0.1:4317
+	Endpoint string            `json:"endpoint" toml:"endpoint"`
+	Insecure bool              `json:"insecure" toml:"insecure"`
+	Headers  map[string]string `json:"headers" toml:"headers"`
+	Timeout  time.Duration     `json:"timeout" toml:"timeout"`
+	// Sampler always, never, ratio
+	Sampler      string  `json:"sampler" toml:"sampler"`
+	SamplerRatio float64 `json:"samplerRatio" toml:"samplerRatio"`
+	// ParentBased 是否遵循上游的采样决定
+	ParentBased bool `json:"parentBased" toml:"parentBased"`
+	// Propagators tracecontext, baggage, b3, b3multi
+	Propagators  []string      `json:"propagators" toml:"propagators"`
+	BatchTimeout time.Duration `json:"batchTimeout" toml:"batchTimeout"`
+
+	memoryExporter *tracetest.InMemoryExporter
+}
+
+// StdConfig returns standard configuration
+func StdConfig() *Config {
+	return RawConfig(constant.ConfigKey("trace"))
+}
+
+// RawConfig returns configuration by key
+func RawConfig(key string) *Config {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(key, config); err != nil {
+		xlog.Jupiter().Panic("unmarshal trace config", xlog.FieldMod(ecode.ModTrace), xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr), xlog.FieldErr(err), xlog.FieldKey(key))
+	}
+	return config
+}
+
+// DefaultConfig returns default configuration
+func DefaultConfig() *Config {
+	return &Config{
+		Enable:       false,
+		Name:         pkg.Name(),
+		Exporter:     ExporterOTLP,
+		Endpoint:     "127.0.0.1:4317",
+		Insecure:     true,
+		Timeout:      cast.ToDuration("3s"),
+		Sampler:      SamplerAlways,
+		SamplerRatio: 1,
+		ParentBased:  true,
+		Propagators:  []string{"tracecontext", "baggage", "b3"},
+		BatchTimeout: cast.ToDuration("5s"),
+	}
+}
+
+// MemoryExporter returns the in-memory exporter when Exporter is "memory"
+func (config *Config) MemoryExporter() *tracetest.InMemoryExporter {
+	return config.memoryExporter
+}
+
+// Build builds a TracerProvider, and registers it as the global provider
+func (config *Config) Build() (*sdktrace.TracerProvider, error) {
+	exporter, err := config.buildExporter()
+	if err != nil {
+		return nil, err
+	}
+
+	res := resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceNameKey.String(config.Name),
+		semconv.ServiceVersionKey.String(pkg.AppVersion()),
+		semconv.ServiceInstanceIDKey.String(pkg.AppInstance()),
+		semconv.DeploymentEnvironmentKey.String(pkg.AppMode()),
+	)
+
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithSampler(config.buildSampler()),
+		sdktrace.WithResource(res),
+	}
+	if exporter != nil {
+		if config.Exporter == ExporterMemory {
+			opts = append(opts, sdktrace.WithSyncer(exporter))
+		} else {
+			opts = append(opts, sdktrace.WithBatcher(exporter, sdktrace.WithBatchTimeout(config.BatchTimeout)))
+		}
+	}
+
+	tp := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(config.buildPropagator())
+	return tp, nil
+}
+
+func (config *Config) buildExporter() (sdktrace.SpanExporter, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
+
+	switch config.Exporter {
+	case ExporterOTLP:
+		opts := []otlptracegrpc.Option{
+			otlptracegrpc.WithEndpoint(config.Endpoint),
+			otlptracegrpc.WithHeaders(config.Headers),
+			otlptracegrpc.WithTimeout(config.Timeout),
+		}
+		if config.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		return otlptracegrpc.New(ctx, opts...)
+	case ExporterOTLPHTTP:
+		opts := []otlptracehttp.Option{
+			otlptracehttp.WithEndpoint(config.Endpoint),
+			otlptracehttp.WithHeaders(config.Headers),
+			otlptracehttp.WithTimeout(config.Timeout),
+		}
+		if config.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		return otlptracehttp.New(ctx, opts...)
+	case ExporterStdout:
+		return stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case ExporterMemory:
+		config.memoryExporter = tracetest.NewInMemoryExporter()
+		return config.memoryExporter, nil
+	case ExporterNone, "":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown trace exporter: %s", config.Exporter)
+}
+
+func (config *Config) buildSampler() sdktrace.Sampler {
+	var sampler sdktrace.Sampler
+	switch config.Sampler {
+	case SamplerNever:
+		sampler = sdktrace.NeverSample()
+	case SamplerRatio:
+		sampler = sdktrace.TraceIDRatioBased(config.SamplerRatio)
+	default:
+		sampler = sdktrace.AlwaysSample()
+	}
+	if config.ParentBased {
+		sampler = sdktrace.ParentBased(sampler)
+	}
+	return sampler
+}
+
+func (config *Config) buildPropagator() propagation.TextMapPropagator {
+	propagators := make([]propagation.TextMapPropagator, 0, len(config.Propagators))
+	for _, name := range config.Propagators {
+		switch strings.ToLower(name) {
+		case "tracecontext", "w3c":
+			propagators = append(propagators, propagation.TraceContext{})
+		case "baggage":
+			propagators = append(propagators, propagation.Baggage{})
+		case "b3":
+			propagators = append(propagators, b3.New(b3.WithInjectEncoding(b3.B3SingleHeader)))
+		case "b3multi":
+			propagators = append(propagators, b3.New(b3.WithInjectEncoding(b3.B3MultipleHeader)))
+		default:
+			xlog.Jupiter().Warn("unknown trace propagator", xlog.FieldMod(ecode.ModTrace), xlog.FieldName(name))
+		}
+	}
+	if len(propagators) == 0 {
+		propagators = append(propagators, propagation.TraceContext{}, propagation.Baggage{})
+	}
+	return propagation.NewCompositeTextMapPropagator(propagators...)
+}
@@ -0,0 +1,63 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xtrace
+
+import (
+	"context"
+
+	"github.com/zhengyansheng/jupiter/pkg/conf"
+	"github.com/zhengyansheng/jupiter/pkg/core/constant"
+	"github.com/zhengyansheng/jupiter/pkg/core/ecode"
+	"github.com/zhengyansheng/jupiter/pkg/core/hooks"
+	"github.com/zhengyansheng/jupiter/pkg/xlog"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+var (
+	stdConfig *Config
+	provider  *sdktrace.TracerProvider
+)
+
+func init() {
+	hooks.Register(hooks.Stage_AfterLoadConfig, func() {
+		if conf.Get(constant.ConfigKey("trace")) == nil {
+			return
+		}
+
+		stdConfig = StdConfig()
+		if !stdConfig.Enable {
+			return
+		}
+
+		tp, err := stdConfig.Build()
+		if err != nil {
+			xlog.Jupiter().Error("build trace provider", xlog.FieldMod(ecode.ModTrace), xlog.FieldErr(err))
+			return
+		}
+		provider = tp
+		xlog.Jupiter().Info("build trace provider", xlog.FieldMod(ecode.ModTrace), xlog.String("exporter", stdConfig.Exporter), xlog.String("sampler", stdConfig.Sampler))
+	})
+
+	hooks.Register(hooks.Stage_AfterStop, func() {
+		if provider == nil {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), stdConfig.Timeout)
+		defer cancel()
+		if err := provider.Shutdown(ctx); err != nil {
+			xlog.Jupiter().Error("shutdown trace provider", xlog.FieldMod(ecode.ModTrace), xlog.FieldErr(err))
+		}
+	})
+}
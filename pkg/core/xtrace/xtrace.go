@@ -0,0 +1,94 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xtrace
+
+import (
+	"context"
+
+	"github.com/zhengyansheng/jupiter/pkg"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+)
+
+const instrumentationName = "github.com/zhengyansheng/jupiter"
+
+// Tracer creates spans of the given kind, and propagates span context
+// through the carrier: server/consumer spans extract from it, client/producer
+// spans inject into it.
+type Tracer struct {
+	kind trace.SpanKind
+}
+
+// NewTracer ...
+func NewTracer(kind trace.SpanKind) *Tracer {
+	return &Tracer{kind: kind}
+}
+
+// Start starts a span, carrier could be nil.
+func (t *Tracer) Start(ctx context.Context, spanName string, carrier propagation.TextMapCarrier, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	if carrier != nil && (t.kind == trace.SpanKindServer || t.kind == trace.SpanKindConsumer) {
+		ctx = otel.GetTextMapPropagator().Extract(ctx, carrier)
+	}
+
+	opts = append(opts, trace.WithSpanKind(t.kind))
+	ctx, span := otel.Tracer(instrumentationName, trace.WithInstrumentationVersion(pkg.JupiterVersion())).Start(ctx, spanName, opts...)
+
+	if carrier != nil && (t.kind == trace.SpanKindClient || t.kind == trace.SpanKindProducer) {
+		otel.GetTextMapPropagator().Inject(ctx, carrier)
+	}
+	return ctx, span
+}
+
+// ExtractTraceID returns the trace id in ctx, or empty string if there is none.
+func ExtractTraceID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.HasTraceID() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
+
+// MetadataCarrier adapts grpc metadata to propagation.TextMapCarrier.
+type MetadataCarrier metadata.MD
+
+var _ propagation.TextMapCarrier = MetadataCarrier{}
+
+// Get ...
+func (mc MetadataCarrier) Get(key string) string {
+	values := metadata.MD(mc).Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// Set ...
+func (mc MetadataCarrier) Set(key, value string) {
+	metadata.MD(mc).Set(key, value)
+}
+
+// Keys ...
+func (mc MetadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(mc))
+	for key := range mc {
+		keys = append(keys, key)
+	}
+	return keys
+}
@@ -0,0 +1,97 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xtrace
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+)
+
+func buildMemoryConfig(t *testing.T) *Config {
+	config := DefaultConfig()
+	config.Exporter = ExporterMemory
+	tp, err := config.Build()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = tp.Shutdown(context.Background())
+	})
+	return config
+}
+
+func TestTracerPropagation(t *testing.T) {
+	config := buildMemoryConfig(t)
+
+	header := http.Header{}
+	ctx, client := NewTracer(trace.SpanKindClient).Start(context.Background(), "client", propagation.HeaderCarrier(header))
+	assert.NotEmpty(t, header.Get("traceparent"))
+	assert.NotEmpty(t, header.Get("b3"))
+
+	_, server := NewTracer(trace.SpanKindServer).Start(context.Background(), "server", propagation.HeaderCarrier(header))
+	server.End()
+	client.End()
+
+	assert.Equal(t, ExtractTraceID(ctx), server.SpanContext().TraceID().String())
+
+	spans := config.MemoryExporter().GetSpans()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "server", spans[0].Name)
+	assert.Equal(t, trace.SpanKindServer, spans[0].SpanKind)
+	assert.Equal(t, client.SpanContext().SpanID(), spans[0].Parent.SpanID())
+	assert.Equal(t, "client", spans[1].Name)
+}
+
+func TestMetadataCarrier(t *testing.T) {
+	buildMemoryConfig(t)
+
+	md := metadata.MD{}
+	ctx, span := NewTracer(trace.SpanKindClient).Start(context.Background(), "grpc", MetadataCarrier(md))
+	defer span.End()
+
+	assert.Len(t, md.Get("traceparent"), 1)
+	assert.Contains(t, MetadataCarrier(md).Keys(), "traceparent")
+	assert.Contains(t, MetadataCarrier(md).Get("traceparent"), ExtractTraceID(ctx))
+}
+
+func TestSampler(t *testing.T) {
+	config := DefaultConfig()
+	config.Exporter = ExporterMemory
+	config.Sampler = SamplerNever
+	_, err := config.Build()
+	require.NoError(t, err)
+
+	_, span := NewTracer(trace.SpanKindInternal).Start(context.Background(), "dropped", nil)
+	span.End()
+
+	assert.False(t, span.SpanContext().IsSampled())
+	assert.Empty(t, config.MemoryExporter().GetSpans())
+}
+
+func TestUnknownExporter(t *testing.T) {
+	config := DefaultConfig()
+	config.Exporter = "unknown"
+	_, err := config.Build()
+	assert.Error(t, err)
+}
+
+func TestExtractTraceID(t *testing.T) {
+	assert.Empty(t, ExtractTraceID(context.Background()))
+}
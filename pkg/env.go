@@ -14,6 +14,7 @@ var (
 	appMode     string
 	appRegion   string
 	appZone     string
+	appDeploy   string
 	appHost     string
 	appInstance string
 	appPodIP    string
@@ -25,6 +26,7 @@ func InitEnv() {
 	appMode = os.Getenv(constant.EnvAppMode)
 	appRegion = os.Getenv(constant.EnvAppRegion)
 	appZone = os.Getenv(constant.EnvAppZone)
+	appDeploy = os.Getenv(constant.EnvDeployment)
 	appHost = os.Getenv(constant.EnvAppHost)
 	appInstance = os.Getenv(constant.EnvAppInstance)
 	if appInstance == "" {
@@ -79,6 +81,15 @@ func SetAppZone(zone string) {
 	appZone = zone
 }
 
+// AppDeployment returns the deployment group of application, traffic is isolated between deployments.
+func AppDeployment() string {
+	return appDeploy
+}
+
+func SetAppDeployment(deployment string) {
+	appDeploy = deployment
+}
+
 func AppHost() string {
 	return appHost
 }
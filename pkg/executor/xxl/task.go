@@ -9,6 +9,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/zhengyansheng/jupiter/pkg/core/metric"
 	"github.com/zhengyansheng/jupiter/pkg/executor"
 	"github.com/zhengyansheng/jupiter/pkg/executor/xxl/logger"
 )
@@ -92,6 +93,8 @@ func (t *Task) Run(ctx context.Context, cb CallbackFunc) {
 		case r := <-done:
 			atomic.StoreInt32(&t.running, 0)
 			if r.err != nil {
+				metric.JobHandleCounter.Inc("xxl", t.Name, metric.CodeJobFail)
+				metric.JobHandleHistogram.Observe(time.Since(beg).Seconds(), "xxl", t.Name, metric.CodeJobFail)
 				if r.taskResultType == TaskResultTypePanic {
 					t.Trace("执行异常退出")
 				} else {
@@ -103,10 +106,14 @@ func (t *Task) Run(ctx context.Context, cb CallbackFunc) {
 			if r.msg != "" {
 				msg = r.msg
 			}
+			metric.JobHandleCounter.Inc("xxl", t.Name, metric.CodeJobSuccess)
+			metric.JobHandleHistogram.Observe(time.Since(beg).Seconds(), "xxl", t.Name, metric.CodeJobSuccess)
 			t.Trace("执行完成")
 			_ = cb(ctx, TaskResultTypeDone, msg)
 		case <-ctx.Done():
 			atomic.StoreInt32(&t.running, 0)
+			metric.JobHandleCounter.Inc("xxl", t.Name, metric.CodeJobFail)
+			metric.JobHandleHistogram.Observe(time.Since(beg).Seconds(), "xxl", t.Name, metric.CodeJobFail)
 			if d, ok := ctx.Deadline(); ok && time.Now().After(d) {
 				t.Trace("任务超时")
 				_ = cb(ctx, TaskResultTypeTimeout, "任务超时")
@@ -0,0 +1,86 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"time"
+
+	"github.com/zhengyansheng/jupiter/pkg/conf"
+	"github.com/zhengyansheng/jupiter/pkg/core/constant"
+	"github.com/zhengyansheng/jupiter/pkg/core/ecode"
+	"github.com/zhengyansheng/jupiter/pkg/registry"
+	"github.com/zhengyansheng/jupiter/pkg/xlog"
+	"go.uber.org/zap"
+)
+
+// StdConfig ...
+func StdConfig(name string) *Config {
+	return RawConfig(constant.ConfigKey("registry." + name))
+}
+
+// RawConfig ...
+func RawConfig(key string) *Config {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(key, &config); err != nil {
+		xlog.Jupiter().Panic("unmarshal key", xlog.FieldMod(ecode.ModRegistryConsul), xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr), xlog.FieldErr(err), xlog.String("key", key), xlog.Any("config", config))
+	}
+	return config
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		Address:                        "127.0.0.1:8500",
+		Scheme:                         "http",
+		ReadTimeout:                    time.Second * 3,
+		WaitTime:                       time.Second * 30,
+		ServiceTTL:                     time.Second * 30,
+		DeregisterCriticalServiceAfter: time.Minute,
+		logger:                         xlog.Jupiter().Named(ecode.ModRegistryConsul),
+	}
+}
+
+// Config ...
+type Config struct {
+	// Address consul agent地址
+	Address string
+	// Scheme 访问consul的协议, http或https
+	Scheme     string
+	Token      string
+	Datacenter string
+	// ReadTimeout 注册、注销等请求的超时时间
+	ReadTimeout time.Duration
+	// WaitTime watch阻塞查询的最长等待时间
+	WaitTime time.Duration
+	// ServiceTTL TTL健康检查的周期, 为0时不注册健康检查
+	ServiceTTL time.Duration
+	// DeregisterCriticalServiceAfter 健康检查失败多久后注销服务
+	DeregisterCriticalServiceAfter time.Duration
+	logger                         *xlog.Logger
+}
+
+// Build ...
+func (config Config) Build() (registry.Registry, error) {
+	return newConsulRegistry(&config)
+}
+
+// MustBuild ...
+func (config Config) MustBuild() registry.Registry {
+	reg, err := config.Build()
+	if err != nil {
+		xlog.Jupiter().Panic("build registry failed", zap.Error(err))
+	}
+	return reg
+}
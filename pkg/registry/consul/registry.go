@@ -0,0 +1,343 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/zhengyansheng/jupiter/pkg/core/ecode"
+	"github.com/zhengyansheng/jupiter/pkg/registry"
+	"github.com/zhengyansheng/jupiter/pkg/server"
+	"github.com/zhengyansheng/jupiter/pkg/util/xgo"
+	"github.com/zhengyansheng/jupiter/pkg/xlog"
+)
+
+const (
+	// minRetryInterval is the initial interval to retry a failed watch
+	minRetryInterval = time.Second
+	// maxRetryInterval is the max interval to retry a failed watch
+	maxRetryInterval = time.Second * 30
+)
+
+type consulRegistry struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	client *api.Client
+	*Config
+	// services registered by this registry, service id => registration
+	services sync.Map
+
+	once sync.Once
+}
+
+var _ registry.Registry = new(consulRegistry)
+
+func newConsulRegistry(config *Config) (*consulRegistry, error) {
+	if config.logger == nil {
+		config.logger = xlog.Jupiter().Named(ecode.ModRegistryConsul)
+	}
+	config.logger = config.logger.With(xlog.FieldAddr(config.Address))
+
+	apiConfig := api.DefaultConfig()
+	apiConfig.Address = config.Address
+	apiConfig.Scheme = config.Scheme
+	apiConfig.Token = config.Token
+	apiConfig.Datacenter = config.Datacenter
+	client, err := api.NewClient(apiConfig)
+	if err != nil {
+		config.logger.Error("create consul client", xlog.FieldErrKind(ecode.ErrKindRequestErr), xlog.FieldErr(err))
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &consulRegistry{
+		ctx:    ctx,
+		cancel: cancel,
+		client: client,
+		Config: config,
+	}, nil
+}
+
+func (reg *consulRegistry) Kind() string { return "consul" }
+
+// RegisterService register service to consul agent
+func (reg *consulRegistry) RegisterService(ctx context.Context, info *server.ServiceInfo) error {
+	host, portStr, err := net.SplitHostPort(info.Address)
+	if err != nil {
+		return fmt.Errorf("invalid service address %s: %w", info.Address, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return fmt.Errorf("invalid service port %s: %w", info.Address, err)
+	}
+
+	id := serviceID(info)
+	registration := &api.AgentServiceRegistration{
+		ID:      id,
+		Name:    info.Name,
+		Tags:    []string{info.Scheme},
+		Address: host,
+		Port:    port,
+		Meta:    registry.ToMetadata(info),
+	}
+	if reg.ServiceTTL > 0 {
+		registration.Check = &api.AgentServiceCheck{
+			CheckID:                        checkID(id),
+			TTL:                            reg.ServiceTTL.String(),
+			DeregisterCriticalServiceAfter: reg.DeregisterCriticalServiceAfter.String(),
+		}
+	}
+
+	if err := reg.register(ctx, registration); err != nil {
+		return err
+	}
+
+	if reg.ServiceTTL > 0 {
+		reg.once.Do(func() {
+			// we use reg.ctx to stop the heartbeat loop on close
+			xgo.Go(func() { reg.doHeartbeat(reg.ctx) })
+		})
+	}
+	return nil
+}
+
+// UnregisterService unregister service from consul agent
+func (reg *consulRegistry) UnregisterService(ctx context.Context, info *server.ServiceInfo) error {
+	return reg.unregister(ctx, serviceID(info))
+}
+
+// ListServices list the passing services matched with prefix
+func (reg *consulRegistry) ListServices(ctx context.Context, prefix string) ([]*server.ServiceInfo, error) {
+	ctx, cancel := reg.withTimeout(ctx)
+	defer cancel()
+
+	key := registry.ParseServiceKey(prefix)
+	entries, _, err := reg.client.Health().ServiceMultipleTags(key.Name, tags(key), true, (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		reg.logger.Error("list services", xlog.FieldErrKind(ecode.ErrKindRequestErr), xlog.FieldErr(err), xlog.FieldKey(prefix))
+		return nil, err
+	}
+
+	services := make([]*server.ServiceInfo, 0, len(entries))
+	for _, entry := range entries {
+		info := toServiceInfo(key, entry)
+		if key.Match(&info) {
+			services = append(services, &info)
+		}
+	}
+	return services, nil
+}
+
+// WatchServices watch service change with consul blocking query, then return address list
+func (reg *consulRegistry) WatchServices(ctx context.Context, prefix string) (chan registry.Endpoints, error) {
+	key := registry.ParseServiceKey(prefix)
+	listCtx, cancel := reg.withTimeout(ctx)
+	entries, meta, err := reg.client.Health().ServiceMultipleTags(key.Name, tags(key), true, (&api.QueryOptions{}).WithContext(listCtx))
+	cancel()
+	if err != nil {
+		reg.logger.Error("watch services", xlog.FieldErrKind(ecode.MsgWatchRequestErr), xlog.FieldErr(err), xlog.FieldKey(prefix))
+		return nil, err
+	}
+
+	var addresses = make(chan registry.Endpoints, 10)
+	addresses <- *toEndpoints(key, entries)
+
+	xgo.Go(func() {
+		defer close(addresses)
+
+		lastIndex := meta.LastIndex
+		retryInterval := minRetryInterval
+		for {
+			opts := (&api.QueryOptions{WaitIndex: lastIndex, WaitTime: reg.WaitTime}).WithContext(ctx)
+			entries, meta, err := reg.client.Health().ServiceMultipleTags(key.Name, tags(key), true, opts)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				reg.logger.Warn("watch services", xlog.FieldErr(err), xlog.FieldKey(prefix), xlog.Duration("retry", retryInterval))
+				select {
+				case <-time.After(retryInterval):
+				case <-ctx.Done():
+					return
+				}
+				if retryInterval *= 2; retryInterval > maxRetryInterval {
+					retryInterval = maxRetryInterval
+				}
+				continue
+			}
+			retryInterval = minRetryInterval
+
+			// the index is unchanged when blocking query timeout
+			if meta.LastIndex == lastIndex {
+				continue
+			}
+			// reset the index if it goes backwards, see consul blocking query docs
+			if lastIndex = meta.LastIndex; lastIndex < opts.WaitIndex {
+				lastIndex = 0
+			}
+
+			select {
+			case addresses <- *toEndpoints(key, entries):
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+
+	return addresses, nil
+}
+
+// Close stops heartbeat and unregisters all services
+func (reg *consulRegistry) Close() error {
+	if reg.cancel != nil {
+		reg.cancel()
+	}
+	var wg sync.WaitGroup
+	reg.services.Range(func(k, v interface{}) bool {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if err := reg.unregister(ctx, id); err != nil {
+				reg.logger.Error("unregister service", xlog.FieldErrKind(ecode.ErrKindRequestErr), xlog.FieldErr(err), xlog.FieldKey(id))
+			} else {
+				reg.logger.Info("unregister service", xlog.FieldKey(id))
+			}
+		}(k.(string))
+		return true
+	})
+	wg.Wait()
+	return nil
+}
+
+func (reg *consulRegistry) register(ctx context.Context, registration *api.AgentServiceRegistration) error {
+	ctx, cancel := reg.withTimeout(ctx)
+	defer cancel()
+
+	err := reg.client.Agent().ServiceRegisterOpts(registration, api.ServiceRegisterOpts{}.WithContext(ctx))
+	if err != nil {
+		reg.logger.Error("register service", xlog.FieldErrKind(ecode.ErrKindRegisterErr), xlog.FieldErr(err), xlog.FieldKey(registration.ID))
+		return err
+	}
+
+	// mark the check passing at once, otherwise the service is critical until next heartbeat
+	if registration.Check != nil {
+		if err := reg.client.Agent().UpdateTTLOpts(registration.Check.CheckID, "", api.HealthPassing, (&api.QueryOptions{}).WithContext(ctx)); err != nil {
+			reg.logger.Warn("update ttl", xlog.FieldErr(err), xlog.FieldKey(registration.ID))
+		}
+	}
+
+	reg.logger.Info("register service", xlog.FieldKey(registration.ID), xlog.FieldValueAny(registration.Meta))
+	reg.services.Store(registration.ID, registration)
+	return nil
+}
+
+func (reg *consulRegistry) unregister(ctx context.Context, id string) error {
+	ctx, cancel := reg.withTimeout(ctx)
+	defer cancel()
+
+	err := reg.client.Agent().ServiceDeregisterOpts(id, (&api.QueryOptions{}).WithContext(ctx))
+	if err == nil {
+		reg.services.Delete(id)
+	}
+	return err
+}
+
+// doHeartbeat periodically passes the ttl checks of registered services,
+// the service is registered again if the agent lost it, eg: agent restarted.
+func (reg *consulRegistry) doHeartbeat(ctx context.Context) {
+	ticker := time.NewTicker(reg.ServiceTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			reg.logger.Debug("exit heartbeat")
+			return
+		}
+
+		reg.services.Range(func(k, v interface{}) bool {
+			registration := v.(*api.AgentServiceRegistration)
+			opts := (&api.QueryOptions{}).WithContext(ctx)
+			err := reg.client.Agent().UpdateTTLOpts(registration.Check.CheckID, "", api.HealthPassing, opts)
+			if err == nil {
+				return true
+			}
+
+			reg.logger.Warn("update ttl failed, register again", xlog.FieldErr(err), xlog.FieldKeyAny(k))
+			if err := reg.register(ctx, registration); err != nil {
+				reg.logger.Error("register again", xlog.FieldErrKind(ecode.ErrKindRegisterErr), xlog.FieldErr(err), xlog.FieldKeyAny(k))
+			}
+			return true
+		})
+	}
+}
+
+func (reg *consulRegistry) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, reg.ReadTimeout)
+}
+
+func serviceID(info *server.ServiceInfo) string {
+	return info.Scheme + ":" + info.Name + ":" + info.Address
+}
+
+func checkID(serviceID string) string {
+	return "service:" + serviceID
+}
+
+func tags(key registry.ServiceKey) []string {
+	if key.Scheme == "" {
+		return nil
+	}
+	return []string{key.Scheme}
+}
+
+func toServiceInfo(key registry.ServiceKey, entry *api.ServiceEntry) server.ServiceInfo {
+	info := registry.FromMetadata(entry.Service.Meta)
+	host := entry.Service.Address
+	if host == "" {
+		host = entry.Node.Address
+	}
+	info.Address = net.JoinHostPort(host, strconv.Itoa(entry.Service.Port))
+	if info.Name == "" {
+		info.Name = entry.Service.Service
+	}
+	if info.Scheme == "" {
+		info.Scheme = key.Scheme
+	}
+	return info
+}
+
+func toEndpoints(key registry.ServiceKey, entries []*api.ServiceEntry) *registry.Endpoints {
+	endpoints := registry.NewEndpoints()
+	for _, entry := range entries {
+		info := toServiceInfo(key, entry)
+		if key.Match(&info) {
+			endpoints.Nodes[info.Address] = info
+		}
+	}
+	return endpoints
+}
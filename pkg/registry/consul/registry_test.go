@@ -0,0 +1,199 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zhengyansheng/jupiter/pkg/core/constant"
+	"github.com/zhengyansheng/jupiter/pkg/registry/registrytest"
+	"github.com/zhengyansheng/jupiter/pkg/xlog"
+)
+
+// fakeConsul is an in-process consul agent, which implements the apis used by registry
+type fakeConsul struct {
+	mu       sync.Mutex
+	index    uint64
+	changed  chan struct{}
+	services map[string]*api.AgentServiceRegistration
+	checks   map[string]string
+}
+
+func newFakeConsul() *fakeConsul {
+	return &fakeConsul{
+		index:    1,
+		changed:  make(chan struct{}),
+		services: make(map[string]*api.AgentServiceRegistration),
+		checks:   make(map[string]string),
+	}
+}
+
+func (fc *fakeConsul) bump() {
+	fc.index++
+	close(fc.changed)
+	fc.changed = make(chan struct{})
+}
+
+func (fc *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	switch path := r.URL.Path; {
+	case path == "/v1/agent/service/register":
+		var registration api.AgentServiceRegistration
+		if err := json.NewDecoder(r.Body).Decode(&registration); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fc.services[registration.ID] = &registration
+		if registration.Check != nil {
+			fc.checks[registration.Check.CheckID] = api.HealthCritical
+		}
+		fc.bump()
+	case strings.HasPrefix(path, "/v1/agent/service/deregister/"):
+		id := strings.TrimPrefix(path, "/v1/agent/service/deregister/")
+		if registration, ok := fc.services[id]; ok && registration.Check != nil {
+			delete(fc.checks, registration.Check.CheckID)
+		}
+		delete(fc.services, id)
+		fc.bump()
+	case strings.HasPrefix(path, "/v1/agent/check/update/"):
+		id := strings.TrimPrefix(path, "/v1/agent/check/update/")
+		if _, ok := fc.checks[id]; !ok {
+			http.Error(w, "unknown check", http.StatusNotFound)
+			return
+		}
+		var update struct{ Status string }
+		_ = json.NewDecoder(r.Body).Decode(&update)
+		if fc.checks[id] != update.Status {
+			fc.checks[id] = update.Status
+			fc.bump()
+		}
+	case strings.HasPrefix(path, "/v1/health/service/"):
+		fc.serveHealth(w, r, strings.TrimPrefix(path, "/v1/health/service/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (fc *fakeConsul) serveHealth(w http.ResponseWriter, r *http.Request, name string) {
+	query := r.URL.Query()
+	if index, _ := strconv.ParseUint(query.Get("index"), 10, 64); index >= fc.index {
+		wait, _ := time.ParseDuration(query.Get("wait"))
+		changed := fc.changed
+		fc.mu.Unlock()
+		select {
+		case <-changed:
+		case <-time.After(wait):
+		case <-r.Context().Done():
+		}
+		fc.mu.Lock()
+	}
+
+	entries := make([]*api.ServiceEntry, 0)
+	for _, registration := range fc.services {
+		if registration.Name != name {
+			continue
+		}
+		if tag := query.Get("tag"); tag != "" && !contains(registration.Tags, tag) {
+			continue
+		}
+		if registration.Check != nil && query.Has("passing") && fc.checks[registration.Check.CheckID] != api.HealthPassing {
+			continue
+		}
+		entries = append(entries, &api.ServiceEntry{
+			Node: &api.Node{Address: "127.0.0.1"},
+			Service: &api.AgentService{
+				ID:      registration.ID,
+				Service: registration.Name,
+				Tags:    registration.Tags,
+				Address: registration.Address,
+				Port:    registration.Port,
+				Meta:    registration.Meta,
+			},
+		})
+	}
+
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(fc.index, 10))
+	_ = json.NewEncoder(w).Encode(entries)
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
+func newTestRegistry(t *testing.T) (*consulRegistry, *fakeConsul) {
+	fc := newFakeConsul()
+	srv := httptest.NewServer(fc)
+	t.Cleanup(srv.Close)
+
+	config := DefaultConfig()
+	config.Address = strings.TrimPrefix(srv.URL, "http://")
+	config.WaitTime = time.Second
+	config.ServiceTTL = time.Millisecond * 300
+	config.logger = xlog.Jupiter()
+	reg, err := newConsulRegistry(config)
+	require.Nil(t, err)
+	return reg, fc
+}
+
+func Test_consulRegistry(t *testing.T) {
+	reg, _ := newTestRegistry(t)
+	registrytest.TestRegistry(t, reg)
+
+	t.Run("heartbeat", func(t *testing.T) {
+		reg, fc := newTestRegistry(t)
+		ctx := context.Background()
+		info := registrytest.NewServiceInfo("10.10.10.1:9091")
+		require.Nil(t, reg.RegisterService(ctx, info))
+
+		services, err := reg.ListServices(ctx, "grpc:"+registrytest.ServiceName)
+		require.Nil(t, err)
+		require.Len(t, services, 1)
+		assert.Equal(t, constant.ServiceProvider, services[0].Kind)
+
+		// the agent lost the service, heartbeat should register it again
+		fc.mu.Lock()
+		fc.services = make(map[string]*api.AgentServiceRegistration)
+		fc.checks = make(map[string]string)
+		fc.mu.Unlock()
+		assert.Eventually(t, func() bool {
+			services, err := reg.ListServices(ctx, "grpc:"+registrytest.ServiceName)
+			return err == nil && len(services) == 1
+		}, time.Second*2, time.Millisecond*50)
+		require.Nil(t, reg.Close())
+	})
+}
+
+func Test_consulRegistry_WatchServices(t *testing.T) {
+	reg, _ := newTestRegistry(t)
+	registrytest.TestWatchServices(t, reg)
+}
@@ -35,7 +35,8 @@ type Endpoints struct {
 	ProviderConfigs map[string]ProviderConfig
 }
 
-func newEndpoints() *Endpoints {
+// NewEndpoints ...
+func NewEndpoints() *Endpoints {
 	return &Endpoints{
 		Nodes:           make(map[string]server.ServiceInfo),
 		RouteConfigs:    make(map[string]RouteConfig),
@@ -49,7 +50,7 @@ func (in *Endpoints) DeepCopy() *Endpoints {
 		return nil
 	}
 
-	out := newEndpoints()
+	out := NewEndpoints()
 	in.DeepCopyInfo(out)
 	return out
 }
@@ -15,11 +15,35 @@
 package etcdv3
 
 import (
+	"net/http"
+	"sync"
+
+	jsoniter "github.com/json-iterator/go"
 	"github.com/zhengyansheng/jupiter/pkg/registry"
+	"github.com/zhengyansheng/jupiter/pkg/server/governor"
 )
 
+// registries not closed yet, *etcdv3Registry => struct{}
+var registries sync.Map
+
 func init() {
-	registry.RegisterBuilder("etcdv3", func(confKey string) registry.Registry {
-		return RawConfig(confKey).MustBuild()
+	governor.HandleFunc("/debug/registry/etcdv3", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = jsoniter.NewEncoder(w).Encode(States())
+	})
+	registry.RegisterBuilder("etcdv3", func(confKey string) (registry.Registry, error) {
+		return RawConfig(confKey).Build()
+	})
+	// etcd is the scheme used by grpc client, eg: etcd:///main
+	registry.RegisterAlias("etcd", "etcdv3")
+}
+
+// States returns the states of all registries not closed yet
+func States() []State {
+	states := make([]State, 0)
+	registries.Range(func(key, _ interface{}) bool {
+		states = append(states, key.(*etcdv3Registry).State())
+		return true
 	})
+	return states
 }
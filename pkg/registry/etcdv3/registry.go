@@ -19,6 +19,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -44,6 +45,9 @@ type etcdv3Registry struct {
 	cancel  context.CancelFunc
 	rmu     *sync.RWMutex
 	leaseID clientv3.LeaseID
+	// lease ttl and time of the last keepalive response, guarded by rmu
+	leaseTTL      int64
+	lastKeepalive time.Time
 
 	once sync.Once
 }
@@ -78,6 +82,7 @@ func newETCDRegistry(config *Config) (*etcdv3Registry, error) {
 		kvs:    sync.Map{},
 		rmu:    &sync.RWMutex{},
 	}
+	registries.Store(reg, struct{}{})
 
 	return reg, nil
 }
@@ -115,9 +120,12 @@ func (reg *etcdv3Registry) ListServices(ctx context.Context, prefix string) (ser
 			continue
 		}
 
-		services = append(services, &server.ServiceInfo{
-			Address: service.Addr,
-		})
+		var info server.ServiceInfo
+		if service.MetadataX != nil {
+			info = *service.MetadataX
+		}
+		info.Address = service.Addr
+		services = append(services, &info)
 	}
 
 	return
@@ -192,6 +200,7 @@ func (reg *etcdv3Registry) Close() error {
 	if reg.cancel != nil {
 		reg.cancel()
 	}
+	registries.Delete(reg)
 	var wg sync.WaitGroup
 	reg.kvs.Range(func(k, v interface{}) bool {
 		wg.Add(1)
@@ -294,6 +303,67 @@ func (reg *etcdv3Registry) setLeaseID(leaseId clientv3.LeaseID) {
 	defer reg.rmu.Unlock()
 
 	reg.leaseID = leaseId
+	reg.leaseTTL = 0
+}
+
+func (reg *etcdv3Registry) setKeepalive(ttl int64) {
+	reg.rmu.Lock()
+	defer reg.rmu.Unlock()
+
+	reg.leaseTTL = ttl
+	reg.lastKeepalive = time.Now()
+}
+
+// State is the registration state of etcdv3 registry
+type State struct {
+	Endpoints  []string `json:"endpoints"`
+	ServiceTTL string   `json:"serviceTTL"`
+	// LeaseID is the hex lease id, empty when the lease is not granted or lost
+	LeaseID string `json:"leaseId"`
+	// LeaseTTL is the remaining ttl in seconds reported by the last keepalive
+	LeaseTTL      int64     `json:"leaseTTL"`
+	LastKeepalive time.Time `json:"lastKeepalive"`
+	// Keys are all keys registered by this registry, including prometheus keys
+	Keys     []string              `json:"keys"`
+	Services []*server.ServiceInfo `json:"services"`
+}
+
+// State returns the registered services and lease state of registry
+func (reg *etcdv3Registry) State() State {
+	state := State{
+		ServiceTTL: reg.ServiceTTL.String(),
+		Keys:       make([]string, 0),
+		Services:   make([]*server.ServiceInfo, 0),
+	}
+	if reg.Config.Config != nil {
+		state.Endpoints = reg.Config.Config.Endpoints
+	}
+
+	reg.rmu.RLock()
+	if reg.leaseID != 0 {
+		state.LeaseID = fmt.Sprintf("%x", int64(reg.leaseID))
+	}
+	state.LeaseTTL = reg.leaseTTL
+	state.LastKeepalive = reg.lastKeepalive
+	reg.rmu.RUnlock()
+
+	reg.kvs.Range(func(key, value any) bool {
+		state.Keys = append(state.Keys, key.(string))
+		var update registry.Update
+		if err := json.Unmarshal([]byte(value.(string)), &update); err != nil {
+			// prometheus key, the value is address only
+			return true
+		}
+		if update.MetadataX != nil {
+			state.Services = append(state.Services, update.MetadataX)
+		}
+		return true
+	})
+	sort.Strings(state.Keys)
+	sort.SliceStable(state.Services, func(i, j int) bool {
+		return state.Services[i].RegistryName() < state.Services[j].RegistryName()
+	})
+	return state
 }
 
 // doKeepAlive periodically sends keep alive requests to etcd server.
@@ -365,6 +435,7 @@ func (reg *etcdv3Registry) doKeepalive(ctx context.Context) {
 				continue
 			}
 
+			reg.setKeepalive(data.TTL)
 			// just record detailed keepalive info
 			reg.logger.Debug("do keepalive", xlog.Any("data", data), xlog.String("leaseid", fmt.Sprintf("%x", reg.getLeaseID())))
 		case <-reg.ctx.Done():
@@ -439,9 +510,13 @@ func updateAddrList(al *registry.Endpoints, prefix, scheme string, kvs ...*mvccp
 
 			switch meta.Op {
 			case registry.Add:
-				al.Nodes[addr] = server.ServiceInfo{
-					Address: addr,
+				// keep the service info, which is used by balancer, eg: zone, deployment
+				var info server.ServiceInfo
+				if meta.MetadataX != nil {
+					info = *meta.MetadataX
 				}
+				info.Address = addr
+				al.Nodes[addr] = info
 			case registry.Delete:
 				delete(al.Nodes, addr)
 			}
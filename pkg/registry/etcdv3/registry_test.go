@@ -17,6 +17,7 @@ package etcdv3
 import (
 	"context"
 	"fmt"
+	"sync"
 	"testing"
 	"time"
 
@@ -195,3 +196,31 @@ func TestKeepalive(t *testing.T) {
 	_ = reg.Close()
 	time.Sleep(time.Second * 1)
 }
+
+func Test_etcdv3Registry_State(t *testing.T) {
+	reg := &etcdv3Registry{
+		Config: DefaultConfig(),
+		rmu:    &sync.RWMutex{},
+	}
+	info := &server.ServiceInfo{
+		Name:    "service_1",
+		Scheme:  "grpc",
+		Address: "10.10.10.1:9091",
+		Kind:    constant.ServiceProvider,
+	}
+	reg.kvs.Store(reg.registerKey(info), reg.registerValue(info))
+	reg.kvs.Store("/prometheus/job/service_1/host", "10.10.10.1:9092")
+
+	state := reg.State()
+	assert.Equal(t, "", state.LeaseID)
+	assert.Equal(t, "1m0s", state.ServiceTTL)
+	assert.Equal(t, []string{"/prometheus/job/service_1/host", info.RegistryName()}, state.Keys)
+	assert.Equal(t, []*server.ServiceInfo{info}, state.Services)
+
+	reg.leaseID = 0x694d7e4b2c1a
+	reg.setKeepalive(60)
+	state = reg.State()
+	assert.Equal(t, "694d7e4b2c1a", state.LeaseID)
+	assert.Equal(t, int64(60), state.LeaseTTL)
+	assert.False(t, state.LastKeepalive.IsZero())
+}
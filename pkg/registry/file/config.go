@@ -0,0 +1,67 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package file
+
+import (
+	"github.com/zhengyansheng/jupiter/pkg/conf"
+	"github.com/zhengyansheng/jupiter/pkg/core/constant"
+	"github.com/zhengyansheng/jupiter/pkg/core/ecode"
+	"github.com/zhengyansheng/jupiter/pkg/registry"
+	"github.com/zhengyansheng/jupiter/pkg/xlog"
+	"go.uber.org/zap"
+)
+
+// StdConfig ...
+func StdConfig(name string) *Config {
+	return RawConfig(constant.ConfigKey("registry." + name))
+}
+
+// RawConfig ...
+func RawConfig(key string) *Config {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(key, &config); err != nil {
+		xlog.Jupiter().Panic("unmarshal key", xlog.FieldMod(ecode.ModRegistryFile), xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr), xlog.FieldErr(err), xlog.String("key", key), xlog.Any("config", config))
+	}
+	return config
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		Path:   "./registry.json",
+		logger: xlog.Jupiter().Named(ecode.ModRegistryFile),
+	}
+}
+
+// Config ...
+type Config struct {
+	// Path 服务列表文件路径, 按扩展名解析: .json, .toml, .yaml
+	Path   string
+	logger *xlog.Logger
+}
+
+// Build ...
+func (config Config) Build() (registry.Registry, error) {
+	return newFileRegistry(&config)
+}
+
+// MustBuild ...
+func (config Config) MustBuild() registry.Registry {
+	reg, err := config.Build()
+	if err != nil {
+		xlog.Jupiter().Panic("build registry failed", zap.Error(err))
+	}
+	return reg
+}
@@ -0,0 +1,298 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package file
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"github.com/fsnotify/fsnotify"
+	"github.com/zhengyansheng/jupiter/pkg/core/ecode"
+	"github.com/zhengyansheng/jupiter/pkg/registry"
+	"github.com/zhengyansheng/jupiter/pkg/server"
+	"github.com/zhengyansheng/jupiter/pkg/util/xgo"
+	"github.com/zhengyansheng/jupiter/pkg/xlog"
+	"gopkg.in/yaml.v3"
+)
+
+// services is the content of registry file
+type services struct {
+	Services []*server.ServiceInfo `json:"services" toml:"services" yaml:"services"`
+}
+
+// fileRegistry keeps services in a static file, which is useful for local
+// development and tests. The file is rewritten on register and unregister,
+// it should be written by one process only.
+type fileRegistry struct {
+	path string
+	*Config
+	mu sync.Mutex
+	// services registered by this registry, service id => service info
+	services sync.Map
+}
+
+var _ registry.Registry = new(fileRegistry)
+
+func newFileRegistry(config *Config) (*fileRegistry, error) {
+	if config.logger == nil {
+		config.logger = xlog.Jupiter().Named(ecode.ModRegistryFile)
+	}
+
+	path, err := filepath.Abs(config.Path)
+	if err != nil {
+		return nil, err
+	}
+	switch filepath.Ext(path) {
+	case ".json", ".toml", ".yaml", ".yml":
+	default:
+		return nil, fmt.Errorf("unsupported registry file: %s", path)
+	}
+	config.logger = config.logger.With(xlog.String("path", path))
+
+	return &fileRegistry{
+		path:   path,
+		Config: config,
+	}, nil
+}
+
+func (reg *fileRegistry) Kind() string { return "file" }
+
+// RegisterService adds or replaces the service in file
+func (reg *fileRegistry) RegisterService(ctx context.Context, info *server.ServiceInfo) error {
+	err := reg.update(func(content *services) {
+		content.Services = remove(content.Services, info)
+		content.Services = append(content.Services, info)
+	})
+	if err != nil {
+		reg.logger.Error("register service", xlog.FieldErrKind(ecode.ErrKindRegisterErr), xlog.FieldErr(err), xlog.FieldKey(serviceID(info)))
+		return err
+	}
+
+	reg.logger.Info("register service", xlog.FieldKey(serviceID(info)))
+	reg.services.Store(serviceID(info), info)
+	return nil
+}
+
+// UnregisterService removes the service from file
+func (reg *fileRegistry) UnregisterService(ctx context.Context, info *server.ServiceInfo) error {
+	err := reg.update(func(content *services) {
+		content.Services = remove(content.Services, info)
+	})
+	if err == nil {
+		reg.services.Delete(serviceID(info))
+	}
+	return err
+}
+
+// ListServices list services in file matched with prefix
+func (reg *fileRegistry) ListServices(ctx context.Context, prefix string) ([]*server.ServiceInfo, error) {
+	content, err := reg.read()
+	if err != nil {
+		reg.logger.Error("list services", xlog.FieldErrKind(ecode.ErrKindRequestErr), xlog.FieldErr(err), xlog.FieldKey(prefix))
+		return nil, err
+	}
+
+	key := registry.ParseServiceKey(prefix)
+	list := make([]*server.ServiceInfo, 0)
+	for _, info := range content.Services {
+		if key.Match(info) {
+			list = append(list, info)
+		}
+	}
+	return list, nil
+}
+
+// WatchServices watch the file change, then return address list
+func (reg *fileRegistry) WatchServices(ctx context.Context, prefix string) (chan registry.Endpoints, error) {
+	// watch the dir, so the file replaced by rename is also watched
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := watcher.Add(filepath.Dir(reg.path)); err != nil {
+		watcher.Close()
+		reg.logger.Error("watch services", xlog.FieldErrKind(ecode.MsgWatchRequestErr), xlog.FieldErr(err), xlog.FieldKey(prefix))
+		return nil, err
+	}
+
+	key := registry.ParseServiceKey(prefix)
+	last, err := reg.endpoints(key)
+	if err != nil {
+		watcher.Close()
+		reg.logger.Error("watch services", xlog.FieldErrKind(ecode.MsgWatchRequestErr), xlog.FieldErr(err), xlog.FieldKey(prefix))
+		return nil, err
+	}
+
+	var addresses = make(chan registry.Endpoints, 10)
+	addresses <- *last
+
+	xgo.Go(func() {
+		defer close(addresses)
+		defer watcher.Close()
+
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != reg.path {
+					continue
+				}
+
+				endpoints, err := reg.endpoints(key)
+				if err != nil {
+					// the file may be written partially by others, wait for next event
+					reg.logger.Warn("read registry file", xlog.FieldErr(err), xlog.FieldKey(prefix))
+					continue
+				}
+				if reflect.DeepEqual(endpoints, last) {
+					continue
+				}
+				last = endpoints
+
+				select {
+				case addresses <- *endpoints.DeepCopy():
+				case <-ctx.Done():
+					return
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				reg.logger.Warn("watch registry file", xlog.FieldErr(err), xlog.FieldKey(prefix))
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+
+	return addresses, nil
+}
+
+// Close unregisters all services
+func (reg *fileRegistry) Close() error {
+	reg.services.Range(func(k, v interface{}) bool {
+		if err := reg.UnregisterService(context.Background(), v.(*server.ServiceInfo)); err != nil {
+			reg.logger.Error("unregister service", xlog.FieldErrKind(ecode.ErrKindRequestErr), xlog.FieldErr(err), xlog.FieldKeyAny(k))
+		} else {
+			reg.logger.Info("unregister service", xlog.FieldKeyAny(k))
+		}
+		return true
+	})
+	return nil
+}
+
+func (reg *fileRegistry) endpoints(key registry.ServiceKey) (*registry.Endpoints, error) {
+	content, err := reg.read()
+	if err != nil {
+		return nil, err
+	}
+
+	endpoints := registry.NewEndpoints()
+	for _, info := range content.Services {
+		if key.Match(info) {
+			endpoints.Nodes[info.Address] = *info
+		}
+	}
+	return endpoints, nil
+}
+
+// read returns the services in file, empty if file doesn't exist
+func (reg *fileRegistry) read() (*services, error) {
+	var content services
+	data, err := os.ReadFile(reg.path)
+	if os.IsNotExist(err) {
+		return &content, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch filepath.Ext(reg.path) {
+	case ".json":
+		err = json.Unmarshal(data, &content)
+	case ".toml":
+		err = toml.Unmarshal(data, &content)
+	default:
+		err = yaml.Unmarshal(data, &content)
+	}
+	return &content, err
+}
+
+// update modifies the services in file, the file is replaced by rename to
+// avoid others reading a partial file
+func (reg *fileRegistry) update(fn func(*services)) error {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	content, err := reg.read()
+	if err != nil {
+		return err
+	}
+	fn(content)
+
+	var data []byte
+	switch filepath.Ext(reg.path) {
+	case ".json":
+		data, err = json.MarshalIndent(content, "", "  ")
+	case ".toml":
+		var buf bytes.Buffer
+		err = toml.NewEncoder(&buf).Encode(content)
+		data = buf.Bytes()
+	default:
+		data, err = yaml.Marshal(content)
+	}
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(reg.path), filepath.Base(reg.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), reg.path)
+}
+
+func serviceID(info *server.ServiceInfo) string {
+	return info.Scheme + ":" + info.Name + ":" + info.Address
+}
+
+// remove removes the service with the same id from list
+func remove(list []*server.ServiceInfo, info *server.ServiceInfo) []*server.ServiceInfo {
+	out := make([]*server.ServiceInfo, 0, len(list))
+	for _, item := range list {
+		if serviceID(item) != serviceID(info) {
+			out = append(out, item)
+		}
+	}
+	return out
+}
@@ -0,0 +1,77 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package file
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zhengyansheng/jupiter/pkg/registry/registrytest"
+)
+
+func newTestRegistry(t *testing.T, ext string) *fileRegistry {
+	config := DefaultConfig()
+	config.Path = filepath.Join(t.TempDir(), "registry"+ext)
+	reg, err := newFileRegistry(config)
+	require.Nil(t, err)
+	return reg
+}
+
+func Test_fileRegistry(t *testing.T) {
+	for _, ext := range []string{".json", ".toml", ".yaml"} {
+		t.Run(ext, func(t *testing.T) {
+			registrytest.TestRegistry(t, newTestRegistry(t, ext))
+		})
+	}
+
+	t.Run("restore", func(t *testing.T) {
+		reg := newTestRegistry(t, ".json")
+		info := registrytest.NewServiceInfo("10.10.10.1:9091")
+		require.Nil(t, reg.RegisterService(context.Background(), info))
+
+		// 其他实例可以读取到完整的服务信息
+		other, err := newFileRegistry(reg.Config)
+		require.Nil(t, err)
+		services, err := other.ListServices(context.Background(), "grpc:"+registrytest.ServiceName)
+		require.Nil(t, err)
+		require.Len(t, services, 1)
+		assert.Equal(t, info, services[0])
+	})
+
+	_, err := newFileRegistry(&Config{Path: "registry.ini"})
+	assert.NotNil(t, err)
+}
+
+func Test_fileRegistry_WatchServices(t *testing.T) {
+	registrytest.TestWatchServices(t, newTestRegistry(t, ".json"))
+
+	t.Run("edited by others", func(t *testing.T) {
+		reg := newTestRegistry(t, ".json")
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		ch, err := reg.WatchServices(ctx, "grpc:"+registrytest.ServiceName)
+		require.Nil(t, err)
+		registrytest.Receive(t, ch)
+
+		require.Nil(t, os.WriteFile(reg.Path, []byte(`{"services":[{"name":"service-1","scheme":"grpc","address":"10.10.10.1:9092"}]}`), 0644))
+		endpoints := registrytest.Receive(t, ch)
+		require.Len(t, endpoints.Nodes, 1)
+		assert.Contains(t, endpoints.Nodes, "10.10.10.1:9092")
+	})
+}
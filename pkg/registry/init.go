@@ -15,18 +15,28 @@
 package registry
 
 import (
+	"fmt"
 	"log"
+	"sort"
+	"sync"
 
 	"github.com/zhengyansheng/jupiter/pkg/conf"
 	"github.com/zhengyansheng/jupiter/pkg/core/constant"
+	"github.com/zhengyansheng/jupiter/pkg/core/singleton"
 	"github.com/zhengyansheng/jupiter/pkg/xlog"
 )
 
 // var _registerers = sync.Map{}
-var registryBuilder = make(map[string]Builder)
+var registryBuilder = make(map[string]BuildFunc)
+
+// registryAlias alias => kind, eg: etcd => etcdv3
+var registryAlias = make(map[string]string)
+
+// singletonMu makes the check and build in Singleton atomic
+var singletonMu sync.Mutex
 
 type Config map[string]struct {
-	Kind          string `json:"kind" description:"底层注册器类型, eg: etcdv3, consul"`
+	Kind          string `json:"kind" description:"底层注册器类型, eg: etcdv3, consul, nacos, k8s, file"`
 	ConfigKey     string `json:"configKey" description:"底册注册器的配置键"`
 	DeplaySeconds int    `json:"deplaySeconds" description:"延迟注册"`
 }
@@ -54,25 +64,81 @@ func init() {
 				item.ConfigKey = constant.ConfigKey("registry.default")
 			}
 
-			build, ok := registryBuilder[itemKind]
-			if !ok {
+			if _, ok := registryBuilder[itemKind]; !ok {
 				xlog.Jupiter().Sugar().Infof("invalid registry kind: %s", itemKind)
 				continue
 			}
 
 			xlog.Jupiter().Sugar().Infof("build registrerer %s with config: %s", name, item.ConfigKey)
-			DefaultRegisterer = build(item.ConfigKey)
+			reg, err := Singleton(itemKind, item.ConfigKey)
+			if err != nil {
+				xlog.Jupiter().Panic("build registry failed", xlog.FieldName(name), xlog.FieldKey(item.ConfigKey), xlog.FieldErr(err))
+			}
+			DefaultRegisterer = reg
 		}
 	})
 }
 
-type Builder func(string) Registry
-
+// BuildFunc builds a registry with the config key
 type BuildFunc func(string) (Registry, error)
 
-func RegisterBuilder(kind string, build Builder) {
+// RegisterBuilder registers the build func of kind, kind is also used as
+// the scheme of grpc resolver, eg: etcdv3, consul, nacos, k8s, file
+func RegisterBuilder(kind string, build BuildFunc) {
 	if _, ok := registryBuilder[kind]; ok {
 		log.Panicf("duplicate register registry builder: %s", kind)
 	}
 	registryBuilder[kind] = build
 }
+
+// RegisterAlias registers alias as another scheme of kind, the registries of
+// alias and kind built with the same config key are shared
+func RegisterAlias(alias string, kind string) {
+	build, ok := registryBuilder[kind]
+	if !ok {
+		log.Panicf("register alias %s of unknown registry kind: %s", alias, kind)
+	}
+	RegisterBuilder(alias, build)
+	registryAlias[alias] = kind
+}
+
+// Kinds returns all registered registry kinds
+func Kinds() []string {
+	kinds := make([]string, 0, len(registryBuilder))
+	for kind := range registryBuilder {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// Build builds a registry of kind with the config key
+func Build(kind string, configKey string) (Registry, error) {
+	build, ok := registryBuilder[kind]
+	if !ok {
+		return nil, fmt.Errorf("invalid registry kind: %s, maybe the registry package is not imported", kind)
+	}
+	return build(configKey)
+}
+
+// Singleton returns the registry of kind built with the config key, it's built only once
+func Singleton(kind string, configKey string) (Registry, error) {
+	if name, ok := registryAlias[kind]; ok {
+		kind = name
+	}
+	key := kind + "@" + configKey
+
+	singletonMu.Lock()
+	defer singletonMu.Unlock()
+	if val, ok := singleton.Load(constant.ModuleRegistry, key); ok && val != nil {
+		return val.(Registry), nil
+	}
+
+	reg, err := Build(kind, configKey)
+	if err != nil {
+		return nil, err
+	}
+
+	singleton.Store(constant.ModuleRegistry, key, reg)
+	return reg, nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSingleton(t *testing.T) {
+	var builds atomic.Int32
+	RegisterBuilder("singleton-test", func(string) (Registry, error) {
+		builds.Add(1)
+		return &Local{}, nil
+	})
+	RegisterAlias("singleton-alias", "singleton-test")
+	assert.Contains(t, Kinds(), "singleton-alias")
+
+	// 并发获取及别名获取的都是同一个实例
+	var wg sync.WaitGroup
+	regs := make([]Registry, 10)
+	for i := range regs {
+		kind := "singleton-test"
+		if i%2 == 0 {
+			kind = "singleton-alias"
+		}
+		wg.Add(1)
+		go func(i int, kind string) {
+			defer wg.Done()
+			reg, err := Singleton(kind, "jupiter.registry.singleton")
+			assert.Nil(t, err)
+			regs[i] = reg
+		}(i, kind)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), builds.Load())
+	for _, reg := range regs {
+		assert.Same(t, regs[0], reg)
+	}
+
+	_, err := Singleton("singleton-test", "jupiter.registry.other")
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), builds.Load())
+}
@@ -0,0 +1,110 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8s
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/zhengyansheng/jupiter/pkg/conf"
+	"github.com/zhengyansheng/jupiter/pkg/core/constant"
+	"github.com/zhengyansheng/jupiter/pkg/core/ecode"
+	"github.com/zhengyansheng/jupiter/pkg/registry"
+	"github.com/zhengyansheng/jupiter/pkg/xlog"
+	"go.uber.org/zap"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// namespaceFile is the namespace of pod mounted by service account
+const namespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// StdConfig ...
+func StdConfig(name string) *Config {
+	return RawConfig(constant.ConfigKey("registry." + name))
+}
+
+// RawConfig ...
+func RawConfig(key string) *Config {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(key, &config); err != nil {
+		xlog.Jupiter().Panic("unmarshal key", xlog.FieldMod(ecode.ModRegistryK8s), xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr), xlog.FieldErr(err), xlog.String("key", key), xlog.Any("config", config))
+	}
+	return config
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		ReadTimeout: time.Second * 3,
+		logger:      xlog.Jupiter().Named(ecode.ModRegistryK8s),
+	}
+}
+
+// Config ...
+type Config struct {
+	// Kubeconfig kubeconfig文件路径, 为空时使用in-cluster配置
+	Kubeconfig string
+	// Namespace Endpoints所在命名空间, 为空时使用pod所在命名空间
+	Namespace string
+	// ReadTimeout 请求apiserver的超时时间
+	ReadTimeout time.Duration
+	logger      *xlog.Logger
+}
+
+// Build ...
+func (config Config) Build() (registry.Registry, error) {
+	var (
+		restConfig *rest.Config
+		err        error
+	)
+	if config.Kubeconfig != "" {
+		restConfig, err = clientcmd.BuildConfigFromFlags("", config.Kubeconfig)
+	} else {
+		restConfig, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	return newK8sRegistry(&config, clientset), nil
+}
+
+// MustBuild ...
+func (config Config) MustBuild() registry.Registry {
+	reg, err := config.Build()
+	if err != nil {
+		xlog.Jupiter().Panic("build registry failed", zap.Error(err))
+	}
+	return reg
+}
+
+func (config *Config) namespace() string {
+	if config.Namespace != "" {
+		return config.Namespace
+	}
+	if data, err := os.ReadFile(namespaceFile); err == nil {
+		if ns := strings.TrimSpace(string(data)); ns != "" {
+			return ns
+		}
+	}
+	return "default"
+}
@@ -0,0 +1,449 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zhengyansheng/jupiter/pkg/core/ecode"
+	"github.com/zhengyansheng/jupiter/pkg/registry"
+	"github.com/zhengyansheng/jupiter/pkg/server"
+	"github.com/zhengyansheng/jupiter/pkg/util/xgo"
+	"github.com/zhengyansheng/jupiter/pkg/xlog"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// annotationPrefix is the prefix of annotation keeping service info of address
+	annotationPrefix = "jupiter.io/"
+	// managedByLabel marks the endpoints created by registry
+	managedByLabel = "app.kubernetes.io/managed-by"
+
+	minRetryInterval = time.Second
+	maxRetryInterval = time.Second * 30
+)
+
+// k8sRegistry registers services into Endpoints named by service name, the
+// port name of Endpoints is the scheme of service. The Endpoints should not be
+// selected by a Service with selector, otherwise it's overwritten by k8s.
+type k8sRegistry struct {
+	client    kubernetes.Interface
+	namespace string
+	*Config
+	// services registered by this registry, service id => service info
+	services sync.Map
+}
+
+var _ registry.Registry = new(k8sRegistry)
+
+func newK8sRegistry(config *Config, client kubernetes.Interface) *k8sRegistry {
+	if config.logger == nil {
+		config.logger = xlog.Jupiter().Named(ecode.ModRegistryK8s)
+	}
+	namespace := config.namespace()
+	config.logger = config.logger.With(xlog.String("namespace", namespace))
+
+	return &k8sRegistry{
+		client:    client,
+		namespace: namespace,
+		Config:    config,
+	}
+}
+
+func (reg *k8sRegistry) Kind() string { return "k8s" }
+
+// RegisterService adds the service address into Endpoints
+func (reg *k8sRegistry) RegisterService(ctx context.Context, info *server.ServiceInfo) error {
+	host, port, err := splitHostPort(info.Address)
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := reg.withTimeout(ctx)
+	defer cancel()
+
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		endpoints, err := reg.client.CoreV1().Endpoints(reg.namespace).Get(ctx, info.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			endpoints = &corev1.Endpoints{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      info.Name,
+					Namespace: reg.namespace,
+					Labels:    map[string]string{managedByLabel: "jupiter"},
+				},
+			}
+			addAddress(endpoints, info.Scheme, host, port, string(value))
+			_, err = reg.client.CoreV1().Endpoints(reg.namespace).Create(ctx, endpoints, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) {
+				// created by others at the same time, retry as conflict
+				return apierrors.NewConflict(corev1.Resource("endpoints"), info.Name, err)
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		addAddress(endpoints, info.Scheme, host, port, string(value))
+		_, err = reg.client.CoreV1().Endpoints(reg.namespace).Update(ctx, endpoints, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		reg.logger.Error("register service", xlog.FieldErrKind(ecode.ErrKindRegisterErr), xlog.FieldErr(err), xlog.FieldKey(serviceID(info)))
+		return err
+	}
+
+	reg.logger.Info("register service", xlog.FieldKey(serviceID(info)), xlog.FieldValue(string(value)))
+	reg.services.Store(serviceID(info), info)
+	return nil
+}
+
+// UnregisterService removes the service address from Endpoints
+func (reg *k8sRegistry) UnregisterService(ctx context.Context, info *server.ServiceInfo) error {
+	host, port, err := splitHostPort(info.Address)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := reg.withTimeout(ctx)
+	defer cancel()
+
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		endpoints, err := reg.client.CoreV1().Endpoints(reg.namespace).Get(ctx, info.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if !removeAddress(endpoints, info.Scheme, host, port) {
+			return nil
+		}
+		_, err = reg.client.CoreV1().Endpoints(reg.namespace).Update(ctx, endpoints, metav1.UpdateOptions{})
+		return err
+	})
+	if err == nil {
+		reg.services.Delete(serviceID(info))
+	}
+	return err
+}
+
+// ListServices list the ready addresses matched with prefix
+func (reg *k8sRegistry) ListServices(ctx context.Context, prefix string) ([]*server.ServiceInfo, error) {
+	ctx, cancel := reg.withTimeout(ctx)
+	defer cancel()
+
+	key := registry.ParseServiceKey(prefix)
+	endpoints, err := reg.client.CoreV1().Endpoints(reg.namespace).Get(ctx, key.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return []*server.ServiceInfo{}, nil
+	}
+	if err != nil {
+		reg.logger.Error("list services", xlog.FieldErrKind(ecode.ErrKindRequestErr), xlog.FieldErr(err), xlog.FieldKey(prefix))
+		return nil, err
+	}
+
+	infos := toServiceInfos(key, endpoints)
+	services := make([]*server.ServiceInfo, 0, len(infos))
+	for i := range infos {
+		services = append(services, &infos[i])
+	}
+	return services, nil
+}
+
+// WatchServices watch the Endpoints change, then return address list
+func (reg *k8sRegistry) WatchServices(ctx context.Context, prefix string) (chan registry.Endpoints, error) {
+	key := registry.ParseServiceKey(prefix)
+	endpoints, resourceVersion, err := reg.get(ctx, key.Name)
+	if err != nil {
+		reg.logger.Error("watch services", xlog.FieldErrKind(ecode.MsgWatchRequestErr), xlog.FieldErr(err), xlog.FieldKey(prefix))
+		return nil, err
+	}
+
+	var addresses = make(chan registry.Endpoints, 10)
+	addresses <- *toEndpoints(key, endpoints)
+
+	xgo.Go(func() {
+		defer close(addresses)
+
+		retryInterval := minRetryInterval
+		for {
+			err := reg.watch(ctx, key, resourceVersion, addresses)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				reg.logger.Warn("watch services", xlog.FieldErr(err), xlog.FieldKey(prefix), xlog.Duration("retry", retryInterval))
+				select {
+				case <-time.After(retryInterval):
+				case <-ctx.Done():
+					return
+				}
+				if retryInterval *= 2; retryInterval > maxRetryInterval {
+					retryInterval = maxRetryInterval
+				}
+			} else {
+				retryInterval = minRetryInterval
+			}
+
+			// the watch is closed by apiserver or failed, list again to catch up
+			endpoints, resourceVersion, err = reg.get(ctx, key.Name)
+			if err != nil {
+				resourceVersion = ""
+				continue
+			}
+			select {
+			case addresses <- *toEndpoints(key, endpoints):
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+
+	return addresses, nil
+}
+
+// Close unregisters all services
+func (reg *k8sRegistry) Close() error {
+	reg.services.Range(func(k, v interface{}) bool {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := reg.UnregisterService(ctx, v.(*server.ServiceInfo)); err != nil {
+			reg.logger.Error("unregister service", xlog.FieldErrKind(ecode.ErrKindRequestErr), xlog.FieldErr(err), xlog.FieldKeyAny(k))
+		} else {
+			reg.logger.Info("unregister service", xlog.FieldKeyAny(k))
+		}
+		return true
+	})
+	return nil
+}
+
+// get returns the Endpoints of name and the resource version to watch from,
+// Endpoints is nil if it's not found
+func (reg *k8sRegistry) get(ctx context.Context, name string) (*corev1.Endpoints, string, error) {
+	ctx, cancel := reg.withTimeout(ctx)
+	defer cancel()
+
+	list, err := reg.client.CoreV1().Endpoints(reg.namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("metadata.name", name).String(),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	for i := range list.Items {
+		if list.Items[i].Name == name {
+			return &list.Items[i], list.ResourceVersion, nil
+		}
+	}
+	return nil, list.ResourceVersion, nil
+}
+
+func (reg *k8sRegistry) watch(ctx context.Context, key registry.ServiceKey, resourceVersion string, addresses chan registry.Endpoints) error {
+	watcher, err := reg.client.CoreV1().Endpoints(reg.namespace).Watch(ctx, metav1.ListOptions{
+		FieldSelector:   fields.OneTermEqualSelector("metadata.name", key.Name).String(),
+		ResourceVersion: resourceVersion,
+	})
+	if err != nil {
+		return err
+	}
+	defer watcher.Stop()
+
+	for {
+		select {
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				return nil
+			}
+
+			var out *registry.Endpoints
+			switch event.Type {
+			case watch.Added, watch.Modified:
+				endpoints, ok := event.Object.(*corev1.Endpoints)
+				if !ok || endpoints.Name != key.Name {
+					continue
+				}
+				out = toEndpoints(key, endpoints)
+			case watch.Deleted:
+				endpoints, ok := event.Object.(*corev1.Endpoints)
+				if !ok || endpoints.Name != key.Name {
+					continue
+				}
+				out = registry.NewEndpoints()
+			case watch.Error:
+				return apierrors.FromObject(event.Object)
+			default:
+				continue
+			}
+
+			select {
+			case addresses <- *out:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (reg *k8sRegistry) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, reg.ReadTimeout)
+}
+
+func serviceID(info *server.ServiceInfo) string {
+	return info.Scheme + ":" + info.Name + ":" + info.Address
+}
+
+func splitHostPort(address string) (string, int32, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid service address %s: %w", address, err)
+	}
+	portInt, err := strconv.ParseInt(port, 10, 32)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid service port %s: %w", address, err)
+	}
+	return host, int32(portInt), nil
+}
+
+// annotationKey returns the annotation key of address, eg: jupiter.io/grpc.10.0.0.1.9091
+func annotationKey(scheme, host string, port int32) string {
+	return annotationPrefix + strings.ReplaceAll(scheme+"."+host+"."+strconv.Itoa(int(port)), ":", "-")
+}
+
+// addAddress adds the address into the subset with the same port, or a new subset
+func addAddress(endpoints *corev1.Endpoints, scheme, host string, port int32, value string) {
+	if endpoints.Annotations == nil {
+		endpoints.Annotations = make(map[string]string)
+	}
+	endpoints.Annotations[annotationKey(scheme, host, port)] = value
+
+	for i, subset := range endpoints.Subsets {
+		if !hasPort(subset, scheme, port) {
+			continue
+		}
+		for _, address := range subset.Addresses {
+			if address.IP == host {
+				return
+			}
+		}
+		endpoints.Subsets[i].Addresses = append(endpoints.Subsets[i].Addresses, corev1.EndpointAddress{IP: host})
+		return
+	}
+
+	endpoints.Subsets = append(endpoints.Subsets, corev1.EndpointSubset{
+		Addresses: []corev1.EndpointAddress{{IP: host}},
+		Ports:     []corev1.EndpointPort{{Name: scheme, Port: port, Protocol: corev1.ProtocolTCP}},
+	})
+}
+
+// removeAddress removes the address from Endpoints, returns false if nothing changed
+func removeAddress(endpoints *corev1.Endpoints, scheme, host string, port int32) bool {
+	changed := false
+	if _, ok := endpoints.Annotations[annotationKey(scheme, host, port)]; ok {
+		delete(endpoints.Annotations, annotationKey(scheme, host, port))
+		changed = true
+	}
+
+	subsets := endpoints.Subsets[:0]
+	for _, subset := range endpoints.Subsets {
+		if hasPort(subset, scheme, port) {
+			addresses := subset.Addresses[:0]
+			for _, address := range subset.Addresses {
+				if address.IP == host {
+					changed = true
+					continue
+				}
+				addresses = append(addresses, address)
+			}
+			subset.Addresses = addresses
+		}
+		if len(subset.Addresses) > 0 || len(subset.NotReadyAddresses) > 0 {
+			subsets = append(subsets, subset)
+		}
+	}
+	endpoints.Subsets = subsets
+	return changed
+}
+
+func hasPort(subset corev1.EndpointSubset, scheme string, port int32) bool {
+	for _, p := range subset.Ports {
+		if p.Name == scheme && p.Port == port {
+			return true
+		}
+	}
+	return false
+}
+
+// toServiceInfos converts the ready addresses of Endpoints into service infos matched with key
+func toServiceInfos(key registry.ServiceKey, endpoints *corev1.Endpoints) []server.ServiceInfo {
+	infos := make([]server.ServiceInfo, 0)
+	if endpoints == nil {
+		return infos
+	}
+
+	for _, subset := range endpoints.Subsets {
+		for _, port := range subset.Ports {
+			if key.Scheme != "" && port.Name != key.Scheme {
+				continue
+			}
+			for _, address := range subset.Addresses {
+				info := server.ServiceInfo{
+					Name:    endpoints.Name,
+					Scheme:  port.Name,
+					Enable:  true,
+					Healthy: true,
+				}
+				if value, ok := endpoints.Annotations[annotationKey(port.Name, address.IP, port.Port)]; ok {
+					_ = json.Unmarshal([]byte(value), &info)
+				}
+				info.Address = net.JoinHostPort(address.IP, strconv.Itoa(int(port.Port)))
+				if key.Match(&info) {
+					infos = append(infos, info)
+				}
+			}
+		}
+	}
+	return infos
+}
+
+func toEndpoints(key registry.ServiceKey, endpoints *corev1.Endpoints) *registry.Endpoints {
+	out := registry.NewEndpoints()
+	for _, info := range toServiceInfos(key, endpoints) {
+		out.Nodes[info.Address] = info
+	}
+	return out
+}
@@ -0,0 +1,111 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zhengyansheng/jupiter/pkg/registry"
+	"github.com/zhengyansheng/jupiter/pkg/registry/registrytest"
+	"github.com/zhengyansheng/jupiter/pkg/xlog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newTestRegistry() (*k8sRegistry, *fake.Clientset) {
+	client := fake.NewSimpleClientset()
+	config := DefaultConfig()
+	config.Namespace = "jupiter"
+	config.logger = xlog.Jupiter()
+	return newK8sRegistry(config, client), client
+}
+
+// syncedRegistry waits for the watch to start in WatchServices, because fake
+// clientset doesn't replay the events before watch
+type syncedRegistry struct {
+	*k8sRegistry
+	watched chan struct{}
+}
+
+func newSyncedRegistry() (*syncedRegistry, *fake.Clientset) {
+	reg, client := newTestRegistry()
+	watched := make(chan struct{}, 1)
+	client.PrependWatchReactor("endpoints", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		select {
+		case watched <- struct{}{}:
+		default:
+		}
+		return false, nil, nil
+	})
+	return &syncedRegistry{k8sRegistry: reg, watched: watched}, client
+}
+
+func (reg *syncedRegistry) WatchServices(ctx context.Context, prefix string) (chan registry.Endpoints, error) {
+	ch, err := reg.k8sRegistry.WatchServices(ctx, prefix)
+	if err == nil {
+		<-reg.watched
+	}
+	return ch, err
+}
+
+func Test_k8sRegistry(t *testing.T) {
+	reg, client := newTestRegistry()
+	registrytest.TestRegistry(t, reg)
+
+	endpoints, err := client.CoreV1().Endpoints("jupiter").Get(context.Background(), registrytest.ServiceName, metav1.GetOptions{})
+	require.Nil(t, err)
+	assert.Len(t, endpoints.Subsets, 0)
+	assert.Len(t, endpoints.Annotations, 0)
+
+	t.Run("endpoints", func(t *testing.T) {
+		reg, client := newTestRegistry()
+		ctx := context.Background()
+		require.Nil(t, reg.RegisterService(ctx, registrytest.NewServiceInfo("10.10.10.1:9091")))
+		require.Nil(t, reg.RegisterService(ctx, registrytest.NewServiceInfo("10.10.10.2:9091")))
+
+		endpoints, err := client.CoreV1().Endpoints("jupiter").Get(ctx, registrytest.ServiceName, metav1.GetOptions{})
+		require.Nil(t, err)
+		require.Len(t, endpoints.Subsets, 1)
+		assert.Len(t, endpoints.Subsets[0].Addresses, 2)
+		assert.Equal(t, "grpc", endpoints.Subsets[0].Ports[0].Name)
+	})
+}
+
+func Test_k8sRegistry_WatchServices(t *testing.T) {
+	reg, _ := newSyncedRegistry()
+	registrytest.TestWatchServices(t, reg)
+
+	t.Run("deleted by others", func(t *testing.T) {
+		reg, client := newSyncedRegistry()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		ch, err := reg.WatchServices(ctx, "grpc:"+registrytest.ServiceName)
+		require.Nil(t, err)
+		registrytest.Receive(t, ch)
+
+		require.Nil(t, reg.RegisterService(context.Background(), registrytest.NewServiceInfo("10.10.10.1:9091")))
+		endpoints := registrytest.Receive(t, ch)
+		require.Len(t, endpoints.Nodes, 1)
+
+		require.Nil(t, client.CoreV1().Endpoints("jupiter").Delete(context.Background(), registrytest.ServiceName, metav1.DeleteOptions{}))
+		endpoints = registrytest.Receive(t, ch)
+		assert.Len(t, endpoints.Nodes, 0)
+	})
+}
@@ -0,0 +1,56 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"strings"
+
+	"github.com/zhengyansheng/jupiter/pkg/server"
+)
+
+// ServiceKey identifies a service in registry
+type ServiceKey struct {
+	Scheme  string
+	Name    string
+	Version string
+	Mode    string
+}
+
+// ParseServiceKey parses prefix in the form of "scheme:name:version:mode/",
+// which is the same as ServiceInfo.ServicePrefix. A bare service name is
+// also accepted, empty fields match any service.
+func ParseServiceKey(prefix string) ServiceKey {
+	parts := strings.Split(strings.TrimSuffix(prefix, "/"), ":")
+	if len(parts) == 1 {
+		return ServiceKey{Name: parts[0]}
+	}
+
+	key := ServiceKey{Scheme: parts[0], Name: parts[1]}
+	if len(parts) > 2 {
+		key.Version = parts[2]
+	}
+	if len(parts) > 3 {
+		key.Mode = parts[3]
+	}
+	return key
+}
+
+// Match reports whether the service belongs to key
+func (key ServiceKey) Match(info *server.ServiceInfo) bool {
+	return info.Name == key.Name &&
+		(key.Scheme == "" || info.Scheme == key.Scheme) &&
+		(key.Version == "" || info.Version == "" || info.Version == key.Version) &&
+		(key.Mode == "" || info.Mode == "" || info.Mode == key.Mode)
+}
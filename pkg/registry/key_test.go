@@ -0,0 +1,74 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zhengyansheng/jupiter/pkg/core/constant"
+	"github.com/zhengyansheng/jupiter/pkg/server"
+)
+
+func TestParseServiceKey(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   ServiceKey
+	}{
+		{"main", ServiceKey{Name: "main"}},
+		{"main/", ServiceKey{Name: "main"}},
+		{"grpc:main", ServiceKey{Scheme: "grpc", Name: "main"}},
+		{"grpc:main:v1:dev/", ServiceKey{Scheme: "grpc", Name: "main", Version: "v1", Mode: "dev"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			assert.Equal(t, tt.want, ParseServiceKey(tt.prefix))
+		})
+	}
+}
+
+func TestServiceKey_Match(t *testing.T) {
+	info := &server.ServiceInfo{Name: "main", Scheme: "grpc", Version: "v1", Mode: "dev"}
+
+	assert.True(t, ParseServiceKey("main").Match(info))
+	assert.True(t, ParseServiceKey("grpc:main:v1:dev/").Match(info))
+	assert.False(t, ParseServiceKey("http:main").Match(info))
+	assert.False(t, ParseServiceKey("grpc:main:v2:dev").Match(info))
+	assert.False(t, ParseServiceKey("grpc:other").Match(info))
+}
+
+func TestMetadata(t *testing.T) {
+	info := server.ServiceInfo{
+		Name:       "main",
+		AppID:      "app",
+		Scheme:     "grpc",
+		Weight:     100,
+		Enable:     true,
+		Healthy:    true,
+		Region:     "region",
+		Zone:       "zone",
+		Kind:       constant.ServiceProvider,
+		Version:    "v1",
+		Mode:       "dev",
+		Hostname:   "host",
+		Deployment: "default",
+		Group:      "red",
+		Metadata:   map[string]string{"foo": "bar"},
+	}
+
+	md := ToMetadata(&info)
+	assert.Equal(t, "bar", md["md_foo"])
+	assert.Equal(t, info, FromMetadata(md))
+}
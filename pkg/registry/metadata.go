@@ -0,0 +1,77 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"strings"
+
+	"github.com/spf13/cast"
+	"github.com/zhengyansheng/jupiter/pkg/core/constant"
+	"github.com/zhengyansheng/jupiter/pkg/server"
+)
+
+const metadataPrefix = "md_"
+
+// ToMetadata flattens the service info into string map, for registries which
+// only support string metadata, eg: consul, nacos
+func ToMetadata(info *server.ServiceInfo) map[string]string {
+	md := map[string]string{
+		"name":       info.Name,
+		"appId":      info.AppID,
+		"scheme":     info.Scheme,
+		"weight":     cast.ToString(info.Weight),
+		"enable":     cast.ToString(info.Enable),
+		"healthy":    cast.ToString(info.Healthy),
+		"region":     info.Region,
+		"zone":       info.Zone,
+		"kind":       cast.ToString(uint8(info.Kind)),
+		"version":    info.Version,
+		"mode":       info.Mode,
+		"hostname":   info.Hostname,
+		"deployment": info.Deployment,
+		"group":      info.Group,
+	}
+	for key, val := range info.Metadata {
+		md[metadataPrefix+key] = val
+	}
+	return md
+}
+
+// FromMetadata restores the service info flattened by ToMetadata
+func FromMetadata(md map[string]string) server.ServiceInfo {
+	info := server.ServiceInfo{
+		Name:       md["name"],
+		AppID:      md["appId"],
+		Scheme:     md["scheme"],
+		Weight:     cast.ToFloat64(md["weight"]),
+		Enable:     cast.ToBool(md["enable"]),
+		Healthy:    cast.ToBool(md["healthy"]),
+		Region:     md["region"],
+		Zone:       md["zone"],
+		Version:    md["version"],
+		Mode:       md["mode"],
+		Hostname:   md["hostname"],
+		Deployment: md["deployment"],
+		Group:      md["group"],
+		Metadata:   make(map[string]string),
+	}
+	info.Kind = constant.ServiceKind(cast.ToUint8(md["kind"]))
+	for key, val := range md {
+		if strings.HasPrefix(key, metadataPrefix) {
+			info.Metadata[strings.TrimPrefix(key, metadataPrefix)] = val
+		}
+	}
+	return info
+}
@@ -0,0 +1,122 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nacos
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/nacos-group/nacos-sdk-go/v2/clients"
+	"github.com/nacos-group/nacos-sdk-go/v2/common/constant"
+	"github.com/nacos-group/nacos-sdk-go/v2/vo"
+	"github.com/zhengyansheng/jupiter/pkg/conf"
+	jconstant "github.com/zhengyansheng/jupiter/pkg/core/constant"
+	"github.com/zhengyansheng/jupiter/pkg/core/ecode"
+	"github.com/zhengyansheng/jupiter/pkg/registry"
+	"github.com/zhengyansheng/jupiter/pkg/xlog"
+	"go.uber.org/zap"
+)
+
+// StdConfig ...
+func StdConfig(name string) *Config {
+	return RawConfig(jconstant.ConfigKey("registry." + name))
+}
+
+// RawConfig ...
+func RawConfig(key string) *Config {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(key, &config); err != nil {
+		xlog.Jupiter().Panic("unmarshal key", xlog.FieldMod(ecode.ModRegistryNacos), xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr), xlog.FieldErr(err), xlog.String("key", key), xlog.Any("config", config))
+	}
+	return config
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		Addrs:     []string{"127.0.0.1:8848"},
+		GroupName: constant.DEFAULT_GROUP,
+		Timeout:   time.Second * 3,
+		LogDir:    "/tmp/nacos/log",
+		CacheDir:  "/tmp/nacos/cache",
+		LogLevel:  "warn",
+		logger:    xlog.Jupiter().Named(ecode.ModRegistryNacos),
+	}
+}
+
+// Config ...
+type Config struct {
+	// Addrs nacos服务地址, eg: 127.0.0.1:8848
+	Addrs []string
+	// NamespaceID 命名空间, public命名空间留空
+	NamespaceID string
+	// GroupName 服务分组, 默认为DEFAULT_GROUP
+	GroupName string
+	// ClusterName 服务所在集群, 为空时不区分集群
+	ClusterName string
+	Username    string
+	Password    string
+	// Timeout 请求nacos的超时时间
+	Timeout  time.Duration
+	LogDir   string
+	CacheDir string
+	LogLevel string
+	logger   *xlog.Logger
+}
+
+// Build ...
+func (config Config) Build() (registry.Registry, error) {
+	serverConfigs := make([]constant.ServerConfig, 0, len(config.Addrs))
+	for _, addr := range config.Addrs {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid nacos address %s: %w", addr, err)
+		}
+		portInt, err := strconv.ParseUint(port, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid nacos port %s: %w", addr, err)
+		}
+		serverConfigs = append(serverConfigs, constant.ServerConfig{IpAddr: host, Port: portInt})
+	}
+
+	client, err := clients.NewNamingClient(vo.NacosClientParam{
+		ClientConfig: &constant.ClientConfig{
+			NamespaceId:         config.NamespaceID,
+			TimeoutMs:           uint64(config.Timeout.Milliseconds()),
+			Username:            config.Username,
+			Password:            config.Password,
+			LogDir:              config.LogDir,
+			CacheDir:            config.CacheDir,
+			LogLevel:            config.LogLevel,
+			NotLoadCacheAtStart: true,
+		},
+		ServerConfigs: serverConfigs,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newNacosRegistry(&config, client), nil
+}
+
+// MustBuild ...
+func (config Config) MustBuild() registry.Registry {
+	reg, err := config.Build()
+	if err != nil {
+		xlog.Jupiter().Panic("build registry failed", zap.Error(err))
+	}
+	return reg
+}
@@ -0,0 +1,270 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nacos
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/nacos-group/nacos-sdk-go/v2/clients/naming_client"
+	"github.com/nacos-group/nacos-sdk-go/v2/model"
+	"github.com/nacos-group/nacos-sdk-go/v2/vo"
+	"github.com/zhengyansheng/jupiter/pkg/core/ecode"
+	"github.com/zhengyansheng/jupiter/pkg/registry"
+	"github.com/zhengyansheng/jupiter/pkg/server"
+	"github.com/zhengyansheng/jupiter/pkg/util/xgo"
+	"github.com/zhengyansheng/jupiter/pkg/xlog"
+)
+
+// defaultWeight is used when service weight is not set, nacos ignores the instance with zero weight
+const defaultWeight = 100
+
+type nacosRegistry struct {
+	client naming_client.INamingClient
+	*Config
+	// instances registered by this registry, service id => deregister param
+	instances sync.Map
+}
+
+var _ registry.Registry = new(nacosRegistry)
+
+func newNacosRegistry(config *Config, client naming_client.INamingClient) *nacosRegistry {
+	if config.logger == nil {
+		config.logger = xlog.Jupiter().Named(ecode.ModRegistryNacos)
+	}
+	config.logger = config.logger.With(xlog.FieldAddrAny(config.Addrs))
+
+	return &nacosRegistry{
+		client: client,
+		Config: config,
+	}
+}
+
+func (reg *nacosRegistry) Kind() string { return "nacos" }
+
+// RegisterService register service as an ephemeral instance of nacos
+func (reg *nacosRegistry) RegisterService(ctx context.Context, info *server.ServiceInfo) error {
+	host, port, err := splitHostPort(info.Address)
+	if err != nil {
+		return err
+	}
+
+	weight := info.Weight
+	if weight <= 0 {
+		weight = defaultWeight
+	}
+	_, err = reg.client.RegisterInstance(vo.RegisterInstanceParam{
+		Ip:          host,
+		Port:        port,
+		Weight:      weight,
+		Enable:      info.Enable,
+		Healthy:     true,
+		Metadata:    registry.ToMetadata(info),
+		ClusterName: reg.ClusterName,
+		ServiceName: info.Name,
+		GroupName:   reg.GroupName,
+		Ephemeral:   true,
+	})
+	if err != nil {
+		reg.logger.Error("register service", xlog.FieldErrKind(ecode.ErrKindRegisterErr), xlog.FieldErr(err), xlog.FieldKey(serviceID(info)))
+		return err
+	}
+
+	reg.logger.Info("register service", xlog.FieldKey(serviceID(info)))
+	reg.instances.Store(serviceID(info), vo.DeregisterInstanceParam{
+		Ip:          host,
+		Port:        port,
+		Cluster:     reg.ClusterName,
+		ServiceName: info.Name,
+		GroupName:   reg.GroupName,
+		Ephemeral:   true,
+	})
+	return nil
+}
+
+// UnregisterService unregister service from nacos
+func (reg *nacosRegistry) UnregisterService(ctx context.Context, info *server.ServiceInfo) error {
+	host, port, err := splitHostPort(info.Address)
+	if err != nil {
+		return err
+	}
+
+	return reg.unregister(serviceID(info), vo.DeregisterInstanceParam{
+		Ip:          host,
+		Port:        port,
+		Cluster:     reg.ClusterName,
+		ServiceName: info.Name,
+		GroupName:   reg.GroupName,
+		Ephemeral:   true,
+	})
+}
+
+// ListServices list the healthy instances matched with prefix
+func (reg *nacosRegistry) ListServices(ctx context.Context, prefix string) ([]*server.ServiceInfo, error) {
+	key := registry.ParseServiceKey(prefix)
+	service, err := reg.client.GetService(vo.GetServiceParam{
+		Clusters:    reg.clusters(),
+		ServiceName: key.Name,
+		GroupName:   reg.GroupName,
+	})
+	if err != nil {
+		reg.logger.Error("list services", xlog.FieldErrKind(ecode.ErrKindRequestErr), xlog.FieldErr(err), xlog.FieldKey(prefix))
+		return nil, err
+	}
+
+	services := make([]*server.ServiceInfo, 0, len(service.Hosts))
+	for _, info := range toServiceInfos(key, service.Hosts) {
+		info := info
+		services = append(services, &info)
+	}
+	return services, nil
+}
+
+// WatchServices subscribe service change of nacos, then return address list
+func (reg *nacosRegistry) WatchServices(ctx context.Context, prefix string) (chan registry.Endpoints, error) {
+	key := registry.ParseServiceKey(prefix)
+	service, err := reg.client.GetService(vo.GetServiceParam{
+		Clusters:    reg.clusters(),
+		ServiceName: key.Name,
+		GroupName:   reg.GroupName,
+	})
+	if err != nil {
+		reg.logger.Error("watch services", xlog.FieldErrKind(ecode.MsgWatchRequestErr), xlog.FieldErr(err), xlog.FieldKey(prefix))
+		return nil, err
+	}
+
+	var (
+		mu        sync.Mutex
+		closed    bool
+		addresses = make(chan registry.Endpoints, 10)
+	)
+	addresses <- *toEndpoints(key, service.Hosts)
+
+	// nacos matches the callback by pointer when unsubscribe, so the same param must be used
+	param := &vo.SubscribeParam{
+		ServiceName: key.Name,
+		Clusters:    reg.clusters(),
+		GroupName:   reg.GroupName,
+		SubscribeCallback: func(hosts []model.Instance, err error) {
+			// err is not nil only when hosts is empty, which is a valid update
+			mu.Lock()
+			defer mu.Unlock()
+			if closed {
+				return
+			}
+			select {
+			case addresses <- *toEndpoints(key, hosts):
+			case <-ctx.Done():
+			}
+		},
+	}
+	if err := reg.client.Subscribe(param); err != nil {
+		reg.logger.Error("subscribe services", xlog.FieldErrKind(ecode.MsgWatchRequestErr), xlog.FieldErr(err), xlog.FieldKey(prefix))
+		return nil, err
+	}
+
+	xgo.Go(func() {
+		<-ctx.Done()
+		if err := reg.client.Unsubscribe(param); err != nil {
+			reg.logger.Warn("unsubscribe services", xlog.FieldErr(err), xlog.FieldKey(prefix))
+		}
+		mu.Lock()
+		closed = true
+		close(addresses)
+		mu.Unlock()
+	})
+
+	return addresses, nil
+}
+
+// Close unregisters all services and closes the naming client
+func (reg *nacosRegistry) Close() error {
+	reg.instances.Range(func(k, v interface{}) bool {
+		if err := reg.unregister(k.(string), v.(vo.DeregisterInstanceParam)); err != nil {
+			reg.logger.Error("unregister service", xlog.FieldErrKind(ecode.ErrKindRequestErr), xlog.FieldErr(err), xlog.FieldKeyAny(k))
+		} else {
+			reg.logger.Info("unregister service", xlog.FieldKeyAny(k))
+		}
+		return true
+	})
+	reg.client.CloseClient()
+	return nil
+}
+
+func (reg *nacosRegistry) unregister(id string, param vo.DeregisterInstanceParam) error {
+	_, err := reg.client.DeregisterInstance(param)
+	if err == nil {
+		reg.instances.Delete(id)
+	}
+	return err
+}
+
+func (reg *nacosRegistry) clusters() []string {
+	if reg.ClusterName == "" {
+		return nil
+	}
+	return []string{reg.ClusterName}
+}
+
+func serviceID(info *server.ServiceInfo) string {
+	return info.Scheme + ":" + info.Name + ":" + info.Address
+}
+
+func splitHostPort(address string) (string, uint64, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid service address %s: %w", address, err)
+	}
+	portInt, err := strconv.ParseUint(port, 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid service port %s: %w", address, err)
+	}
+	return host, portInt, nil
+}
+
+// toServiceInfos converts the available instances into service infos matched with key
+func toServiceInfos(key registry.ServiceKey, hosts []model.Instance) []server.ServiceInfo {
+	infos := make([]server.ServiceInfo, 0, len(hosts))
+	for _, host := range hosts {
+		if !host.Healthy || !host.Enable || host.Weight <= 0 {
+			continue
+		}
+
+		info := registry.FromMetadata(host.Metadata)
+		info.Address = net.JoinHostPort(host.Ip, strconv.FormatUint(host.Port, 10))
+		info.Weight = host.Weight
+		if info.Name == "" {
+			info.Name = key.Name
+		}
+		if info.Scheme == "" {
+			info.Scheme = key.Scheme
+		}
+		if key.Match(&info) {
+			infos = append(infos, info)
+		}
+	}
+	return infos
+}
+
+func toEndpoints(key registry.ServiceKey, hosts []model.Instance) *registry.Endpoints {
+	endpoints := registry.NewEndpoints()
+	for _, info := range toServiceInfos(key, hosts) {
+		endpoints.Nodes[info.Address] = info
+	}
+	return endpoints
+}
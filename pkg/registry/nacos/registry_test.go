@@ -0,0 +1,169 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nacos
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/nacos-group/nacos-sdk-go/v2/clients/naming_client"
+	"github.com/nacos-group/nacos-sdk-go/v2/model"
+	"github.com/nacos-group/nacos-sdk-go/v2/vo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zhengyansheng/jupiter/pkg/registry/registrytest"
+	"github.com/zhengyansheng/jupiter/pkg/xlog"
+)
+
+// fakeNamingClient is an in-process nacos naming client, the services are keyed by group and name
+type fakeNamingClient struct {
+	naming_client.INamingClient
+
+	mu          sync.Mutex
+	instances   map[string][]model.Instance
+	subscribers map[string][]*vo.SubscribeParam
+	closed      bool
+}
+
+func newFakeNamingClient() *fakeNamingClient {
+	return &fakeNamingClient{
+		instances:   make(map[string][]model.Instance),
+		subscribers: make(map[string][]*vo.SubscribeParam),
+	}
+}
+
+func (fc *fakeNamingClient) RegisterInstance(param vo.RegisterInstanceParam) (bool, error) {
+	fc.update(param.GroupName, param.ServiceName, func(hosts []model.Instance) []model.Instance {
+		hosts = removeHost(hosts, param.Ip, param.Port)
+		return append(hosts, model.Instance{
+			Ip:          param.Ip,
+			Port:        param.Port,
+			Weight:      param.Weight,
+			Healthy:     param.Healthy,
+			Enable:      param.Enable,
+			Ephemeral:   param.Ephemeral,
+			ClusterName: param.ClusterName,
+			ServiceName: param.ServiceName,
+			Metadata:    param.Metadata,
+		})
+	})
+	return true, nil
+}
+
+func (fc *fakeNamingClient) DeregisterInstance(param vo.DeregisterInstanceParam) (bool, error) {
+	fc.update(param.GroupName, param.ServiceName, func(hosts []model.Instance) []model.Instance {
+		return removeHost(hosts, param.Ip, param.Port)
+	})
+	return true, nil
+}
+
+func (fc *fakeNamingClient) GetService(param vo.GetServiceParam) (model.Service, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return model.Service{Name: param.ServiceName, Hosts: fc.instances[param.GroupName+"@@"+param.ServiceName]}, nil
+}
+
+func (fc *fakeNamingClient) Subscribe(param *vo.SubscribeParam) error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	key := param.GroupName + "@@" + param.ServiceName
+	fc.subscribers[key] = append(fc.subscribers[key], param)
+	return nil
+}
+
+func (fc *fakeNamingClient) Unsubscribe(param *vo.SubscribeParam) error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	key := param.GroupName + "@@" + param.ServiceName
+	subscribers := fc.subscribers[key][:0]
+	for _, subscriber := range fc.subscribers[key] {
+		if subscriber != param {
+			subscribers = append(subscribers, subscriber)
+		}
+	}
+	fc.subscribers[key] = subscribers
+	return nil
+}
+
+func (fc *fakeNamingClient) CloseClient() {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.closed = true
+}
+
+func (fc *fakeNamingClient) update(group, name string, fn func([]model.Instance) []model.Instance) {
+	fc.mu.Lock()
+	key := group + "@@" + name
+	hosts := fn(fc.instances[key])
+	fc.instances[key] = hosts
+	subscribers := append([]*vo.SubscribeParam{}, fc.subscribers[key]...)
+	fc.mu.Unlock()
+
+	// nacos notifies subscribers with an error when hosts is empty
+	var err error
+	if len(hosts) == 0 {
+		err = errors.New("hosts is empty")
+	}
+	for _, subscriber := range subscribers {
+		subscriber.SubscribeCallback(hosts, err)
+	}
+}
+
+func removeHost(hosts []model.Instance, ip string, port uint64) []model.Instance {
+	out := make([]model.Instance, 0, len(hosts))
+	for _, host := range hosts {
+		if host.Ip != ip || host.Port != port {
+			out = append(out, host)
+		}
+	}
+	return out
+}
+
+func newTestRegistry() (*nacosRegistry, *fakeNamingClient) {
+	client := newFakeNamingClient()
+	config := DefaultConfig()
+	config.logger = xlog.Jupiter()
+	return newNacosRegistry(config, client), client
+}
+
+func Test_nacosRegistry(t *testing.T) {
+	reg, client := newTestRegistry()
+	registrytest.TestRegistry(t, reg)
+	assert.True(t, client.closed)
+
+	t.Run("default weight", func(t *testing.T) {
+		reg, _ := newTestRegistry()
+		ctx := context.Background()
+		info := registrytest.NewServiceInfo("10.10.10.1:9091")
+		info.Weight = 0
+		require.Nil(t, reg.RegisterService(ctx, info))
+
+		services, err := reg.ListServices(ctx, "grpc:"+registrytest.ServiceName)
+		require.Nil(t, err)
+		require.Len(t, services, 1)
+		assert.Equal(t, float64(defaultWeight), services[0].Weight)
+	})
+}
+
+func Test_nacosRegistry_WatchServices(t *testing.T) {
+	reg, client := newTestRegistry()
+	registrytest.TestWatchServices(t, reg)
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	assert.Len(t, client.subscribers["DEFAULT_GROUP@@"+registrytest.ServiceName], 0)
+}
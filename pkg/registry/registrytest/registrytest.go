@@ -0,0 +1,174 @@
+// Copyright 2022 zhengyansheng
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package registrytest 提供各注册中心实现共用的测试数据及通用行为测试
+package registrytest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zhengyansheng/jupiter/pkg/core/constant"
+	"github.com/zhengyansheng/jupiter/pkg/registry"
+	"github.com/zhengyansheng/jupiter/pkg/server"
+)
+
+// ServiceName 测试服务的名称, 同时满足k8s的命名规则
+const ServiceName = "service-1"
+
+// NewServiceInfo 返回测试服务在address上的节点信息
+func NewServiceInfo(address string) *server.ServiceInfo {
+	return &server.ServiceInfo{
+		Name:       ServiceName,
+		AppID:      "app_1",
+		Scheme:     "grpc",
+		Address:    address,
+		Weight:     100,
+		Enable:     true,
+		Healthy:    true,
+		Metadata:   map[string]string{"foo": "bar"},
+		Region:     "region_1",
+		Zone:       "zone_1",
+		Kind:       constant.ServiceProvider,
+		Deployment: "default",
+	}
+}
+
+// Receive 返回ch中的下一个Endpoints, 超时则测试失败
+func Receive(t testing.TB, ch chan registry.Endpoints) registry.Endpoints {
+	t.Helper()
+	select {
+	case endpoints := <-ch:
+		return endpoints
+	case <-time.After(time.Second * 3):
+		t.Fatal("no endpoints received")
+		return registry.Endpoints{}
+	}
+}
+
+// ReceiveUntil 返回ch中第一个满足cond的Endpoints, 超时或ch被关闭则测试失败
+func ReceiveUntil(t testing.TB, ch chan registry.Endpoints, cond func(registry.Endpoints) bool) registry.Endpoints {
+	t.Helper()
+	deadline := time.After(time.Second * 3)
+	for {
+		select {
+		case endpoints, ok := <-ch:
+			require.True(t, ok, "channel is closed")
+			if cond(endpoints) {
+				return endpoints
+			}
+		case <-deadline:
+			t.Fatal("no expected endpoints received")
+			return registry.Endpoints{}
+		}
+	}
+}
+
+// ExpectClosed 等待ch被关闭
+func ExpectClosed(t testing.TB, ch chan registry.Endpoints) {
+	t.Helper()
+	deadline := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return
+			}
+		case <-deadline:
+			t.Fatal("channel is not closed")
+			return
+		}
+	}
+}
+
+// TestRegistry 测试注册、查询、注销及关闭的通用行为, reg中不能有已注册的测试服务
+func TestRegistry(t *testing.T, reg registry.Registry) {
+	ctx := context.Background()
+
+	services, err := reg.ListServices(ctx, "grpc:"+ServiceName)
+	require.Nil(t, err)
+	assert.Len(t, services, 0)
+
+	info := NewServiceInfo("10.10.10.1:9091")
+	require.Nil(t, reg.RegisterService(ctx, info))
+	// 重复注册同一节点只保留一个
+	require.Nil(t, reg.RegisterService(ctx, info))
+	require.Nil(t, reg.RegisterService(ctx, NewServiceInfo("10.10.10.2:9091")))
+
+	services, err = reg.ListServices(ctx, "grpc:"+ServiceName+":v1:dev/")
+	require.Nil(t, err)
+	require.Len(t, services, 2)
+	nodes := make(map[string]*server.ServiceInfo, len(services))
+	for _, service := range services {
+		nodes[service.Address] = service
+	}
+	require.Contains(t, nodes, "10.10.10.1:9091")
+	assert.Equal(t, "zone_1", nodes["10.10.10.1:9091"].Zone)
+	assert.Equal(t, "bar", nodes["10.10.10.1:9091"].Metadata["foo"])
+
+	services, err = reg.ListServices(ctx, "http:"+ServiceName)
+	require.Nil(t, err)
+	assert.Len(t, services, 0)
+
+	services, err = reg.ListServices(ctx, "grpc:service-2")
+	require.Nil(t, err)
+	assert.Len(t, services, 0)
+
+	require.Nil(t, reg.UnregisterService(ctx, info))
+	services, err = reg.ListServices(ctx, "grpc:"+ServiceName)
+	require.Nil(t, err)
+	require.Len(t, services, 1)
+	assert.Equal(t, "10.10.10.2:9091", services[0].Address)
+
+	// 关闭时注销本实例注册的所有节点
+	require.Nil(t, reg.Close())
+	services, err = reg.ListServices(ctx, "grpc:"+ServiceName)
+	require.Nil(t, err)
+	assert.Len(t, services, 0)
+}
+
+// TestWatchServices 测试监听服务的通用行为: 节点的增加、注册中心关闭后节点的移除及ctx取消后关闭ch,
+// WatchServices返回时需已开始监听
+func TestWatchServices(t *testing.T, reg registry.Registry) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := reg.WatchServices(ctx, "grpc:"+ServiceName)
+	require.Nil(t, err)
+
+	endpoints := Receive(t, ch)
+	assert.Len(t, endpoints.Nodes, 0)
+
+	require.Nil(t, reg.RegisterService(context.Background(), NewServiceInfo("10.10.10.1:9091")))
+	endpoints = ReceiveUntil(t, ch, func(endpoints registry.Endpoints) bool {
+		return len(endpoints.Nodes) == 1
+	})
+	assert.Equal(t, "zone_1", endpoints.Nodes["10.10.10.1:9091"].Zone)
+
+	require.Nil(t, reg.RegisterService(context.Background(), NewServiceInfo("10.10.10.2:9091")))
+	endpoints = ReceiveUntil(t, ch, func(endpoints registry.Endpoints) bool {
+		return len(endpoints.Nodes) == 2
+	})
+	assert.Equal(t, "zone_1", endpoints.Nodes["10.10.10.2:9091"].Zone)
+
+	require.Nil(t, reg.Close())
+	ReceiveUntil(t, ch, func(endpoints registry.Endpoints) bool {
+		return len(endpoints.Nodes) == 0
+	})
+
+	cancel()
+	ExpectClosed(t, ch)
+}
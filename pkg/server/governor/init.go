@@ -16,9 +16,11 @@ package governor
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 
 	jsoniter "github.com/json-iterator/go"
 	"github.com/zhengyansheng/jupiter/pkg"
@@ -46,9 +48,61 @@ func registerHandlers() {
 		if r.URL.Query().Get("pretty") == "true" {
 			encoder.SetIndent("", "    ")
 		}
+		if r.URL.Query().Has("explain") {
+			_ = encoder.Encode(conf.Explain(r.URL.Query().Get("explain")))
+			return
+		}
 		_ = encoder.Encode(conf.Traverse("."))
 	})
 
+	HandleFunc("/configs/history", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = jsoniter.NewEncoder(w).Encode(conf.History())
+	})
+
+	HandleFunc("/configs/diff", func(w http.ResponseWriter, r *http.Request) {
+		from, err := strconv.ParseInt(r.URL.Query().Get("from"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid from version", http.StatusBadRequest)
+			return
+		}
+		to, err := strconv.ParseInt(r.URL.Query().Get("to"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid to version", http.StatusBadRequest)
+			return
+		}
+		changes, err := conf.Diff(from, to)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = jsoniter.NewEncoder(w).Encode(changes)
+	})
+
+	// 回滚仅在本地生效, 需使用POST请求
+	HandleFunc("/configs/rollback", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		version, err := strconv.ParseInt(r.URL.Query().Get("version"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid version", http.StatusBadRequest)
+			return
+		}
+		if err := conf.Rollback(version); err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, conf.ErrSnapshotNotFound) {
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		w.WriteHeader(200)
+		_, _ = w.Write([]byte("SUCCESS"))
+	})
+
 	HandleFunc("/debug/config", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(200)
 		_, _ = w.Write(xstring.PrettyJSONBytes(conf.Traverse(".")))
@@ -56,7 +110,7 @@ func registerHandlers() {
 
 	HandleFunc("/debug/env", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(200)
-		_ = jsoniter.NewEncoder(w).Encode(os.Environ())
+		_ = jsoniter.NewEncoder(w).Encode(conf.MaskEnviron(os.Environ()))
 	})
 
 	HandleFunc("/build/info", func(w http.ResponseWriter, r *http.Request) {
@@ -147,7 +147,7 @@ func defaultServiceInfo() ServiceInfo {
 		Hostname:   pkg.AppHost(),
 		Version:    "v1",
 		Kind:       0,
-		Deployment: "",
+		Deployment: pkg.AppDeployment(),
 		Group:      "",
 	}
 	si.Metadata["startTime"] = pkg.StartTime()
@@ -29,7 +29,7 @@ import (
 // Config HTTP config
 type Config struct {
 	Host            string
-	Port            int
+	Port            int `validate:"min=0,max=65535"`
 	Deployment      string
 	Debug           bool
 	DisableMetric   bool
@@ -67,10 +67,11 @@ func StdConfig(name string) *Config {
 // RawConfig ...
 func RawConfig(key string) *Config {
 	var config = DefaultConfig()
-	if err := conf.UnmarshalKey(key, &config); err != nil &&
+	if err := conf.UnmarshalKey(key, &config, conf.Strict()); err != nil &&
 		errors.Cause(err) != conf.ErrInvalidKey {
 		config.logger.Panic("http server parse config panic", xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr), xlog.FieldErr(err), xlog.FieldKey(key), xlog.FieldValueAny(config))
 	}
+	conf.RegisterValidation(key, func() interface{} { return DefaultConfig() })
 	return config
 }
 
@@ -102,6 +103,9 @@ func (config *Config) MustBuild() *Server {
 
 // Build create server instance, then initialize it with necessary interceptor
 func (config *Config) Build() (*Server, error) {
+	if err := conf.ValidateStruct(config); err != nil {
+		return nil, err
+	}
 	server, err := newServer(config)
 	if err != nil {
 		return nil, err
@@ -22,8 +22,14 @@ import (
 
 	"github.com/alibaba/sentinel-golang/core/base"
 	"github.com/labstack/echo/v4"
+	"github.com/zhengyansheng/jupiter/pkg/core/metric"
 	"github.com/zhengyansheng/jupiter/pkg/core/sentinel"
+	"github.com/zhengyansheng/jupiter/pkg/core/xtrace"
 	"github.com/zhengyansheng/jupiter/pkg/xlog"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
@@ -80,16 +86,48 @@ func recoverMiddleware(slowQueryThresholdInMilli int64) echo.MiddlewareFunc {
 func metricServerInterceptor() echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) (err error) {
-			return nil
+			beg := time.Now()
+			err = next(c)
+			method := c.Request().Method + "." + c.Path()
+			code := c.Response().Status
+			if he, ok := err.(*echo.HTTPError); ok {
+				code = he.Code
+			}
+			metric.ServerHandleHistogram.Observe(time.Since(beg).Seconds(), metric.TypeHTTP, method, extractAID(c))
+			metric.ServerHandleCounter.Inc(metric.TypeHTTP, method, extractAID(c), http.StatusText(code))
+			return err
 		}
 	}
 }
 
 func traceServerInterceptor() echo.MiddlewareFunc {
+	tracer := xtrace.NewTracer(trace.SpanKindServer)
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) (err error) {
+			req := c.Request()
+			ctx, span := tracer.Start(req.Context(), req.Method+" "+c.Path(), propagation.HeaderCarrier(req.Header),
+				trace.WithAttributes(semconv.HTTPServerAttributesFromHTTPRequest("", c.Path(), req)...),
+			)
+			defer span.End()
+
+			traceID := span.SpanContext().TraceID().String()
+			ctx = xlog.NewContext(ctx, xlog.Default(), traceID)
+			ctx = xlog.NewContext(ctx, xlog.Jupiter(), traceID)
+			c.SetRequest(req.WithContext(ctx))
 
-			return next(c)
+			err = next(c)
+
+			status := c.Response().Status
+			if he, ok := err.(*echo.HTTPError); ok {
+				status = he.Code
+			}
+			span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(status)...)
+			span.SetStatus(semconv.SpanStatusFromHTTPStatusCodeAndSpanKind(status, trace.SpanKindServer))
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			return err
 		}
 	}
 }
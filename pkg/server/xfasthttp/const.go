@@ -36,3 +36,9 @@ const (
 const (
 	charsetUTF8 = "charset=utf-8"
 )
+
+// RouteKey handler处理请求时通过ctx.SetUserValue(RouteKey, "/user/{id}")设置匹配的路由模板，用于请求指标的method
+const RouteKey = "jupiter.route"
+
+// unmatchedRoute 未匹配路由的请求在指标中使用的路径
+const unmatchedRoute = "unmatched"
@@ -21,12 +21,17 @@ import (
 	"time"
 
 	"github.com/valyala/fasthttp"
+	"github.com/zhengyansheng/jupiter/pkg/core/metric"
 	"github.com/zhengyansheng/jupiter/pkg/xlog"
 	"go.uber.org/zap"
 )
 
 type Middleware func(h fasthttp.RequestHandler) fasthttp.RequestHandler
 
+func extractAID(ctx *fasthttp.RequestCtx) string {
+	return string(ctx.Request.Header.Peek("AID"))
+}
+
 // recoverMiddleware ...
 func recoverMiddleware(c *Config) Middleware {
 	return func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
@@ -74,3 +79,24 @@ func recoverMiddleware(c *Config) Middleware {
 		}
 	}
 }
+
+// routePath 返回handler通过RouteKey设置的路由模板，未设置时返回固定值，
+// 避免原始路径中的参数导致指标的label数量无限增长
+func routePath(ctx *fasthttp.RequestCtx) string {
+	if route, ok := ctx.UserValue(RouteKey).(string); ok && route != "" {
+		return route
+	}
+	return unmatchedRoute
+}
+
+func metricServerInterceptor() Middleware {
+	return func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return func(ctx *fasthttp.RequestCtx) {
+			beg := time.Now()
+			next(ctx)
+			method := string(ctx.Method()) + "." + routePath(ctx)
+			metric.ServerHandleHistogram.Observe(time.Since(beg).Seconds(), metric.TypeHTTP, method, extractAID(ctx))
+			metric.ServerHandleCounter.Inc(metric.TypeHTTP, method, extractAID(ctx), fasthttp.StatusMessage(ctx.Response.StatusCode()))
+		}
+	}
+}
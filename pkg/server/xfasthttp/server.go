@@ -91,6 +91,9 @@ func (s *Server) Healthz() bool {
 func (s *Server) Serve() error {
 	var err error
 
+	if !s.config.DisableMetric {
+		s.Handler = metricServerInterceptor()(s.Handler)
+	}
 	s.Handler = recoverMiddleware(s.config)(s.Handler)
 
 	if s.config.EnableTLS {
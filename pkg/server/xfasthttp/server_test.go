@@ -18,7 +18,10 @@ import (
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"github.com/zhengyansheng/jupiter/pkg/core/metric"
 )
 
 func Test_Server(t *testing.T) {
@@ -33,3 +36,22 @@ func Test_Server(t *testing.T) {
 	assert.NotNil(t, s.Info())
 	s.Stop()
 }
+
+func Test_metricServerInterceptor(t *testing.T) {
+	handler := metricServerInterceptor()(func(ctx *fasthttp.RequestCtx) {
+		if string(ctx.Path()) != "/missing" {
+			ctx.SetUserValue(RouteKey, "/users/{id}")
+		}
+	})
+
+	for _, path := range []string{"/users/1", "/users/2", "/missing"} {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI(path)
+		ctx.Request.Header.SetMethod(fasthttp.MethodGet)
+		ctx.Request.Header.Set("AID", "metric-test")
+		handler(ctx)
+	}
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(metric.ServerHandleCounter.WithLabelValues(metric.TypeHTTP, "GET./users/{id}", "metric-test", "OK")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metric.ServerHandleCounter.WithLabelValues(metric.TypeHTTP, "GET.unmatched", "metric-test", "OK")))
+}
@@ -26,8 +26,12 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-
+	"github.com/zhengyansheng/jupiter/pkg/core/metric"
+	"github.com/zhengyansheng/jupiter/pkg/core/xtrace"
 	"github.com/zhengyansheng/jupiter/pkg/xlog"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
@@ -169,8 +173,29 @@ func metricServerInterceptor() gin.HandlerFunc {
 }
 
 func traceServerInterceptor() gin.HandlerFunc {
+	tracer := xtrace.NewTracer(trace.SpanKindServer)
 	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			route = c.Request.URL.Path
+		}
+		ctx, span := tracer.Start(c.Request.Context(), c.Request.Method+" "+route, propagation.HeaderCarrier(c.Request.Header),
+			trace.WithAttributes(semconv.HTTPServerAttributesFromHTTPRequest("", route, c.Request)...),
+		)
+		defer span.End()
+
+		traceID := span.SpanContext().TraceID().String()
+		ctx = xlog.NewContext(ctx, xlog.Default(), traceID)
+		ctx = xlog.NewContext(ctx, xlog.Jupiter(), traceID)
+		c.Request = c.Request.WithContext(ctx)
 
 		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(status)...)
+		span.SetStatus(semconv.SpanStatusFromHTTPStatusCodeAndSpanKind(status, trace.SpanKindServer))
+		if len(c.Errors) > 0 {
+			span.RecordError(c.Errors.Last())
+		}
 	}
 }
@@ -15,3 +15,6 @@
 package xgoframe
 
 const codeMS = 1000
+
+// unmatchedRoute 未匹配路由的请求在指标中使用的路径
+const unmatchedRoute = "unmatched"
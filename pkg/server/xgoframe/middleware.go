@@ -19,7 +19,12 @@ import (
 	"time"
 
 	"github.com/gogf/gf/net/ghttp"
+	"github.com/zhengyansheng/jupiter/pkg/core/metric"
+	"github.com/zhengyansheng/jupiter/pkg/core/xtrace"
 	"github.com/zhengyansheng/jupiter/pkg/xlog"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
@@ -54,13 +59,48 @@ func recoverMiddleware(logger *xlog.Logger, slowQueryThresholdInMilli int64) ght
 	}
 }
 
+func extractAID(r *ghttp.Request) string {
+	return r.Header.Get("AID")
+}
+
+// routePath 返回请求匹配的路由模板，避免原始路径中的参数导致指标的label数量无限增长
+func routePath(r *ghttp.Request) string {
+	if r.Router == nil || r.Router.Uri == "" {
+		return unmatchedRoute
+	}
+	return r.Router.Uri
+}
+
 func metricServerInterceptor() ghttp.HandlerFunc {
 	return func(r *ghttp.Request) {
+		beg := time.Now()
+		r.Middleware.Next()
+		method := r.Method + "." + routePath(r)
+		metric.ServerHandleHistogram.Observe(time.Since(beg).Seconds(), metric.TypeHTTP, method, extractAID(r))
+		metric.ServerHandleCounter.Inc(metric.TypeHTTP, method, extractAID(r), http.StatusText(r.Response.Status))
 	}
 }
+
 func traceServerInterceptor() ghttp.HandlerFunc {
+	tracer := xtrace.NewTracer(trace.SpanKindServer)
 	return func(r *ghttp.Request) {
-		r.Request = r.WithContext(r.Context())
+		ctx, span := tracer.Start(r.Context(), r.Method+" "+r.URL.Path, propagation.HeaderCarrier(r.Header),
+			trace.WithAttributes(semconv.HTTPServerAttributesFromHTTPRequest("", r.URL.Path, r.Request)...),
+		)
+		defer span.End()
+
+		traceID := span.SpanContext().TraceID().String()
+		ctx = xlog.NewContext(ctx, xlog.Default(), traceID)
+		ctx = xlog.NewContext(ctx, xlog.Jupiter(), traceID)
+		r.Request = r.WithContext(ctx)
+
 		r.Middleware.Next()
+
+		status := r.Response.Status
+		span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(status)...)
+		span.SetStatus(semconv.SpanStatusFromHTTPStatusCodeAndSpanKind(status, trace.SpanKindServer))
+		if err := r.GetError(); err != nil {
+			span.RecordError(err)
+		}
 	}
 }
@@ -30,10 +30,10 @@ import (
 type Config struct {
 	Name       string `json:"name"`
 	Host       string `json:"host"`
-	Port       int    `json:"port"`
+	Port       int    `json:"port" validate:"min=0,max=65535"`
 	Deployment string `json:"deployment"`
 	// Network network type, tcp4 by default
-	Network string `json:"network" toml:"network"`
+	Network string `json:"network" toml:"network" validate:"oneof=tcp tcp4 tcp6 unix"`
 	// EnableAccessLog enable Access Interceptor, true by default
 	EnableAccessLog bool
 	// DisableTrace disable Trace Interceptor, false by default
@@ -74,13 +74,14 @@ func StdConfig(name string) *Config {
 // RawConfig ...
 func RawConfig(key string) *Config {
 	var config = DefaultConfig()
-	if err := conf.UnmarshalKey(key, &config); err != nil {
+	if err := conf.UnmarshalKey(key, &config, conf.Strict()); err != nil {
 		config.logger.Panic("grpc server parse config panic",
 			xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr),
 			xlog.FieldErr(err), xlog.FieldKey(key),
 			xlog.FieldValueAny(config),
 		)
 	}
+	conf.RegisterValidation(key, func() interface{} { return DefaultConfig() })
 	return config
 }
 
@@ -145,7 +146,9 @@ func (config *Config) MustBuild() *Server {
 
 // Build ...
 func (config *Config) Build() (*Server, error) {
-
+	if err := conf.ValidateStruct(config); err != nil {
+		return nil, err
+	}
 	return newServer(config)
 }
 
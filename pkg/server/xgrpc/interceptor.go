@@ -23,32 +23,54 @@ import (
 	"time"
 
 	"github.com/alibaba/sentinel-golang/core/base"
-	"github.com/zhengyansheng/jupiter/pkg/core/ecode"
+	"github.com/zhengyansheng/jupiter/pkg/core/metric"
 	"github.com/zhengyansheng/jupiter/pkg/core/sentinel"
+	"github.com/zhengyansheng/jupiter/pkg/core/xtrace"
 	"github.com/zhengyansheng/jupiter/pkg/xlog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 )
 
 func prometheusUnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-
+	beg := time.Now()
+	metric.ServerInflightGauge.Inc(metric.TypeGRPCUnary, info.FullMethod)
 	resp, err := handler(ctx, req)
+	metric.ServerInflightGauge.Dec(metric.TypeGRPCUnary, info.FullMethod)
+
+	metric.ServerHandleHistogram.Observe(time.Since(beg).Seconds(), metric.TypeGRPCUnary, info.FullMethod, extractAID(ctx))
+	metric.ServerHandleCounter.Inc(metric.TypeGRPCUnary, info.FullMethod, extractAID(ctx), status.Code(err).String())
 
 	return resp, err
 }
 
 func prometheusStreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	beg := time.Now()
+	metric.ServerInflightGauge.Inc(metric.TypeGRPCStream, info.FullMethod)
+	err := handler(srv, ss)
+	metric.ServerInflightGauge.Dec(metric.TypeGRPCStream, info.FullMethod)
 
-	return nil
+	metric.ServerHandleHistogram.Observe(time.Since(beg).Seconds(), metric.TypeGRPCStream, info.FullMethod, extractAID(ss.Context()))
+	metric.ServerHandleCounter.Inc(metric.TypeGRPCStream, info.FullMethod, extractAID(ss.Context()), status.Code(err).String())
+
+	return err
 }
 
 func NewTraceUnaryServerInterceptor() grpc.UnaryServerInterceptor {
-
+	tracer := xtrace.NewTracer(trace.SpanKindServer)
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (reply interface{}, err error) {
+		ctx, span := startServerSpan(tracer, ctx, info.FullMethod)
+		defer span.End()
 
-		return handler(ctx, req)
+		reply, err = handler(ctx, req)
+		endServerSpan(span, err)
+		return reply, err
 	}
 }
 
@@ -63,12 +85,47 @@ func (css contextedServerStream) Context() context.Context {
 }
 
 func NewTraceStreamServerInterceptor() grpc.StreamServerInterceptor {
+	tracer := xtrace.NewTracer(trace.SpanKindServer)
 	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, span := startServerSpan(tracer, ss.Context(), info.FullMethod)
+		defer span.End()
 
-		return handler(srv, contextedServerStream{
+		err := handler(srv, contextedServerStream{
 			ServerStream: ss,
-			ctx:          context.TODO(),
+			ctx:          ctx,
 		})
+		endServerSpan(span, err)
+		return err
+	}
+}
+
+func startServerSpan(tracer *xtrace.Tracer, ctx context.Context, fullMethod string) (context.Context, trace.Span) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		md = metadata.MD{}
+	}
+	ctx, span := tracer.Start(ctx, fullMethod, xtrace.MetadataCarrier(md),
+		trace.WithAttributes(
+			semconv.RPCSystemKey.String("grpc"),
+			semconv.RPCMethodKey.String(fullMethod),
+		),
+	)
+	if p, ok := peer.FromContext(ctx); ok {
+		span.SetAttributes(semconv.NetPeerIPKey.String(p.Addr.String()))
+	}
+
+	traceID := span.SpanContext().TraceID().String()
+	ctx = xlog.NewContext(ctx, xlog.Default(), traceID)
+	ctx = xlog.NewContext(ctx, xlog.Jupiter(), traceID)
+	return ctx, span
+}
+
+func endServerSpan(span trace.Span, err error) {
+	code := status.Code(err)
+	span.SetAttributes(attribute.Int64("rpc.grpc.status_code", int64(code)))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, code.String())
 	}
 }
 
@@ -17,6 +17,7 @@ package gorm
 import (
 	"errors"
 
+	cfg "github.com/zhengyansheng/jupiter/pkg/conf"
 	"github.com/zhengyansheng/jupiter/pkg/util/xretry"
 	"github.com/zhengyansheng/jupiter/pkg/xlog"
 	"gorm.io/gorm"
@@ -27,6 +28,9 @@ func dial(name string, config *Config) *gorm.DB {
 	if config.DSN == "" {
 		xlog.Jupiter().Panic("empty dsn", xlog.FieldName(name))
 	}
+	if err := cfg.ValidateStruct(config, cfg.TagName("toml")); err != nil {
+		xlog.Jupiter().Panic("invalid config", xlog.FieldName(name), xlog.FieldErr(err))
+	}
 
 	dsn, err := parseDSN(config.DSN)
 	if err != nil {
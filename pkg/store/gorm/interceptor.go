@@ -15,12 +15,18 @@
 package gorm
 
 import (
+	"context"
 	"errors"
 	"time"
 
 	"github.com/alibaba/sentinel-golang/core/base"
+	"github.com/zhengyansheng/jupiter/pkg/core/metric"
 	"github.com/zhengyansheng/jupiter/pkg/core/sentinel"
+	"github.com/zhengyansheng/jupiter/pkg/core/xtrace"
 	"github.com/zhengyansheng/jupiter/pkg/xlog"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
 )
 
@@ -34,7 +40,30 @@ var errSlowCommand = errors.New("mysql slow command")
 func metricInterceptor() Interceptor {
 	return func(dsn *DSN, op string, options *Config, next Handler) Handler {
 		return func(scope *gorm.DB) {
-			_ = scope
+			beg := time.Now()
+			next(scope)
+			cost := time.Since(beg)
+
+			name := dsn.DBName + "." + scope.Statement.Table
+			if scope.Error != nil && !errors.Is(scope.Error, gorm.ErrRecordNotFound) {
+				metric.LibHandleCounter.Inc(metric.TypeGorm, name, dsn.Addr, "ERR")
+				xlog.Jupiter().Error("mysql err", xlog.FieldErr(scope.Error), xlog.FieldName(name), xlog.FieldMethod(op))
+			} else {
+				metric.LibHandleCounter.Inc(metric.TypeGorm, name, dsn.Addr, "OK")
+			}
+			metric.LibHandleHistogram.WithLabelValues(metric.TypeGorm, name, dsn.Addr).Observe(cost.Seconds())
+
+			if options.SlowThreshold > time.Duration(0) && options.SlowThreshold < cost {
+				xlog.Jupiter().Error(
+					"slow",
+					xlog.FieldErr(errSlowCommand),
+					xlog.FieldMethod(op),
+					xlog.FieldExtMessage(logSQL(scope.Statement.SQL.String(), scope.Statement.Vars, options.DetailSQL)),
+					xlog.FieldAddr(dsn.Addr),
+					xlog.FieldName(name),
+					xlog.FieldCost(cost),
+				)
+			}
 		}
 	}
 }
@@ -47,15 +76,34 @@ func logSQL(sql string, args []interface{}, containArgs bool) string {
 }
 
 func traceInterceptor() Interceptor {
-
+	tracer := xtrace.NewTracer(trace.SpanKindClient)
 	return func(dsn *DSN, op string, options *Config, next Handler) Handler {
 		return func(scope *gorm.DB) {
-			if ctx := scope.Statement.Context; ctx != nil {
-
-				return
+			ctx := scope.Statement.Context
+			if ctx == nil {
+				ctx = context.Background()
 			}
 
+			ctx, span := tracer.Start(ctx, op, nil,
+				trace.WithAttributes(
+					semconv.DBSystemMySQL,
+					semconv.DBNameKey.String(dsn.DBName),
+					semconv.NetPeerNameKey.String(dsn.Addr),
+				),
+			)
+			defer span.End()
+			scope.Statement.Context = ctx
+
 			next(scope)
+
+			span.SetAttributes(
+				semconv.DBSQLTableKey.String(scope.Statement.Table),
+				semconv.DBStatementKey.String(logSQL(scope.Statement.SQL.String(), scope.Statement.Vars, options.DetailSQL)),
+			)
+			if scope.Error != nil && !errors.Is(scope.Error, gorm.ErrRecordNotFound) {
+				span.RecordError(scope.Error)
+				span.SetStatus(codes.Error, scope.Error.Error())
+			}
 		}
 	}
 }
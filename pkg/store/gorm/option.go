@@ -36,9 +36,10 @@ func RawConfig(key string) *Config {
 	config := DefaultConfig()
 	config.Name = key
 
-	if err := cfg.UnmarshalKey(key, &config, cfg.TagName("toml")); err != nil {
+	if err := cfg.UnmarshalKey(key, &config, cfg.TagName("toml"), cfg.Strict()); err != nil {
 		xlog.Jupiter().Panic("unmarshal config", xlog.FieldErr(err), xlog.FieldName(key))
 	}
+	cfg.RegisterValidation(key, func() interface{} { return DefaultConfig() }, cfg.TagName("toml"))
 
 	if xdebug.IsDevelopmentMode() {
 		xdebug.PrettyJsonPrint(key, config)
@@ -55,15 +56,15 @@ type Config struct {
 	// Debug开关
 	Debug bool `json:"debug" toml:"debug"`
 	// 最大空闲连接数
-	MaxIdleConns int `json:"maxIdleConns" toml:"maxIdleConns"`
+	MaxIdleConns int `json:"maxIdleConns" toml:"maxIdleConns" validate:"min=0"`
 	// 最大活动连接数
-	MaxOpenConns int `json:"maxOpenConns" toml:"maxOpenConns"`
+	MaxOpenConns int `json:"maxOpenConns" toml:"maxOpenConns" validate:"min=0"`
 	// 连接的最大存活时间
 	ConnMaxLifetime time.Duration `json:"connMaxLifetime" toml:"connMaxLifetime"`
 	// 创建连接的错误级别，=panic时，如果创建失败，立即panic
 	OnDialError string `json:"level" toml:"level"`
 	// 慢日志阈值
-	SlowThreshold time.Duration `json:"slowThreshold" toml:"slowThreshold"`
+	SlowThreshold time.Duration `json:"slowThreshold" toml:"slowThreshold" validate:"min=0"`
 	// 拨超时时间
 	DialTimeout time.Duration `json:"dialTimeout" toml:"dialTimeout"`
 	// 自动使用影子表
@@ -74,7 +75,7 @@ type Config struct {
 	// select * from aid = 288016;
 	DetailSQL bool `json:"detailSql" toml:"detailSql"`
 	// 重试次数
-	Retry int `json:"retry" toml:"retry"`
+	Retry int `json:"retry" toml:"retry" validate:"min=0"`
 	// 重试等待时间
 	RetryWaitTime time.Duration `json:"retryWaitTime" toml:"retryWaitTime"`
 
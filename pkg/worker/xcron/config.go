@@ -24,6 +24,7 @@ import (
 	"github.com/zhengyansheng/jupiter/pkg/conf"
 	"github.com/zhengyansheng/jupiter/pkg/core/constant"
 	"github.com/zhengyansheng/jupiter/pkg/core/ecode"
+	"github.com/zhengyansheng/jupiter/pkg/core/metric"
 	"github.com/zhengyansheng/jupiter/pkg/xlog"
 	"go.etcd.io/etcd/client/v3/concurrency"
 	"go.uber.org/zap"
@@ -208,8 +209,12 @@ func (wj wrappedJob) run() (err error) {
 		if err != nil {
 			fields = append(fields, xlog.String("err", err.Error()), xlog.Duration("cost", time.Since(beg)))
 			wj.logger.Error("run", fields...)
+			metric.JobHandleCounter.Inc("cron", wj.Name(), metric.CodeJobFail)
+			metric.JobHandleHistogram.Observe(time.Since(beg).Seconds(), "cron", wj.Name(), metric.CodeJobFail)
 		} else {
 			wj.logger.Info("run", fields...)
+			metric.JobHandleCounter.Inc("cron", wj.Name(), metric.CodeJobSuccess)
+			metric.JobHandleHistogram.Observe(time.Since(beg).Seconds(), "cron", wj.Name(), metric.CodeJobSuccess)
 		}
 	}()
 
@@ -18,10 +18,6 @@ import (
 	"context"
 )
 
-const (
-	traceIDField = "trace_id"
-)
-
 type (
 	defaultLoggerKey struct{}
 	jupiterLoggerKey struct{}
@@ -35,11 +31,11 @@ func NewContext(ctx context.Context, l *Logger, traceID string) context.Context
 }
 
 func newContextWithDefaultLogger(ctx context.Context, l *Logger, traceID string) context.Context {
-	return context.WithValue(ctx, defaultLoggerKey{}, l.With(String(traceIDField, traceID)))
+	return context.WithValue(ctx, defaultLoggerKey{}, l.With(FieldTID(traceID)))
 }
 
 func newContextWithJupiterLogger(ctx context.Context, l *Logger, traceID string) context.Context {
-	return context.WithValue(ctx, jupiterLoggerKey{}, l.With(String(traceIDField, traceID)))
+	return context.WithValue(ctx, jupiterLoggerKey{}, l.With(FieldTID(traceID)))
 }
 
 // Deprecated: use xlog.L instead
@@ -114,6 +114,8 @@ module.exports = {
             "/jupiter/4.7sentinel",
             "/jupiter/4.8trace",
             "/jupiter/4.9freecache",
+            "/jupiter/4.10kafka",
+            "/jupiter/4.11mq",
           ],
         },
         {
@@ -136,6 +138,7 @@ module.exports = {
             "/jupiter/6.9mongodb",
             "/jupiter/6.10rocketmq",
             "/jupiter/6.11sentinel",
+            "/jupiter/6.12kafka",
           ],
         },
         {